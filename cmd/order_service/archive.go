@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/order_tracker/internal/config"
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/retention"
+	"github.com/stsolovey/order_tracker/internal/storage"
+)
+
+// runArchiveCommand handles `order_service archive <list|run|restore>`.
+func runArchiveCommand(ctx context.Context, cfg *config.Config, log *logrus.Logger, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("archive: %w: expected list, run or restore", errMissingArgs)
+	}
+
+	db, err := storage.NewStorage(ctx, log, cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("archive storage.NewStorage(...): %w", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "list":
+		partitions, err := db.OrderPartitions(ctx)
+		if err != nil {
+			return fmt.Errorf("archive list: %w", err)
+		}
+
+		archives, err := filepath.Glob(filepath.Join(cfg.PartitionArchiveDir, "orders-*.ndjson.gz"))
+		if err != nil {
+			return fmt.Errorf("archive list filepath.Glob(...): %w", err)
+		}
+
+		return printJSON(struct {
+			Partitions []models.OrderPartition `json:"partitions"`
+			Archives   []string                `json:"archives"`
+		}{partitions, archives})
+	case "run":
+		maintainer := retention.NewMaintainer(log, db, cfg.PartitionMaintenanceInterval,
+			cfg.PartitionPremakeMonths, cfg.PartitionRetentionMonths, cfg.PartitionArchiveDir)
+
+		archives, err := maintainer.RunOnce(ctx)
+		if err != nil {
+			return fmt.Errorf("archive run: %w", err)
+		}
+
+		return printJSON(archives)
+	case "restore":
+		if len(args) < 2 { //nolint:mnd
+			return fmt.Errorf("archive restore: %w: expected at least one archive file", errMissingArgs)
+		}
+
+		for _, path := range args[1:] {
+			restored, err := db.RestoreArchive(ctx, path)
+			if err != nil {
+				return fmt.Errorf("archive restore %s: %w", path, err)
+			}
+
+			log.Infof("Restored %d orders from %s", restored, path)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("archive: %w: %s", errUnknownCommand, args[0])
+	}
+}
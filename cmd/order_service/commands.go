@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/order_tracker/internal/config"
+)
+
+var (
+	errUnknownCommand = errors.New("unknown command")
+	errMissingArgs    = errors.New("missing arguments")
+)
+
+func runCommand(ctx context.Context, cfg *config.Config, log *logrus.Logger, args []string) error {
+	switch args[0] {
+	case "archive":
+		return runArchiveCommand(ctx, cfg, log, args[1:])
+	case "migrate":
+		return runMigrateCommand(ctx, cfg, log, args[1:])
+	case "dlq":
+		return runDeadLetterCommand(ctx, cfg, log, args[1:])
+	case "renormalize":
+		return runRenormalizeCommand(ctx, cfg, log, args[1:])
+	case "replay":
+		return runReplayCommand(ctx, cfg, log, args[1:])
+	default:
+		return fmt.Errorf("%w: %s", errUnknownCommand, args[0])
+	}
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("commands.go printJSON encoder.Encode(...): %w", err)
+	}
+
+	return nil
+}
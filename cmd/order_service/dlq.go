@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/order_tracker/internal/config"
+	natsclient "github.com/stsolovey/order_tracker/internal/nats-client"
+)
+
+const defaultDeadLetterListLimit = 100
+
+// runDeadLetterCommand handles `order_service dlq <list|show|requeue|delete|purge>`.
+func runDeadLetterCommand(ctx context.Context, cfg *config.Config, log *logrus.Logger, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("dlq: %w: expected list, show, requeue, delete or purge", errMissingArgs)
+	}
+
+	client, err := natsclient.New(cfg, log, nil)
+	if err != nil {
+		return fmt.Errorf("dlq natsclient.New(...): %w", err)
+	}
+	defer client.Close()
+
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("dlq list", flag.ContinueOnError)
+		limit := flags.Int("limit", defaultDeadLetterListLimit, "maximum number of dead letters to list (0 for all)")
+
+		if err := flags.Parse(args[1:]); err != nil {
+			return fmt.Errorf("dlq list flags.Parse(...): %w", err)
+		}
+
+		letters, err := client.ListDeadLetters(ctx, *limit)
+		if err != nil {
+			return fmt.Errorf("dlq list: %w", err)
+		}
+
+		return printJSON(letters)
+	case "show":
+		return forEachSequence(args[1:], func(seq uint64) error {
+			letter, err := client.GetDeadLetter(ctx, seq)
+			if err != nil {
+				return fmt.Errorf("dlq show: %w", err)
+			}
+
+			return printJSON(letter)
+		})
+	case "requeue":
+		return forEachSequence(args[1:], func(seq uint64) error {
+			if err := client.RequeueDeadLetter(ctx, seq); err != nil {
+				return fmt.Errorf("dlq requeue: %w", err)
+			}
+
+			log.Infof("Dead letter %d requeued", seq)
+
+			return nil
+		})
+	case "delete":
+		return forEachSequence(args[1:], func(seq uint64) error {
+			if err := client.DeleteDeadLetter(ctx, seq); err != nil {
+				return fmt.Errorf("dlq delete: %w", err)
+			}
+
+			log.Infof("Dead letter %d deleted", seq)
+
+			return nil
+		})
+	case "purge":
+		if err := client.PurgeDeadLetters(ctx); err != nil {
+			return fmt.Errorf("dlq purge: %w", err)
+		}
+
+		log.Info("Dead-letter stream purged")
+
+		return nil
+	default:
+		return fmt.Errorf("dlq: %w: %s", errUnknownCommand, args[0])
+	}
+}
+
+func forEachSequence(args []string, fn func(seq uint64) error) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: expected at least one stream sequence", errMissingArgs)
+	}
+
+	for _, arg := range args {
+		seq, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid stream sequence %q: %w", arg, err)
+		}
+
+		if err := fn(seq); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,16 +2,21 @@ package main
 
 import (
 	"context"
-	"errors"
+	"os"
 	"os/signal"
 	"syscall"
 
 	_ "github.com/jackc/pgx/v5/stdlib" // Importing `pgx/v5/stdlib` is necessary for `sql.Open("pgx", s.dsn)`.
-	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
 	"github.com/stsolovey/order_tracker/internal/config"
 	"github.com/stsolovey/order_tracker/internal/logger"
 	natsclient "github.com/stsolovey/order_tracker/internal/nats-client"
 	ordercache "github.com/stsolovey/order_tracker/internal/order-cache"
+	"github.com/stsolovey/order_tracker/internal/outbox"
+	"github.com/stsolovey/order_tracker/internal/repository"
+	"github.com/stsolovey/order_tracker/internal/repository/filestore"
+	"github.com/stsolovey/order_tracker/internal/repository/memory"
+	"github.com/stsolovey/order_tracker/internal/retention"
 	"github.com/stsolovey/order_tracker/internal/server"
 	"github.com/stsolovey/order_tracker/internal/service"
 	"github.com/stsolovey/order_tracker/internal/storage"
@@ -24,52 +29,77 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	defer cancel()
 
-	db, err := storage.NewStorage(ctx, log, cfg.DatabaseURL)
-	if err != nil {
-		log.WithError(err).Panic("Failed to initialize storage")
-	}
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, cfg, log, os.Args[1:]); err != nil {
+			log.WithError(err).Panicf("Command %q failed", os.Args[1])
+		}
 
-	if err := db.Migrate(); err != nil {
-		log.WithError(err).Panic("Failed to execute migrations")
+		return
 	}
 
+	runService(ctx, cfg, log)
+}
+
+func runService(ctx context.Context, cfg *config.Config, log *logrus.Logger) {
+	repo, db, closeRepo := openRepository(ctx, cfg, log)
+	defer closeRepo()
+
 	orderCache := ordercache.New(log)
-	app := service.New(log, orderCache, db)
+	app := service.New(log, orderCache, repo)
+	app.SetWarmupOptions(service.WarmupOptions{
+		ChunkSize:        cfg.CacheWarmupChunkSize,
+		Workers:          cfg.CacheWarmupWorkers,
+		MaxOrders:        cfg.CacheWarmupMaxOrders,
+		MaxAge:           cfg.CacheWarmupMaxAge,
+		ProgressInterval: cfg.CacheWarmupProgressInterval,
+	})
 
 	if err := app.Init(ctx); err != nil {
 		log.WithError(err).Panic("Error app initialisation")
 	}
 
-	nc, err := nats.Connect(cfg.NATSURL)
+	natsClient, err := natsclient.New(cfg, log, app)
 	if err != nil {
-		log.WithError(err).Panic("Failed to connect to NATS")
+		log.WithError(err).Panic("Failed to initialize NATS client")
 	}
-	defer nc.Close()
+	defer natsClient.Close()
 
-	js, err := nc.JetStream()
-	if err != nil {
-		log.WithError(err).Panic("Failed to get JetStream context")
+	if err := natsClient.EnsureStream(ctx); err != nil {
+		log.WithError(err).Panic("Failed to provision stream")
 	}
 
-	streamName := "ORDERS"
-	streamSubjects := []string{"orders"}
-	_, err = js.AddStream(&nats.StreamConfig{
-		Name:     streamName,
-		Subjects: streamSubjects,
-	})
+	subscribe := natsClient.Subscribe
+	if cfg.NATSConsumerMode == config.ConsumerModePull {
+		subscribe = natsClient.PullSubscribe
+	}
 
-	if err != nil && !errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
-		log.WithError(err).Panic("Failed to create stream")
+	for _, subject := range natsClient.OrderSubjects() {
+		if err := subscribe(ctx, subject); err != nil {
+			log.WithError(err).Panicf("Failed to subscribe to NATS subject %s", subject)
+		}
 	}
 
-	natsClient, err := natsclient.New(cfg, log, app)
-	if err != nil {
-		log.WithError(err).Panic("Failed to initialize NATS client")
+	if err := natsClient.SubscribeTombstones(ctx); err != nil {
+		log.WithError(err).Panic("Failed to subscribe to NATS delete subject")
 	}
-	defer natsClient.Close()
 
-	if err := natsClient.Subscribe(ctx, "orders"); err != nil {
-		log.WithError(err).Panic("Failed to subscribe to NATS subject")
+	instance := app.EnableCacheSync(natsClient.PublishInvalidation)
+	if err := natsClient.SubscribeInvalidations(ctx, app.ApplyInvalidation); err != nil {
+		log.WithError(err).Panic("Failed to subscribe to cache invalidations")
+	}
+
+	go app.RunCacheSyncHeartbeat(ctx, cfg.CacheSyncHeartbeat)
+	log.Infof("Cache sync enabled as instance %s", instance)
+
+	// The outbox and partitions only exist in Postgres.
+	if db != nil {
+		relay := outbox.NewRelay(log, db, natsClient.PublishEvent,
+			cfg.OutboxInterval, cfg.OutboxBatchSize, cfg.OutboxRetention)
+		go relay.Run(ctx)
+
+		maintainer := retention.NewMaintainer(log, db, cfg.PartitionMaintenanceInterval,
+			cfg.PartitionPremakeMonths, cfg.PartitionRetentionMonths, cfg.PartitionArchiveDir)
+		go maintainer.Run(ctx)
 	}
 
 	httpServer := server.CreateServer(cfg, log, app)
@@ -78,3 +108,52 @@ func main() {
 		log.WithError(err).Panic("Server stopped unexpectedly")
 	}
 }
+
+// openRepository opens the configured storage backend. db is nil unless the
+// backend is Postgres.
+func openRepository(
+	ctx context.Context, cfg *config.Config, log *logrus.Logger,
+) (repository.Repository, *storage.Storage, func()) {
+	switch cfg.StorageBackend {
+	case config.StorageBackendMemory:
+		log.Warn("Using in-memory storage: orders are lost on restart")
+
+		return memory.New(), nil, func() {}
+	case config.StorageBackendFile:
+		store, err := filestore.Open(cfg.StorageFile)
+		if err != nil {
+			log.WithError(err).Panic("Failed to open storage file")
+		}
+
+		log.Infof("Using file storage at %s", cfg.StorageFile)
+
+		return store, nil, func() {
+			if err := store.Close(); err != nil {
+				log.WithError(err).Error("Failed to close storage file")
+			}
+		}
+	}
+
+	db, err := storage.NewStorage(ctx, log, cfg.DatabaseURL)
+	if err != nil {
+		log.WithError(err).Panic("Failed to initialize storage")
+	}
+
+	if err := db.ConnectReplicas(ctx, storage.ReplicaOptions{
+		DSNs:          cfg.ReplicaURLs,
+		MaxLag:        cfg.ReplicaMaxLag,
+		CheckInterval: cfg.ReplicaCheckInterval,
+	}); err != nil {
+		db.Close()
+		log.WithError(err).Panic("Failed to initialize read replicas")
+	}
+
+	if cfg.MigrateOnStart {
+		if err := db.Migrate(); err != nil {
+			db.Close()
+			log.WithError(err).Panic("Failed to execute migrations")
+		}
+	}
+
+	return db, db, db.Close
+}
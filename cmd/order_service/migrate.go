@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/order_tracker/internal/config"
+	"github.com/stsolovey/order_tracker/internal/storage"
+)
+
+// runMigrateCommand handles `order_service migrate <up|down|redo|to|status>`.
+func runMigrateCommand(ctx context.Context, cfg *config.Config, log *logrus.Logger, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate: %w: expected up, down, redo, to or status", errMissingArgs)
+	}
+
+	db, err := storage.NewStorage(ctx, log, cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("migrate storage.NewStorage(...): %w", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		limit, err := migrateSteps(args[1:], 0)
+		if err != nil {
+			return err
+		}
+
+		applied, err := db.MigrateUp(ctx, limit)
+		if err != nil {
+			return fmt.Errorf("migrate up: %w", err)
+		}
+
+		log.Infof("Applied %d migrations", applied)
+
+		return nil
+	case "down":
+		steps, err := migrateSteps(args[1:], 1)
+		if err != nil {
+			return err
+		}
+
+		rolledBack, err := db.MigrateDown(ctx, steps)
+		if err != nil {
+			return fmt.Errorf("migrate down: %w", err)
+		}
+
+		log.Infof("Rolled back %d migrations", rolledBack)
+
+		return nil
+	case "redo":
+		if err := db.MigrateRedo(ctx); err != nil {
+			return fmt.Errorf("migrate redo: %w", err)
+		}
+
+		log.Info("Redid the last migration")
+
+		return nil
+	case "to":
+		if len(args) < 2 { //nolint:mnd
+			return fmt.Errorf("migrate to: %w: expected a migration id", errMissingArgs)
+		}
+
+		moved, err := db.MigrateTo(ctx, args[1])
+		if err != nil {
+			return fmt.Errorf("migrate to: %w", err)
+		}
+
+		if moved < 0 {
+			log.Infof("Rolled back %d migrations", -moved)
+		} else {
+			log.Infof("Applied %d migrations", moved)
+		}
+
+		return nil
+	case "status":
+		statuses, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("migrate status: %w", err)
+		}
+
+		return printJSON(statuses)
+	default:
+		return fmt.Errorf("migrate: %w: %s", errUnknownCommand, args[0])
+	}
+}
+
+// migrateSteps parses the optional step count following up or down.
+func migrateSteps(args []string, fallback int) (int, error) {
+	if len(args) == 0 {
+		return fallback, nil
+	}
+
+	steps, err := strconv.ParseUint(args[0], 10, 31)
+	if err != nil {
+		return 0, fmt.Errorf("invalid step count %q: %w", args[0], err)
+	}
+
+	return int(steps), nil
+}
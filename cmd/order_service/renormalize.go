@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/order_tracker/internal/codec"
+	"github.com/stsolovey/order_tracker/internal/config"
+	natsclient "github.com/stsolovey/order_tracker/internal/nats-client"
+	ordercache "github.com/stsolovey/order_tracker/internal/order-cache"
+	"github.com/stsolovey/order_tracker/internal/service"
+)
+
+// runRenormalizeCommand handles `order_service renormalize`, which decodes
+// the stored raw payloads again so that orders pick up new model fields.
+// Rewritten orders are announced on the cache sync subject, so running
+// instances refresh their caches.
+func runRenormalizeCommand(ctx context.Context, cfg *config.Config, log *logrus.Logger, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("renormalize: %w: %s", errUnknownCommand, args[0])
+	}
+
+	repo, _, closeRepo := openRepository(ctx, cfg, log)
+	defer closeRepo()
+
+	app := service.New(log, ordercache.New(log), repo)
+
+	client, err := natsclient.New(cfg, log, app)
+	if err != nil {
+		return fmt.Errorf("renormalize natsclient.New(...): %w", err)
+	}
+	defer client.Close()
+
+	app.EnableCacheSync(client.PublishInvalidation)
+
+	stats, err := app.RenormalizeOrders(ctx, codec.Default().DecodeRaw)
+	if err != nil {
+		return fmt.Errorf("renormalize: %w", err)
+	}
+
+	return printJSON(stats)
+}
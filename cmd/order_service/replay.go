@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/order_tracker/internal/config"
+	natsclient "github.com/stsolovey/order_tracker/internal/nats-client"
+	ordercache "github.com/stsolovey/order_tracker/internal/order-cache"
+	"github.com/stsolovey/order_tracker/internal/service"
+	"github.com/stsolovey/order_tracker/internal/storage"
+)
+
+// runReplayCommand handles `order_service replay`, which rebuilds the
+// database from the orders stream.
+func runReplayCommand(ctx context.Context, cfg *config.Config, log *logrus.Logger, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fromSeq := flags.Uint64("from-seq", 0, "stream sequence to start from (default: first message)")
+	fromTime := flags.String("from-time", "", "RFC3339 timestamp to start from, instead of -from-seq")
+	dryRun := flags.Bool("dry-run", false, "decode and validate orders without writing them")
+	rate := flags.Int("rate", 0, "maximum messages per second (0 for unthrottled)")
+	progress := flags.Duration("progress", 0, "interval between progress reports")
+
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("replay flags.Parse(...): %w", err)
+	}
+
+	opts := natsclient.ReplayOptions{
+		StartSeq:         *fromSeq,
+		DryRun:           *dryRun,
+		Rate:             *rate,
+		ProgressInterval: *progress,
+	}
+
+	if *fromTime != "" {
+		startTime, err := time.Parse(time.RFC3339, *fromTime)
+		if err != nil {
+			return fmt.Errorf("replay invalid -from-time %q: %w", *fromTime, err)
+		}
+
+		opts.StartTime = startTime
+	}
+
+	db, err := storage.NewStorage(ctx, log, cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("replay storage.NewStorage(...): %w", err)
+	}
+	defer db.Close()
+
+	app := service.New(log, ordercache.New(log), db)
+
+	client, err := natsclient.New(cfg, log, app)
+	if err != nil {
+		return fmt.Errorf("replay natsclient.New(...): %w", err)
+	}
+	defer client.Close()
+
+	stats, err := client.Replay(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+
+	return printJSON(stats)
+}
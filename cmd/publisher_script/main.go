@@ -1,12 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/stsolovey/order_tracker/internal/codec"
 	"github.com/stsolovey/order_tracker/internal/config"
 	"github.com/stsolovey/order_tracker/internal/logger"
 	"github.com/stsolovey/order_tracker/internal/models"
@@ -21,6 +21,11 @@ func main() {
 	cfg := config.New("./.env")
 	log := logger.New(cfg.LogLevel)
 
+	orderCodec, err := codec.Default().Lookup(cfg.NATSContentType)
+	if err != nil {
+		log.WithError(err).Panic("Failed to select codec")
+	}
+
 	nc, err := nats.Connect(cfg.NATSURL)
 	if err != nil {
 		log.WithError(err).Panic("Failed to connect to NATS")
@@ -35,12 +40,16 @@ func main() {
 	for i := range numOfOrdersToGenerate {
 		order := generateSampleOrder(i)
 
-		data, err := json.Marshal(order)
+		data, err := orderCodec.Marshal(&order)
 		if err != nil {
 			log.WithError(err).Panic("Failed to marshal order")
 		}
 
-		_, err = js.Publish("orders", data)
+		msg := nats.NewMsg(cfg.NATSSubject)
+		msg.Data = data
+		msg.Header.Set(codec.HeaderContentType, orderCodec.ContentType())
+
+		_, err = js.PublishMsg(msg)
 		if err != nil {
 			log.WithError(err).Panic("Failed to publish order")
 		}
@@ -85,15 +94,15 @@ func generateSampleOrder(i int) models.Order {
 			Transaction: fmt.Sprintf("TXN_%d", randInt(transactionNumberMax)),
 			Currency:    "USD",
 			Provider:    "test_provider",
-			Amount:      float64(randInt(amountMax)),
+			Amount:      models.NewMoney(int64(randInt(amountMax)), 0),
 			PaymentDT:   time.Now(),
 		},
 		Items: []models.Item{
 			{
 				OrderUID:    orderUID,
-				ChrtID:      randInt(chrtIDMax),
+				ChrtID:      randInt(chrtIDMax) + 1,
 				TrackNumber: fmt.Sprintf("TRACK_%d", randInt(trackNumberMax)),
-				Price:       float64(randInt(priceMax)),
+				Price:       models.NewMoney(int64(randInt(priceMax)), 0),
 				Name:        "Test Item 1",
 				NMID:        randInt(nmidMax),
 				Brand:       "TestBrand",
@@ -101,9 +110,9 @@ func generateSampleOrder(i int) models.Order {
 			},
 			{
 				OrderUID:    orderUID,
-				ChrtID:      randInt(chrtIDMax),
+				ChrtID:      randInt(chrtIDMax) + 1,
 				TrackNumber: fmt.Sprintf("TRACK_%d", randInt(trackNumberMax)),
-				Price:       float64(randInt(priceMax)),
+				Price:       models.NewMoney(int64(randInt(priceMax)), 0),
 				Name:        "Test Item 2",
 				NMID:        randInt(nmidMax),
 				Brand:       "TestBrand",
@@ -0,0 +1,142 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+const (
+	HeaderContentType = "Content-Type"
+
+	ContentTypeJSON        = "application/json"
+	ContentTypeProtobuf    = "application/x-protobuf"
+	ContentTypeMessagePack = "application/msgpack"
+)
+
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// Codec encodes and decodes orders in one wire format.
+type Codec interface {
+	ContentType() string
+	Marshal(order *models.Order) ([]byte, error)
+	Unmarshal(data []byte, order *models.Order) error
+}
+
+// Registry selects a codec by content type. An empty content type selects
+// the default codec.
+type Registry struct {
+	codecs   map[string]Codec
+	fallback Codec
+}
+
+func NewRegistry(fallback Codec) *Registry {
+	r := &Registry{
+		codecs:   make(map[string]Codec),
+		fallback: fallback,
+	}
+
+	r.Register(fallback)
+
+	return r
+}
+
+// Default returns a registry with the JSON, Protobuf, MessagePack and WB
+// codecs, defaulting to JSON.
+func Default() *Registry {
+	r := NewRegistry(JSON{})
+	r.Register(Protobuf{}, "application/protobuf", "application/vnd.google.protobuf")
+	r.Register(MessagePack{}, "application/x-msgpack", "application/vnd.msgpack")
+	r.Register(WB{})
+
+	return r
+}
+
+// Register adds a codec under its own content type and any aliases.
+func (r *Registry) Register(c Codec, aliases ...string) {
+	r.codecs[c.ContentType()] = c
+
+	for _, alias := range aliases {
+		r.codecs[alias] = c
+	}
+}
+
+// Lookup returns the codec for a Content-Type value. Parameters such as
+// charset are ignored.
+func (r *Registry) Lookup(contentType string) (Codec, error) {
+	if strings.TrimSpace(contentType) == "" {
+		return r.fallback, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+	}
+
+	c, ok := r.codecs[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+	}
+
+	return c, nil
+}
+
+// Negotiate picks the codec for an HTTP Accept header, preferring higher
+// q-values. An empty header or a wildcard range selects the default codec.
+// It reports false when nothing acceptable is registered.
+func (r *Registry) Negotiate(accept string) (Codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return r.fallback, true
+	}
+
+	var (
+		best  Codec
+		bestQ float64
+	)
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		c := r.codecs[mediaType]
+		if mediaType == "*/*" || mediaType == "application/*" {
+			c = r.fallback
+		}
+
+		if c != nil && q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+
+	return best, best != nil
+}
+
+// Decode unmarshals data with the codec selected by contentType.
+func (r *Registry) Decode(contentType string, data []byte) (models.Order, error) {
+	var order models.Order
+
+	c, err := r.Lookup(contentType)
+	if err != nil {
+		return order, err
+	}
+
+	if err := c.Unmarshal(data, &order); err != nil {
+		return order, fmt.Errorf("codec %s: %w", c.ContentType(), err)
+	}
+
+	return order, nil
+}
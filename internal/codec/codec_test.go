@@ -0,0 +1,226 @@
+package codec_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/codec"
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/models/orderpb"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+type CodecSuite struct {
+	suite.Suite
+	registry *codec.Registry
+	order    models.Order
+}
+
+func (s *CodecSuite) SetupTest() {
+	s.registry = codec.Default()
+
+	created := time.Date(2024, 6, 1, 12, 0, 0, 123456000, time.UTC)
+
+	s.order = models.Order{
+		OrderUID:        "testUID123",
+		TrackNumber:     "TN1234567890",
+		Locale:          "en",
+		CustomerID:      "Cust123",
+		DeliveryService: "TestService",
+		SMID:            99,
+		DateCreated:     created,
+		Version:         7,
+		Delivery: models.Delivery{
+			OrderUID: "testUID123",
+			Name:     "John Doe",
+			Phone:    "+1234567890",
+			City:     "TestCity",
+			Address:  "123 Test St",
+		},
+		Payment: models.Payment{
+			OrderUID:    "testUID123",
+			Transaction: "TX1234567890",
+			Currency:    "USD",
+			Provider:    "TestProvider",
+			Amount:      models.MustParseMoney("12345678901234.123456789"),
+			CustomFee:   models.MustParseMoney("0.1"),
+			PaymentDT:   created,
+		},
+		Items: []models.Item{
+			{ChrtID: 1, OrderUID: "testUID123", Price: models.MustParseMoney("100"), Name: "Item 1", NMID: 1001, Status: 202},
+			{ChrtID: 2, OrderUID: "testUID123", Price: models.MustParseMoney("50.5"), Name: "Item 2", NMID: 1002, Sale: 30},
+		},
+	}
+}
+
+func TestCodecSuite(t *testing.T) {
+	suite.Run(t, new(CodecSuite))
+}
+
+func (s *CodecSuite) TestRoundTrip() {
+	for _, contentType := range []string{
+		codec.ContentTypeJSON, codec.ContentTypeProtobuf, codec.ContentTypeMessagePack,
+	} {
+		s.Run(contentType, func() {
+			c, err := s.registry.Lookup(contentType)
+			s.Require().NoError(err)
+			s.Require().Equal(contentType, c.ContentType())
+
+			data, err := c.Marshal(&s.order)
+			s.Require().NoError(err)
+
+			decoded, err := s.registry.Decode(contentType, data)
+			s.Require().NoError(err)
+
+			s.Require().True(s.order.DateCreated.Equal(decoded.DateCreated))
+			s.Require().True(s.order.Payment.PaymentDT.Equal(decoded.Payment.PaymentDT))
+
+			decoded.DateCreated, decoded.Payment.PaymentDT = s.order.DateCreated, s.order.Payment.PaymentDT
+			s.Require().Equal(s.order, decoded)
+		})
+	}
+}
+
+func (s *CodecSuite) TestLegacyFloatAmounts() {
+	want := models.MustParseMoney("29.99")
+
+	s.Run(codec.ContentTypeProtobuf, func() {
+		data, err := proto.Marshal(&orderpb.Order{Payment: &orderpb.Payment{Amount: 29.99}})
+		s.Require().NoError(err)
+
+		decoded, err := s.registry.Decode(codec.ContentTypeProtobuf, data)
+		s.Require().NoError(err)
+		s.Require().Equal(want, decoded.Payment.Amount)
+	})
+
+	s.Run(codec.ContentTypeMessagePack, func() {
+		data, err := msgpack.Marshal(map[string]any{"payment": map[string]any{"amount": 29.99, "goodsTotal": 30}})
+		s.Require().NoError(err)
+
+		decoded, err := s.registry.Decode(codec.ContentTypeMessagePack, data)
+		s.Require().NoError(err)
+		s.Require().Equal(want, decoded.Payment.Amount)
+		s.Require().Equal(models.NewMoney(30, 0), decoded.Payment.GoodsTotal)
+	})
+}
+
+func (s *CodecSuite) TestLookup() {
+	c, err := s.registry.Lookup("")
+	s.Require().NoError(err)
+	s.Require().Equal(codec.ContentTypeJSON, c.ContentType(), "JSON is the default")
+
+	c, err = s.registry.Lookup("application/json; charset=utf-8")
+	s.Require().NoError(err)
+	s.Require().Equal(codec.ContentTypeJSON, c.ContentType())
+
+	c, err = s.registry.Lookup("application/x-msgpack")
+	s.Require().NoError(err)
+	s.Require().Equal(codec.ContentTypeMessagePack, c.ContentType())
+
+	_, err = s.registry.Lookup("text/xml")
+	s.Require().ErrorIs(err, codec.ErrUnsupportedContentType)
+}
+
+func (s *CodecSuite) TestNegotiate() {
+	cases := []struct {
+		accept      string
+		contentType string
+	}{
+		{"", codec.ContentTypeJSON},
+		{"*/*", codec.ContentTypeJSON},
+		{"application/vnd.wb.order+json", codec.ContentTypeWB},
+		{"text/html, application/x-protobuf;q=0.5, application/msgpack;q=0.9", codec.ContentTypeMessagePack},
+		{"application/vnd.wb.order+json;q=0.1, */*;q=0.2", codec.ContentTypeJSON},
+	}
+
+	for _, tc := range cases {
+		c, ok := s.registry.Negotiate(tc.accept)
+		s.Require().True(ok, tc.accept)
+		s.Require().Equal(tc.contentType, c.ContentType(), tc.accept)
+	}
+
+	_, ok := s.registry.Negotiate("text/html, application/json;q=0")
+	s.Require().False(ok)
+}
+
+func (s *CodecSuite) TestWB() {
+	payload := []byte(`{
+		"order_uid": "b563feb7b2b84b6test",
+		"track_number": "WBILMTESTTRACK",
+		"entry": "WBIL",
+		"delivery": {"name": "Test Testov", "phone": "+9720000000", "city": "Kiryat Mozkin"},
+		"payment": {"transaction": "b563feb7b2b84b6test", "currency": "USD", "amount": 1817,
+			"payment_dt": 1637907727, "goods_total": 317},
+		"items": [{"chrt_id": 9934930, "track_number": "WBILMTESTTRACK", "price": 453,
+			"total_price": 317, "nm_id": 2389212, "status": 202}],
+		"locale": "en",
+		"customer_id": "test",
+		"sm_id": 99,
+		"date_created": "2021-11-26T06:22:19Z"
+	}`)
+
+	order, err := s.registry.Decode(codec.ContentTypeWB, payload)
+	s.Require().NoError(err)
+
+	s.Require().Equal("b563feb7b2b84b6test", order.OrderUID)
+	s.Require().Equal(order.OrderUID, order.Delivery.OrderUID)
+	s.Require().Equal(order.OrderUID, order.Payment.OrderUID)
+	s.Require().Equal(order.OrderUID, order.Items[0].OrderUID)
+	s.Require().Equal(9934930, order.Items[0].ChrtID)
+	s.Require().Equal(99, order.SMID)
+	s.Require().Equal(int64(1637907727), order.Payment.PaymentDT.Unix())
+	s.Require().True(time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC).Equal(order.DateCreated))
+
+	encoded, err := codec.WB{}.Marshal(&order)
+	s.Require().NoError(err)
+	s.Require().Contains(string(encoded), `"payment_dt":1637907727`)
+	s.Require().Contains(string(encoded), `"chrt_id":9934930`)
+	s.Require().NotContains(string(encoded), "orderUid")
+}
+
+func (s *CodecSuite) TestRawJSON() {
+	for _, c := range []codec.Codec{codec.JSON{}, codec.Protobuf{}, codec.MessagePack{}, codec.WB{}} {
+		s.Run(c.ContentType(), func() {
+			data, err := c.Marshal(&s.order)
+			s.Require().NoError(err)
+
+			raw, err := s.registry.RawJSON(c.ContentType(), data)
+			s.Require().NoError(err)
+			s.Require().Equal(c.ContentType(), raw.ContentType)
+			s.Require().True(json.Valid(raw.Payload))
+
+			decoded, err := s.registry.Decode(c.ContentType(), data)
+			s.Require().NoError(err)
+
+			fromRaw, err := s.registry.DecodeRaw(raw)
+			s.Require().NoError(err)
+
+			want, err := json.Marshal(decoded)
+			s.Require().NoError(err)
+			got, err := json.Marshal(fromRaw)
+			s.Require().NoError(err)
+			s.Require().JSONEq(string(want), string(got))
+		})
+	}
+}
+
+func (s *CodecSuite) TestRawJSONKeepsUnknownFields() {
+	payload := []byte(`{"orderUid":"testUID123","giftWrap":{"paper":"red"}}`)
+
+	raw, err := s.registry.RawJSON(codec.ContentTypeJSON, payload)
+	s.Require().NoError(err)
+	s.Require().JSONEq(string(payload), string(raw.Payload))
+
+	packed, err := msgpack.Marshal(map[string]any{"orderUid": "testUID123", "giftWrap": map[string]any{"paper": "red"}})
+	s.Require().NoError(err)
+
+	raw, err = s.registry.RawJSON(codec.ContentTypeMessagePack, packed)
+	s.Require().NoError(err)
+	s.Require().JSONEq(string(payload), string(raw.Payload))
+
+	_, err = s.registry.RawJSON(codec.ContentTypeJSON, []byte(`{"orderUid":`))
+	s.Require().Error(err)
+}
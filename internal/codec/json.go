@@ -0,0 +1,31 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type JSON struct{}
+
+func (JSON) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (JSON) Marshal(order *models.Order) ([]byte, error) {
+	data, err := json.Marshal(order)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal(...): %w", err)
+	}
+
+	return data, nil
+}
+
+func (JSON) Unmarshal(data []byte, order *models.Order) error {
+	if err := json.Unmarshal(data, order); err != nil {
+		return fmt.Errorf("json.Unmarshal(...): %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func init() {
+	msgpack.Register(models.Money{}, encodeMoney, decodeMoney)
+}
+
+// encodeMoney writes amounts as decimal strings, which keeps them exact.
+func encodeMoney(enc *msgpack.Encoder, v reflect.Value) error {
+	return enc.EncodeString(v.Interface().(models.Money).String()) //nolint:forcetypeassert
+}
+
+// decodeMoney reads decimal strings as well as the integers and floats sent
+// by producers that predate exact amounts.
+func decodeMoney(dec *msgpack.Decoder, v reflect.Value) error {
+	raw, err := dec.DecodeInterfaceLoose()
+	if err != nil {
+		return fmt.Errorf("msgpack decode money: %w", err)
+	}
+
+	var m models.Money
+
+	switch raw := raw.(type) {
+	case nil:
+	case string:
+		if m, err = models.ParseMoney(raw); err != nil {
+			return err
+		}
+	case int64:
+		m = models.NewMoney(raw, 0)
+	case uint64:
+		if m, err = models.ParseMoney(fmt.Sprint(raw)); err != nil {
+			return err
+		}
+	case float64:
+		m = models.MoneyFromFloat(raw)
+	default:
+		return fmt.Errorf("%w: msgpack %T", models.ErrInvalidMoney, raw)
+	}
+
+	v.Set(reflect.ValueOf(m))
+
+	return nil
+}
+
+// MessagePack encodes orders as maps keyed by their JSON field names, so
+// both formats share one definition in models.
+type MessagePack struct{}
+
+func (MessagePack) ContentType() string {
+	return ContentTypeMessagePack
+}
+
+func (MessagePack) Marshal(order *models.Order) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+
+	if err := enc.Encode(order); err != nil {
+		return nil, fmt.Errorf("msgpack Encode(...): %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (MessagePack) Unmarshal(data []byte, order *models.Order) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+
+	if err := dec.Decode(order); err != nil {
+		return fmt.Errorf("msgpack Decode(...): %w", err)
+	}
+
+	return nil
+}
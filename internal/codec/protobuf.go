@@ -0,0 +1,205 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/models/orderpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Protobuf encodes orders as orderpb.Order, see api/proto/order.proto.
+type Protobuf struct{}
+
+func (Protobuf) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (Protobuf) Marshal(order *models.Order) ([]byte, error) {
+	data, err := proto.Marshal(ToProto(order))
+	if err != nil {
+		return nil, fmt.Errorf("proto.Marshal(...): %w", err)
+	}
+
+	return data, nil
+}
+
+func (Protobuf) Unmarshal(data []byte, order *models.Order) error {
+	var pb orderpb.Order
+
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return fmt.Errorf("proto.Unmarshal(...): %w", err)
+	}
+
+	decoded, err := FromProto(&pb)
+	if err != nil {
+		return fmt.Errorf("FromProto(...): %w", err)
+	}
+
+	*order = decoded
+
+	return nil
+}
+
+func ToProto(o *models.Order) *orderpb.Order {
+	pb := &orderpb.Order{
+		OrderUid:          o.OrderUID,
+		TrackNumber:       o.TrackNumber,
+		Entry:             o.Entry,
+		Locale:            o.Locale,
+		InternalSignature: o.InternalSignature,
+		CustomerId:        o.CustomerID,
+		DeliveryService:   o.DeliveryService,
+		Shardkey:          o.Shardkey,
+		SmId:              int64(o.SMID),
+		DateCreated:       toTimestamp(o.DateCreated),
+		OofShard:          o.OOFShard,
+		Version:           o.Version,
+		Delivery: &orderpb.Delivery{
+			OrderUid: o.Delivery.OrderUID,
+			Name:     o.Delivery.Name,
+			Phone:    o.Delivery.Phone,
+			Zip:      o.Delivery.Zip,
+			City:     o.Delivery.City,
+			Address:  o.Delivery.Address,
+			Region:   o.Delivery.Region,
+			Email:    o.Delivery.Email,
+		},
+		Payment: &orderpb.Payment{
+			OrderUid:     o.Payment.OrderUID,
+			Transaction:  o.Payment.Transaction,
+			RequestId:    o.Payment.RequestID,
+			Currency:     o.Payment.Currency,
+			Provider:     o.Payment.Provider,
+			Amount:       o.Payment.Amount.Float64(),
+			PaymentDt:    toTimestamp(o.Payment.PaymentDT),
+			Bank:         o.Payment.Bank,
+			DeliveryCost: o.Payment.DeliveryCost.Float64(),
+			GoodsTotal:   o.Payment.GoodsTotal.Float64(),
+			CustomFee:    o.Payment.CustomFee.Float64(),
+
+			AmountDecimal:       o.Payment.Amount.String(),
+			DeliveryCostDecimal: o.Payment.DeliveryCost.String(),
+			GoodsTotalDecimal:   o.Payment.GoodsTotal.String(),
+			CustomFeeDecimal:    o.Payment.CustomFee.String(),
+		},
+		Items: make([]*orderpb.Item, 0, len(o.Items)),
+	}
+
+	for _, item := range o.Items {
+		pb.Items = append(pb.Items, &orderpb.Item{
+			ChrtId:      int64(item.ChrtID),
+			OrderUid:    item.OrderUID,
+			TrackNumber: item.TrackNumber,
+			Price:       item.Price.Float64(),
+			Rid:         item.RID,
+			Name:        item.Name,
+			Sale:        int64(item.Sale),
+			Size:        item.Size,
+			TotalPrice:  item.TotalPrice.Float64(),
+			NmId:        int64(item.NMID),
+			Brand:       item.Brand,
+			Status:      int64(item.Status),
+
+			PriceDecimal:      item.Price.String(),
+			TotalPriceDecimal: item.TotalPrice.String(),
+		})
+	}
+
+	return pb
+}
+
+func FromProto(pb *orderpb.Order) (models.Order, error) {
+	var errs []error
+
+	// money prefers the exact decimal field and falls back to the double sent
+	// by producers that predate it.
+	money := func(exact string, approx float64) models.Money {
+		if exact == "" {
+			return models.MoneyFromFloat(approx)
+		}
+
+		m, err := models.ParseMoney(exact)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		return m
+	}
+
+	order := models.Order{
+		OrderUID:          pb.GetOrderUid(),
+		TrackNumber:       pb.GetTrackNumber(),
+		Entry:             pb.GetEntry(),
+		Locale:            pb.GetLocale(),
+		InternalSignature: pb.GetInternalSignature(),
+		CustomerID:        pb.GetCustomerId(),
+		DeliveryService:   pb.GetDeliveryService(),
+		Shardkey:          pb.GetShardkey(),
+		SMID:              int(pb.GetSmId()),
+		DateCreated:       fromTimestamp(pb.GetDateCreated()),
+		OOFShard:          pb.GetOofShard(),
+		Version:           pb.GetVersion(),
+		Delivery: models.Delivery{
+			OrderUID: pb.GetDelivery().GetOrderUid(),
+			Name:     pb.GetDelivery().GetName(),
+			Phone:    pb.GetDelivery().GetPhone(),
+			Zip:      pb.GetDelivery().GetZip(),
+			City:     pb.GetDelivery().GetCity(),
+			Address:  pb.GetDelivery().GetAddress(),
+			Region:   pb.GetDelivery().GetRegion(),
+			Email:    pb.GetDelivery().GetEmail(),
+		},
+		Payment: models.Payment{
+			OrderUID:     pb.GetPayment().GetOrderUid(),
+			Transaction:  pb.GetPayment().GetTransaction(),
+			RequestID:    pb.GetPayment().GetRequestId(),
+			Currency:     pb.GetPayment().GetCurrency(),
+			Provider:     pb.GetPayment().GetProvider(),
+			Amount:       money(pb.GetPayment().GetAmountDecimal(), pb.GetPayment().GetAmount()),
+			PaymentDT:    fromTimestamp(pb.GetPayment().GetPaymentDt()),
+			Bank:         pb.GetPayment().GetBank(),
+			DeliveryCost: money(pb.GetPayment().GetDeliveryCostDecimal(), pb.GetPayment().GetDeliveryCost()),
+			GoodsTotal:   money(pb.GetPayment().GetGoodsTotalDecimal(), pb.GetPayment().GetGoodsTotal()),
+			CustomFee:    money(pb.GetPayment().GetCustomFeeDecimal(), pb.GetPayment().GetCustomFee()),
+		},
+	}
+
+	for _, item := range pb.GetItems() {
+		order.Items = append(order.Items, models.Item{
+			ChrtID:      int(item.GetChrtId()),
+			OrderUID:    item.GetOrderUid(),
+			TrackNumber: item.GetTrackNumber(),
+			Price:       money(item.GetPriceDecimal(), item.GetPrice()),
+			RID:         item.GetRid(),
+			Name:        item.GetName(),
+			Sale:        int(item.GetSale()),
+			Size:        item.GetSize(),
+			TotalPrice:  money(item.GetTotalPriceDecimal(), item.GetTotalPrice()),
+			NMID:        int(item.GetNmId()),
+			Brand:       item.GetBrand(),
+			Status:      int(item.GetStatus()),
+		})
+	}
+
+	return order, errors.Join(errs...)
+}
+
+func toTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
+
+func fromTimestamp(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+
+	return ts.AsTime()
+}
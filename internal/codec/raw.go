@@ -0,0 +1,148 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/models/orderpb"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var errInvalidJSON = errors.New("payload is not valid JSON")
+
+// RawCodec is implemented by codecs whose payloads can be kept as JSON next
+// to the decoded order, so that orders can be decoded again once the model
+// gains new fields.
+type RawCodec interface {
+	// RawJSON renders a payload as JSON, keeping the fields the order model
+	// doesn't know about.
+	RawJSON(data []byte) (json.RawMessage, error)
+	// UnmarshalRawJSON decodes JSON produced by RawJSON.
+	UnmarshalRawJSON(raw json.RawMessage, order *models.Order) error
+}
+
+// RawJSON renders data with the codec selected by contentType. It returns a
+// nil payload for codecs that can't keep one.
+func (r *Registry) RawJSON(contentType string, data []byte) (*models.RawPayload, error) {
+	c, err := r.Lookup(contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, ok := c.(RawCodec)
+	if !ok {
+		return nil, nil //nolint:nilnil
+	}
+
+	raw, err := rc.RawJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("codec %s: %w", c.ContentType(), err)
+	}
+
+	return &models.RawPayload{ContentType: c.ContentType(), Payload: raw}, nil
+}
+
+// DecodeRaw decodes a stored raw payload with the codec that produced it.
+func (r *Registry) DecodeRaw(raw *models.RawPayload) (models.Order, error) {
+	var order models.Order
+
+	c, err := r.Lookup(raw.ContentType)
+	if err != nil {
+		return order, err
+	}
+
+	rc, ok := c.(RawCodec)
+	if !ok {
+		return order, fmt.Errorf("%w: %q keeps no raw payloads", ErrUnsupportedContentType, raw.ContentType)
+	}
+
+	if err := rc.UnmarshalRawJSON(raw.Payload, &order); err != nil {
+		return order, fmt.Errorf("codec %s: %w", c.ContentType(), err)
+	}
+
+	return order, nil
+}
+
+func validJSON(data []byte) (json.RawMessage, error) {
+	if !json.Valid(data) {
+		return nil, errInvalidJSON
+	}
+
+	return bytes.Clone(data), nil
+}
+
+func (JSON) RawJSON(data []byte) (json.RawMessage, error) {
+	return validJSON(data)
+}
+
+func (c JSON) UnmarshalRawJSON(raw json.RawMessage, order *models.Order) error {
+	return c.Unmarshal(raw, order)
+}
+
+func (WB) RawJSON(data []byte) (json.RawMessage, error) {
+	return validJSON(data)
+}
+
+func (c WB) UnmarshalRawJSON(raw json.RawMessage, order *models.Order) error {
+	return c.Unmarshal(raw, order)
+}
+
+// RawJSON converts the MessagePack map to JSON. Its keys are the JSON field
+// names, so the result decodes like a JSON payload.
+func (MessagePack) RawJSON(data []byte) (json.RawMessage, error) {
+	var value interface{}
+
+	if err := msgpack.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("msgpack.Unmarshal(...): %w", err)
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal(...): %w", err)
+	}
+
+	return raw, nil
+}
+
+func (MessagePack) UnmarshalRawJSON(raw json.RawMessage, order *models.Order) error {
+	return JSON{}.Unmarshal(raw, order)
+}
+
+// RawJSON renders the message in the protobuf JSON mapping. Fields that
+// api/proto/order.proto doesn't declare can't be rendered and are lost.
+func (Protobuf) RawJSON(data []byte) (json.RawMessage, error) {
+	var pb orderpb.Order
+
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return nil, fmt.Errorf("proto.Unmarshal(...): %w", err)
+	}
+
+	raw, err := protojson.Marshal(&pb)
+	if err != nil {
+		return nil, fmt.Errorf("protojson.Marshal(...): %w", err)
+	}
+
+	return raw, nil
+}
+
+func (Protobuf) UnmarshalRawJSON(raw json.RawMessage, order *models.Order) error {
+	var pb orderpb.Order
+
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(raw, &pb); err != nil {
+		return fmt.Errorf("protojson.Unmarshal(...): %w", err)
+	}
+
+	decoded, err := FromProto(&pb)
+	if err != nil {
+		return fmt.Errorf("FromProto(...): %w", err)
+	}
+
+	*order = decoded
+
+	return nil
+}
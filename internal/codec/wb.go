@@ -0,0 +1,205 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+const ContentTypeWB = "application/vnd.wb.order+json"
+
+// WB reads and writes the original upstream order format documented in
+// api/openapi-orders.yml: snake_case keys, payment_dt in Unix seconds and no
+// order_uid on the nested sections.
+type WB struct{}
+
+type wbOrder struct {
+	OrderUID          string     `json:"order_uid"`
+	TrackNumber       string     `json:"track_number"`
+	Entry             string     `json:"entry"`
+	Delivery          wbDelivery `json:"delivery"`
+	Payment           wbPayment  `json:"payment"`
+	Items             []wbItem   `json:"items"`
+	Locale            string     `json:"locale"`
+	InternalSignature string     `json:"internal_signature"`
+	CustomerID        string     `json:"customer_id"`
+	DeliveryService   string     `json:"delivery_service"`
+	Shardkey          string     `json:"shardkey"`
+	SMID              int        `json:"sm_id"`
+	DateCreated       time.Time  `json:"date_created"`
+	OOFShard          string     `json:"oof_shard"`
+}
+
+type wbDelivery struct {
+	Name    string `json:"name"`
+	Phone   string `json:"phone"`
+	Zip     string `json:"zip"`
+	City    string `json:"city"`
+	Address string `json:"address"`
+	Region  string `json:"region"`
+	Email   string `json:"email"`
+}
+
+type wbPayment struct {
+	Transaction  string       `json:"transaction"`
+	RequestID    string       `json:"request_id"`
+	Currency     string       `json:"currency"`
+	Provider     string       `json:"provider"`
+	Amount       models.Money `json:"amount"`
+	PaymentDT    int64        `json:"payment_dt"`
+	Bank         string       `json:"bank"`
+	DeliveryCost models.Money `json:"delivery_cost"`
+	GoodsTotal   models.Money `json:"goods_total"`
+	CustomFee    models.Money `json:"custom_fee"`
+}
+
+type wbItem struct {
+	ChrtID      int          `json:"chrt_id"`
+	TrackNumber string       `json:"track_number"`
+	Price       models.Money `json:"price"`
+	RID         string       `json:"rid"`
+	Name        string       `json:"name"`
+	Sale        int          `json:"sale"`
+	Size        string       `json:"size"`
+	TotalPrice  models.Money `json:"total_price"`
+	NMID        int          `json:"nm_id"`
+	Brand       string       `json:"brand"`
+	Status      int          `json:"status"`
+}
+
+func (WB) ContentType() string {
+	return ContentTypeWB
+}
+
+func (WB) Marshal(o *models.Order) ([]byte, error) {
+	wb := wbOrder{
+		OrderUID:    o.OrderUID,
+		TrackNumber: o.TrackNumber,
+		Entry:       o.Entry,
+		Delivery: wbDelivery{
+			Name:    o.Delivery.Name,
+			Phone:   o.Delivery.Phone,
+			Zip:     o.Delivery.Zip,
+			City:    o.Delivery.City,
+			Address: o.Delivery.Address,
+			Region:  o.Delivery.Region,
+			Email:   o.Delivery.Email,
+		},
+		Locale:            o.Locale,
+		InternalSignature: o.InternalSignature,
+		CustomerID:        o.CustomerID,
+		DeliveryService:   o.DeliveryService,
+		Shardkey:          o.Shardkey,
+		SMID:              o.SMID,
+		DateCreated:       o.DateCreated,
+		OOFShard:          o.OOFShard,
+		Payment: wbPayment{
+			Transaction:  o.Payment.Transaction,
+			RequestID:    o.Payment.RequestID,
+			Currency:     o.Payment.Currency,
+			Provider:     o.Payment.Provider,
+			Amount:       o.Payment.Amount,
+			Bank:         o.Payment.Bank,
+			DeliveryCost: o.Payment.DeliveryCost,
+			GoodsTotal:   o.Payment.GoodsTotal,
+			CustomFee:    o.Payment.CustomFee,
+		},
+		Items: make([]wbItem, 0, len(o.Items)),
+	}
+
+	if !o.Payment.PaymentDT.IsZero() {
+		wb.Payment.PaymentDT = o.Payment.PaymentDT.Unix()
+	}
+
+	for _, item := range o.Items {
+		wb.Items = append(wb.Items, wbItem{
+			ChrtID:      item.ChrtID,
+			TrackNumber: item.TrackNumber,
+			Price:       item.Price,
+			RID:         item.RID,
+			Name:        item.Name,
+			Sale:        item.Sale,
+			Size:        item.Size,
+			TotalPrice:  item.TotalPrice,
+			NMID:        item.NMID,
+			Brand:       item.Brand,
+			Status:      item.Status,
+		})
+	}
+
+	data, err := json.Marshal(wb)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal(...): %w", err)
+	}
+
+	return data, nil
+}
+
+func (WB) Unmarshal(data []byte, order *models.Order) error {
+	var wb wbOrder
+
+	if err := json.Unmarshal(data, &wb); err != nil {
+		return fmt.Errorf("json.Unmarshal(...): %w", err)
+	}
+
+	*order = models.Order{
+		OrderUID:          wb.OrderUID,
+		TrackNumber:       wb.TrackNumber,
+		Entry:             wb.Entry,
+		Locale:            wb.Locale,
+		InternalSignature: wb.InternalSignature,
+		CustomerID:        wb.CustomerID,
+		DeliveryService:   wb.DeliveryService,
+		Shardkey:          wb.Shardkey,
+		SMID:              wb.SMID,
+		DateCreated:       wb.DateCreated,
+		OOFShard:          wb.OOFShard,
+		Delivery: models.Delivery{
+			OrderUID: wb.OrderUID,
+			Name:     wb.Delivery.Name,
+			Phone:    wb.Delivery.Phone,
+			Zip:      wb.Delivery.Zip,
+			City:     wb.Delivery.City,
+			Address:  wb.Delivery.Address,
+			Region:   wb.Delivery.Region,
+			Email:    wb.Delivery.Email,
+		},
+		Payment: models.Payment{
+			OrderUID:     wb.OrderUID,
+			Transaction:  wb.Payment.Transaction,
+			RequestID:    wb.Payment.RequestID,
+			Currency:     wb.Payment.Currency,
+			Provider:     wb.Payment.Provider,
+			Amount:       wb.Payment.Amount,
+			Bank:         wb.Payment.Bank,
+			DeliveryCost: wb.Payment.DeliveryCost,
+			GoodsTotal:   wb.Payment.GoodsTotal,
+			CustomFee:    wb.Payment.CustomFee,
+		},
+	}
+
+	if wb.Payment.PaymentDT != 0 {
+		order.Payment.PaymentDT = time.Unix(wb.Payment.PaymentDT, 0).UTC()
+	}
+
+	for _, item := range wb.Items {
+		order.Items = append(order.Items, models.Item{
+			ChrtID:      item.ChrtID,
+			OrderUID:    wb.OrderUID,
+			TrackNumber: item.TrackNumber,
+			Price:       item.Price,
+			RID:         item.RID,
+			Name:        item.Name,
+			Sale:        item.Sale,
+			Size:        item.Size,
+			TotalPrice:  item.TotalPrice,
+			NMID:        item.NMID,
+			Brand:       item.Brand,
+			Status:      item.Status,
+		})
+	}
+
+	return nil
+}
@@ -4,16 +4,131 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+const (
+	defaultNATSSubject         = "orders"
+	defaultNATSDeleteSubject   = "orders.delete"
+	defaultNATSContentType     = "application/json"
+	defaultNATSStreamName      = "ORDERS"
+	defaultNATSStreamRetention = "limits"
+	defaultNATSStreamStorage   = "file"
+	defaultNATSStreamReplicas  = 1
+	defaultNATSStreamMaxBytes  = -1
+	defaultNATSDurableName     = "order_tracker"
+	defaultNATSAckWait         = 30 * time.Second
+	defaultNATSMaxAckPending   = 1000
+	defaultNATSDeliverPolicy   = "all"
+
+	defaultNATSMaxDeliver        = 5
+	defaultNATSRetryBaseDelay    = 2 * time.Second
+	defaultNATSRetryMaxDelay     = time.Minute
+	defaultNATSDeadLetterStream  = "ORDERS_DLQ"
+	defaultNATSDeadLetterSubject = "dlq.orders"
+
+	ConsumerModePush = "push"
+	ConsumerModePull = "pull"
+
+	defaultNATSPullBatchSize = 100
+	defaultNATSPullMaxWait   = time.Second
+	defaultNATSPullWorkers   = 1
+
+	defaultNATSWorkers = 8
+
+	defaultOutboxSubject   = "orders.events"
+	defaultOutboxInterval  = time.Second
+	defaultOutboxBatchSize = 100
+	defaultOutboxRetention = 24 * time.Hour
+
+	defaultReplicaMaxLag        = 5 * time.Second
+	defaultReplicaCheckInterval = 5 * time.Second
+
+	defaultCacheSyncSubject   = "orders.cache"
+	defaultCacheSyncHeartbeat = 10 * time.Second
+
+	defaultCacheWarmupChunkSize        = 1000
+	defaultCacheWarmupWorkers          = 4
+	defaultCacheWarmupProgressInterval = 5 * time.Second
+
+	defaultPartitionPremakeMonths       = 3
+	defaultPartitionArchiveDir          = "./archive"
+	defaultPartitionMaintenanceInterval = time.Hour
+
+	StorageBackendPostgres = "postgres"
+	StorageBackendMemory   = "memory"
+	StorageBackendFile     = "file"
+
+	defaultStorageFile = "./data/orders.ndjson"
+)
+
 type Config struct {
 	DatabaseURL string
 	AppPort     string
 	AppHost     string
 	LogLevel    string
 	NATSURL     string
+
+	ReplicaURLs          []string
+	ReplicaMaxLag        time.Duration
+	ReplicaCheckInterval time.Duration
+
+	NATSSubject         string
+	NATSDeleteSubject   string
+	NATSContentType     string
+	NATSSubjectCodecs   map[string]string
+	NATSStreamName      string
+	NATSStreamSubjects  []string
+	NATSStreamRetention string
+	NATSStreamMaxAge    time.Duration
+	NATSStreamMaxBytes  int64
+	NATSStreamReplicas  int
+	NATSStreamStorage   string
+	NATSDurableName     string
+	NATSAckWait         time.Duration
+	NATSMaxAckPending   int
+	NATSDeliverPolicy   string
+
+	NATSMaxDeliver        int
+	NATSRetryBaseDelay    time.Duration
+	NATSRetryMaxDelay     time.Duration
+	NATSDeadLetterStream  string
+	NATSDeadLetterSubject string
+
+	NATSConsumerMode  string
+	NATSPullBatchSize int
+	NATSPullMaxWait   time.Duration
+	NATSPullWorkers   int
+	NATSWorkers       int
+
+	OutboxSubject   string
+	OutboxInterval  time.Duration
+	OutboxBatchSize int
+	OutboxRetention time.Duration
+
+	CacheSyncSubject   string
+	CacheSyncHeartbeat time.Duration
+
+	CacheWarmupChunkSize        int
+	CacheWarmupWorkers          int
+	CacheWarmupMaxOrders        int
+	CacheWarmupMaxAge           time.Duration
+	CacheWarmupProgressInterval time.Duration
+
+	PartitionPremakeMonths       int
+	PartitionRetentionMonths     int
+	PartitionArchiveDir          string
+	PartitionMaintenanceInterval time.Duration
+
+	StorageBackend string
+	StorageFile    string
+
+	MigrateOnStart bool
 }
 
 func New(path string) *Config {
@@ -31,24 +146,47 @@ func New(path string) *Config {
 	appPort := os.Getenv("APP_PORT")
 	logLevel := os.Getenv("LOG_LEVEL")
 	natsURL := os.Getenv("NATS_URL")
+	consumerMode := getEnv("NATS_CONSUMER_MODE", ConsumerModePush)
+	natsSubject := getEnv("NATS_SUBJECT", defaultNATSSubject)
+	natsDeleteSubject := getEnv("NATS_DELETE_SUBJECT", defaultNATSDeleteSubject)
+	subjectCodecs := getEnvMap("NATS_SUBJECT_CONTENT_TYPES")
+	storageBackend := getEnv("STORAGE_BACKEND", StorageBackendPostgres)
+	usesPostgres := storageBackend == StorageBackendPostgres
+
+	streamSubjects := []string{natsSubject, natsDeleteSubject}
+	for subject := range subjectCodecs {
+		if !slices.Contains(streamSubjects, subject) {
+			streamSubjects = append(streamSubjects, subject)
+		}
+	}
+
+	defaultDurableName := defaultNATSDurableName
+	if consumerMode == ConsumerModePull {
+		defaultDurableName += "_pull"
+	}
 
 	var dsn string
 
 	switch {
-	case postgresHost == "":
+	case usesPostgres && postgresHost == "":
 		panic("postgresHost environment variable is missing")
-	case postgresPort == "":
+	case usesPostgres && postgresPort == "":
 		panic("postgresPort environment variable is missing")
-	case postgresUser == "":
+	case usesPostgres && postgresUser == "":
 		panic("postgresUser environment variable is missing")
-	case postgresPassword == "":
+	case usesPostgres && postgresPassword == "":
 		panic("postgresPassword environment variable is missing")
-	case postgresDB == "":
+	case usesPostgres && postgresDB == "":
 		panic("postgresDB environment variable is missing")
 	case appPort == "":
 		panic("appPort environment variable is missing")
 	case natsURL == "":
 		panic("natsURL environment variable is missing")
+	case consumerMode != ConsumerModePush && consumerMode != ConsumerModePull:
+		panic(fmt.Sprintf("NATS_CONSUMER_MODE must be %q or %q, got %q", ConsumerModePush, ConsumerModePull, consumerMode))
+	case !usesPostgres && storageBackend != StorageBackendMemory && storageBackend != StorageBackendFile:
+		panic(fmt.Sprintf("STORAGE_BACKEND must be %q, %q or %q, got %q",
+			StorageBackendPostgres, StorageBackendMemory, StorageBackendFile, storageBackend))
 	default:
 		hostPort := net.JoinHostPort(postgresHost, postgresPort)
 		dsn = fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable",
@@ -60,6 +198,161 @@ func New(path string) *Config {
 			AppHost:     appHost,
 			LogLevel:    logLevel,
 			NATSURL:     natsURL,
+
+			ReplicaURLs:          getEnvList("POSTGRES_REPLICA_URLS", nil),
+			ReplicaMaxLag:        getEnvDuration("POSTGRES_REPLICA_MAX_LAG", defaultReplicaMaxLag),
+			ReplicaCheckInterval: getEnvDuration("POSTGRES_REPLICA_CHECK_INTERVAL", defaultReplicaCheckInterval),
+
+			NATSSubject:         natsSubject,
+			NATSDeleteSubject:   natsDeleteSubject,
+			NATSContentType:     getEnv("NATS_CONTENT_TYPE", defaultNATSContentType),
+			NATSSubjectCodecs:   subjectCodecs,
+			NATSStreamName:      getEnv("NATS_STREAM_NAME", defaultNATSStreamName),
+			NATSStreamSubjects:  getEnvList("NATS_STREAM_SUBJECTS", streamSubjects),
+			NATSStreamRetention: getEnv("NATS_STREAM_RETENTION", defaultNATSStreamRetention),
+			NATSStreamMaxAge:    getEnvDuration("NATS_STREAM_MAX_AGE", 0),
+			NATSStreamMaxBytes:  getEnvInt64("NATS_STREAM_MAX_BYTES", defaultNATSStreamMaxBytes),
+			NATSStreamReplicas:  getEnvInt("NATS_STREAM_REPLICAS", defaultNATSStreamReplicas),
+			NATSStreamStorage:   getEnv("NATS_STREAM_STORAGE", defaultNATSStreamStorage),
+			NATSDurableName:     getEnv("NATS_DURABLE_NAME", defaultDurableName),
+			NATSAckWait:         getEnvDuration("NATS_ACK_WAIT", defaultNATSAckWait),
+			NATSMaxAckPending:   getEnvInt("NATS_MAX_ACK_PENDING", defaultNATSMaxAckPending),
+			NATSDeliverPolicy:   getEnv("NATS_DELIVER_POLICY", defaultNATSDeliverPolicy),
+
+			NATSMaxDeliver:        getEnvInt("NATS_MAX_DELIVER", defaultNATSMaxDeliver),
+			NATSRetryBaseDelay:    getEnvDuration("NATS_RETRY_BASE_DELAY", defaultNATSRetryBaseDelay),
+			NATSRetryMaxDelay:     getEnvDuration("NATS_RETRY_MAX_DELAY", defaultNATSRetryMaxDelay),
+			NATSDeadLetterStream:  getEnv("NATS_DEAD_LETTER_STREAM", defaultNATSDeadLetterStream),
+			NATSDeadLetterSubject: getEnv("NATS_DEAD_LETTER_SUBJECT", defaultNATSDeadLetterSubject),
+
+			NATSConsumerMode:  consumerMode,
+			NATSPullBatchSize: getEnvInt("NATS_PULL_BATCH_SIZE", defaultNATSPullBatchSize),
+			NATSPullMaxWait:   getEnvDuration("NATS_PULL_MAX_WAIT", defaultNATSPullMaxWait),
+			NATSPullWorkers:   getEnvInt("NATS_PULL_WORKERS", defaultNATSPullWorkers),
+			NATSWorkers:       getEnvInt("NATS_WORKERS", defaultNATSWorkers),
+
+			OutboxSubject:   getEnv("OUTBOX_SUBJECT", defaultOutboxSubject),
+			OutboxInterval:  getEnvDuration("OUTBOX_INTERVAL", defaultOutboxInterval),
+			OutboxBatchSize: getEnvInt("OUTBOX_BATCH_SIZE", defaultOutboxBatchSize),
+			OutboxRetention: getEnvDuration("OUTBOX_RETENTION", defaultOutboxRetention),
+
+			CacheSyncSubject:   getEnv("CACHE_SYNC_SUBJECT", defaultCacheSyncSubject),
+			CacheSyncHeartbeat: getEnvDuration("CACHE_SYNC_HEARTBEAT", defaultCacheSyncHeartbeat),
+
+			CacheWarmupChunkSize: getEnvInt("CACHE_WARMUP_CHUNK_SIZE", defaultCacheWarmupChunkSize),
+			CacheWarmupWorkers:   getEnvInt("CACHE_WARMUP_WORKERS", defaultCacheWarmupWorkers),
+			CacheWarmupMaxOrders: getEnvInt("CACHE_WARMUP_MAX_ORDERS", 0),
+			CacheWarmupMaxAge:    getEnvDuration("CACHE_WARMUP_MAX_AGE", 0),
+			CacheWarmupProgressInterval: getEnvDuration(
+				"CACHE_WARMUP_PROGRESS_INTERVAL", defaultCacheWarmupProgressInterval),
+
+			PartitionPremakeMonths:   getEnvInt("PARTITION_PREMAKE_MONTHS", defaultPartitionPremakeMonths),
+			PartitionRetentionMonths: getEnvInt("PARTITION_RETENTION_MONTHS", 0),
+			PartitionArchiveDir:      getEnv("PARTITION_ARCHIVE_DIR", defaultPartitionArchiveDir),
+			PartitionMaintenanceInterval: getEnvDuration(
+				"PARTITION_MAINTENANCE_INTERVAL", defaultPartitionMaintenanceInterval),
+
+			StorageBackend: storageBackend,
+			StorageFile:    getEnv("STORAGE_FILE", defaultStorageFile),
+
+			MigrateOnStart: getEnvBool("MIGRATE_ON_START", true),
 		}
 	}
 }
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		panic(fmt.Sprintf("%s environment variable must be an integer, got %q", key, value))
+	}
+
+	return parsed
+}
+
+func getEnvInt64(key string, fallback int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("%s environment variable must be an integer, got %q", key, value))
+	}
+
+	return parsed
+}
+
+func getEnvList(key string, fallback []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	var list []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// getEnvMap parses a comma-separated list of key=value pairs.
+func getEnvMap(key string) map[string]string {
+	pairs := make(map[string]string)
+
+	for _, item := range getEnvList(key, nil) {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			panic(fmt.Sprintf("%s environment variable must be a list of key=value pairs, got %q", key, item))
+		}
+
+		pairs[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	return pairs
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		panic(fmt.Sprintf("%s environment variable must be a duration, got %q", key, value))
+	}
+
+	return parsed
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		panic(fmt.Sprintf("%s environment variable must be a boolean, got %q", key, value))
+	}
+
+	return parsed
+}
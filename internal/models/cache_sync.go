@@ -0,0 +1,17 @@
+package models
+
+// CacheInvalidation tells the other service instances that an order changed
+// in storage. Seq numbers the invalidations of one instance consecutively,
+// so a receiver that sees a gap knows it missed some. A heartbeat carries no
+// order and repeats the sender's last Seq.
+type CacheInvalidation struct {
+	Instance string `json:"instance"`
+	Seq      uint64 `json:"seq"`
+	OrderUID string `json:"orderUid,omitempty"`
+	Version  uint64 `json:"version,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+func (i CacheInvalidation) IsHeartbeat() bool {
+	return i.OrderUID == ""
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrPreconditionFailed = errors.New("order does not match the precondition")
+
+// ETag returns a strong entity tag identifying the content of the order,
+// version included. It is computed from the order as storage keeps it, so
+// an order hashes the same whether it was read from the cache or from any
+// storage backend.
+func (o *Order) ETag() string {
+	canonical := *o
+	canonical.Source = Source{}
+	canonical.Raw = nil
+	canonical.DateCreated = etagTime(o.DateCreated)
+	canonical.Delivery.OrderUID = o.OrderUID
+	canonical.Payment.OrderUID = o.OrderUID
+	canonical.Payment.PaymentDT = etagTime(o.Payment.PaymentDT)
+	canonical.Items = nil
+
+	if len(o.Items) > 0 {
+		canonical.Items = slices.Clone(o.Items)
+
+		for i := range canonical.Items {
+			canonical.Items[i].OrderUID = o.OrderUID
+		}
+
+		slices.SortFunc(canonical.Items, func(a, b Item) int { return a.ChrtID - b.ChrtID })
+	}
+
+	data, _ := json.Marshal(canonical) //nolint:errchkjson // Order always marshals
+
+	sum := sha256.Sum256(data)
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagTime keeps a time the way timestamp columns do: the wall clock time,
+// to the microsecond.
+func etagTime(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(),
+		t.Nanosecond()/int(time.Microsecond)*int(time.Microsecond), time.UTC)
+}
+
+// MatchIfMatch reports whether the stored order satisfies an If-Match header
+// value: "*" matches any live order, and a list of entity tags matches when
+// one of them is the order's ETag. Weak tags never match. stored is nil when
+// there is no live order.
+func MatchIfMatch(header string, stored *Order) bool {
+	if stored == nil {
+		return false
+	}
+
+	return matchETags(header, stored.ETag(), false)
+}
+
+// MatchIfNoneMatch reports whether an If-None-Match header value names the
+// order's current representation, comparing tags weakly.
+func MatchIfNoneMatch(header string, order *Order) bool {
+	return matchETags(header, order.ETag(), true)
+}
+
+func matchETags(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" {
+			return true
+		}
+
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		if tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
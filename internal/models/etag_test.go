@@ -0,0 +1,70 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type ETagSuite struct {
+	suite.Suite
+}
+
+func TestETagSuite(t *testing.T) {
+	suite.Run(t, new(ETagSuite))
+}
+
+func etagOrder() *models.Order {
+	return &models.Order{
+		OrderUID:    "testUID123",
+		Version:     3,
+		DateCreated: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+		Items: []models.Item{
+			{ChrtID: 1, OrderUID: "testUID123", Price: models.MustParseMoney("10")},
+			{ChrtID: 2, OrderUID: "testUID123", Price: models.MustParseMoney("20")},
+		},
+	}
+}
+
+// Orders differing only in how storage would normalize them share an ETag.
+func (s *ETagSuite) TestETagIsCanonical() {
+	order := etagOrder()
+	etag := order.ETag()
+
+	s.Require().Regexp(`^"[0-9a-f]{32}"$`, etag)
+
+	same := etagOrder()
+	same.DateCreated = same.DateCreated.Add(300 * time.Nanosecond)
+	same.Items[0], same.Items[1] = same.Items[1], same.Items[0]
+	same.Source = models.Source{Subject: "orders"}
+	same.IfMatch = etag
+	s.Require().Equal(etag, same.ETag())
+
+	for name, change := range map[string]func(*models.Order){
+		"version": func(o *models.Order) { o.Version++ },
+		"item":    func(o *models.Order) { o.Items[1].Price = models.MustParseMoney("21") },
+		"created": func(o *models.Order) { o.DateCreated = o.DateCreated.Add(time.Microsecond) },
+	} {
+		changed := etagOrder()
+		change(changed)
+		s.Require().NotEqual(etag, changed.ETag(), name)
+	}
+}
+
+func (s *ETagSuite) TestMatch() {
+	order := etagOrder()
+	etag := order.ETag()
+
+	s.Require().True(models.MatchIfMatch(etag, order))
+	s.Require().True(models.MatchIfMatch(`"other", `+etag, order))
+	s.Require().True(models.MatchIfMatch("*", order))
+	s.Require().False(models.MatchIfMatch(`"other"`, order))
+	s.Require().False(models.MatchIfMatch("W/"+etag, order), "weak tags never match If-Match")
+	s.Require().False(models.MatchIfMatch("*", nil))
+
+	s.Require().True(models.MatchIfNoneMatch("W/"+etag, order))
+	s.Require().True(models.MatchIfNoneMatch("*", order))
+	s.Require().False(models.MatchIfNoneMatch(`"other"`, order))
+}
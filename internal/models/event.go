@@ -0,0 +1,102 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	ChangeTypeCreated = "order.created"
+	ChangeTypeUpdated = "order.updated"
+	ChangeTypeDeleted = "order.deleted"
+
+	SectionOrder    = "order"
+	SectionDelivery = "delivery"
+	SectionPayment  = "payment"
+	SectionItems    = "items"
+)
+
+type OrderEvent struct {
+	ID              int64     `json:"id"`
+	OrderUID        string    `json:"orderUid"`
+	Version         uint64    `json:"version"`
+	ChangeType      string    `json:"changeType"`
+	ChangedSections []string  `json:"changedSections"`
+	OccurredAt      time.Time `json:"occurredAt"`
+}
+
+// ChangedSections lists the sections of o that differ from prev. A nil prev
+// means the order is new and every section is reported.
+func (o *Order) ChangedSections(prev *Order) []string {
+	if prev == nil {
+		return []string{SectionOrder, SectionDelivery, SectionPayment, SectionItems}
+	}
+
+	var sections []string
+
+	if !o.sameHeader(prev) {
+		sections = append(sections, SectionOrder)
+	}
+
+	if o.Delivery.withoutOrderUID() != prev.Delivery.withoutOrderUID() {
+		sections = append(sections, SectionDelivery)
+	}
+
+	if !o.Payment.same(&prev.Payment) {
+		sections = append(sections, SectionPayment)
+	}
+
+	if !sameItems(o.Items, prev.Items) {
+		sections = append(sections, SectionItems)
+	}
+
+	return sections
+}
+
+func (o *Order) sameHeader(other *Order) bool {
+	return o.OrderUID == other.OrderUID &&
+		o.TrackNumber == other.TrackNumber &&
+		o.Entry == other.Entry &&
+		o.Locale == other.Locale &&
+		o.InternalSignature == other.InternalSignature &&
+		o.CustomerID == other.CustomerID &&
+		o.DeliveryService == other.DeliveryService &&
+		o.Shardkey == other.Shardkey &&
+		o.SMID == other.SMID &&
+		o.OOFShard == other.OOFShard &&
+		wallClock(o.DateCreated).Equal(wallClock(other.DateCreated))
+}
+
+func (d Delivery) withoutOrderUID() Delivery {
+	d.OrderUID = ""
+
+	return d
+}
+
+func (p *Payment) same(other *Payment) bool {
+	a, b := *p, *other
+	a.OrderUID, b.OrderUID = "", ""
+	a.PaymentDT, b.PaymentDT = wallClock(a.PaymentDT), wallClock(b.PaymentDT)
+
+	return a == b
+}
+
+func sameItems(a, b []Item) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	byChrtID := func(x, y Item) int { return x.ChrtID - y.ChrtID }
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.SortFunc(a, byChrtID)
+	slices.SortFunc(b, byChrtID)
+
+	return slices.Equal(a, b)
+}
+
+// wallClock drops the location and sub-microsecond precision, which is what
+// survives a round-trip through a Postgres TIMESTAMP column.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(),
+		t.Nanosecond()/int(time.Microsecond)*int(time.Microsecond), time.UTC)
+}
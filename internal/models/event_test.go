@@ -0,0 +1,72 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type ChangedSectionsSuite struct {
+	suite.Suite
+	order models.Order
+}
+
+func (s *ChangedSectionsSuite) SetupTest() {
+	created := time.Date(2024, 6, 1, 12, 0, 0, 123456789, time.FixedZone("MSK", 3*60*60))
+
+	s.order = models.Order{
+		OrderUID:    "testUID123",
+		TrackNumber: "TN1234567890",
+		DateCreated: created,
+		Delivery:    models.Delivery{OrderUID: "testUID123", Name: "John Doe"},
+		Payment:     models.Payment{OrderUID: "testUID123", Amount: models.MustParseMoney("150"), PaymentDT: created},
+		Items: []models.Item{
+			{ChrtID: 1, OrderUID: "testUID123", Price: models.MustParseMoney("100")},
+			{ChrtID: 2, OrderUID: "testUID123", Price: models.MustParseMoney("50")},
+		},
+	}
+}
+
+func TestChangedSectionsSuite(t *testing.T) {
+	suite.Run(t, new(ChangedSectionsSuite))
+}
+
+func (s *ChangedSectionsSuite) stored() *models.Order {
+	stored := s.order
+	stored.Delivery.OrderUID = ""
+	stored.Payment.OrderUID = ""
+	stored.DateCreated = time.Date(2024, 6, 1, 12, 0, 0, 123456000, time.UTC)
+	stored.Payment.PaymentDT = stored.DateCreated
+	stored.Items = []models.Item{s.order.Items[1], s.order.Items[0]}
+
+	return &stored
+}
+
+func (s *ChangedSectionsSuite) TestNewOrder() {
+	s.Require().Equal([]string{
+		models.SectionOrder, models.SectionDelivery, models.SectionPayment, models.SectionItems,
+	}, s.order.ChangedSections(nil))
+}
+
+func (s *ChangedSectionsSuite) TestUnchanged() {
+	s.Require().Empty(s.order.ChangedSections(s.stored()))
+}
+
+func (s *ChangedSectionsSuite) TestChangedSections() {
+	prev := s.stored()
+
+	s.order.TrackNumber = "TN0"
+	s.order.Items[1].Price = models.MustParseMoney("60")
+
+	s.Require().Equal([]string{models.SectionOrder, models.SectionItems}, s.order.ChangedSections(prev))
+}
+
+func (s *ChangedSectionsSuite) TestItemRemoved() {
+	prev := s.stored()
+
+	s.order.Items = s.order.Items[:1]
+
+	s.Require().Equal([]string{models.SectionItems}, s.order.ChangedSections(prev))
+}
@@ -0,0 +1,194 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// Money is an exact decimal amount. It keeps the canonical decimal text
+// (no trailing zeros, empty for zero), so amounts compare with == and
+// survive JSON, NATS payloads and DECIMAL columns without rounding. In JSON
+// it is written as a plain number, like the float64 it replaces.
+type Money struct {
+	value string
+}
+
+// NewMoney returns units * 10^exp, e.g. NewMoney(2999, -2) is 29.99.
+func NewMoney(units int64, exp int32) Money {
+	return moneyFromDecimal(decimal.New(units, exp))
+}
+
+// Limits of a parsed amount. An exponent like 1e2000000000 takes a few
+// bytes to send but gigabytes to print, so amounts are checked before their
+// decimal text is built.
+const (
+	maxMoneyDigits = 38
+	maxMoneyScale  = 30
+)
+
+// ParseMoney parses a decimal number such as "29.99", "-1.5" or "1e3". It
+// rejects amounts with more than maxMoneyDigits digits or more than
+// maxMoneyScale digits after the decimal point.
+func ParseMoney(s string) (Money, error) {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w %q: %w", ErrInvalidMoney, s, err)
+	}
+
+	if d.IsZero() {
+		return Money{}, nil
+	}
+
+	exp := int64(d.Exponent())
+	if exp < -maxMoneyScale || exp > maxMoneyDigits || int64(d.NumDigits())+max(exp, 0) > maxMoneyDigits {
+		return Money{}, fmt.Errorf("%w %q: out of range", ErrInvalidMoney, s)
+	}
+
+	return moneyFromDecimal(d), nil
+}
+
+// MustParseMoney is ParseMoney for constants; it panics on invalid input.
+func MustParseMoney(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// MoneyFromFloat converts a float to the shortest decimal that rounds to the
+// same float, so 29.99 stays 29.99. It exists for producers that still send
+// amounts as floating point numbers.
+func MoneyFromFloat(f float64) Money {
+	return moneyFromDecimal(decimal.NewFromFloat(f))
+}
+
+func moneyFromDecimal(d decimal.Decimal) Money {
+	if d.IsZero() {
+		return Money{}
+	}
+
+	return Money{value: d.String()}
+}
+
+func (m Money) Decimal() decimal.Decimal {
+	if m.value == "" {
+		return decimal.Zero
+	}
+
+	return decimal.RequireFromString(m.value)
+}
+
+func (m Money) String() string {
+	if m.value == "" {
+		return "0"
+	}
+
+	return m.value
+}
+
+func (m Money) IsZero() bool {
+	return m.value == ""
+}
+
+func (m Money) IsNegative() bool {
+	return m.value != "" && m.value[0] == '-'
+}
+
+// Float64 returns the nearest float, for formats that only carry doubles.
+func (m Money) Float64() float64 {
+	f, _ := m.Decimal().Float64()
+
+	return f
+}
+
+func (m Money) Add(other Money) Money {
+	return moneyFromDecimal(m.Decimal().Add(other.Decimal()))
+}
+
+func (m Money) Cmp(other Money) int {
+	return m.Decimal().Cmp(other.Decimal())
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a number, a quoted decimal string or null.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*m = Money{}
+
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidMoney, err)
+		}
+
+		data = []byte(s)
+	}
+
+	parsed, err := ParseMoney(string(data))
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+
+	return nil
+}
+
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalText(data []byte) error {
+	parsed, err := ParseMoney(string(data))
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+
+	return nil
+}
+
+// Value stores the amount as its decimal text, which Postgres parses into a
+// DECIMAL column exactly.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = Money{}
+
+		return nil
+	case string:
+		return m.UnmarshalText([]byte(v))
+	case []byte:
+		return m.UnmarshalText(v)
+	case int64:
+		*m = NewMoney(v, 0)
+
+		return nil
+	case float64:
+		*m = MoneyFromFloat(v)
+
+		return nil
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidMoney, src)
+	}
+}
@@ -0,0 +1,134 @@
+package models_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type MoneySuite struct {
+	suite.Suite
+}
+
+func TestMoneySuite(t *testing.T) {
+	suite.Run(t, new(MoneySuite))
+}
+
+func (s *MoneySuite) TestParse() {
+	s.Require().Equal(models.MustParseMoney("29.99"), models.MustParseMoney("29.990"), "Trailing zeros are dropped")
+	s.Require().Equal(models.MustParseMoney("1000"), models.MustParseMoney("1e3"))
+	s.Require().Equal(models.Money{}, models.MustParseMoney("0.00"))
+	s.Require().Equal(models.NewMoney(2999, -2), models.MustParseMoney("29.99"))
+	s.Require().Equal("0", models.Money{}.String())
+
+	_, err := models.ParseMoney("12,5")
+	s.Require().ErrorIs(err, models.ErrInvalidMoney)
+
+	for _, amount := range []string{"1e20000000", "1e2000000000", "1e-31", "123456789012345678901234567890123456789", "1e38"} {
+		_, err := models.ParseMoney(amount)
+		s.Require().ErrorIs(err, models.ErrInvalidMoney, amount)
+	}
+
+	s.Require().Equal("99999999999999999999999999999999999999", models.MustParseMoney("99999999999999999999999999999999999999").String())
+	s.Require().Equal("0.000000000000000000000000000001", models.MustParseMoney("1e-30").String())
+	s.Require().Equal(models.Money{}, models.MustParseMoney("0e2000000000"))
+
+	var payment models.Payment
+	s.Require().ErrorIs(json.Unmarshal([]byte(`{"amount":1e2000000000}`), &payment), models.ErrInvalidMoney)
+
+	s.Require().True(models.MustParseMoney("-0.01").IsNegative())
+	s.Require().False(models.Money{}.IsNegative())
+}
+
+func (s *MoneySuite) TestArithmetic() {
+	var total models.Money
+	for range 10 {
+		total = total.Add(models.MustParseMoney("0.1"))
+	}
+
+	s.Require().Equal(models.NewMoney(1, 0), total, "No float rounding artifacts")
+	s.Require().Equal(1, total.Cmp(models.MustParseMoney("0.99")))
+}
+
+func (s *MoneySuite) TestJSON() {
+	payment := models.Payment{Amount: models.MustParseMoney("12345678901234.123456789")}
+
+	data, err := json.Marshal(payment)
+	s.Require().NoError(err)
+	s.Require().Contains(string(data), `"amount":12345678901234.123456789`, "Amounts are plain JSON numbers")
+
+	var decoded models.Payment
+	s.Require().NoError(json.Unmarshal(data, &decoded))
+	s.Require().Equal(payment.Amount, decoded.Amount)
+
+	s.Require().NoError(json.Unmarshal([]byte(`{"amount":"29.99","goodsTotal":null,"customFee":5}`), &decoded))
+	s.Require().Equal(models.MustParseMoney("29.99"), decoded.Amount)
+	s.Require().True(decoded.GoodsTotal.IsZero())
+	s.Require().Equal(models.NewMoney(5, 0), decoded.CustomFee)
+
+	s.Require().Error(json.Unmarshal([]byte(`{"amount":true}`), &decoded))
+}
+
+func (s *MoneySuite) TestJSON_MatchesFloatPayment() {
+	// legacyPayment is Payment as it was before amounts became Money.
+	type legacyPayment struct {
+		OrderUID     string    `json:"orderUid"`
+		Transaction  string    `json:"transaction"`
+		RequestID    string    `json:"requestId,omitempty"`
+		Currency     string    `json:"currency"`
+		Provider     string    `json:"provider"`
+		Amount       float64   `json:"amount"`
+		PaymentDT    time.Time `json:"paymentDt"`
+		Bank         string    `json:"bank,omitempty"`
+		DeliveryCost float64   `json:"deliveryCost,omitempty"`
+		GoodsTotal   float64   `json:"goodsTotal"`
+		CustomFee    float64   `json:"customFee"`
+	}
+
+	paymentDT := time.Date(2024, 5, 24, 12, 0, 0, 0, time.UTC)
+
+	for _, deliveryCost := range []float64{0, 1500.5} {
+		legacy := legacyPayment{
+			OrderUID:     "testUID123",
+			Transaction:  "testUID123",
+			Currency:     "USD",
+			Provider:     "wbpay",
+			Amount:       1817,
+			PaymentDT:    paymentDT,
+			DeliveryCost: deliveryCost,
+			GoodsTotal:   317,
+		}
+
+		want, err := json.Marshal(legacy)
+		s.Require().NoError(err)
+
+		var payment models.Payment
+		s.Require().NoError(json.Unmarshal(want, &payment))
+		s.Require().Equal(models.MoneyFromFloat(deliveryCost), payment.DeliveryCost)
+
+		got, err := json.Marshal(payment)
+		s.Require().NoError(err)
+		s.Require().JSONEq(string(want), string(got))
+		s.Require().Equal(deliveryCost != 0, strings.Contains(string(got), "deliveryCost"))
+	}
+}
+
+func (s *MoneySuite) TestScan() {
+	var m models.Money
+
+	s.Require().NoError(m.Scan("29.990"))
+	s.Require().Equal(models.MustParseMoney("29.99"), m)
+
+	s.Require().NoError(m.Scan(nil))
+	s.Require().True(m.IsZero())
+
+	s.Require().Error(m.Scan(true))
+
+	value, err := models.MustParseMoney("0.1").Value()
+	s.Require().NoError(err)
+	s.Require().Equal("0.1", value)
+}
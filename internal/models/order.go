@@ -1,7 +1,9 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -10,6 +12,8 @@ var (
 	ErrDeliveryNotFound = errors.New("delivery not found")
 	ErrPaymentNotFound  = errors.New("payment not found")
 	ErrItemsNotFound    = errors.New("items not found")
+	ErrStaleVersion     = errors.New("order version is not newer than the stored one")
+	ErrRawNotFound      = errors.New("raw payload not found")
 )
 
 type HTTPResponse struct {
@@ -29,9 +33,16 @@ type Order struct {
 	SMID              int       `json:"smId,omitempty"`
 	DateCreated       time.Time `json:"dateCreated"`
 	OOFShard          string    `json:"oofShard,omitempty"`
+	Version           uint64    `json:"version,omitempty"`
 	Delivery          Delivery  `json:"delivery"`
 	Payment           Payment   `json:"payment"`
 	Items             []Item    `json:"items"`
+	Source            Source    `json:"-"`
+	// Raw is the payload the order was decoded from, if it was kept.
+	Raw *RawPayload `json:"-"`
+	// IfMatch, when set, is an If-Match header value the stored order must
+	// match for the write to apply; see MatchIfMatch.
+	IfMatch string `json:"-"`
 }
 
 type Delivery struct {
@@ -51,25 +62,72 @@ type Payment struct {
 	RequestID    string    `json:"requestId,omitempty"`
 	Currency     string    `json:"currency"`
 	Provider     string    `json:"provider"`
-	Amount       float64   `json:"amount"`
+	Amount       Money     `json:"amount"`
 	PaymentDT    time.Time `json:"paymentDt"`
 	Bank         string    `json:"bank,omitempty"`
-	DeliveryCost float64   `json:"deliveryCost,omitempty"`
-	GoodsTotal   float64   `json:"goodsTotal"`
-	CustomFee    float64   `json:"customFee"`
+	DeliveryCost Money     `json:"deliveryCost,omitempty"`
+	GoodsTotal   Money     `json:"goodsTotal"`
+	CustomFee    Money     `json:"customFee"`
+}
+
+// MarshalJSON leaves out a zero deliveryCost, as the float64 field it
+// replaced did: omitempty doesn't apply to a struct such as Money.
+func (p Payment) MarshalJSON() ([]byte, error) {
+	type payment Payment
+
+	out := struct {
+		payment
+		DeliveryCost *Money `json:"deliveryCost,omitempty"`
+	}{payment: payment(p)}
+
+	if !p.DeliveryCost.IsZero() {
+		out.DeliveryCost = &p.DeliveryCost
+	}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("order.go Payment.MarshalJSON: %w", err)
+	}
+
+	return data, nil
 }
 
 type Item struct {
-	ChrtID      int     `json:"chrtId"`
-	OrderUID    string  `json:"orderUid"`
-	TrackNumber string  `json:"trackNumber"`
-	Price       float64 `json:"price"`
-	RID         string  `json:"rid,omitempty"`
-	Name        string  `json:"name"`
-	Sale        int     `json:"sale,omitempty"`
-	Size        string  `json:"size,omitempty"`
-	TotalPrice  float64 `json:"totalPrice"`
-	NMID        int     `json:"nmId"`
-	Brand       string  `json:"brand"`
-	Status      int     `json:"status"`
+	ChrtID      int    `json:"chrtId"`
+	OrderUID    string `json:"orderUid"`
+	TrackNumber string `json:"trackNumber"`
+	Price       Money  `json:"price"`
+	RID         string `json:"rid,omitempty"`
+	Name        string `json:"name"`
+	Sale        int    `json:"sale,omitempty"`
+	Size        string `json:"size,omitempty"`
+	TotalPrice  Money  `json:"totalPrice"`
+	NMID        int    `json:"nmId"`
+	Brand       string `json:"brand"`
+	Status      int    `json:"status"`
+}
+
+// RawPayload is an incoming order payload rendered as JSON by its codec,
+// including the fields Order doesn't model.
+type RawPayload struct {
+	ContentType string          `json:"contentType"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+// Supersedes reports whether o may replace stored. Unversioned orders
+// (version 0) always apply; versioned ones must be strictly newer.
+func (o *Order) Supersedes(stored *Order) bool {
+	return o.Version == 0 || o.Version > stored.Version
+}
+
+// Tombstone requests the deletion of an order. A soft delete hides the order
+// but keeps its row and version, so older updates arriving later are still
+// rejected as stale; a hard delete removes the order completely.
+type Tombstone struct {
+	OrderUID string `json:"orderUid"`
+	Version  uint64 `json:"version,omitempty"`
+	Hard     bool   `json:"hard,omitempty"`
+	Source   Source `json:"-"`
+	// IfMatch works as Order.IfMatch.
+	IfMatch string `json:"-"`
 }
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	SortDateCreated = "dateCreated"
+	SortOrderUID    = "orderUid"
+
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+var ErrInvalidQuery = errors.New("invalid order query")
+
+// OrderFilter narrows an order listing. Empty fields don't filter;
+// CreatedFrom is inclusive and CreatedTo exclusive.
+type OrderFilter struct {
+	CustomerID      string
+	TrackNumber     string
+	DeliveryService string
+	PaymentProvider string
+	Currency        string
+	ItemBrand       string
+	CreatedFrom     time.Time
+	CreatedTo       time.Time
+}
+
+// OrderQuery selects a page of orders. Cursor is the NextCursor of the
+// previous page and must be used with the same filter and sort.
+type OrderQuery struct {
+	Filter OrderFilter
+	Sort   string
+	Desc   bool
+	Limit  int
+	Cursor string
+}
+
+type OrderPage struct {
+	Orders     []Order `json:"orders"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+// OrderStream selects the orders read by a streaming scan. Orders come in
+// order_uid order, ChunkSize at a time; CreatedFrom skips older orders. With
+// Partitions > 1 only the orders whose order_uid hashes to Partition are
+// read, so several readers can scan disjoint parts concurrently.
+type OrderStream struct {
+	ChunkSize   int
+	CreatedFrom time.Time
+	Partition   int
+	Partitions  int
+}
+
+// OrderCursor is the sort key of the last order on a page. It is handed out
+// base64-encoded, so clients treat it as opaque.
+type OrderCursor struct {
+	Sort        string    `json:"s"`
+	Desc        bool      `json:"d,omitempty"`
+	DateCreated time.Time `json:"c"`
+	OrderUID    string    `json:"u"`
+}
+
+func (c OrderCursor) Encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("order_query.go OrderCursor.Encode json.Marshal(...): %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Normalize fills in the default sort and limit and rejects unknown sorts
+// and out-of-range limits.
+func (q OrderQuery) Normalize() (OrderQuery, error) {
+	switch q.Sort {
+	case "":
+		q.Sort = SortDateCreated
+	case SortDateCreated, SortOrderUID:
+	default:
+		return q, fmt.Errorf("%w: unknown sort %q", ErrInvalidQuery, q.Sort)
+	}
+
+	switch {
+	case q.Limit == 0:
+		q.Limit = DefaultListLimit
+	case q.Limit < 0 || q.Limit > MaxListLimit:
+		return q, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxListLimit)
+	}
+
+	return q, nil
+}
+
+// After decodes the cursor of a normalized query. It returns nil for the
+// first page.
+func (q OrderQuery) After() (*OrderCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+
+	var c OrderCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+
+	if c.Sort != q.Sort || c.Desc != q.Desc {
+		return nil, fmt.Errorf("%w: cursor was issued for a different sort", ErrInvalidQuery)
+	}
+
+	return &c, nil
+}
+
+// NextCursor returns the cursor of the page that follows last.
+func (q OrderQuery) NextCursor(last Order) (string, error) {
+	return OrderCursor{Sort: q.Sort, Desc: q.Desc, DateCreated: last.DateCreated, OrderUID: last.OrderUID}.Encode()
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrPartitionNotFound = errors.New("partition not found")
+
+// OrderPartition is a month of orders. Its orders, deliveries, payments and
+// items are stored in partitions of their own, so the month can be archived
+// and dropped as a whole. A detached partition was left behind by an
+// archive run that didn't finish; its rows are no longer visible.
+type OrderPartition struct {
+	Month    time.Time `json:"month"`
+	Orders   int64     `json:"orders"`
+	Detached bool      `json:"detached,omitempty"`
+}
+
+// PartitionMonth returns the first instant of the month containing t, which
+// is the lower bound of its partition.
+func PartitionMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
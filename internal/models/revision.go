@@ -0,0 +1,139 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// Source describes where a change came from. It is filled in by the NATS
+// client and stored with every revision; it is not part of the order
+// payload.
+type Source struct {
+	Subject    string    `json:"subject,omitempty"`
+	Sequence   uint64    `json:"sequence,omitempty"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// Revision is one stored state of an order. Order is nil for deletions and
+// in revision listings.
+type Revision struct {
+	OrderUID        string    `json:"orderUid"`
+	Revision        int       `json:"revision"`
+	Version         uint64    `json:"version"`
+	ChangeType      string    `json:"changeType"`
+	ChangedSections []string  `json:"changedSections,omitempty"`
+	Source          Source    `json:"source"`
+	RecordedAt      time.Time `json:"recordedAt"`
+	Order           *Order    `json:"order,omitempty"`
+}
+
+type FieldChange struct {
+	Path string `json:"path"`
+	From any    `json:"from"`
+	To   any    `json:"to"`
+}
+
+type RevisionDiff struct {
+	OrderUID string        `json:"orderUid"`
+	From     int           `json:"from"`
+	To       int           `json:"to"`
+	Changes  []FieldChange `json:"changes"`
+}
+
+// DiffOrders lists the fields that differ between from and to, addressed by
+// their JSON paths, e.g. "payment.amount". Items are matched by chrtId
+// ("items[chrtId=1].price") rather than by position. A nil order has no
+// fields, so every field of the other one is reported as added or removed.
+func DiffOrders(from, to *Order) ([]FieldChange, error) {
+	before, err := flattenOrder(from)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := flattenOrder(to)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(before)+len(after))
+	for path := range before {
+		paths = append(paths, path)
+	}
+
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+
+	slices.Sort(paths)
+
+	changes := make([]FieldChange, 0)
+
+	for _, path := range paths {
+		a, b := before[path], after[path]
+		if a != b {
+			changes = append(changes, FieldChange{Path: path, From: a, To: b})
+		}
+	}
+
+	return changes, nil
+}
+
+func flattenOrder(o *Order) (map[string]any, error) {
+	fields := make(map[string]any)
+	if o == nil {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(o)
+	if err != nil {
+		return nil, fmt.Errorf("revision.go flattenOrder json.Marshal(...): %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var tree any
+	if err := dec.Decode(&tree); err != nil {
+		return nil, fmt.Errorf("revision.go flattenOrder Decode(...): %w", err)
+	}
+
+	flatten("", tree, fields)
+
+	return fields, nil
+}
+
+// flatten stores every leaf of tree in fields under its path. Leaves are
+// strings, json.Numbers, bools or nil, so they compare with ==.
+func flatten(path string, tree any, fields map[string]any) {
+	switch node := tree.(type) {
+	case map[string]any:
+		for key, value := range node {
+			flatten(strings.TrimPrefix(path+"."+key, "."), value, fields)
+		}
+	case []any:
+		for i, value := range node {
+			flatten(elementPath(path, i, value), value, fields)
+		}
+	default:
+		fields[path] = node
+	}
+}
+
+func elementPath(path string, i int, value any) string {
+	if item, ok := value.(map[string]any); ok && path == "items" {
+		if chrtID, ok := item["chrtId"].(json.Number); ok {
+			return fmt.Sprintf("%s[chrtId=%s]", path, chrtID)
+		}
+	}
+
+	return fmt.Sprintf("%s[%d]", path, i)
+}
@@ -0,0 +1,56 @@
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type DiffSuite struct {
+	suite.Suite
+}
+
+func TestDiffSuite(t *testing.T) {
+	suite.Run(t, new(DiffSuite))
+}
+
+func (s *DiffSuite) TestDiffOrders() {
+	from := &models.Order{
+		OrderUID: "testUID123",
+		Payment:  models.Payment{Amount: models.MustParseMoney("100.10")},
+		Items: []models.Item{
+			{ChrtID: 1, Price: models.MustParseMoney("10")},
+			{ChrtID: 2, Price: models.MustParseMoney("20")},
+		},
+	}
+
+	to := *from
+	to.Payment.Amount = models.MustParseMoney("100.2")
+	to.Items = []models.Item{
+		{ChrtID: 2, Price: models.MustParseMoney("20")},
+		{ChrtID: 1, Price: models.MustParseMoney("15")},
+	}
+
+	changes, err := models.DiffOrders(from, &to)
+	s.Require().NoError(err)
+	s.Require().Equal([]models.FieldChange{
+		{Path: "items[chrtId=1].price", From: json.Number("10"), To: json.Number("15")},
+		{Path: "payment.amount", From: json.Number("100.1"), To: json.Number("100.2")},
+	}, changes, "Items are matched by chrtId, not by position")
+
+	changes, err = models.DiffOrders(from, from)
+	s.Require().NoError(err)
+	s.Require().Empty(changes)
+}
+
+func (s *DiffSuite) TestDiffOrders_Deleted() {
+	changes, err := models.DiffOrders(&models.Order{OrderUID: "testUID123"}, nil)
+	s.Require().NoError(err)
+	s.Require().NotEmpty(changes)
+
+	for _, change := range changes {
+		s.Require().Nil(change.To, change.Path)
+	}
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Fields of an order a search matches on, as reported in highlights.
+const (
+	SearchFieldOrderUID        = "orderUid"
+	SearchFieldTrackNumber     = "trackNumber"
+	SearchFieldCustomerID      = "customerId"
+	SearchFieldDeliveryCity    = "delivery.city"
+	SearchFieldDeliveryAddress = "delivery.address"
+	SearchFieldItemName        = "items.name"
+	SearchFieldItemBrand       = "items.brand"
+)
+
+var ErrSearchUnsupported = errors.New("order search is not supported by the storage backend")
+
+// SearchQuery selects a page of orders matching a full-text search. Every
+// word of Text must occur, as a word or the prefix of one, in any of the
+// order identifiers, the delivery city and address or the names and brands
+// of the items; the words may be spread over several of them. Cursor is the NextCursor of the previous page and must be used
+// with the same Text.
+type SearchQuery struct {
+	Text   string
+	Limit  int
+	Cursor string
+}
+
+// Highlight is a matched field of an order with the matching words wrapped
+// in <mark> tags.
+type Highlight struct {
+	Field    string `json:"field"`
+	Fragment string `json:"fragment"`
+}
+
+type SearchResult struct {
+	Order      Order       `json:"order"`
+	Rank       float32     `json:"rank"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+// SearchPage holds results ordered by descending rank and then by order_uid.
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// SearchCursor is the rank and order_uid of the last result on a page.
+type SearchCursor struct {
+	Text     string  `json:"q"`
+	Rank     float32 `json:"r"`
+	OrderUID string  `json:"u"`
+}
+
+func (c SearchCursor) Encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("search.go SearchCursor.Encode json.Marshal(...): %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Normalize trims the text, fills in the default limit and rejects empty
+// texts and out-of-range limits.
+func (q SearchQuery) Normalize() (SearchQuery, error) {
+	q.Text = strings.TrimSpace(q.Text)
+	if q.Text == "" {
+		return q, fmt.Errorf("%w: search text is empty", ErrInvalidQuery)
+	}
+
+	switch {
+	case q.Limit == 0:
+		q.Limit = DefaultListLimit
+	case q.Limit < 0 || q.Limit > MaxListLimit:
+		return q, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxListLimit)
+	}
+
+	return q, nil
+}
+
+// After decodes the cursor of a normalized query. It returns nil for the
+// first page.
+func (q SearchQuery) After() (*SearchCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+
+	var c SearchCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+
+	if c.Text != q.Text {
+		return nil, fmt.Errorf("%w: cursor was issued for a different search", ErrInvalidQuery)
+	}
+
+	return &c, nil
+}
+
+// NextCursor returns the cursor of the page that follows last.
+func (q SearchQuery) NextCursor(last SearchResult) (string, error) {
+	return SearchCursor{Text: q.Text, Rank: last.Rank, OrderUID: last.Order.OrderUID}.Encode()
+}
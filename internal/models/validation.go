@@ -0,0 +1,164 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+const (
+	RuleRequired = "required"
+	RuleMismatch = "mismatch"
+	RuleMin      = "min"
+	RuleRange    = "range"
+	RuleFormat   = "format"
+	RuleUnique   = "unique"
+
+	maxSalePercent = 100
+	currencyLength = 3
+)
+
+var ErrInvalidOrder = errors.New("invalid order")
+
+type Violation struct {
+	Path    string `json:"path"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Violations []Violation `json:"violations"`
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		parts = append(parts, v.Path+": "+v.Message)
+	}
+
+	return fmt.Sprintf("%s: %s", ErrInvalidOrder, strings.Join(parts, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidOrder
+}
+
+type validator struct {
+	violations []Violation
+}
+
+func (v *validator) add(path, rule, message string) {
+	v.violations = append(v.violations, Violation{Path: path, Rule: rule, Message: message})
+}
+
+func (v *validator) required(path, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(path, RuleRequired, "must not be empty")
+	}
+}
+
+func (v *validator) nonNegative(path string, value Money) {
+	if value.IsNegative() {
+		v.add(path, RuleMin, "must not be negative")
+	}
+}
+
+func (v *validator) sameOrder(path, value, orderUID string) {
+	if value != orderUID {
+		v.add(path, RuleMismatch, fmt.Sprintf("must match order uid %q, got %q", orderUID, value))
+	}
+}
+
+// Validate checks an order against the schema and business rules and
+// returns a *ValidationError listing every violation found.
+func (o *Order) Validate() error {
+	v := &validator{}
+
+	v.required("orderUid", o.OrderUID)
+	v.required("trackNumber", o.TrackNumber)
+	v.required("locale", o.Locale)
+	v.required("customerId", o.CustomerID)
+	v.required("deliveryService", o.DeliveryService)
+
+	if o.DateCreated.IsZero() {
+		v.add("dateCreated", RuleRequired, "must be set")
+	}
+
+	o.Delivery.validate(v, "delivery", o.OrderUID)
+	o.Payment.validate(v, "payment", o.OrderUID)
+
+	if len(o.Items) == 0 {
+		v.add("items", RuleRequired, "order must contain at least one item")
+	}
+
+	seen := make(map[int]int, len(o.Items))
+
+	for i := range o.Items {
+		path := fmt.Sprintf("items[%d]", i)
+		o.Items[i].validate(v, path, o.OrderUID)
+
+		if first, ok := seen[o.Items[i].ChrtID]; ok {
+			v.add(path+".chrtId", RuleUnique, fmt.Sprintf("duplicates items[%d].chrtId", first))
+		} else {
+			seen[o.Items[i].ChrtID] = i
+		}
+	}
+
+	if len(v.violations) > 0 {
+		return &ValidationError{Violations: v.violations}
+	}
+
+	return nil
+}
+
+func (d *Delivery) validate(v *validator, path, orderUID string) {
+	v.sameOrder(path+".orderUid", d.OrderUID, orderUID)
+	v.required(path+".name", d.Name)
+	v.required(path+".phone", d.Phone)
+	v.required(path+".city", d.City)
+	v.required(path+".address", d.Address)
+
+	if d.Email != "" {
+		if _, err := mail.ParseAddress(d.Email); err != nil {
+			v.add(path+".email", RuleFormat, "must be a valid email address")
+		}
+	}
+}
+
+func (p *Payment) validate(v *validator, path, orderUID string) {
+	v.sameOrder(path+".orderUid", p.OrderUID, orderUID)
+	v.required(path+".transaction", p.Transaction)
+	v.required(path+".provider", p.Provider)
+
+	if len(p.Currency) != currencyLength || strings.ToUpper(p.Currency) != p.Currency {
+		v.add(path+".currency", RuleFormat, "must be a three-letter upper-case ISO 4217 code")
+	}
+
+	if p.PaymentDT.IsZero() {
+		v.add(path+".paymentDt", RuleRequired, "must be set")
+	}
+
+	v.nonNegative(path+".amount", p.Amount)
+	v.nonNegative(path+".deliveryCost", p.DeliveryCost)
+	v.nonNegative(path+".goodsTotal", p.GoodsTotal)
+	v.nonNegative(path+".customFee", p.CustomFee)
+}
+
+func (i *Item) validate(v *validator, path, orderUID string) {
+	v.sameOrder(path+".orderUid", i.OrderUID, orderUID)
+	v.required(path+".trackNumber", i.TrackNumber)
+	v.required(path+".name", i.Name)
+	v.required(path+".brand", i.Brand)
+
+	if i.ChrtID <= 0 {
+		v.add(path+".chrtId", RuleMin, "must be positive")
+	}
+
+	if i.Sale < 0 || i.Sale > maxSalePercent {
+		v.add(path+".sale", RuleRange, "must be between 0 and 100")
+	}
+
+	v.nonNegative(path+".price", i.Price)
+	v.nonNegative(path+".totalPrice", i.TotalPrice)
+}
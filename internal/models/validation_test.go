@@ -0,0 +1,109 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type ValidationSuite struct {
+	suite.Suite
+	order models.Order
+}
+
+func (s *ValidationSuite) SetupTest() {
+	s.order = models.Order{
+		OrderUID:        "testUID123",
+		TrackNumber:     "TN1234567890",
+		Locale:          "en",
+		CustomerID:      "Cust123",
+		DeliveryService: "TestService",
+		DateCreated:     time.Now(),
+		Delivery: models.Delivery{
+			OrderUID: "testUID123",
+			Name:     "John Doe",
+			Phone:    "+1234567890",
+			City:     "TestCity",
+			Address:  "123 Test St",
+			Email:    "john.doe@example.com",
+		},
+		Payment: models.Payment{
+			OrderUID:    "testUID123",
+			Transaction: "TX1234567890",
+			Currency:    "USD",
+			Provider:    "TestProvider",
+			Amount:      models.MustParseMoney("150.00"),
+			PaymentDT:   time.Now(),
+		},
+		Items: []models.Item{
+			{
+				ChrtID:      1,
+				OrderUID:    "testUID123",
+				TrackNumber: "TN1234567890",
+				Price:       models.MustParseMoney("150.00"),
+				Name:        "Test Item 1",
+				Sale:        30,
+				NMID:        1001,
+				Brand:       "TestBrand",
+			},
+		},
+	}
+}
+
+func TestValidationSuite(t *testing.T) {
+	suite.Run(t, new(ValidationSuite))
+}
+
+func (s *ValidationSuite) TestValidOrder() {
+	s.Require().NoError(s.order.Validate())
+}
+
+func (s *ValidationSuite) TestViolations() {
+	testCases := []struct {
+		name   string
+		mutate func(o *models.Order)
+		path   string
+		rule   string
+	}{
+		{"empty order uid", func(o *models.Order) { o.OrderUID = "" }, "orderUid", models.RuleRequired},
+		{"no items", func(o *models.Order) { o.Items = nil }, "items", models.RuleRequired},
+		{"negative amount", func(o *models.Order) { o.Payment.Amount = models.MustParseMoney("-10") }, "payment.amount", models.RuleMin},
+		{
+			"delivery of another order",
+			func(o *models.Order) { o.Delivery.OrderUID = "otherUID" },
+			"delivery.orderUid", models.RuleMismatch,
+		},
+		{"invalid currency", func(o *models.Order) { o.Payment.Currency = "usd" }, "payment.currency", models.RuleFormat},
+		{"invalid email", func(o *models.Order) { o.Delivery.Email = "not-an-email" }, "delivery.email", models.RuleFormat},
+		{"sale out of range", func(o *models.Order) { o.Items[0].Sale = 150 }, "items[0].sale", models.RuleRange},
+		{"zero chrt id", func(o *models.Order) { o.Items[0].ChrtID = 0 }, "items[0].chrtId", models.RuleMin},
+		{
+			"duplicate chrt id",
+			func(o *models.Order) { o.Items = append(o.Items, o.Items[0]) },
+			"items[1].chrtId", models.RuleUnique,
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			s.SetupTest()
+			tc.mutate(&s.order)
+
+			err := s.order.Validate()
+			s.Require().ErrorIs(err, models.ErrInvalidOrder)
+
+			var validationErr *models.ValidationError
+			s.Require().ErrorAs(err, &validationErr)
+
+			found := false
+			for _, v := range validationErr.Violations {
+				if v.Path == tc.path && v.Rule == tc.rule {
+					found = true
+				}
+			}
+			s.Require().True(found, "expected %s violation on %s, got %v", tc.rule, tc.path, validationErr.Violations)
+		})
+	}
+}
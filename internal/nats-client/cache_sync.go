@@ -0,0 +1,50 @@
+package natsclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+// PublishInvalidation broadcasts a cache invalidation on core NATS. It
+// doesn't wait for the server: receivers detect lost invalidations by their
+// sequence numbers.
+func (nc *Client) PublishInvalidation(_ context.Context, inv models.CacheInvalidation) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return fmt.Errorf("cache_sync.go PublishInvalidation json.Marshal(...): %w", err)
+	}
+
+	if err := nc.conn.Publish(nc.cacheSyncSubject, data); err != nil {
+		return fmt.Errorf("cache_sync.go PublishInvalidation nc.conn.Publish(...): %w", err)
+	}
+
+	return nil
+}
+
+// SubscribeInvalidations hands every invalidation published on the cache
+// sync subject to apply. The subscription is not a queue subscription, so
+// every instance receives every invalidation, in the order each sender
+// published them.
+func (nc *Client) SubscribeInvalidations(
+	ctx context.Context, apply func(context.Context, models.CacheInvalidation),
+) error {
+	_, err := nc.conn.Subscribe(nc.cacheSyncSubject, func(msg *nats.Msg) {
+		var inv models.CacheInvalidation
+		if err := json.Unmarshal(msg.Data, &inv); err != nil {
+			nc.log.WithError(err).Error("failed to unmarshal cache invalidation")
+
+			return
+		}
+
+		apply(ctx, inv)
+	})
+	if err != nil {
+		return fmt.Errorf("natsclient SubscribeInvalidations(...): %w", err)
+	}
+
+	return nil
+}
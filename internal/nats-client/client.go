@@ -2,26 +2,77 @@ package natsclient
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/order_tracker/internal/codec"
 	"github.com/stsolovey/order_tracker/internal/config"
 	"github.com/stsolovey/order_tracker/internal/models"
 	"github.com/stsolovey/order_tracker/internal/service"
 )
 
-const queueGroupName = "order_tracker"
-
 type Client struct {
 	conn    *nats.Conn
 	js      nats.JetStreamContext
 	log     *logrus.Logger
 	service service.OrderServiceInterface
+
+	codecs        *codec.Registry
+	publishCodec  codec.Codec
+	subjectCodecs map[string]string
+
+	subject       string
+	deleteSubject string
+	stream        *nats.StreamConfig
+	consumer      consumerSettings
+
+	maxDeliver        int
+	retryBaseDelay    time.Duration
+	retryMaxDelay     time.Duration
+	deadLetterStream  string
+	deadLetterSubject string
+
+	pullBatchSize int
+	pullMaxWait   time.Duration
+	pullWorkers   int
+
+	workers  int
+	pool     *workerPool
+	poolOnce sync.Once
+
+	eventsSubject    string
+	cacheSyncSubject string
 }
 
 func New(cfg *config.Config, log *logrus.Logger, svc service.OrderServiceInterface) (*Client, error) {
+	stream, err := newStreamConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("natsclient New(...) newStreamConfig(...): %w", err)
+	}
+
+	consumer, err := newConsumerSettings(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("natsclient New(...) newConsumerSettings(...): %w", err)
+	}
+
+	codecs := codec.Default()
+
+	publishCodec, err := codecs.Lookup(cfg.NATSContentType)
+	if err != nil {
+		return nil, fmt.Errorf("natsclient New(...) codecs.Lookup(...): %w", err)
+	}
+
+	for subject, contentType := range cfg.NATSSubjectCodecs {
+		if _, err := codecs.Lookup(contentType); err != nil {
+			return nil, fmt.Errorf("natsclient New(...) subject %s: %w", subject, err)
+		}
+	}
+
 	nc, err := nats.Connect(cfg.NATSURL)
 	if err != nil {
 		return nil, fmt.Errorf("natsclient New(...) nats.Connect(...): %w", err)
@@ -37,58 +88,248 @@ func New(cfg *config.Config, log *logrus.Logger, svc service.OrderServiceInterfa
 		js:      js,
 		log:     log,
 		service: svc,
+
+		codecs:        codecs,
+		publishCodec:  publishCodec,
+		subjectCodecs: cfg.NATSSubjectCodecs,
+
+		subject:       cfg.NATSSubject,
+		deleteSubject: cfg.NATSDeleteSubject,
+		stream:        stream,
+		consumer:      consumer,
+
+		maxDeliver:        cfg.NATSMaxDeliver,
+		retryBaseDelay:    cfg.NATSRetryBaseDelay,
+		retryMaxDelay:     cfg.NATSRetryMaxDelay,
+		deadLetterStream:  cfg.NATSDeadLetterStream,
+		deadLetterSubject: cfg.NATSDeadLetterSubject,
+
+		pullBatchSize: cfg.NATSPullBatchSize,
+		pullMaxWait:   cfg.NATSPullMaxWait,
+		pullWorkers:   cfg.NATSPullWorkers,
+
+		workers: cfg.NATSWorkers,
+
+		eventsSubject:    cfg.OutboxSubject,
+		cacheSyncSubject: cfg.CacheSyncSubject,
+	}
+
+	if err := client.ensureDeadLetterStream(); err != nil {
+		return nil, fmt.Errorf("natsclient New(...) client.ensureDeadLetterStream(...): %w", err)
 	}
 
 	return client, nil
 }
 
+// Subscribe starts a durable push consumer on subject. Messages are handled
+// by the worker pool, so orders with different OrderUIDs are upserted
+// concurrently while updates of the same order keep their order.
 func (nc *Client) Subscribe(ctx context.Context, subject string) error {
+	pool := nc.workerPool(ctx)
+
 	go func() {
 		<-ctx.Done()
+		pool.wait()
 		nc.Close()
 	}()
 
-	_, err := nc.js.QueueSubscribe(subject, queueGroupName, func(msg *nats.Msg) {
-		var order models.Order
+	durable := nc.durableFor(subject)
 
-		if err := json.Unmarshal(msg.Data, &order); err != nil {
-			nc.log.WithError(err).Error("failed to unmarshal order")
+	if err := nc.reconcileConsumer(ctx, durable); err != nil {
+		return fmt.Errorf("natsclient Subscribe(...): %w", err)
+	}
 
-			if err := msg.Nak(); err != nil {
-				nc.log.WithError(err).Error("failed to negatively acknowledge message")
-			}
+	_, err := nc.js.QueueSubscribe(subject, durable, func(msg *nats.Msg) {
+		nc.handleMessage(ctx, msg)
+	}, nc.subOpts()...)
+	if err != nil {
+		return fmt.Errorf("natsclient Subscribe(...): %w", err)
+	}
 
-			return
-		}
+	return nil
+}
+
+func (nc *Client) subOpts() []nats.SubOpt {
+	opts := []nats.SubOpt{
+		nats.ManualAck(),
+		nats.AckWait(nc.consumer.ackWait),
+		nats.MaxAckPending(nc.consumer.maxAckPending),
+	}
+
+	switch nc.consumer.deliverPolicy {
+	case nats.DeliverNewPolicy:
+		opts = append(opts, nats.DeliverNew())
+	case nats.DeliverLastPolicy:
+		opts = append(opts, nats.DeliverLast())
+	case nats.DeliverLastPerSubjectPolicy:
+		opts = append(opts, nats.DeliverLastPerSubject())
+	default:
+		opts = append(opts, nats.DeliverAll())
+	}
+
+	if nc.maxDeliver > 0 {
+		opts = append(opts, nats.MaxDeliver(nc.maxDeliver))
+	}
+
+	return opts
+}
+
+func (nc *Client) handleMessage(ctx context.Context, msg *nats.Msg) {
+	order, err := nc.decodeOrder(msg)
+	if err != nil {
+		nc.log.WithError(err).Error("failed to unmarshal order")
+		nc.reject(msg, err)
 
-		if err := nc.service.UpsertOrder(ctx, order); err != nil {
-			nc.log.WithError(err).Error("failed to upsert order")
+		return
+	}
+
+	nc.workerPool(ctx).dispatch(ctx, order.OrderUID, func() {
+		nc.settle(msg, &order, nc.service.UpsertOrder(ctx, order))
+	})
+}
+
+// workerPool returns the pool shared by all push subscriptions, so that an
+// order is never processed by two workers at once even if its updates and
+// tombstones arrive on different subjects. The pool lives as long as the
+// context of the first subscription.
+func (nc *Client) workerPool(ctx context.Context) *workerPool {
+	nc.poolOnce.Do(func() {
+		workers := max(nc.workers, 1)
+		nc.pool = newWorkerPool(ctx, workers, nc.consumer.maxAckPending/workers)
+	})
 
-			return
+	return nc.pool
+}
+
+// OrderSubjects lists the subjects orders are consumed from: the main subject
+// and every subject with its own content type.
+func (nc *Client) OrderSubjects() []string {
+	subjects := []string{nc.subject}
+
+	for subject := range nc.subjectCodecs {
+		if subject != nc.subject && subject != nc.deleteSubject {
+			subjects = append(subjects, subject)
 		}
+	}
+
+	slices.Sort(subjects[1:])
+
+	return subjects
+}
+
+func (nc *Client) isOrderSubject(subject string) bool {
+	_, mapped := nc.subjectCodecs[subject]
+
+	return subject == nc.subject || (mapped && subject != nc.deleteSubject)
+}
+
+// decodeOrder unmarshals a message payload with the codec selected by its
+// Content-Type header, falling back to the content type configured for the
+// subject. The payload is kept with the order as JSON. Orders without an
+// explicit version get the JetStream stream sequence as their version.
+func (nc *Client) decodeOrder(msg *nats.Msg) (models.Order, error) {
+	contentType := msg.Header.Get(codec.HeaderContentType)
+	if contentType == "" {
+		contentType = nc.subjectCodecs[msg.Subject]
+	}
+
+	order, err := nc.codecs.Decode(contentType, msg.Data)
+	if err != nil {
+		return order, fmt.Errorf("unmarshal order: %w: %w", ErrMalformedMessage, err)
+	}
+
+	if order.Raw, err = nc.codecs.RawJSON(contentType, msg.Data); err != nil {
+		return order, fmt.Errorf("raw order: %w: %w", ErrMalformedMessage, err)
+	}
+
+	order.Source = messageSource(msg)
+
+	if order.Version == 0 {
+		order.Version = order.Source.Sequence
+	}
+
+	return order, nil
+}
+
+// messageSource records where a message came from, for the revision history.
+func messageSource(msg *nats.Msg) models.Source {
+	source := models.Source{Subject: msg.Subject, ReceivedAt: time.Now().UTC()}
+
+	if meta, err := msg.Metadata(); err == nil {
+		source.Sequence = meta.Sequence.Stream
+	}
+
+	return source
+}
 
+// settle acks, naks or dead-letters a message according to the outcome of
+// upserting its order.
+func (nc *Client) settle(msg *nats.Msg, order *models.Order, err error) {
+	switch {
+	case err == nil:
 		nc.log.Infof("Order %s upserted successfully", order.OrderUID)
+		nc.ack(msg)
+	case errors.Is(err, models.ErrStaleVersion):
+		nc.log.Infof("Order %s version %d is stale, skipping", order.OrderUID, order.Version)
+		nc.ack(msg)
+	default:
+		nc.log.WithError(err).Error("failed to upsert order")
+		nc.reject(msg, fmt.Errorf("upsert order %s: %w", order.OrderUID, err))
+	}
+}
 
-		if err := msg.Ack(); err != nil {
-			nc.log.WithError(err).Error("failed to acknowledge message")
-		}
-	})
+func (nc *Client) ack(msg *nats.Msg) {
+	if err := msg.Ack(); err != nil {
+		nc.log.WithError(err).Error("failed to acknowledge message")
+	}
+}
+
+// reject handles a message that could not be processed. Permanent failures
+// are moved to the dead-letter stream right away; anything else is
+// redelivered with backoff until it has been delivered maxDeliver times.
+func (nc *Client) reject(msg *nats.Msg, reason error) {
+	meta, err := msg.Metadata()
 	if err != nil {
-		return fmt.Errorf("natsclient Subscribe(...): %w", err)
+		nc.log.WithError(err).Error("failed to read message metadata")
 	}
 
-	return nil
+	permanent := isPermanent(reason)
+
+	if meta == nil || (!permanent && (nc.maxDeliver <= 0 || meta.NumDelivered < uint64(nc.maxDeliver))) {
+		nc.retry(msg, meta)
+
+		return
+	}
+
+	if err := nc.deadLetter(msg, meta, reason); err != nil {
+		nc.log.WithError(err).Error("failed to dead-letter message")
+		nc.retry(msg, meta)
+
+		return
+	}
+
+	nc.log.WithField("sequence", meta.Sequence.Stream).
+		WithField("permanent", permanent).
+		Warnf("Message dead-lettered after %d deliveries: %v", meta.NumDelivered, reason)
+
+	if err := msg.Term(); err != nil {
+		nc.log.WithError(err).Error("failed to terminate message")
+	}
 }
 
 func (nc *Client) PublishOrder(order models.Order) error {
-	data, err := json.Marshal(order)
+	data, err := nc.publishCodec.Marshal(&order)
 	if err != nil {
-		return fmt.Errorf("client.go PublishOrder(...) json.Marshal(order): %w", err)
+		return fmt.Errorf("client.go PublishOrder(...) nc.publishCodec.Marshal(order): %w", err)
 	}
 
-	_, err = nc.js.Publish("orders", data)
+	msg := nats.NewMsg(nc.subject)
+	msg.Data = data
+	msg.Header.Set(codec.HeaderContentType, nc.publishCodec.ContentType())
+
+	_, err = nc.js.PublishMsg(msg)
 	if err != nil {
-		return fmt.Errorf("client.go PublishOrder(...) nc.js.Publish(...): %w", err)
+		return fmt.Errorf("client.go PublishOrder(...) nc.js.PublishMsg(...): %w", err)
 	}
 
 	return nil
@@ -97,3 +338,15 @@ func (nc *Client) PublishOrder(order models.Order) error {
 func (nc *Client) Close() {
 	nc.conn.Close()
 }
+
+func (nc *Client) ensureDeadLetterStream() error {
+	_, err := nc.js.AddStream(&nats.StreamConfig{
+		Name:     nc.deadLetterStream,
+		Subjects: []string{nc.deadLetterSubject},
+	})
+	if err != nil && !errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+		return fmt.Errorf("nc.js.AddStream(%s): %w", nc.deadLetterStream, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package natsclient
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/codec"
+)
+
+type ClientSuite struct {
+	suite.Suite
+	client *Client
+}
+
+func (s *ClientSuite) SetupTest() {
+	s.client = &Client{
+		codecs:        codec.Default(),
+		subject:       "orders",
+		deleteSubject: "orders.delete",
+		consumer:      consumerSettings{durable: "order_tracker"},
+		subjectCodecs: map[string]string{
+			"orders.wb":  codec.ContentTypeWB,
+			"orders.bin": codec.ContentTypeProtobuf,
+		},
+	}
+}
+
+func TestClientSuite(t *testing.T) {
+	suite.Run(t, new(ClientSuite))
+}
+
+func (s *ClientSuite) TestOrderSubjects() {
+	s.Require().Equal([]string{"orders", "orders.bin", "orders.wb"}, s.client.OrderSubjects())
+	s.Require().Equal("order_tracker", s.client.durableFor("orders"))
+	s.Require().Equal("order_tracker_orders_wb", s.client.durableFor("orders.wb"))
+	s.Require().False(s.client.isOrderSubject("orders.delete"))
+}
+
+func (s *ClientSuite) TestDecodeOrder_SubjectContentType() {
+	msg := nats.NewMsg("orders.wb")
+	msg.Data = []byte(`{"order_uid":"testUID123","items":[{"chrt_id":1}]}`)
+
+	order, err := s.client.decodeOrder(msg)
+	s.Require().NoError(err)
+	s.Require().Equal("testUID123", order.OrderUID)
+	s.Require().Equal(1, order.Items[0].ChrtID)
+
+	s.Run("header wins over the subject", func() {
+		msg.Data = []byte(`{"orderUid":"testUID456"}`)
+		msg.Header.Set(codec.HeaderContentType, codec.ContentTypeJSON)
+
+		order, err := s.client.decodeOrder(msg)
+		s.Require().NoError(err)
+		s.Require().Equal("testUID456", order.OrderUID)
+	})
+}
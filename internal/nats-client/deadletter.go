@@ -0,0 +1,244 @@
+package natsclient
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stsolovey/order_tracker/internal/codec"
+)
+
+const (
+	HeaderOriginalSubject  = "Order-Tracker-Original-Subject"
+	HeaderOriginalSequence = "Order-Tracker-Original-Sequence"
+	HeaderDeliveryCount    = "Order-Tracker-Delivery-Count"
+	HeaderFailureReason    = "Order-Tracker-Failure-Reason"
+	HeaderFailedAt         = "Order-Tracker-Failed-At"
+)
+
+var (
+	ErrDeadLetterNotFound  = errors.New("dead letter not found")
+	ErrDeadLetterNoSubject = errors.New("dead letter has no original subject")
+	ErrDeadLetterDuplicate = errors.New("requeued dead letter was dropped as a duplicate")
+)
+
+var deadLetterHeaders = []string{
+	HeaderOriginalSubject, HeaderOriginalSequence, HeaderDeliveryCount, HeaderFailureReason, HeaderFailedAt,
+}
+
+type DeadLetter struct {
+	Sequence         uint64              `json:"sequence"`
+	OrderUID         string              `json:"orderUid,omitempty"`
+	OriginalSubject  string              `json:"originalSubject"`
+	OriginalSequence uint64              `json:"originalSequence"`
+	DeliveryCount    uint64              `json:"deliveryCount"`
+	Reason           string              `json:"reason"`
+	FailedAt         time.Time           `json:"failedAt"`
+	Headers          map[string][]string `json:"headers,omitempty"`
+	Payload          string              `json:"payload"`
+	PayloadEncoding  string              `json:"payloadEncoding,omitempty"`
+}
+
+func (nc *Client) deadLetter(msg *nats.Msg, meta *nats.MsgMetadata, reason error) error {
+	dlq := newDeadLetterMsg(nc.deadLetterSubject, msg, meta, reason, time.Now())
+
+	if _, err := nc.js.PublishMsg(dlq); err != nil {
+		return fmt.Errorf("deadletter.go deadLetter nc.js.PublishMsg(...): %w", err)
+	}
+
+	return nil
+}
+
+func (nc *Client) ListDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	info, err := nc.js.StreamInfo(nc.deadLetterStream, nats.Context(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("deadletter.go ListDeadLetters nc.js.StreamInfo(...): %w", err)
+	}
+
+	var letters []DeadLetter
+
+	for seq := info.State.FirstSeq; seq <= info.State.LastSeq && info.State.Msgs > 0; seq++ {
+		if limit > 0 && len(letters) >= limit {
+			break
+		}
+
+		letter, err := nc.GetDeadLetter(ctx, seq)
+		if errors.Is(err, ErrDeadLetterNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("deadletter.go ListDeadLetters: %w", err)
+		}
+
+		letters = append(letters, *letter)
+	}
+
+	return letters, nil
+}
+
+func (nc *Client) GetDeadLetter(ctx context.Context, seq uint64) (*DeadLetter, error) {
+	raw, err := nc.js.GetMsg(nc.deadLetterStream, seq, nats.Context(ctx))
+	if err != nil {
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			return nil, ErrDeadLetterNotFound
+		}
+
+		return nil, fmt.Errorf("deadletter.go GetDeadLetter nc.js.GetMsg(%d): %w", seq, err)
+	}
+
+	letter := parseDeadLetter(raw, nc.codecs)
+
+	return &letter, nil
+}
+
+// RequeueDeadLetter republishes a dead-lettered message to the subject it
+// originally came from and removes it from the dead-letter stream.
+func (nc *Client) RequeueDeadLetter(ctx context.Context, seq uint64) error {
+	raw, err := nc.js.GetMsg(nc.deadLetterStream, seq, nats.Context(ctx))
+	if err != nil {
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			return ErrDeadLetterNotFound
+		}
+
+		return fmt.Errorf("deadletter.go RequeueDeadLetter nc.js.GetMsg(%d): %w", seq, err)
+	}
+
+	msg, err := newRequeueMsg(raw)
+	if err != nil {
+		return fmt.Errorf("deadletter.go RequeueDeadLetter newRequeueMsg(%d): %w", seq, err)
+	}
+
+	ack, err := nc.js.PublishMsg(msg, nats.Context(ctx))
+	if err != nil {
+		return fmt.Errorf("deadletter.go RequeueDeadLetter nc.js.PublishMsg(...): %w", err)
+	}
+
+	// The dead letter is kept unless the stream really took the message.
+	if ack.Duplicate {
+		return fmt.Errorf("deadletter.go RequeueDeadLetter(%d): %w", seq, ErrDeadLetterDuplicate)
+	}
+
+	if err := nc.DeleteDeadLetter(ctx, seq); err != nil {
+		return fmt.Errorf("deadletter.go RequeueDeadLetter: %w", err)
+	}
+
+	return nil
+}
+
+func (nc *Client) DeleteDeadLetter(ctx context.Context, seq uint64) error {
+	if err := nc.js.DeleteMsg(nc.deadLetterStream, seq, nats.Context(ctx)); err != nil {
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			return ErrDeadLetterNotFound
+		}
+
+		return fmt.Errorf("deadletter.go DeleteDeadLetter nc.js.DeleteMsg(%d): %w", seq, err)
+	}
+
+	return nil
+}
+
+func (nc *Client) PurgeDeadLetters(ctx context.Context) error {
+	if err := nc.js.PurgeStream(nc.deadLetterStream, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("deadletter.go PurgeDeadLetters nc.js.PurgeStream(...): %w", err)
+	}
+
+	return nil
+}
+
+func newDeadLetterMsg(
+	subject string,
+	msg *nats.Msg,
+	meta *nats.MsgMetadata,
+	reason error,
+	failedAt time.Time,
+) *nats.Msg {
+	dlq := nats.NewMsg(subject)
+	dlq.Data = msg.Data
+
+	for key, values := range msg.Header {
+		for _, value := range values {
+			dlq.Header.Add(key, value)
+		}
+	}
+
+	// The dead-letter stream would drop a message failing again after a
+	// requeue as a duplicate of its first dead letter.
+	dlq.Header.Del(nats.MsgIdHdr)
+
+	dlq.Header.Set(HeaderOriginalSubject, msg.Subject)
+	dlq.Header.Set(HeaderOriginalSequence, strconv.FormatUint(meta.Sequence.Stream, 10))
+	dlq.Header.Set(HeaderDeliveryCount, strconv.FormatUint(meta.NumDelivered, 10))
+	dlq.Header.Set(HeaderFailureReason, reason.Error())
+	dlq.Header.Set(HeaderFailedAt, failedAt.UTC().Format(time.RFC3339Nano))
+
+	return dlq
+}
+
+func newRequeueMsg(raw *nats.RawStreamMsg) (*nats.Msg, error) {
+	subject := raw.Header.Get(HeaderOriginalSubject)
+	if subject == "" {
+		return nil, ErrDeadLetterNoSubject
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = raw.Data
+
+	for key, values := range raw.Header {
+		for _, value := range values {
+			msg.Header.Add(key, value)
+		}
+	}
+
+	for _, key := range deadLetterHeaders {
+		msg.Header.Del(key)
+	}
+
+	// The original ID is still within the duplicate window of the orders
+	// stream for a while, which would silently drop the requeued message.
+	msg.Header.Del(nats.MsgIdHdr)
+
+	return msg, nil
+}
+
+// parseDeadLetter describes a dead-lettered message. Payloads that aren't
+// JSON are returned base64-encoded.
+func parseDeadLetter(raw *nats.RawStreamMsg, codecs *codec.Registry) DeadLetter {
+	letter := DeadLetter{
+		Sequence:        raw.Sequence,
+		OriginalSubject: raw.Header.Get(HeaderOriginalSubject),
+		Reason:          raw.Header.Get(HeaderFailureReason),
+		Headers:         make(map[string][]string),
+		Payload:         string(raw.Data),
+	}
+
+	letter.OriginalSequence, _ = strconv.ParseUint(raw.Header.Get(HeaderOriginalSequence), 10, 64)
+	letter.DeliveryCount, _ = strconv.ParseUint(raw.Header.Get(HeaderDeliveryCount), 10, 64)
+
+	if failedAt, err := time.Parse(time.RFC3339Nano, raw.Header.Get(HeaderFailedAt)); err == nil {
+		letter.FailedAt = failedAt
+	} else {
+		letter.FailedAt = raw.Time
+	}
+
+	for key, values := range raw.Header {
+		letter.Headers[key] = values
+	}
+
+	contentType := raw.Header.Get(codec.HeaderContentType)
+
+	if order, err := codecs.Decode(contentType, raw.Data); err == nil {
+		letter.OrderUID = order.OrderUID
+	}
+
+	if c, err := codecs.Lookup(contentType); err != nil || c.ContentType() != codec.ContentTypeJSON {
+		letter.Payload = base64.StdEncoding.EncodeToString(raw.Data)
+		letter.PayloadEncoding = "base64"
+	}
+
+	return letter
+}
@@ -0,0 +1,104 @@
+package natsclient
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/codec"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type DeadLetterSuite struct {
+	suite.Suite
+}
+
+func TestDeadLetterSuite(t *testing.T) {
+	suite.Run(t, new(DeadLetterSuite))
+}
+
+func (s *DeadLetterSuite) TestNewDeadLetterMsg() {
+	msg := nats.NewMsg("orders")
+	msg.Data = []byte(`{"orderUid":"testUID123"}`)
+	msg.Header.Set("Content-Type", "application/json")
+	msg.Header.Set(nats.MsgIdHdr, "testUID123-1")
+
+	meta := &nats.MsgMetadata{
+		Sequence:     nats.SequencePair{Stream: 42},
+		NumDelivered: 5,
+	}
+	failedAt := time.Date(2024, 5, 24, 12, 0, 0, 0, time.UTC)
+
+	dlq := newDeadLetterMsg("dlq.orders", msg, meta, errors.New("upsert failed"), failedAt)
+
+	s.Require().Equal("dlq.orders", dlq.Subject)
+	s.Require().Equal(msg.Data, dlq.Data)
+	s.Require().Equal("application/json", dlq.Header.Get("Content-Type"), "original headers should be kept")
+	s.Require().Equal("orders", dlq.Header.Get(HeaderOriginalSubject))
+	s.Require().Equal("42", dlq.Header.Get(HeaderOriginalSequence))
+	s.Require().Equal("5", dlq.Header.Get(HeaderDeliveryCount))
+	s.Require().Equal("upsert failed", dlq.Header.Get(HeaderFailureReason))
+	s.Require().Empty(dlq.Header.Get(nats.MsgIdHdr), "the message ID should be dropped")
+
+	s.Run("parsed back into a dead letter", func() {
+		letter := parseDeadLetter(&nats.RawStreamMsg{
+			Subject:  dlq.Subject,
+			Sequence: 7,
+			Header:   dlq.Header,
+			Data:     dlq.Data,
+		}, codec.Default())
+
+		s.Require().Equal(uint64(7), letter.Sequence)
+		s.Require().Equal("testUID123", letter.OrderUID)
+		s.Require().Equal("orders", letter.OriginalSubject)
+		s.Require().Equal(uint64(42), letter.OriginalSequence)
+		s.Require().Equal(uint64(5), letter.DeliveryCount)
+		s.Require().Equal("upsert failed", letter.Reason)
+		s.Require().True(failedAt.Equal(letter.FailedAt))
+		s.Require().Equal(string(msg.Data), letter.Payload)
+		s.Require().Empty(letter.PayloadEncoding)
+	})
+
+	s.Run("requeued without dead-letter headers", func() {
+		// Dead letters stored before the message ID was dropped still carry it.
+		header := nats.Header{}
+		for key, values := range dlq.Header {
+			header[key] = values
+		}
+
+		header.Set(nats.MsgIdHdr, "testUID123-1")
+
+		requeued, err := newRequeueMsg(&nats.RawStreamMsg{Header: header, Data: dlq.Data})
+		s.Require().NoError(err)
+		s.Require().Equal("orders", requeued.Subject)
+		s.Require().Equal("application/json", requeued.Header.Get("Content-Type"))
+		s.Require().Empty(requeued.Header.Get(nats.MsgIdHdr))
+
+		for _, key := range deadLetterHeaders {
+			s.Require().Empty(requeued.Header.Get(key), key)
+		}
+	})
+}
+
+func (s *DeadLetterSuite) TestParseDeadLetter_Binary() {
+	order := models.Order{OrderUID: "testUID123"}
+
+	data, err := codec.Protobuf{}.Marshal(&order)
+	s.Require().NoError(err)
+
+	header := nats.Header{}
+	header.Set(codec.HeaderContentType, codec.ContentTypeProtobuf)
+
+	letter := parseDeadLetter(&nats.RawStreamMsg{Header: header, Data: data}, codec.Default())
+	s.Require().Equal("testUID123", letter.OrderUID)
+	s.Require().Equal("base64", letter.PayloadEncoding)
+	s.Require().Equal(base64.StdEncoding.EncodeToString(data), letter.Payload)
+}
+
+func (s *DeadLetterSuite) TestNewRequeueMsg_NoSubject() {
+	_, err := newRequeueMsg(&nats.RawStreamMsg{Header: nats.Header{}, Data: []byte("{}")})
+	s.Require().ErrorIs(err, ErrDeadLetterNoSubject)
+}
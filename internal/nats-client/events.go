@@ -0,0 +1,44 @@
+package natsclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+const headerEventType = "Order-Tracker-Event-Type"
+
+// PublishEvent publishes an order change event to the events subject with
+// JetStream and waits for the stream capturing the subject to acknowledge
+// it, so it fails when no stream stored the event. The outbox id doubles as
+// the message id, so the stream deduplicates redeliveries.
+func (nc *Client) PublishEvent(ctx context.Context, event models.OrderEvent) error {
+	msg, err := newEventMsg(nc.eventsSubject, event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := nc.js.PublishMsg(msg, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("events.go PublishEvent(...) nc.js.PublishMsg(...): %w", err)
+	}
+
+	return nil
+}
+
+func newEventMsg(subject string, event models.OrderEvent) (*nats.Msg, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("events.go newEventMsg(...) json.Marshal(event): %w", err)
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	msg.Header.Set(headerEventType, event.ChangeType)
+	msg.Header.Set(nats.MsgIdHdr, "outbox-"+strconv.FormatInt(event.ID, 10))
+
+	return msg, nil
+}
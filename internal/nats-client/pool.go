@@ -0,0 +1,67 @@
+package natsclient
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// workerPool runs jobs concurrently while keeping jobs with the same key in
+// order: every key is hashed to one worker and each worker runs its jobs one
+// at a time. Queues are bounded, so dispatch blocks once a worker falls
+// behind; together with MaxAckPending this keeps the number of in-flight
+// messages bounded.
+type workerPool struct {
+	queues []chan func()
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(ctx context.Context, workers, queueSize int) *workerPool {
+	workers = max(workers, 1)
+	pool := &workerPool{queues: make([]chan func(), workers)}
+
+	for i := range pool.queues {
+		queue := make(chan func(), max(queueSize, 0))
+		pool.queues[i] = queue
+
+		pool.wg.Add(1)
+
+		go func() {
+			defer pool.wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-queue:
+					job()
+				}
+			}
+		}()
+	}
+
+	return pool
+}
+
+// dispatch queues job on the worker owning key. It returns false if ctx is
+// done before the job could be queued.
+func (p *workerPool) dispatch(ctx context.Context, key string, job func()) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case p.queues[p.partition(key)] <- job:
+		return true
+	}
+}
+
+func (p *workerPool) partition(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// wait blocks until every worker has stopped.
+func (p *workerPool) wait() {
+	p.wg.Wait()
+}
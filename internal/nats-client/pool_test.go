@@ -0,0 +1,103 @@
+package natsclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PoolSuite struct {
+	suite.Suite
+}
+
+func TestPoolSuite(t *testing.T) {
+	suite.Run(t, new(PoolSuite))
+}
+
+func (s *PoolSuite) TestDispatch_KeepsOrderPerKey() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := newWorkerPool(ctx, 4, 8)
+
+	const keys, jobsPerKey = 10, 100
+
+	var (
+		mu   sync.Mutex
+		seen = make(map[string][]int)
+		wg   sync.WaitGroup
+	)
+
+	for i := range jobsPerKey {
+		for k := range keys {
+			key := fmt.Sprintf("orderUID%d", k)
+
+			wg.Add(1)
+			s.Require().True(pool.dispatch(ctx, key, func() {
+				defer wg.Done()
+				mu.Lock()
+				seen[key] = append(seen[key], i)
+				mu.Unlock()
+			}))
+		}
+	}
+
+	wg.Wait()
+
+	for key, got := range seen {
+		s.Require().Len(got, jobsPerKey, key)
+		s.Require().IsIncreasing(got, key)
+	}
+}
+
+func (s *PoolSuite) TestDispatch_RunsKeysConcurrently() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := newWorkerPool(ctx, 2, 1)
+
+	// Find two keys owned by different workers.
+	first, second := "orderUID0", ""
+	for i := 1; second == ""; i++ {
+		if key := fmt.Sprintf("orderUID%d", i); pool.partition(key) != pool.partition(first) {
+			second = key
+		}
+	}
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+
+	s.Require().True(pool.dispatch(ctx, first, func() { <-release }))
+	s.Require().True(pool.dispatch(ctx, second, func() { close(done) }))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		s.Fail("a blocked worker stalled another key")
+	}
+
+	close(release)
+}
+
+func (s *PoolSuite) TestDispatch_StopsWithContext() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pool := newWorkerPool(ctx, 1, 0)
+
+	var ran atomic.Int32
+
+	block := make(chan struct{})
+	s.Require().True(pool.dispatch(ctx, "orderUID0", func() { <-block; ran.Add(1) }))
+
+	cancel()
+	close(block)
+	pool.wait()
+
+	s.Require().False(pool.dispatch(ctx, "orderUID0", func() { ran.Add(1) }))
+	s.Require().Equal(int32(1), ran.Load())
+}
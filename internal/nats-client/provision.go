@@ -0,0 +1,254 @@
+package natsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stsolovey/order_tracker/internal/config"
+)
+
+var ErrInvalidSetting = errors.New("invalid NATS setting")
+
+var durableReplacer = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_")
+
+var (
+	retentionPolicies = map[string]nats.RetentionPolicy{
+		"limits":    nats.LimitsPolicy,
+		"interest":  nats.InterestPolicy,
+		"workqueue": nats.WorkQueuePolicy,
+	}
+	storageTypes = map[string]nats.StorageType{
+		"file":   nats.FileStorage,
+		"memory": nats.MemoryStorage,
+	}
+	deliverPolicies = map[string]nats.DeliverPolicy{
+		"all":              nats.DeliverAllPolicy,
+		"new":              nats.DeliverNewPolicy,
+		"last":             nats.DeliverLastPolicy,
+		"last_per_subject": nats.DeliverLastPerSubjectPolicy,
+	}
+)
+
+type consumerSettings struct {
+	durable       string
+	ackWait       time.Duration
+	maxAckPending int
+	deliverPolicy nats.DeliverPolicy
+}
+
+// Drift is a difference between the configured and the actual JetStream
+// setting. Fixable drifts are reconciled in place, the others are only
+// reported because the server does not allow changing them.
+type Drift struct {
+	Field   string
+	Want    string
+	Got     string
+	Fixable bool
+}
+
+func newStreamConfig(cfg *config.Config) (*nats.StreamConfig, error) {
+	retention, ok := retentionPolicies[cfg.NATSStreamRetention]
+	if !ok {
+		return nil, fmt.Errorf("%w: retention policy %q", ErrInvalidSetting, cfg.NATSStreamRetention)
+	}
+
+	storage, ok := storageTypes[cfg.NATSStreamStorage]
+	if !ok {
+		return nil, fmt.Errorf("%w: storage type %q", ErrInvalidSetting, cfg.NATSStreamStorage)
+	}
+
+	return &nats.StreamConfig{
+		Name:      cfg.NATSStreamName,
+		Subjects:  cfg.NATSStreamSubjects,
+		Retention: retention,
+		MaxAge:    cfg.NATSStreamMaxAge,
+		MaxBytes:  cfg.NATSStreamMaxBytes,
+		Replicas:  max(cfg.NATSStreamReplicas, 1),
+		Storage:   storage,
+	}, nil
+}
+
+func newConsumerSettings(cfg *config.Config) (consumerSettings, error) {
+	deliverPolicy, ok := deliverPolicies[cfg.NATSDeliverPolicy]
+	if !ok {
+		return consumerSettings{}, fmt.Errorf("%w: deliver policy %q", ErrInvalidSetting, cfg.NATSDeliverPolicy)
+	}
+
+	return consumerSettings{
+		durable:       cfg.NATSDurableName,
+		ackWait:       cfg.NATSAckWait,
+		maxAckPending: cfg.NATSMaxAckPending,
+		deliverPolicy: deliverPolicy,
+	}, nil
+}
+
+// EnsureStream creates the orders stream or, if it already exists,
+// reconciles its configuration and reports any drift.
+func (nc *Client) EnsureStream(ctx context.Context) error {
+	info, err := nc.js.StreamInfo(nc.stream.Name, nats.Context(ctx))
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		if _, err := nc.js.AddStream(nc.stream, nats.Context(ctx)); err != nil {
+			return fmt.Errorf("natsclient EnsureStream nc.js.AddStream(%s): %w", nc.stream.Name, err)
+		}
+
+		nc.log.Infof("Stream %s created", nc.stream.Name)
+
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("natsclient EnsureStream nc.js.StreamInfo(%s): %w", nc.stream.Name, err)
+	}
+
+	drifts := streamDrift(nc.stream, &info.Config)
+	if !nc.reportDrift("stream", nc.stream.Name, drifts) {
+		return nil
+	}
+
+	updated := info.Config
+	updated.Subjects = nc.stream.Subjects
+	updated.MaxAge = nc.stream.MaxAge
+	updated.MaxBytes = nc.stream.MaxBytes
+	updated.Replicas = nc.stream.Replicas
+
+	if _, err := nc.js.UpdateStream(&updated, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("natsclient EnsureStream nc.js.UpdateStream(%s): %w", nc.stream.Name, err)
+	}
+
+	nc.log.Infof("Stream %s reconciled", nc.stream.Name)
+
+	return nil
+}
+
+// reconcileConsumer brings an existing durable consumer in line with the
+// configured ack settings. A consumer that doesn't exist yet is left to be
+// created by the subscription.
+func (nc *Client) reconcileConsumer(ctx context.Context, durable string) error {
+	info, err := nc.js.ConsumerInfo(nc.stream.Name, durable, nats.Context(ctx))
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("natsclient reconcileConsumer nc.js.ConsumerInfo(%s): %w", durable, err)
+	}
+
+	drifts := consumerDrift(nc.consumer, nc.maxDeliver, &info.Config)
+
+	if info.Config.DeliverPolicy != nc.consumer.deliverPolicy {
+		// The deliver policy of an existing consumer is immutable; bind with
+		// the server's value so the subscription doesn't fail.
+		nc.consumer.deliverPolicy = info.Config.DeliverPolicy
+	}
+
+	if !nc.reportDrift("consumer", durable, drifts) {
+		return nil
+	}
+
+	updated := info.Config
+	updated.AckWait = nc.consumer.ackWait
+	updated.MaxAckPending = nc.consumer.maxAckPending
+
+	if nc.maxDeliver > 0 {
+		updated.MaxDeliver = nc.maxDeliver
+	}
+
+	if _, err := nc.js.UpdateConsumer(nc.stream.Name, &updated, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("natsclient reconcileConsumer nc.js.UpdateConsumer(%s): %w", durable, err)
+	}
+
+	nc.log.Infof("Consumer %s reconciled", durable)
+
+	return nil
+}
+
+// reportDrift logs every drift and reports whether any of them is fixable.
+func (nc *Client) reportDrift(kind, name string, drifts []Drift) bool {
+	fixable := false
+
+	for _, d := range drifts {
+		entry := nc.log.WithField(kind, name).
+			WithField("field", d.Field).
+			WithField("want", d.Want).
+			WithField("got", d.Got)
+
+		if d.Fixable {
+			fixable = true
+
+			entry.Warnf("JetStream %s config drift, reconciling", kind)
+		} else {
+			entry.Errorf("JetStream %s config drift cannot be reconciled in place", kind)
+		}
+	}
+
+	return fixable
+}
+
+// durableFor names the durable consumer of an ingest subject. The main
+// subject keeps the configured name, other subjects get it as a prefix.
+func (nc *Client) durableFor(subject string) string {
+	if subject == nc.subject {
+		return nc.consumer.durable
+	}
+
+	return nc.consumer.durable + "_" + durableReplacer.Replace(subject)
+}
+
+func streamDrift(want, got *nats.StreamConfig) []Drift {
+	var drifts []Drift
+
+	add := func(field string, want, got any, fixable bool) {
+		if fmt.Sprint(want) != fmt.Sprint(got) {
+			drifts = append(drifts, Drift{Field: field, Want: fmt.Sprint(want), Got: fmt.Sprint(got), Fixable: fixable})
+		}
+	}
+
+	wantSubjects, gotSubjects := slices.Clone(want.Subjects), slices.Clone(got.Subjects)
+	slices.Sort(wantSubjects)
+	slices.Sort(gotSubjects)
+
+	add("subjects", wantSubjects, gotSubjects, true)
+	add("max_age", want.MaxAge, got.MaxAge, true)
+	add("max_bytes", want.MaxBytes, got.MaxBytes, true)
+	add("replicas", max(want.Replicas, 1), max(got.Replicas, 1), true)
+	add("retention", want.Retention, got.Retention, false)
+	add("storage", want.Storage, got.Storage, false)
+
+	return drifts
+}
+
+func consumerDrift(want consumerSettings, maxDeliver int, got *nats.ConsumerConfig) []Drift {
+	var drifts []Drift
+
+	add := func(field, want, got string, fixable bool) {
+		if want != got {
+			drifts = append(drifts, Drift{Field: field, Want: want, Got: got, Fixable: fixable})
+		}
+	}
+
+	add("ack_wait", want.ackWait.String(), got.AckWait.String(), true)
+	add("max_ack_pending", strconv.Itoa(want.maxAckPending), strconv.Itoa(got.MaxAckPending), true)
+	add("deliver_policy", deliverPolicyName(want.deliverPolicy), deliverPolicyName(got.DeliverPolicy), false)
+
+	if maxDeliver > 0 {
+		add("max_deliver", strconv.Itoa(maxDeliver), strconv.Itoa(got.MaxDeliver), true)
+	}
+
+	return drifts
+}
+
+func deliverPolicyName(policy nats.DeliverPolicy) string {
+	for name, p := range deliverPolicies {
+		if p == policy {
+			return name
+		}
+	}
+
+	return strconv.Itoa(int(policy))
+}
@@ -0,0 +1,94 @@
+package natsclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/config"
+)
+
+type ProvisionSuite struct {
+	suite.Suite
+	cfg *config.Config
+}
+
+func (s *ProvisionSuite) SetupTest() {
+	s.cfg = &config.Config{
+		NATSStreamName:      "ORDERS",
+		NATSStreamSubjects:  []string{"orders", "orders.delete"},
+		NATSStreamRetention: "limits",
+		NATSStreamMaxAge:    24 * time.Hour,
+		NATSStreamMaxBytes:  -1,
+		NATSStreamReplicas:  1,
+		NATSStreamStorage:   "file",
+		NATSDurableName:     "order_tracker",
+		NATSAckWait:         30 * time.Second,
+		NATSMaxAckPending:   1000,
+		NATSDeliverPolicy:   "all",
+	}
+}
+
+func TestProvisionSuite(t *testing.T) {
+	suite.Run(t, new(ProvisionSuite))
+}
+
+func (s *ProvisionSuite) TestNewStreamConfig() {
+	stream, err := newStreamConfig(s.cfg)
+	s.Require().NoError(err)
+	s.Require().Equal(nats.LimitsPolicy, stream.Retention)
+	s.Require().Equal(nats.FileStorage, stream.Storage)
+
+	s.Run("unknown retention policy", func() {
+		s.cfg.NATSStreamRetention = "forever"
+		_, err := newStreamConfig(s.cfg)
+		s.Require().ErrorIs(err, ErrInvalidSetting)
+	})
+
+	s.Run("unknown deliver policy", func() {
+		s.cfg.NATSDeliverPolicy = "sometimes"
+		_, err := newConsumerSettings(s.cfg)
+		s.Require().ErrorIs(err, ErrInvalidSetting)
+	})
+}
+
+func (s *ProvisionSuite) TestStreamDrift() {
+	want, err := newStreamConfig(s.cfg)
+	s.Require().NoError(err)
+
+	got := *want
+	got.Subjects = []string{"orders.delete", "orders"}
+
+	s.Require().Empty(streamDrift(want, &got), "subject order must not count as drift")
+
+	got.MaxAge = time.Hour
+	got.Storage = nats.MemoryStorage
+
+	drifts := streamDrift(want, &got)
+	s.Require().Len(drifts, 2)
+	s.Require().Equal(Drift{Field: "max_age", Want: "24h0m0s", Got: "1h0m0s", Fixable: true}, drifts[0])
+	s.Require().Equal("storage", drifts[1].Field)
+	s.Require().False(drifts[1].Fixable)
+}
+
+func (s *ProvisionSuite) TestConsumerDrift() {
+	want, err := newConsumerSettings(s.cfg)
+	s.Require().NoError(err)
+
+	got := &nats.ConsumerConfig{
+		AckWait:       30 * time.Second,
+		MaxAckPending: 1000,
+		MaxDeliver:    5,
+		DeliverPolicy: nats.DeliverNewPolicy,
+	}
+
+	drifts := consumerDrift(want, 5, got)
+	s.Require().Equal([]Drift{{Field: "deliver_policy", Want: "all", Got: "new"}}, drifts)
+
+	got.DeliverPolicy = nats.DeliverAllPolicy
+	got.MaxDeliver = 3
+
+	drifts = consumerDrift(want, 5, got)
+	s.Require().Equal([]Drift{{Field: "max_deliver", Want: "5", Got: "3", Fixable: true}}, drifts)
+}
@@ -0,0 +1,103 @@
+package natsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+// PullSubscribe starts a durable pull consumer on subject. Each worker
+// fetches up to pullBatchSize messages, upserts them as a single batch and
+// acks or naks every message according to its own outcome.
+func (nc *Client) PullSubscribe(ctx context.Context, subject string) error {
+	durable := nc.durableFor(subject)
+
+	if err := nc.reconcileConsumer(ctx, durable); err != nil {
+		return fmt.Errorf("natsclient PullSubscribe(...): %w", err)
+	}
+
+	sub, err := nc.js.PullSubscribe(subject, durable, nc.subOpts()...)
+	if err != nil {
+		return fmt.Errorf("natsclient PullSubscribe(...): %w", err)
+	}
+
+	workers := max(nc.pullWorkers, 1)
+
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			nc.pullLoop(ctx, sub)
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		nc.Close()
+	}()
+
+	return nil
+}
+
+func (nc *Client) pullLoop(ctx context.Context, sub *nats.Subscription) {
+	for ctx.Err() == nil {
+		fetchCtx, cancel := context.WithTimeout(ctx, nc.pullMaxWait)
+		msgs, err := sub.Fetch(max(nc.pullBatchSize, 1), nats.Context(fetchCtx))
+
+		cancel()
+
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) ||
+				errors.Is(err, nats.ErrTimeout) {
+				continue
+			}
+
+			if errors.Is(err, nats.ErrConnectionClosed) || errors.Is(err, nats.ErrBadSubscription) {
+				return
+			}
+
+			nc.log.WithError(err).Error("failed to fetch messages")
+
+			continue
+		}
+
+		nc.handleBatch(ctx, msgs)
+	}
+}
+
+func (nc *Client) handleBatch(ctx context.Context, msgs []*nats.Msg) {
+	orders := make([]models.Order, 0, len(msgs))
+	decoded := make([]*nats.Msg, 0, len(msgs))
+
+	for _, msg := range msgs {
+		order, err := nc.decodeOrder(msg)
+		if err != nil {
+			nc.log.WithError(err).Error("failed to unmarshal order")
+			nc.reject(msg, err)
+
+			continue
+		}
+
+		orders = append(orders, order)
+		decoded = append(decoded, msg)
+	}
+
+	if len(orders) == 0 {
+		return
+	}
+
+	results := nc.service.UpsertOrders(ctx, orders)
+
+	for i, msg := range decoded {
+		nc.settle(msg, &orders[i], results[i])
+	}
+
+	nc.log.Debugf("Processed batch of %d messages", len(msgs))
+}
@@ -0,0 +1,258 @@
+package natsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+const (
+	defaultReplayProgressInterval = 5 * time.Second
+	// replayIdleTimeout is how long Replay waits for the next message before
+	// asking the server whether any are left.
+	replayIdleTimeout = 5 * time.Second
+)
+
+var ErrReplayOptions = errors.New("invalid replay options")
+
+type ReplayOptions struct {
+	StartSeq         uint64
+	StartTime        time.Time
+	DryRun           bool
+	Rate             int // messages per second, 0 disables throttling
+	ProgressInterval time.Duration
+}
+
+type ReplayStats struct {
+	LastSeq   uint64 `json:"lastSeq"`
+	Processed uint64 `json:"processed"`
+	Upserted  uint64 `json:"upserted"`
+	Deleted   uint64 `json:"deleted"`
+	Stale     uint64 `json:"stale"`
+	Invalid   uint64 `json:"invalid"`
+	Failed    uint64 `json:"failed"`
+}
+
+// Replay re-feeds the orders stream, order updates and tombstones alike,
+// through the service from a given sequence or timestamp using an ephemeral
+// ordered consumer. It stops at the last message that was in the stream when
+// the replay started, or earlier when the consumer has nothing left to
+// deliver, e.g. because the start time is past the newest message. Versioning makes it safe against a live database:
+// orders older than the stored ones are skipped.
+func (nc *Client) Replay(ctx context.Context, opts ReplayOptions) (ReplayStats, error) {
+	var stats ReplayStats
+
+	startOpt, err := replayStartOpt(opts)
+	if err != nil {
+		return stats, err
+	}
+
+	info, err := nc.js.StreamInfo(nc.stream.Name, nats.Context(ctx))
+	if err != nil {
+		return stats, fmt.Errorf("natsclient Replay nc.js.StreamInfo(%s): %w", nc.stream.Name, err)
+	}
+
+	endSeq := info.State.LastSeq
+	if info.State.Msgs == 0 || opts.StartSeq > endSeq {
+		nc.log.Info("Nothing to replay")
+
+		return stats, nil
+	}
+
+	sub, err := nc.js.SubscribeSync("", nats.OrderedConsumer(), nats.BindStream(nc.stream.Name), startOpt)
+	if err != nil {
+		return stats, fmt.Errorf("natsclient Replay nc.js.SubscribeSync(...): %w", err)
+	}
+
+	defer func() {
+		if err := sub.Unsubscribe(); err != nil {
+			nc.log.WithError(err).Warn("natsclient Replay sub.Unsubscribe()")
+		}
+	}()
+
+	consumer, err := sub.ConsumerInfo()
+	if err != nil {
+		return stats, fmt.Errorf("natsclient Replay sub.ConsumerInfo(): %w", err)
+	}
+
+	if consumer.NumPending == 0 && consumer.Delivered.Consumer == 0 {
+		nc.log.Info("Nothing to replay")
+
+		return stats, nil
+	}
+
+	var throttle <-chan time.Time
+
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(opts.Rate))
+		defer ticker.Stop()
+
+		throttle = ticker.C
+	}
+
+	progressInterval := opts.ProgressInterval
+	if progressInterval <= 0 {
+		progressInterval = defaultReplayProgressInterval
+	}
+
+	lastProgress := time.Now()
+
+	for {
+		msg, err := nextReplayMsg(ctx, sub)
+		if err != nil {
+			return stats, err
+		}
+
+		if msg == nil {
+			nc.logReplayProgress(stats, endSeq)
+
+			return stats, nil
+		}
+
+		meta, err := msg.Metadata()
+		if err != nil {
+			return stats, fmt.Errorf("natsclient Replay msg.Metadata(): %w", err)
+		}
+
+		if throttle != nil {
+			select {
+			case <-ctx.Done():
+				return stats, fmt.Errorf("natsclient Replay: %w", ctx.Err())
+			case <-throttle:
+			}
+		}
+
+		switch {
+		case msg.Subject == nc.deleteSubject:
+			nc.replayTombstone(ctx, msg, opts.DryRun, &stats)
+		case nc.isOrderSubject(msg.Subject):
+			nc.replayMessage(ctx, msg, opts.DryRun, &stats)
+		}
+
+		stats.LastSeq = meta.Sequence.Stream
+
+		if time.Since(lastProgress) >= progressInterval {
+			nc.logReplayProgress(stats, endSeq)
+			lastProgress = time.Now()
+		}
+
+		if meta.Sequence.Stream >= endSeq || meta.NumPending == 0 {
+			nc.logReplayProgress(stats, endSeq)
+
+			return stats, nil
+		}
+	}
+}
+
+// nextReplayMsg waits for the next message of the replay. It returns a nil
+// message when the consumer stayed idle and the server has no messages left
+// for it, so messages removed from the stream mid-replay can't stall it.
+func nextReplayMsg(ctx context.Context, sub *nats.Subscription) (*nats.Msg, error) {
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, replayIdleTimeout)
+		msg, err := sub.NextMsgWithContext(waitCtx)
+
+		cancel()
+
+		if err == nil {
+			return msg, nil
+		}
+
+		if ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("natsclient Replay sub.NextMsgWithContext(...): %w", err)
+		}
+
+		consumer, err := sub.ConsumerInfo()
+		if err != nil {
+			return nil, fmt.Errorf("natsclient Replay sub.ConsumerInfo(): %w", err)
+		}
+
+		if consumer.NumPending == 0 {
+			return nil, nil //nolint:nilnil
+		}
+	}
+}
+
+func (nc *Client) replayMessage(ctx context.Context, msg *nats.Msg, dryRun bool, stats *ReplayStats) {
+	stats.Processed++
+
+	order, err := nc.decodeOrder(msg)
+	if err != nil {
+		stats.Invalid++
+		nc.log.WithError(err).Warn("Replay: failed to unmarshal order")
+
+		return
+	}
+
+	if dryRun {
+		err = order.Validate()
+	} else {
+		err = nc.service.UpsertOrder(ctx, order)
+	}
+
+	switch {
+	case err == nil:
+		stats.Upserted++
+	case errors.Is(err, models.ErrStaleVersion):
+		stats.Stale++
+	case errors.Is(err, models.ErrInvalidOrder):
+		stats.Invalid++
+		nc.log.WithError(err).Warnf("Replay: order %s is invalid", order.OrderUID)
+	default:
+		stats.Failed++
+		nc.log.WithError(err).Errorf("Replay: failed to upsert order %s", order.OrderUID)
+	}
+}
+
+func (nc *Client) replayTombstone(ctx context.Context, msg *nats.Msg, dryRun bool, stats *ReplayStats) {
+	stats.Processed++
+
+	tombstone, err := decodeTombstone(msg)
+	if err != nil {
+		stats.Invalid++
+		nc.log.WithError(err).Warn("Replay: failed to unmarshal tombstone")
+
+		return
+	}
+
+	if !dryRun {
+		err = nc.service.DeleteOrder(ctx, tombstone)
+	}
+
+	switch {
+	case err == nil:
+		stats.Deleted++
+	case errors.Is(err, models.ErrStaleVersion), errors.Is(err, models.ErrOrderNotFound):
+		stats.Stale++
+	default:
+		stats.Failed++
+		nc.log.WithError(err).Errorf("Replay: failed to delete order %s", tombstone.OrderUID)
+	}
+}
+
+func (nc *Client) logReplayProgress(stats ReplayStats, endSeq uint64) {
+	nc.log.WithField("processed", stats.Processed).
+		WithField("upserted", stats.Upserted).
+		WithField("deleted", stats.Deleted).
+		WithField("stale", stats.Stale).
+		WithField("invalid", stats.Invalid).
+		WithField("failed", stats.Failed).
+		Infof("Replay progress: sequence %d of %d", stats.LastSeq, endSeq)
+}
+
+func replayStartOpt(opts ReplayOptions) (nats.SubOpt, error) {
+	switch {
+	case opts.StartSeq > 0 && !opts.StartTime.IsZero():
+		return nil, fmt.Errorf("%w: start sequence and start time are mutually exclusive", ErrReplayOptions)
+	case !opts.StartTime.IsZero():
+		return nats.StartTime(opts.StartTime), nil
+	case opts.StartSeq > 0:
+		return nats.StartSequence(opts.StartSeq), nil
+	default:
+		return nats.DeliverAll(), nil
+	}
+}
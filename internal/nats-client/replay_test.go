@@ -0,0 +1,19 @@
+package natsclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayStartOpt(t *testing.T) {
+	_, err := replayStartOpt(ReplayOptions{StartSeq: 10, StartTime: time.Now()})
+	require.ErrorIs(t, err, ErrReplayOptions)
+
+	for _, opts := range []ReplayOptions{{}, {StartSeq: 10}, {StartTime: time.Now()}} {
+		opt, err := replayStartOpt(opts)
+		require.NoError(t, err)
+		require.NotNil(t, opt)
+	}
+}
@@ -0,0 +1,57 @@
+package natsclient
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stsolovey/order_tracker/internal/storage"
+)
+
+// ErrMalformedMessage marks messages that can't be decoded. Redelivering
+// them can't help, so they are dead-lettered right away.
+var ErrMalformedMessage = errors.New("malformed message")
+
+func isPermanent(err error) bool {
+	return errors.Is(err, ErrMalformedMessage) || storage.Classify(err) == storage.ErrorPermanent
+}
+
+// retry naks a message so that it is redelivered after an exponential
+// backoff based on how many times it has been delivered already.
+func (nc *Client) retry(msg *nats.Msg, meta *nats.MsgMetadata) {
+	var delivered uint64 = 1
+	if meta != nil {
+		delivered = meta.NumDelivered
+	}
+
+	delay := backoff(nc.retryBaseDelay, nc.retryMaxDelay, delivered)
+
+	if err := msg.NakWithDelay(delay); err != nil {
+		nc.log.WithError(err).Error("failed to negatively acknowledge message")
+
+		return
+	}
+
+	nc.log.Debugf("Message will be redelivered in %s (delivery %d)", delay, delivered)
+}
+
+// backoff doubles base for every delivery after the first, caps the result
+// at maxDelay and randomizes its upper half, so that messages that failed
+// together don't come back together.
+func backoff(base, maxDelay time.Duration, delivered uint64) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+
+	delay := base
+	for i := uint64(1); i < delivered && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	if maxDelay > 0 {
+		delay = min(delay, maxDelay)
+	}
+
+	return delay/2 + rand.N(delay/2+1) //nolint:gosec
+}
@@ -0,0 +1,57 @@
+package natsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type RetrySuite struct {
+	suite.Suite
+}
+
+func TestRetrySuite(t *testing.T) {
+	suite.Run(t, new(RetrySuite))
+}
+
+func (s *RetrySuite) TestBackoff() {
+	base, maxDelay := time.Second, 10*time.Second
+
+	cases := []struct {
+		delivered uint64
+		want      time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, tc := range cases {
+		for range 50 {
+			delay := backoff(base, maxDelay, tc.delivered)
+			s.Require().GreaterOrEqual(delay, tc.want/2, "delivery %d", tc.delivered)
+			s.Require().LessOrEqual(delay, tc.want, "delivery %d", tc.delivered)
+		}
+	}
+
+	s.Require().Zero(backoff(0, maxDelay, 3))
+}
+
+func (s *RetrySuite) TestIsPermanent() {
+	s.Require().True(isPermanent(fmt.Errorf("unmarshal order: %w: %w", ErrMalformedMessage, errors.New("bad"))))
+	s.Require().True(isPermanent(fmt.Errorf("upsert: %w", models.ErrInvalidOrder)))
+	s.Require().True(isPermanent(&pgconn.PgError{Code: "23505"}))
+
+	s.Require().False(isPermanent(&pgconn.PgError{Code: "40001"}))
+	s.Require().False(isPermanent(context.DeadlineExceeded))
+	s.Require().False(isPermanent(errors.New("something else")))
+}
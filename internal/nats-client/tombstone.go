@@ -0,0 +1,81 @@
+package natsclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+var errMissingOrderUID = errors.New("tombstone has no orderUid")
+
+// SubscribeTombstones consumes deletion requests from the delete subject.
+// Tombstones have their own durable consumer, so they are handled the same
+// way in push and pull mode.
+func (nc *Client) SubscribeTombstones(ctx context.Context) error {
+	_, err := nc.js.QueueSubscribe(nc.deleteSubject, nc.consumer.durable+"_delete", func(msg *nats.Msg) {
+		nc.handleTombstone(ctx, msg)
+	}, nc.subOpts()...)
+	if err != nil {
+		return fmt.Errorf("natsclient SubscribeTombstones(...): %w", err)
+	}
+
+	return nil
+}
+
+func (nc *Client) handleTombstone(ctx context.Context, msg *nats.Msg) {
+	tombstone, err := decodeTombstone(msg)
+	if err != nil {
+		nc.log.WithError(err).Error("failed to unmarshal tombstone")
+		nc.reject(msg, err)
+
+		return
+	}
+
+	nc.workerPool(ctx).dispatch(ctx, tombstone.OrderUID, func() {
+		nc.settleTombstone(msg, tombstone, nc.service.DeleteOrder(ctx, tombstone))
+	})
+}
+
+func (nc *Client) settleTombstone(msg *nats.Msg, tombstone models.Tombstone, err error) {
+	switch {
+	case err == nil:
+		nc.log.Infof("Order %s deleted", tombstone.OrderUID)
+		nc.ack(msg)
+	case errors.Is(err, models.ErrOrderNotFound):
+		nc.log.Infof("Order %s to delete not found, skipping", tombstone.OrderUID)
+		nc.ack(msg)
+	case errors.Is(err, models.ErrStaleVersion):
+		nc.log.Infof("Tombstone for order %s version %d is stale, skipping", tombstone.OrderUID, tombstone.Version)
+		nc.ack(msg)
+	default:
+		nc.log.WithError(err).Error("failed to delete order")
+		nc.reject(msg, fmt.Errorf("delete order %s: %w", tombstone.OrderUID, err))
+	}
+}
+
+// decodeTombstone unmarshals a deletion request. Like orders, tombstones
+// without an explicit version get the stream sequence as their version, so
+// they are ordered against the order updates in the same stream.
+func decodeTombstone(msg *nats.Msg) (models.Tombstone, error) {
+	var tombstone models.Tombstone
+
+	if err := json.Unmarshal(msg.Data, &tombstone); err != nil {
+		return tombstone, fmt.Errorf("unmarshal tombstone: %w: %w", ErrMalformedMessage, err)
+	}
+
+	if tombstone.OrderUID == "" {
+		return tombstone, fmt.Errorf("%w: %w", ErrMalformedMessage, errMissingOrderUID)
+	}
+
+	tombstone.Source = messageSource(msg)
+
+	if tombstone.Version == 0 {
+		tombstone.Version = tombstone.Source.Sequence
+	}
+
+	return tombstone, nil
+}
@@ -0,0 +1,43 @@
+package natsclient
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type TombstoneSuite struct {
+	suite.Suite
+}
+
+func TestTombstoneSuite(t *testing.T) {
+	suite.Run(t, new(TombstoneSuite))
+}
+
+func (s *TombstoneSuite) TestDecodeTombstone() {
+	msg := nats.NewMsg("orders.delete")
+	msg.Data = []byte(`{"orderUid":"testUID123","version":7,"hard":true}`)
+
+	tombstone, err := decodeTombstone(msg)
+	s.Require().NoError(err)
+	s.Require().Equal("orders.delete", tombstone.Source.Subject)
+	s.Require().False(tombstone.Source.ReceivedAt.IsZero())
+
+	tombstone.Source = models.Source{}
+	s.Require().Equal(models.Tombstone{OrderUID: "testUID123", Version: 7, Hard: true}, tombstone)
+}
+
+func (s *TombstoneSuite) TestDecodeTombstone_Invalid() {
+	msg := nats.NewMsg("orders.delete")
+
+	msg.Data = []byte(`{"version":7}`)
+	_, err := decodeTombstone(msg)
+	s.Require().ErrorIs(err, errMissingOrderUID)
+	s.Require().ErrorIs(err, ErrMalformedMessage)
+
+	msg.Data = []byte(`not json`)
+	_, err = decodeTombstone(msg)
+	s.Require().Error(err)
+}
@@ -2,6 +2,7 @@ package ordercache
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	"github.com/sirupsen/logrus"
@@ -38,6 +39,17 @@ func (oc *OrderCache) Upsert(_ context.Context, order models.Order) error {
 	oc.mu.Lock()
 	defer oc.mu.Unlock()
 
+	if stored, found := oc.m[order.OrderUID]; found {
+		if !order.Supersedes(&stored) {
+			return fmt.Errorf("order_cache.go Upsert(%s) version %d <= %d: %w",
+				order.OrderUID, order.Version, stored.Version, models.ErrStaleVersion)
+		}
+
+		if order.Version == 0 {
+			order.Version = stored.Version
+		}
+	}
+
 	oc.m[order.OrderUID] = order
 	oc.log.Debugf("order_cache.go Upsert(...), order upserted: %s", order.OrderUID)
 
@@ -55,3 +67,12 @@ func (oc *OrderCache) Delete(_ context.Context, orderUID string) {
 		oc.log.Debug("Order not found:", orderUID)
 	}
 }
+
+// Clear evicts every order.
+func (oc *OrderCache) Clear(_ context.Context) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	oc.m = make(map[string]models.Order)
+	oc.log.Debug("Cache cleared")
+}
@@ -104,3 +104,67 @@ func (s *OrderCacheSuite) TestDelete() {
 		s.Require().Nil(retrievedOrder, "Retrieved order should be nil after deletion")
 	})
 }
+
+func (s *OrderCacheSuite) TestUpsertVersioning() {
+	order := models.Order{
+		OrderUID:    "testUID789",
+		TrackNumber: "TN1",
+		Version:     5,
+	}
+
+	err := s.cache.Upsert(s.ctx, order)
+	s.Require().NoError(err)
+
+	s.Run("older version is rejected", func() {
+		older := order
+		older.TrackNumber = "TN0"
+		older.Version = 4
+
+		err := s.cache.Upsert(s.ctx, older)
+		s.Require().ErrorIs(err, models.ErrStaleVersion)
+
+		retrieved, err := s.cache.Get(s.ctx, order.OrderUID)
+		s.Require().NoError(err)
+		s.Require().Equal("TN1", retrieved.TrackNumber, "cache must not move backwards")
+	})
+
+	s.Run("same version is rejected", func() {
+		err := s.cache.Upsert(s.ctx, order)
+		s.Require().ErrorIs(err, models.ErrStaleVersion)
+	})
+
+	s.Run("newer version is applied", func() {
+		newer := order
+		newer.TrackNumber = "TN2"
+		newer.Version = 6
+
+		s.Require().NoError(s.cache.Upsert(s.ctx, newer))
+
+		retrieved, err := s.cache.Get(s.ctx, order.OrderUID)
+		s.Require().NoError(err)
+		s.Require().Equal("TN2", retrieved.TrackNumber)
+	})
+
+	s.Run("unversioned update keeps the stored version", func() {
+		unversioned := order
+		unversioned.TrackNumber = "TN3"
+		unversioned.Version = 0
+
+		s.Require().NoError(s.cache.Upsert(s.ctx, unversioned))
+
+		retrieved, err := s.cache.Get(s.ctx, order.OrderUID)
+		s.Require().NoError(err)
+		s.Require().Equal("TN3", retrieved.TrackNumber)
+		s.Require().Equal(uint64(6), retrieved.Version)
+	})
+}
+
+func (s *OrderCacheSuite) TestClear() {
+	s.Require().NoError(s.cache.Upsert(s.ctx, models.Order{OrderUID: "testUID1"}))
+	s.Require().NoError(s.cache.Upsert(s.ctx, models.Order{OrderUID: "testUID2"}))
+
+	s.cache.Clear(s.ctx)
+
+	_, err := s.cache.Get(s.ctx, "testUID1")
+	s.Require().ErrorIs(err, models.ErrOrderNotFound)
+}
@@ -0,0 +1,102 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type PublishFunc func(ctx context.Context, event models.OrderEvent) error
+
+type Store interface {
+	RelayOutbox(ctx context.Context, limit int, publish PublishFunc) (int, error)
+	PurgeOutbox(ctx context.Context, olderThan time.Time) (int64, error)
+}
+
+// Relay periodically publishes pending outbox events and purges the sent ones
+// once they are older than the retention period.
+type Relay struct {
+	log       *logrus.Logger
+	store     Store
+	publish   PublishFunc
+	interval  time.Duration
+	batchSize int
+	retention time.Duration
+}
+
+func NewRelay(
+	log *logrus.Logger,
+	store Store,
+	publish PublishFunc,
+	interval time.Duration,
+	batchSize int,
+	retention time.Duration,
+) *Relay {
+	return &Relay{
+		log:       log,
+		store:     store,
+		publish:   publish,
+		interval:  interval,
+		batchSize: batchSize,
+		retention: retention,
+	}
+}
+
+// Run relays events until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.Drain(ctx)
+		r.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain relays full batches until the outbox has nothing left that is due,
+// and returns the number of published events.
+func (r *Relay) Drain(ctx context.Context) int {
+	total := 0
+
+	for ctx.Err() == nil {
+		sent, err := r.store.RelayOutbox(ctx, r.batchSize, r.publish)
+		if err != nil {
+			r.log.WithError(err).Error("failed to relay outbox")
+
+			return total
+		}
+
+		total += sent
+
+		if sent < r.batchSize {
+			return total
+		}
+	}
+
+	return total
+}
+
+func (r *Relay) purge(ctx context.Context) {
+	if r.retention <= 0 {
+		return
+	}
+
+	purged, err := r.store.PurgeOutbox(ctx, time.Now().Add(-r.retention))
+	if err != nil {
+		r.log.WithError(err).Error("failed to purge outbox")
+
+		return
+	}
+
+	if purged > 0 {
+		r.log.Infof("Purged %d sent outbox events", purged)
+	}
+}
@@ -0,0 +1,104 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/outbox"
+)
+
+var errPublish = errors.New("publish failed")
+
+type fakeStore struct {
+	pending  []models.OrderEvent
+	batches  []int
+	purgedAt time.Time
+}
+
+func (f *fakeStore) RelayOutbox(ctx context.Context, limit int, publish outbox.PublishFunc) (int, error) {
+	sent := 0
+
+	for _, event := range f.pending[:min(limit, len(f.pending))] {
+		if err := publish(ctx, event); err != nil {
+			break
+		}
+
+		sent++
+	}
+
+	f.pending = f.pending[sent:]
+	f.batches = append(f.batches, sent)
+
+	return sent, nil
+}
+
+func (f *fakeStore) PurgeOutbox(_ context.Context, olderThan time.Time) (int64, error) {
+	f.purgedAt = olderThan
+
+	return 0, nil
+}
+
+type RelaySuite struct {
+	suite.Suite
+	store     *fakeStore
+	published []int64
+}
+
+func (s *RelaySuite) SetupTest() {
+	s.store = &fakeStore{}
+	s.published = nil
+
+	for id := int64(1); id <= 5; id++ {
+		s.store.pending = append(s.store.pending, models.OrderEvent{ID: id})
+	}
+}
+
+func TestRelaySuite(t *testing.T) {
+	suite.Run(t, new(RelaySuite))
+}
+
+func (s *RelaySuite) publish(_ context.Context, event models.OrderEvent) error {
+	s.published = append(s.published, event.ID)
+
+	return nil
+}
+
+func (s *RelaySuite) TestDrainRelaysAllBatches() {
+	relay := outbox.NewRelay(logrus.New(), s.store, s.publish, time.Second, 2, time.Hour)
+
+	s.Require().Equal(5, relay.Drain(context.Background()))
+	s.Require().Equal([]int64{1, 2, 3, 4, 5}, s.published)
+	s.Require().Equal([]int{2, 2, 1}, s.store.batches)
+}
+
+func (s *RelaySuite) TestDrainStopsOnPublishFailure() {
+	publish := func(ctx context.Context, event models.OrderEvent) error {
+		if event.ID == 3 {
+			return errPublish
+		}
+
+		return s.publish(ctx, event)
+	}
+
+	relay := outbox.NewRelay(logrus.New(), s.store, publish, time.Second, 2, time.Hour)
+
+	s.Require().Equal(2, relay.Drain(context.Background()))
+	s.Require().Equal([]int64{1, 2}, s.published)
+	s.Require().Len(s.store.pending, 3)
+}
+
+func (s *RelaySuite) TestRunPurgesAndStops() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	relay := outbox.NewRelay(logrus.New(), s.store, s.publish, time.Second, 10, time.Hour)
+	relay.Run(ctx)
+
+	s.Require().Empty(s.published)
+	s.Require().WithinDuration(time.Now().Add(-time.Hour), s.store.purgedAt, time.Minute)
+}
@@ -0,0 +1,167 @@
+// Package filestore is a repository.Repository kept in a single local file,
+// for running the service standalone without Postgres. Orders are served
+// from memory; every write is appended to the file as JSON lines and synced
+// before it is applied. The file is compacted whenever it is opened.
+package filestore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/stsolovey/order_tracker/internal/repository/memory"
+)
+
+type Store struct {
+	*memory.Store
+
+	file *os.File
+	size int64
+}
+
+// Open loads the store kept in path, creating the file if needed. A last
+// line cut short by a crash is dropped: its write was never acknowledged.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil { //nolint:mnd
+		return nil, fmt.Errorf("filestore.go Open os.MkdirAll(...): %w", err)
+	}
+
+	changes, err := readChanges(path)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &Store{Store: memory.New()}
+	store.Restore(changes)
+
+	store.size, err = compact(path, store.Snapshot())
+	if err != nil {
+		return nil, err
+	}
+
+	store.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, fmt.Errorf("filestore.go Open os.OpenFile(...): %w", err)
+	}
+
+	store.OnWrite(store.append)
+
+	return store, nil
+}
+
+func (s *Store) Close() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("filestore.go Close s.file.Close(): %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) append(changes []memory.Change) error {
+	data, err := encodeChanges(changes)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.file.Write(data); err != nil {
+		// Cut off what was written, so later lines don't follow a torn one.
+		_ = s.file.Truncate(s.size)
+
+		return fmt.Errorf("filestore.go append s.file.Write(...): %w", err)
+	}
+
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("filestore.go append s.file.Sync(): %w", err)
+	}
+
+	s.size += int64(len(data))
+
+	return nil
+}
+
+func readChanges(path string) ([]memory.Change, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("filestore.go readChanges os.Open(...): %w", err)
+	}
+	defer file.Close()
+
+	var (
+		changes []memory.Change
+		reader  = bufio.NewReader(file)
+	)
+
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return changes, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("filestore.go readChanges reader.ReadBytes(...): %w", err)
+		}
+
+		var change memory.Change
+		if err := json.Unmarshal(data, &change); err != nil {
+			return nil, fmt.Errorf("filestore.go readChanges %s:%d: %w", path, line, err)
+		}
+
+		changes = append(changes, change)
+	}
+}
+
+// compact replaces the file at path with one holding only changes and
+// returns its size.
+func compact(path string, changes []memory.Change) (int64, error) {
+	data, err := encodeChanges(changes)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("filestore.go compact os.CreateTemp(...): %w", err)
+	}
+
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	if _, err := file.Write(data); err != nil {
+		return 0, fmt.Errorf("filestore.go compact file.Write(...): %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return 0, fmt.Errorf("filestore.go compact file.Sync(): %w", err)
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return 0, fmt.Errorf("filestore.go compact os.Rename(...): %w", err)
+	}
+
+	return int64(len(data)), nil
+}
+
+func encodeChanges(changes []memory.Change) ([]byte, error) {
+	var buf bytes.Buffer
+
+	encoder := json.NewEncoder(&buf)
+
+	for _, change := range changes {
+		if err := encoder.Encode(change); err != nil {
+			return nil, fmt.Errorf("filestore.go encodeChanges encoder.Encode(...): %w", err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
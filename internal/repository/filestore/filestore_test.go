@@ -0,0 +1,133 @@
+package filestore_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/repository"
+	"github.com/stsolovey/order_tracker/internal/repository/filestore"
+	"github.com/stsolovey/order_tracker/internal/repository/repositorytest"
+)
+
+func TestConformance(t *testing.T) {
+	suite.Run(t, &repositorytest.Suite{
+		NewRepository: func(t *testing.T) repository.Repository {
+			t.Helper()
+
+			return open(t, filepath.Join(t.TempDir(), "orders.ndjson"))
+		},
+	})
+}
+
+type FilestoreSuite struct {
+	suite.Suite
+	path string
+	ctx  context.Context
+}
+
+func TestFilestoreSuite(t *testing.T) {
+	suite.Run(t, new(FilestoreSuite))
+}
+
+func (s *FilestoreSuite) SetupTest() {
+	s.path = filepath.Join(s.T().TempDir(), "data", "orders.ndjson")
+	s.ctx = context.Background()
+}
+
+func open(t *testing.T, path string) *filestore.Store {
+	t.Helper()
+
+	store, err := filestore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = store.Close() })
+
+	return store
+}
+
+func (s *FilestoreSuite) TestReopenKeepsOrdersAndHistory() {
+	store := open(s.T(), s.path)
+
+	kept := repositorytest.NewOrder("order-kept", 0)
+	kept.Version = 1
+	_, err := store.Upsert(s.ctx, kept)
+	s.Require().NoError(err)
+
+	kept.Version = 2
+	kept.TrackNumber = "TRACK-changed"
+	kept.Raw = &models.RawPayload{ContentType: "application/json", Payload: []byte(`{"giftWrap":true}`)}
+	_, err = store.Upsert(s.ctx, kept)
+	s.Require().NoError(err)
+
+	_, err = store.Upsert(s.ctx, repositorytest.NewOrder("order-soft", 1))
+	s.Require().NoError(err)
+	s.Require().NoError(store.Delete(s.ctx, models.Tombstone{OrderUID: "order-soft", Version: 5}))
+
+	_, err = store.Upsert(s.ctx, repositorytest.NewOrder("order-hard", 2))
+	s.Require().NoError(err)
+	s.Require().NoError(store.Delete(s.ctx, models.Tombstone{OrderUID: "order-hard", Hard: true}))
+
+	s.Require().NoError(store.Close())
+
+	reopened := open(s.T(), s.path)
+
+	got, err := reopened.Get(s.ctx, "order-kept")
+	s.Require().NoError(err)
+	s.Require().Equal("TRACK-changed", got.TrackNumber)
+	s.Require().Equal(uint64(2), got.Version)
+
+	raw, err := reopened.RawOrder(s.ctx, "order-kept")
+	s.Require().NoError(err)
+	s.Require().JSONEq(`{"giftWrap":true}`, string(raw.Payload))
+
+	revisions, err := reopened.Revisions(s.ctx, "order-kept")
+	s.Require().NoError(err)
+	s.Require().Len(revisions, 2)
+
+	_, err = reopened.Get(s.ctx, "order-soft")
+	s.Require().ErrorIs(err, models.ErrOrderNotFound)
+
+	soft := repositorytest.NewOrder("order-soft", 1)
+	soft.Version = 5
+	_, err = reopened.Upsert(s.ctx, soft)
+	s.Require().ErrorIs(err, models.ErrStaleVersion, "Tombstones should survive a reopen")
+
+	_, err = reopened.Revisions(s.ctx, "order-hard")
+	s.Require().ErrorIs(err, models.ErrOrderNotFound)
+}
+
+func (s *FilestoreSuite) TestOpenDropsTornLastLine() {
+	store := open(s.T(), s.path)
+
+	_, err := store.Upsert(s.ctx, repositorytest.NewOrder("order-a", 0))
+	s.Require().NoError(err)
+	s.Require().NoError(store.Close())
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0)
+	s.Require().NoError(err)
+	_, err = file.WriteString(`{"order":{"order_uid":"order-b"`)
+	s.Require().NoError(err)
+	s.Require().NoError(file.Close())
+
+	reopened := open(s.T(), s.path)
+
+	_, err = reopened.Get(s.ctx, "order-a")
+	s.Require().NoError(err)
+
+	_, err = reopened.Get(s.ctx, "order-b")
+	s.Require().ErrorIs(err, models.ErrOrderNotFound)
+}
+
+func (s *FilestoreSuite) TestOpenRejectsCorruptFile() {
+	s.Require().NoError(os.MkdirAll(filepath.Dir(s.path), 0o750))
+	s.Require().NoError(os.WriteFile(s.path, []byte("not json\n"), 0o600))
+
+	_, err := filestore.Open(s.path)
+	s.Require().Error(err)
+}
@@ -0,0 +1,563 @@
+// Package memory is a repository.Repository that keeps orders in memory. It
+// needs no database, which makes it suitable for tests and local demos, and
+// it is the engine of the single-file store in package filestore.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+const defaultStreamChunkSize = 1000
+
+// Change is a state written by an upsert or delete: the new state of an
+// order, a new revision, or the removal of an order with its history.
+// Exactly one of Order, Revision and Purged is set. Raw goes with Order.
+type Change struct {
+	Order    *models.Order      `json:"order,omitempty"`
+	Raw      *models.RawPayload `json:"raw,omitempty"`
+	Deleted  bool               `json:"deleted,omitempty"`
+	Revision *models.Revision   `json:"revision,omitempty"`
+	Purged   string             `json:"purged,omitempty"`
+}
+
+type record struct {
+	order   models.Order
+	raw     *models.RawPayload
+	deleted bool
+}
+
+// Store is safe for concurrent use.
+type Store struct {
+	mu        sync.RWMutex
+	orders    map[string]*record
+	revisions map[string][]models.Revision
+	persist   func([]Change) error
+}
+
+func New() *Store {
+	return &Store{
+		orders:    make(map[string]*record),
+		revisions: make(map[string][]models.Revision),
+	}
+}
+
+// OnWrite makes every write hand its changes to persist before they are
+// applied. A write whose changes can't be persisted fails and changes
+// nothing.
+func (s *Store) OnWrite(persist func([]Change) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.persist = persist
+}
+
+// Restore applies changes that were persisted earlier, in order.
+func (s *Store) Restore(changes []Change) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, change := range changes {
+		s.apply(change)
+	}
+}
+
+// Snapshot returns the changes that rebuild the current state from an
+// empty store: every order followed by its revisions.
+func (s *Store) Snapshot() []Change {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	uids := make([]string, 0, len(s.orders))
+	for uid := range s.orders {
+		uids = append(uids, uid)
+	}
+
+	slices.Sort(uids)
+
+	changes := make([]Change, 0, len(uids))
+
+	for _, uid := range uids {
+		rec := s.orders[uid]
+		order := cloneOrder(rec.order)
+		changes = append(changes, Change{Order: &order, Raw: rec.raw, Deleted: rec.deleted})
+
+		for _, rev := range s.revisions[uid] {
+			rev := cloneRevision(rev)
+			changes = append(changes, Change{Revision: &rev})
+		}
+	}
+
+	return changes
+}
+
+func (s *Store) Get(_ context.Context, orderUID string) (*models.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.orders[orderUID]
+	if !ok || rec.deleted {
+		return nil, models.ErrOrderNotFound
+	}
+
+	order := cloneOrder(rec.order)
+
+	return &order, nil
+}
+
+// RawOrder returns the payload the order was last written from.
+func (s *Store) RawOrder(_ context.Context, orderUID string) (*models.RawPayload, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.orders[orderUID]
+	if !ok || rec.deleted {
+		return nil, models.ErrOrderNotFound
+	}
+
+	if rec.raw == nil {
+		return nil, models.ErrRawNotFound
+	}
+
+	raw := *rec.raw
+
+	return &raw, nil
+}
+
+// ListOrders pages through the orders like storage.Storage.ListOrders, and
+// its cursors work the same way.
+func (s *Store) ListOrders(_ context.Context, query models.OrderQuery) (*models.OrderPage, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := query.After()
+	if err != nil {
+		return nil, fmt.Errorf("memory.go ListOrders: %w", err)
+	}
+
+	s.mu.RLock()
+	orders := s.live(func(order *models.Order) bool { return matches(order, query.Filter) })
+	s.mu.RUnlock()
+
+	less := func(a, b *models.Order) bool {
+		if query.Sort == models.SortDateCreated && !a.DateCreated.Equal(b.DateCreated) {
+			return a.DateCreated.Before(b.DateCreated) != query.Desc
+		}
+
+		if query.Desc {
+			return a.OrderUID > b.OrderUID
+		}
+
+		return a.OrderUID < b.OrderUID
+	}
+
+	sort.Slice(orders, func(i, j int) bool { return less(&orders[i], &orders[j]) })
+
+	if after != nil {
+		key := models.Order{OrderUID: after.OrderUID, DateCreated: normalizeTime(after.DateCreated)}
+		start := sort.Search(len(orders), func(i int) bool { return less(&key, &orders[i]) })
+		orders = orders[start:]
+	}
+
+	page := &models.OrderPage{Orders: orders}
+
+	if len(orders) > query.Limit {
+		page.Orders = orders[:query.Limit]
+
+		page.NextCursor, err = query.NextCursor(page.Orders[query.Limit-1])
+		if err != nil {
+			return nil, fmt.Errorf("memory.go ListOrders: %w", err)
+		}
+	}
+
+	return page, nil
+}
+
+// StreamOrders hands the selected orders to fn in order_uid order, chunk by
+// chunk. An error returned by fn stops the scan and is returned wrapped.
+func (s *Store) StreamOrders(
+	_ context.Context, stream models.OrderStream, fn func([]models.Order) error,
+) error {
+	if stream.ChunkSize <= 0 {
+		stream.ChunkSize = defaultStreamChunkSize
+	}
+
+	if stream.Partitions > 1 && (stream.Partition < 0 || stream.Partition >= stream.Partitions) {
+		return fmt.Errorf("%w: partition %d out of %d", models.ErrInvalidQuery, stream.Partition, stream.Partitions)
+	}
+
+	createdFrom := normalizeTime(stream.CreatedFrom)
+
+	s.mu.RLock()
+	orders := s.live(func(order *models.Order) bool {
+		if !stream.CreatedFrom.IsZero() && order.DateCreated.Before(createdFrom) {
+			return false
+		}
+
+		return stream.Partitions <= 1 || partitionOf(order.OrderUID, stream.Partitions) == stream.Partition
+	})
+	s.mu.RUnlock()
+
+	sort.Slice(orders, func(i, j int) bool { return orders[i].OrderUID < orders[j].OrderUID })
+
+	for len(orders) > 0 {
+		chunk := orders[:min(stream.ChunkSize, len(orders))]
+		orders = orders[len(chunk):]
+
+		if err := fn(chunk); err != nil {
+			return fmt.Errorf("memory.go StreamOrders fn(...): %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) Upsert(_ context.Context, order *models.Order) (*models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.upsert(order)
+	if err != nil {
+		return nil, fmt.Errorf("memory.go Upsert: %w", err)
+	}
+
+	return stored, nil
+}
+
+// UpsertBatch upserts the orders one by one and returns the outcome of each.
+// Successful orders get their stored Version written back.
+func (s *Store) UpsertBatch(_ context.Context, orders []*models.Order) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]error, len(orders))
+
+	for i, order := range orders {
+		stored, err := s.upsert(order)
+		if err != nil {
+			results[i] = fmt.Errorf("memory.go UpsertBatch: %w", err)
+
+			continue
+		}
+
+		order.Version = stored.Version
+	}
+
+	return results, nil
+}
+
+func (s *Store) upsert(order *models.Order) (*models.Order, error) {
+	rec, exists := s.orders[order.OrderUID]
+
+	if order.IfMatch != "" && !models.MatchIfMatch(order.IfMatch, liveOrder(rec)) {
+		return nil, models.ErrPreconditionFailed
+	}
+
+	if exists && !order.Supersedes(&rec.order) {
+		return nil, models.ErrStaleVersion
+	}
+
+	next := normalizeOrder(*order)
+
+	var prev *models.Order
+
+	if exists {
+		next.Version = max(next.Version, rec.order.Version)
+
+		if !rec.deleted {
+			prev = &rec.order
+		}
+	}
+
+	changes := []Change{{Order: &next, Raw: order.Raw}}
+
+	if sections := next.ChangedSections(prev); len(sections) > 0 {
+		changeType := models.ChangeTypeUpdated
+		if prev == nil {
+			changeType = models.ChangeTypeCreated
+		}
+
+		snapshot := cloneOrder(next)
+		rev := s.newRevision(models.Revision{
+			OrderUID:        next.OrderUID,
+			Version:         next.Version,
+			ChangeType:      changeType,
+			ChangedSections: sections,
+			Source:          order.Source,
+			Order:           &snapshot,
+		})
+		changes = append(changes, Change{Revision: &rev})
+	}
+
+	if err := s.write(changes); err != nil {
+		return nil, err
+	}
+
+	stored := cloneOrder(next)
+
+	return &stored, nil
+}
+
+// Delete removes an order according to the tombstone, with the same rules
+// as storage.Storage.Delete.
+func (s *Store) Delete(_ context.Context, tombstone models.Tombstone) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.orders[tombstone.OrderUID]
+
+	if tombstone.IfMatch != "" && !models.MatchIfMatch(tombstone.IfMatch, liveOrder(rec)) {
+		return fmt.Errorf("memory.go Delete(%s): %w", tombstone.OrderUID, models.ErrPreconditionFailed)
+	}
+
+	if !ok {
+		return fmt.Errorf("memory.go Delete(%s): %w", tombstone.OrderUID, models.ErrOrderNotFound)
+	}
+
+	if tombstone.Version != 0 && tombstone.Version <= rec.order.Version {
+		return fmt.Errorf("memory.go Delete(%s): %w", tombstone.OrderUID, models.ErrStaleVersion)
+	}
+
+	if rec.deleted && !tombstone.Hard {
+		return fmt.Errorf("memory.go Delete(%s): %w", tombstone.OrderUID, models.ErrOrderNotFound)
+	}
+
+	var changes []Change
+
+	if tombstone.Hard {
+		changes = []Change{{Purged: tombstone.OrderUID}}
+	} else {
+		order := cloneOrder(rec.order)
+		order.Version = max(order.Version, tombstone.Version)
+
+		rev := s.newRevision(models.Revision{
+			OrderUID:   tombstone.OrderUID,
+			Version:    order.Version,
+			ChangeType: models.ChangeTypeDeleted,
+			Source:     tombstone.Source,
+		})
+		changes = []Change{{Order: &order, Raw: rec.raw, Deleted: true}, {Revision: &rev}}
+	}
+
+	if err := s.write(changes); err != nil {
+		return fmt.Errorf("memory.go Delete(%s): %w", tombstone.OrderUID, err)
+	}
+
+	return nil
+}
+
+// Revisions lists the revisions of an order, oldest first, without their
+// snapshots.
+func (s *Store) Revisions(_ context.Context, orderUID string) ([]models.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored := s.revisions[orderUID]
+	if len(stored) == 0 {
+		return nil, models.ErrOrderNotFound
+	}
+
+	revisions := make([]models.Revision, 0, len(stored))
+
+	for _, rev := range stored {
+		rev := cloneRevision(rev)
+		rev.Order = nil
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, nil
+}
+
+func (s *Store) Revision(_ context.Context, orderUID string, revision int) (*models.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rev := range s.revisions[orderUID] {
+		if rev.Revision == revision {
+			rev := cloneRevision(rev)
+
+			return &rev, nil
+		}
+	}
+
+	return nil, fmt.Errorf("memory.go Revision(%s, %d): %w", orderUID, revision, models.ErrRevisionNotFound)
+}
+
+// RevisionAt returns the latest revision of an order recorded at or before
+// at.
+func (s *Store) RevisionAt(_ context.Context, orderUID string, at time.Time) (*models.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions := s.revisions[orderUID]
+
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if !revisions[i].RecordedAt.After(at) {
+			rev := cloneRevision(revisions[i])
+
+			return &rev, nil
+		}
+	}
+
+	return nil, fmt.Errorf("memory.go RevisionAt(%s, %s): %w", orderUID, at, models.ErrRevisionNotFound)
+}
+
+// newRevision numbers rev as the next revision of its order.
+func (s *Store) newRevision(rev models.Revision) models.Revision {
+	rev.Revision = len(s.revisions[rev.OrderUID]) + 1
+	rev.RecordedAt = time.Now().UTC()
+
+	if rev.Source.ReceivedAt.IsZero() {
+		rev.Source.ReceivedAt = rev.RecordedAt
+	}
+
+	if rev.ChangedSections == nil {
+		rev.ChangedSections = []string{}
+	}
+
+	return rev
+}
+
+// write persists and applies changes. The caller holds the write lock.
+func (s *Store) write(changes []Change) error {
+	if s.persist != nil {
+		if err := s.persist(changes); err != nil {
+			return fmt.Errorf("persist: %w", err)
+		}
+	}
+
+	for _, change := range changes {
+		s.apply(change)
+	}
+
+	return nil
+}
+
+func (s *Store) apply(change Change) {
+	switch {
+	case change.Order != nil:
+		s.orders[change.Order.OrderUID] = &record{
+			order:   normalizeOrder(*change.Order),
+			raw:     change.Raw,
+			deleted: change.Deleted,
+		}
+	case change.Revision != nil:
+		s.revisions[change.Revision.OrderUID] = append(s.revisions[change.Revision.OrderUID],
+			cloneRevision(*change.Revision))
+	case change.Purged != "":
+		delete(s.orders, change.Purged)
+		delete(s.revisions, change.Purged)
+	}
+}
+
+// liveOrder returns the order of rec, or nil when there is none or it was
+// deleted.
+func liveOrder(rec *record) *models.Order {
+	if rec == nil || rec.deleted {
+		return nil
+	}
+
+	return &rec.order
+}
+
+// live returns copies of the orders that aren't deleted and match keep. The
+// caller holds the read lock.
+func (s *Store) live(keep func(order *models.Order) bool) []models.Order {
+	var orders []models.Order
+
+	for _, rec := range s.orders {
+		if !rec.deleted && keep(&rec.order) {
+			orders = append(orders, cloneOrder(rec.order))
+		}
+	}
+
+	return orders
+}
+
+func matches(order *models.Order, f models.OrderFilter) bool {
+	for _, eq := range []struct{ field, value string }{
+		{order.CustomerID, f.CustomerID},
+		{order.TrackNumber, f.TrackNumber},
+		{order.DeliveryService, f.DeliveryService},
+		{order.Payment.Provider, f.PaymentProvider},
+		{order.Payment.Currency, f.Currency},
+	} {
+		if eq.value != "" && eq.field != eq.value {
+			return false
+		}
+	}
+
+	if !f.CreatedFrom.IsZero() && order.DateCreated.Before(normalizeTime(f.CreatedFrom)) {
+		return false
+	}
+
+	if !f.CreatedTo.IsZero() && !order.DateCreated.Before(normalizeTime(f.CreatedTo)) {
+		return false
+	}
+
+	if f.ItemBrand != "" {
+		return slices.ContainsFunc(order.Items, func(item models.Item) bool { return item.Brand == f.ItemBrand })
+	}
+
+	return true
+}
+
+func partitionOf(orderUID string, partitions int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(orderUID))
+
+	return int(h.Sum32() % uint32(partitions)) //nolint:gosec
+}
+
+// normalizeOrder returns a copy of order as Postgres would store it: times
+// are kept to the microsecond as wall clock time in UTC, items are sorted by
+// chrt_id, and the source and raw payload aren't kept with it.
+func normalizeOrder(order models.Order) models.Order {
+	order = cloneOrder(order)
+	order.Source = models.Source{}
+	order.Raw = nil
+	order.IfMatch = ""
+	order.DateCreated = normalizeTime(order.DateCreated)
+	order.Delivery.OrderUID = order.OrderUID
+	order.Payment.OrderUID = order.OrderUID
+	order.Payment.PaymentDT = normalizeTime(order.Payment.PaymentDT)
+
+	slices.SortFunc(order.Items, func(a, b models.Item) int { return a.ChrtID - b.ChrtID })
+
+	return order
+}
+
+func normalizeTime(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(),
+		t.Nanosecond()/int(time.Microsecond)*int(time.Microsecond), time.UTC)
+}
+
+func cloneOrder(order models.Order) models.Order {
+	if len(order.Items) == 0 {
+		order.Items = nil
+	} else {
+		order.Items = slices.Clone(order.Items)
+	}
+
+	return order
+}
+
+func cloneRevision(rev models.Revision) models.Revision {
+	rev.ChangedSections = slices.Clone(rev.ChangedSections)
+
+	if rev.Order != nil {
+		order := cloneOrder(*rev.Order)
+		rev.Order = &order
+	}
+
+	return rev
+}
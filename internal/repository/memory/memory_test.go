@@ -0,0 +1,16 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/repository"
+	"github.com/stsolovey/order_tracker/internal/repository/memory"
+	"github.com/stsolovey/order_tracker/internal/repository/repositorytest"
+)
+
+func TestConformance(t *testing.T) {
+	suite.Run(t, &repositorytest.Suite{
+		NewRepository: func(*testing.T) repository.Repository { return memory.New() },
+	})
+}
@@ -0,0 +1,43 @@
+// Package repository defines the order store the service works against.
+// Postgres (package storage), the in-memory store (package memory) and the
+// single-file store (package filestore) implement it, and all of them must
+// pass the conformance suite in package repositorytest.
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+// Repository stores orders together with their revision history.
+//
+// Upserts of an existing order apply only when the order is unversioned
+// (version 0) or strictly newer than the stored one, and fail with
+// models.ErrStaleVersion otherwise. Soft-deleted orders are hidden from
+// reads but keep their version. Lookups of missing orders fail with
+// models.ErrOrderNotFound, invalid listings with models.ErrInvalidQuery and
+// missing revisions with models.ErrRevisionNotFound. An order keeps the raw
+// payload of its last upsert; orders written without one have none and
+// RawOrder fails with models.ErrRawNotFound. Upserts and deletes carrying
+// an IfMatch apply only when the live order matches it, as reported by
+// models.MatchIfMatch, and fail with models.ErrPreconditionFailed otherwise.
+type Repository interface {
+	Get(ctx context.Context, orderUID string) (*models.Order, error)
+	RawOrder(ctx context.Context, orderUID string) (*models.RawPayload, error)
+	ListOrders(ctx context.Context, query models.OrderQuery) (*models.OrderPage, error)
+	StreamOrders(ctx context.Context, stream models.OrderStream, fn func([]models.Order) error) error
+	Upsert(ctx context.Context, order *models.Order) (*models.Order, error)
+	UpsertBatch(ctx context.Context, orders []*models.Order) ([]error, error)
+	Delete(ctx context.Context, tombstone models.Tombstone) error
+	Revisions(ctx context.Context, orderUID string) ([]models.Revision, error)
+	Revision(ctx context.Context, orderUID string, revision int) (*models.Revision, error)
+	RevisionAt(ctx context.Context, orderUID string, at time.Time) (*models.Revision, error)
+}
+
+// Searcher is implemented by repositories that can search orders by text.
+// Searches of an empty text fail with models.ErrInvalidQuery.
+type Searcher interface {
+	SearchOrders(ctx context.Context, query models.SearchQuery) (*models.SearchPage, error)
+}
@@ -0,0 +1,565 @@
+// Package repositorytest holds the conformance suite every
+// repository.Repository must pass, so that the service behaves the same on
+// every backend.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/repository"
+)
+
+var errStop = errors.New("stop")
+
+// Suite is run by every backend with suite.Run. NewRepository is called
+// before each test and must return an empty repository.
+type Suite struct {
+	suite.Suite
+	NewRepository func(t *testing.T) repository.Repository
+
+	repo repository.Repository
+	ctx  context.Context
+}
+
+func (s *Suite) SetupTest() {
+	s.ctx = context.Background()
+	s.repo = s.NewRepository(s.T())
+}
+
+// baseTime is in the past, so every test order is older than revisions
+// recorded while the test runs.
+var baseTime = time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+// NewOrder returns a complete order created minutes after baseTime. Times
+// are in UTC and whole seconds, which every backend stores exactly.
+func NewOrder(uid string, minutes int) *models.Order {
+	created := baseTime.Add(time.Duration(minutes) * time.Minute)
+
+	return &models.Order{
+		OrderUID:        uid,
+		TrackNumber:     "TRACK-" + uid,
+		Entry:           "WBIL",
+		Locale:          "en",
+		CustomerID:      "customer-1",
+		DeliveryService: "meest",
+		Shardkey:        "9",
+		SMID:            99,
+		DateCreated:     created,
+		OOFShard:        "1",
+		Delivery: models.Delivery{
+			OrderUID: uid,
+			Name:     "Test Testov",
+			Phone:    "+9720000000",
+			Zip:      "2639809",
+			City:     "Kiryat Mozkin",
+			Address:  "Ploshad Mira 15",
+			Region:   "Kraiot",
+			Email:    "test@gmail.com",
+		},
+		Payment: models.Payment{
+			OrderUID:     uid,
+			Transaction:  uid,
+			Currency:     "USD",
+			Provider:     "wbpay",
+			Amount:       models.MustParseMoney("1817.00"),
+			PaymentDT:    created,
+			Bank:         "alpha",
+			DeliveryCost: models.MustParseMoney("1500.00"),
+			GoodsTotal:   models.MustParseMoney("317.00"),
+			CustomFee:    models.MustParseMoney("0.00"),
+		},
+		Items: []models.Item{
+			{
+				ChrtID: 2, OrderUID: uid, TrackNumber: "TRACK-" + uid, Price: models.MustParseMoney("453.00"),
+				RID: "rid-2", Name: "Mascaras", Sale: 30, Size: "0", TotalPrice: models.MustParseMoney("317.00"),
+				NMID: 2389212, Brand: "Vivienne Sabo", Status: 202,
+			},
+			{
+				ChrtID: 1, OrderUID: uid, TrackNumber: "TRACK-" + uid, Price: models.MustParseMoney("100.00"),
+				RID: "rid-1", Name: "Brush", Size: "0", TotalPrice: models.MustParseMoney("100.00"),
+				NMID: 1000001, Brand: "Brushes", Status: 202,
+			},
+		},
+	}
+}
+
+// stored is what a backend returns for order: items come sorted by chrtId.
+func stored(order *models.Order, version uint64) models.Order {
+	want := *order
+	want.Version = version
+	want.Items = slices.Clone(order.Items)
+	slices.SortFunc(want.Items, func(a, b models.Item) int { return a.ChrtID - b.ChrtID })
+
+	return want
+}
+
+func (s *Suite) upsert(orders ...*models.Order) {
+	for _, order := range orders {
+		_, err := s.repo.Upsert(s.ctx, order)
+		s.Require().NoError(err, order.OrderUID)
+	}
+}
+
+func (s *Suite) TestGetMissing() {
+	_, err := s.repo.Get(s.ctx, "missing")
+	s.Require().ErrorIs(err, models.ErrOrderNotFound)
+}
+
+func (s *Suite) TestUpsertAndGet() {
+	order := NewOrder("order-a", 0)
+
+	returned, err := s.repo.Upsert(s.ctx, order)
+	s.Require().NoError(err)
+	s.Require().Equal(order.OrderUID, returned.OrderUID)
+	s.Require().Zero(returned.Version)
+
+	got, err := s.repo.Get(s.ctx, order.OrderUID)
+	s.Require().NoError(err)
+	s.Require().Equal(stored(order, 0), *got)
+
+	order.TrackNumber = "TRACK-changed"
+	order.Items = order.Items[:1]
+	s.upsert(order)
+
+	got, err = s.repo.Get(s.ctx, order.OrderUID)
+	s.Require().NoError(err)
+	s.Require().Equal(stored(order, 0), *got, "Items missing from an update should be removed")
+}
+
+func (s *Suite) TestUpsertVersioning() {
+	order := NewOrder("order-v", 0)
+	order.Version = 10
+	s.upsert(order)
+
+	for _, version := range []uint64{9, 10} {
+		older := *order
+		older.TrackNumber = "TRACK-older"
+		older.Version = version
+
+		_, err := s.repo.Upsert(s.ctx, &older)
+		s.Require().ErrorIs(err, models.ErrStaleVersion, "version %d", version)
+	}
+
+	unversioned := *order
+	unversioned.TrackNumber = "TRACK-unversioned"
+	unversioned.Version = 0
+
+	returned, err := s.repo.Upsert(s.ctx, &unversioned)
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(10), returned.Version, "Unversioned writes should keep the stored version")
+
+	newer := *order
+	newer.Version = 11
+	s.upsert(&newer)
+
+	got, err := s.repo.Get(s.ctx, order.OrderUID)
+	s.Require().NoError(err)
+	s.Require().Equal(stored(&newer, 11), *got)
+}
+
+func (s *Suite) TestUpsertBatch() {
+	existing := NewOrder("order-b", 0)
+	existing.Version = 5
+	s.upsert(existing)
+
+	first := NewOrder("order-a", 1)
+	first.Version = 1
+	stale := NewOrder("order-b", 2)
+	stale.Version = 4
+	unversioned := NewOrder("order-c", 3)
+	// A later update of an order in the same batch, which also moves it.
+	repeated := NewOrder("order-a", 4)
+	repeated.Version = 2
+
+	results, err := s.repo.UpsertBatch(s.ctx, []*models.Order{first, stale, unversioned, repeated})
+	s.Require().NoError(err)
+	s.Require().Len(results, 4)
+	s.Require().NoError(results[0])
+	s.Require().ErrorIs(results[1], models.ErrStaleVersion)
+	s.Require().NoError(results[2])
+	s.Require().NoError(results[3])
+	s.Require().Equal(uint64(1), first.Version)
+	s.Require().Equal(uint64(2), repeated.Version)
+
+	for _, order := range []*models.Order{repeated, existing, unversioned} {
+		got, err := s.repo.Get(s.ctx, order.OrderUID)
+		s.Require().NoError(err)
+		s.Require().Equal(stored(order, order.Version), *got)
+	}
+}
+
+func (s *Suite) TestDelete() {
+	s.Run("Missing order", func() {
+		err := s.repo.Delete(s.ctx, models.Tombstone{OrderUID: "missing"})
+		s.Require().ErrorIs(err, models.ErrOrderNotFound)
+	})
+
+	s.Run("Soft delete hides the order and keeps its version", func() {
+		order := NewOrder("order-soft", 0)
+		order.Version = 5
+		s.upsert(order)
+
+		err := s.repo.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID, Version: 4})
+		s.Require().ErrorIs(err, models.ErrStaleVersion)
+
+		s.Require().NoError(s.repo.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID, Version: 6}))
+
+		_, err = s.repo.Get(s.ctx, order.OrderUID)
+		s.Require().ErrorIs(err, models.ErrOrderNotFound)
+
+		err = s.repo.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID})
+		s.Require().ErrorIs(err, models.ErrOrderNotFound)
+
+		order.Version = 6
+		_, err = s.repo.Upsert(s.ctx, order)
+		s.Require().ErrorIs(err, models.ErrStaleVersion, "Older update should not resurrect the order")
+
+		order.Version = 7
+		s.upsert(order)
+
+		got, err := s.repo.Get(s.ctx, order.OrderUID)
+		s.Require().NoError(err, "Newer update should resurrect the order")
+		s.Require().Equal(uint64(7), got.Version)
+	})
+
+	s.Run("Hard delete removes the order and its history", func() {
+		order := NewOrder("order-hard", 0)
+		s.upsert(order)
+
+		s.Require().NoError(s.repo.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID, Hard: true}))
+
+		_, err := s.repo.Get(s.ctx, order.OrderUID)
+		s.Require().ErrorIs(err, models.ErrOrderNotFound)
+
+		_, err = s.repo.Revisions(s.ctx, order.OrderUID)
+		s.Require().ErrorIs(err, models.ErrOrderNotFound)
+
+		err = s.repo.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID, Hard: true})
+		s.Require().ErrorIs(err, models.ErrOrderNotFound)
+	})
+}
+
+// listFixture stores five orders, one minute apart in reverse order_uid
+// order, and soft-deletes a sixth.
+func (s *Suite) listFixture() {
+	for i, uid := range []string{"order-e", "order-d", "order-c", "order-b", "order-a"} {
+		order := NewOrder(uid, i)
+		if i%2 == 1 {
+			order.CustomerID = "customer-2"
+			order.Payment.Currency = "EUR"
+			order.Items[0].Brand = "Rare"
+		}
+
+		s.upsert(order)
+	}
+
+	deleted := NewOrder("order-f", 10)
+	s.upsert(deleted)
+	s.Require().NoError(s.repo.Delete(s.ctx, models.Tombstone{OrderUID: deleted.OrderUID}))
+}
+
+func (s *Suite) list(query models.OrderQuery) []string {
+	page, err := s.repo.ListOrders(s.ctx, query)
+	s.Require().NoError(err)
+
+	uids := make([]string, 0, len(page.Orders))
+	for _, order := range page.Orders {
+		uids = append(uids, order.OrderUID)
+	}
+
+	return uids
+}
+
+func (s *Suite) TestIfMatch() {
+	order := NewOrder("order-if-match", 0)
+	order.Version = 1
+	s.upsert(order)
+
+	stored, err := s.repo.Get(s.ctx, order.OrderUID)
+	s.Require().NoError(err)
+
+	etag := stored.ETag()
+	s.Require().Equal(order.ETag(), etag, "ETag should not depend on how the order was read")
+
+	s.Run("Mismatching writes fail", func() {
+		update := *order
+		update.Version = 2
+		update.IfMatch = `"stale"`
+
+		_, err := s.repo.Upsert(s.ctx, &update)
+		s.Require().ErrorIs(err, models.ErrPreconditionFailed)
+
+		err = s.repo.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID, IfMatch: `"stale", W/` + etag})
+		s.Require().ErrorIs(err, models.ErrPreconditionFailed)
+
+		got, err := s.repo.Get(s.ctx, order.OrderUID)
+		s.Require().NoError(err)
+		s.Require().Equal(etag, got.ETag())
+	})
+
+	s.Run("Matching writes apply", func() {
+		update := *order
+		update.Version = 2
+		update.TrackNumber = "TRACK-updated"
+		update.IfMatch = `"stale", ` + etag
+
+		_, err := s.repo.Upsert(s.ctx, &update)
+		s.Require().NoError(err)
+
+		got, err := s.repo.Get(s.ctx, order.OrderUID)
+		s.Require().NoError(err)
+		s.Require().NotEqual(etag, got.ETag())
+
+		s.Require().NoError(s.repo.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID, IfMatch: got.ETag()}))
+	})
+
+	s.Run("Missing orders match nothing", func() {
+		update := *order
+		update.Version = 3
+		update.IfMatch = "*"
+
+		_, err := s.repo.Upsert(s.ctx, &update)
+		s.Require().ErrorIs(err, models.ErrPreconditionFailed)
+
+		err = s.repo.Delete(s.ctx, models.Tombstone{OrderUID: "missing", IfMatch: "*"})
+		s.Require().ErrorIs(err, models.ErrPreconditionFailed)
+	})
+}
+
+func (s *Suite) TestListOrders() {
+	s.listFixture()
+
+	s.Run("Sorting", func() {
+		s.Require().Equal([]string{"order-e", "order-d", "order-c", "order-b", "order-a"},
+			s.list(models.OrderQuery{}))
+		s.Require().Equal([]string{"order-a", "order-b", "order-c", "order-d", "order-e"},
+			s.list(models.OrderQuery{Desc: true}))
+		s.Require().Equal([]string{"order-a", "order-b", "order-c", "order-d", "order-e"},
+			s.list(models.OrderQuery{Sort: models.SortOrderUID}))
+		s.Require().Equal([]string{"order-e", "order-d", "order-c", "order-b", "order-a"},
+			s.list(models.OrderQuery{Sort: models.SortOrderUID, Desc: true}))
+	})
+
+	s.Run("Filters", func() {
+		s.Require().Equal([]string{"order-d", "order-b"},
+			s.list(models.OrderQuery{Filter: models.OrderFilter{CustomerID: "customer-2"}}))
+		s.Require().Equal([]string{"order-d", "order-b"},
+			s.list(models.OrderQuery{Filter: models.OrderFilter{Currency: "EUR"}}))
+		s.Require().Equal([]string{"order-d", "order-b"},
+			s.list(models.OrderQuery{Filter: models.OrderFilter{ItemBrand: "Rare"}}))
+		s.Require().Equal([]string{"order-c"},
+			s.list(models.OrderQuery{Filter: models.OrderFilter{TrackNumber: "TRACK-order-c"}}))
+		s.Require().Equal([]string{"order-d", "order-c"}, s.list(models.OrderQuery{Filter: models.OrderFilter{
+			CreatedFrom: baseTime.Add(time.Minute),
+			CreatedTo:   baseTime.Add(3 * time.Minute),
+		}}))
+		s.Require().Empty(s.list(models.OrderQuery{Filter: models.OrderFilter{PaymentProvider: "other"}}))
+	})
+
+	s.Run("Orders come with their items", func() {
+		page, err := s.repo.ListOrders(s.ctx, models.OrderQuery{Limit: 1})
+		s.Require().NoError(err)
+		s.Require().Len(page.Orders, 1)
+		s.Require().Equal(stored(NewOrder("order-e", 0), 0), page.Orders[0])
+	})
+
+	for _, query := range []models.OrderQuery{
+		{Limit: 2},
+		{Limit: 2, Desc: true},
+		{Limit: 2, Sort: models.SortOrderUID},
+		{Limit: 2, Sort: models.SortOrderUID, Desc: true, Filter: models.OrderFilter{CustomerID: "customer-1"}},
+	} {
+		s.Run(fmt.Sprintf("Pages of %+v", query), func() {
+			want := s.list(models.OrderQuery{Sort: query.Sort, Desc: query.Desc, Filter: query.Filter})
+
+			var got []string
+
+			for {
+				page, err := s.repo.ListOrders(s.ctx, query)
+				s.Require().NoError(err)
+				s.Require().LessOrEqual(len(page.Orders), query.Limit)
+
+				for _, order := range page.Orders {
+					got = append(got, order.OrderUID)
+				}
+
+				if page.NextCursor == "" {
+					break
+				}
+
+				query.Cursor = page.NextCursor
+			}
+
+			s.Require().Equal(want, got)
+		})
+	}
+
+	s.Run("Invalid queries", func() {
+		page, err := s.repo.ListOrders(s.ctx, models.OrderQuery{Limit: 2})
+		s.Require().NoError(err)
+
+		for name, query := range map[string]models.OrderQuery{
+			"unknown sort":       {Sort: "price"},
+			"negative limit":     {Limit: -1},
+			"limit over maximum": {Limit: models.MaxListLimit + 1},
+			"malformed cursor":   {Cursor: "not a cursor!"},
+			"foreign cursor":     {Cursor: page.NextCursor, Sort: models.SortOrderUID},
+		} {
+			_, err := s.repo.ListOrders(s.ctx, query)
+			s.Require().ErrorIs(err, models.ErrInvalidQuery, name)
+		}
+	})
+}
+
+func (s *Suite) TestStreamOrders() {
+	s.listFixture()
+
+	all := []string{"order-a", "order-b", "order-c", "order-d", "order-e"}
+
+	stream := func(stream models.OrderStream) ([]string, []int) {
+		var (
+			uids   []string
+			chunks []int
+		)
+
+		err := s.repo.StreamOrders(s.ctx, stream, func(orders []models.Order) error {
+			chunks = append(chunks, len(orders))
+
+			for _, order := range orders {
+				s.Require().Len(order.Items, 2, "Streamed orders should come with their items")
+				uids = append(uids, order.OrderUID)
+			}
+
+			return nil
+		})
+		s.Require().NoError(err)
+
+		return uids, chunks
+	}
+
+	uids, chunks := stream(models.OrderStream{ChunkSize: 2})
+	s.Require().Equal(all, uids)
+	s.Require().Equal([]int{2, 2, 1}, chunks)
+
+	uids, _ = stream(models.OrderStream{CreatedFrom: baseTime.Add(3 * time.Minute)})
+	s.Require().Equal([]string{"order-a", "order-b"}, uids)
+
+	var partitioned []string
+
+	for partition := range 3 {
+		uids, _ := stream(models.OrderStream{ChunkSize: 1, Partition: partition, Partitions: 3})
+		partitioned = append(partitioned, uids...)
+	}
+
+	slices.Sort(partitioned)
+	s.Require().Equal(all, partitioned, "Partitions should be disjoint and cover every order")
+
+	err := s.repo.StreamOrders(s.ctx, models.OrderStream{Partition: 3, Partitions: 3},
+		func([]models.Order) error { return nil })
+	s.Require().ErrorIs(err, models.ErrInvalidQuery)
+
+	err = s.repo.StreamOrders(s.ctx, models.OrderStream{ChunkSize: 2},
+		func([]models.Order) error { return errStop })
+	s.Require().ErrorIs(err, errStop)
+}
+
+func (s *Suite) TestRevisions() {
+	order := NewOrder("order-r", 0)
+	order.Version = 1
+	order.Source = models.Source{Subject: "orders.created", Sequence: 7, ReceivedAt: baseTime}
+	s.upsert(order)
+
+	order.Version = 2
+	s.upsert(order)
+
+	updated := *order
+	updated.Version = 3
+	updated.Payment.Amount = models.MustParseMoney("1900.00")
+	s.upsert(&updated)
+
+	s.Require().NoError(s.repo.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID, Version: 4}))
+
+	revisions, err := s.repo.Revisions(s.ctx, order.OrderUID)
+	s.Require().NoError(err)
+	s.Require().Len(revisions, 3, "An upsert that changes nothing records no revision")
+
+	s.Require().Equal(1, revisions[0].Revision)
+	s.Require().Equal(models.ChangeTypeCreated, revisions[0].ChangeType)
+	s.Require().Equal([]string{models.SectionOrder, models.SectionDelivery, models.SectionPayment, models.SectionItems},
+		revisions[0].ChangedSections)
+	s.Require().Equal("orders.created", revisions[0].Source.Subject)
+	s.Require().Equal(uint64(7), revisions[0].Source.Sequence)
+
+	s.Require().Equal(2, revisions[1].Revision)
+	s.Require().Equal(models.ChangeTypeUpdated, revisions[1].ChangeType)
+	s.Require().Equal([]string{models.SectionPayment}, revisions[1].ChangedSections)
+	s.Require().Equal(uint64(3), revisions[1].Version)
+
+	s.Require().Equal(3, revisions[2].Revision)
+	s.Require().Equal(models.ChangeTypeDeleted, revisions[2].ChangeType)
+	s.Require().Equal(uint64(4), revisions[2].Version)
+
+	for _, rev := range revisions {
+		s.Require().Nil(rev.Order, "Listings should not carry snapshots")
+	}
+
+	first, err := s.repo.Revision(s.ctx, order.OrderUID, 1)
+	s.Require().NoError(err)
+	s.Require().NotNil(first.Order)
+	s.Require().Equal(models.MustParseMoney("1817.00"), first.Order.Payment.Amount)
+
+	second, err := s.repo.Revision(s.ctx, order.OrderUID, 2)
+	s.Require().NoError(err)
+	s.Require().Equal(models.MustParseMoney("1900.00"), second.Order.Payment.Amount)
+
+	deleted, err := s.repo.Revision(s.ctx, order.OrderUID, 3)
+	s.Require().NoError(err)
+	s.Require().Nil(deleted.Order, "Deletions have no snapshot")
+
+	_, err = s.repo.Revision(s.ctx, order.OrderUID, 4)
+	s.Require().ErrorIs(err, models.ErrRevisionNotFound)
+
+	latest, err := s.repo.RevisionAt(s.ctx, order.OrderUID, time.Now().Add(time.Minute))
+	s.Require().NoError(err)
+	s.Require().Equal(3, latest.Revision)
+
+	_, err = s.repo.RevisionAt(s.ctx, order.OrderUID, baseTime)
+	s.Require().ErrorIs(err, models.ErrRevisionNotFound)
+
+	_, err = s.repo.Revisions(s.ctx, "missing")
+	s.Require().ErrorIs(err, models.ErrOrderNotFound)
+}
+
+func (s *Suite) TestRawOrder() {
+	order := NewOrder("order-raw", 0)
+	order.Raw = &models.RawPayload{
+		ContentType: "application/json",
+		Payload:     []byte(`{"orderUid": "order-raw", "giftWrap": {"paper": "red"}}`),
+	}
+	s.upsert(order)
+
+	raw, err := s.repo.RawOrder(s.ctx, order.OrderUID)
+	s.Require().NoError(err)
+	s.Require().Equal("application/json", raw.ContentType)
+	s.Require().JSONEq(string(order.Raw.Payload), string(raw.Payload))
+
+	got, err := s.repo.Get(s.ctx, order.OrderUID)
+	s.Require().NoError(err)
+	s.Require().Nil(got.Raw, "Reads should not carry the raw payload")
+
+	order.Raw = nil
+	s.upsert(order)
+
+	_, err = s.repo.RawOrder(s.ctx, order.OrderUID)
+	s.Require().ErrorIs(err, models.ErrRawNotFound, "A write without a payload should drop the stale one")
+
+	_, err = s.repo.RawOrder(s.ctx, "missing")
+	s.Require().ErrorIs(err, models.ErrOrderNotFound)
+}
@@ -0,0 +1,121 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type Store interface {
+	EnsurePartitions(ctx context.Context, from time.Time, months int) error
+	OrderPartitions(ctx context.Context) ([]models.OrderPartition, error)
+	ArchivePartition(ctx context.Context, month time.Time, dir string) (string, error)
+}
+
+// Maintainer periodically creates the partitions of the coming months and
+// archives the months that are older than the retention period.
+type Maintainer struct {
+	log        *logrus.Logger
+	store      Store
+	interval   time.Duration
+	premake    int
+	retention  int
+	archiveDir string
+}
+
+// NewMaintainer returns a Maintainer that keeps premake months of
+// partitions ahead and, unless retention is 0, the current month plus
+// retention full months before it.
+func NewMaintainer(
+	log *logrus.Logger,
+	store Store,
+	interval time.Duration,
+	premake int,
+	retention int,
+	archiveDir string,
+) *Maintainer {
+	return &Maintainer{
+		log:        log,
+		store:      store,
+		interval:   interval,
+		premake:    premake,
+		retention:  retention,
+		archiveDir: archiveDir,
+	}
+}
+
+// Run maintains the partitions until ctx is cancelled.
+func (m *Maintainer) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := m.RunOnce(ctx); err != nil {
+			m.log.WithError(err).Error("failed to maintain order partitions")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce creates the upcoming partitions and archives the expired months,
+// oldest first, together with any month an earlier run left detached. It
+// returns the paths of the written archives and stops at the first month
+// that fails. Months that another instance archived in the meantime are
+// skipped.
+func (m *Maintainer) RunOnce(ctx context.Context) ([]string, error) {
+	now := time.Now().UTC()
+
+	if err := m.store.EnsurePartitions(ctx, now, m.premake); err != nil {
+		return nil, fmt.Errorf("maintainer.go RunOnce m.store.EnsurePartitions(...): %w", err)
+	}
+
+	if m.retention <= 0 {
+		return nil, nil
+	}
+
+	partitions, err := m.store.OrderPartitions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("maintainer.go RunOnce m.store.OrderPartitions(...): %w", err)
+	}
+
+	cutoff := m.Cutoff(now)
+
+	var archives []string
+
+	for _, partition := range partitions {
+		if !partition.Detached && !partition.Month.Before(cutoff) {
+			continue
+		}
+
+		path, err := m.store.ArchivePartition(ctx, partition.Month, m.archiveDir)
+		if errors.Is(err, models.ErrPartitionNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return archives, fmt.Errorf("maintainer.go RunOnce m.store.ArchivePartition(%s): %w",
+				partition.Month.Format("2006-01"), err)
+		}
+
+		m.log.Infof("Archived orders of %s to %s", partition.Month.Format("2006-01"), path)
+
+		archives = append(archives, path)
+	}
+
+	return archives, nil
+}
+
+// Cutoff returns the first month that is kept at now. Earlier months are
+// archived.
+func (m *Maintainer) Cutoff(now time.Time) time.Time {
+	return models.PartitionMonth(now).AddDate(0, -m.retention, 0)
+}
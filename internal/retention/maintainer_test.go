@@ -0,0 +1,133 @@
+package retention_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/retention"
+)
+
+var errArchive = errors.New("archive failed")
+
+type fakeStore struct {
+	ensuredFrom   time.Time
+	ensuredMonths int
+	partitions    []models.OrderPartition
+	archived      []time.Time
+	failures      map[time.Time]error
+}
+
+func (f *fakeStore) EnsurePartitions(_ context.Context, from time.Time, months int) error {
+	f.ensuredFrom = from
+	f.ensuredMonths = months
+
+	return nil
+}
+
+func (f *fakeStore) OrderPartitions(context.Context) ([]models.OrderPartition, error) {
+	return f.partitions, nil
+}
+
+func (f *fakeStore) ArchivePartition(_ context.Context, month time.Time, dir string) (string, error) {
+	if err := f.failures[month]; err != nil {
+		return "", fmt.Errorf("fake: %w", err)
+	}
+
+	f.archived = append(f.archived, month)
+
+	return filepath.Join(dir, month.Format("2006-01")), nil
+}
+
+type MaintainerSuite struct {
+	suite.Suite
+	store   *fakeStore
+	current time.Time
+}
+
+func (s *MaintainerSuite) SetupTest() {
+	s.current = models.PartitionMonth(time.Now())
+	s.store = &fakeStore{failures: make(map[time.Time]error)}
+
+	for i := -6; i <= 3; i++ {
+		s.store.partitions = append(s.store.partitions, models.OrderPartition{Month: s.current.AddDate(0, i, 0)})
+	}
+}
+
+func TestMaintainerSuite(t *testing.T) {
+	suite.Run(t, new(MaintainerSuite))
+}
+
+func (s *MaintainerSuite) month(offset int) time.Time {
+	return s.current.AddDate(0, offset, 0)
+}
+
+func (s *MaintainerSuite) TestRunOnceArchivesExpiredMonths() {
+	maintainer := retention.NewMaintainer(logrus.New(), s.store, time.Hour, 3, 4, "/archive")
+
+	archives, err := maintainer.RunOnce(context.Background())
+	s.Require().NoError(err)
+	s.Require().Equal([]time.Time{s.month(-6), s.month(-5)}, s.store.archived)
+	s.Require().Equal([]string{
+		filepath.Join("/archive", s.month(-6).Format("2006-01")),
+		filepath.Join("/archive", s.month(-5).Format("2006-01")),
+	}, archives)
+	s.Require().Equal(3, s.store.ensuredMonths)
+	s.Require().Equal(s.current, models.PartitionMonth(s.store.ensuredFrom))
+}
+
+func (s *MaintainerSuite) TestRunOnceWithoutRetention() {
+	maintainer := retention.NewMaintainer(logrus.New(), s.store, time.Hour, 3, 0, "/archive")
+
+	archives, err := maintainer.RunOnce(context.Background())
+	s.Require().NoError(err)
+	s.Require().Empty(archives)
+	s.Require().Empty(s.store.archived)
+	s.Require().Equal(3, s.store.ensuredMonths)
+}
+
+func (s *MaintainerSuite) TestRunOnceSkipsMonthsArchivedElsewhere() {
+	s.store.failures[s.month(-6)] = models.ErrPartitionNotFound
+
+	maintainer := retention.NewMaintainer(logrus.New(), s.store, time.Hour, 3, 4, "/archive")
+
+	archives, err := maintainer.RunOnce(context.Background())
+	s.Require().NoError(err)
+	s.Require().Len(archives, 1)
+	s.Require().Equal([]time.Time{s.month(-5)}, s.store.archived)
+}
+
+func (s *MaintainerSuite) TestRunOnceResumesDetachedMonths() {
+	s.store.partitions[8].Detached = true
+
+	maintainer := retention.NewMaintainer(logrus.New(), s.store, time.Hour, 3, 4, "/archive")
+
+	archives, err := maintainer.RunOnce(context.Background())
+	s.Require().NoError(err)
+	s.Require().Len(archives, 3)
+	s.Require().Equal([]time.Time{s.month(-6), s.month(-5), s.month(2)}, s.store.archived)
+}
+
+func (s *MaintainerSuite) TestRunOnceStopsOnFailure() {
+	s.store.failures[s.month(-5)] = errArchive
+
+	maintainer := retention.NewMaintainer(logrus.New(), s.store, time.Hour, 3, 2, "/archive")
+
+	archives, err := maintainer.RunOnce(context.Background())
+	s.Require().ErrorIs(err, errArchive)
+	s.Require().Len(archives, 1)
+	s.Require().Equal([]time.Time{s.month(-6)}, s.store.archived)
+}
+
+func (s *MaintainerSuite) TestCutoff() {
+	maintainer := retention.NewMaintainer(logrus.New(), s.store, time.Hour, 3, 12, "/archive")
+
+	now := time.Date(2024, time.June, 17, 12, 0, 0, 0, time.UTC)
+	s.Require().Equal(time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC), maintainer.Cutoff(now))
+}
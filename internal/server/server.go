@@ -5,12 +5,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
 	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/order_tracker/internal/codec"
 	"github.com/stsolovey/order_tracker/internal/config"
 	"github.com/stsolovey/order_tracker/internal/models"
 	"github.com/stsolovey/order_tracker/internal/service"
@@ -23,6 +28,8 @@ const (
 	idleTimeoutDuration       = 60 * time.Second
 
 	shutdownTimeoutDuration = 5 * time.Second
+
+	maxOrderBodySize = 1 << 20
 )
 
 type Server struct {
@@ -91,17 +98,53 @@ func (s *Server) Shutdown(ctx context.Context) error {
 }
 
 func ConfigureRoutes(r chi.Router, orderService service.OrderServiceInterface, log *logrus.Logger) {
+	codecs := codec.Default()
+
 	r.Route("/api/v1/orders", func(r chi.Router) {
-		r.Get("/", func(w http.ResponseWriter, _ *http.Request) {
-			writeJSONError(log, w, http.StatusBadRequest, "Missing order ID")
+		r.Get("/", func(w http.ResponseWriter, req *http.Request) {
+			listOrders(w, req, orderService, log)
+		})
+		r.Get("/search", func(w http.ResponseWriter, req *http.Request) {
+			searchOrders(w, req, orderService, log)
 		})
 		r.Get("/{uid}", func(w http.ResponseWriter, req *http.Request) {
-			getOrder(w, req, orderService, log)
+			getOrder(w, req, orderService, codecs, log)
+		})
+		r.Get("/{uid}/raw", func(w http.ResponseWriter, req *http.Request) {
+			getRawOrder(w, req, orderService, log)
+		})
+		r.Put("/{uid}", func(w http.ResponseWriter, req *http.Request) {
+			putOrder(w, req, orderService, codecs, log)
+		})
+		r.Delete("/{uid}", func(w http.ResponseWriter, req *http.Request) {
+			deleteOrder(w, req, orderService, log)
+		})
+		r.Get("/{uid}/revisions", func(w http.ResponseWriter, req *http.Request) {
+			listRevisions(w, req, orderService, log)
+		})
+		r.Get("/{uid}/revisions/diff", func(w http.ResponseWriter, req *http.Request) {
+			diffRevisions(w, req, orderService, log)
+		})
+		r.Get("/{uid}/revisions/{revision}", func(w http.ResponseWriter, req *http.Request) {
+			getRevision(w, req, orderService, log)
 		})
 	})
+	r.Get("/api/v1/stats/validation", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(log, w, http.StatusOK, orderService.ValidationStats())
+	})
 }
 
-func getOrder(w http.ResponseWriter, r *http.Request, app service.OrderServiceInterface, log *logrus.Logger) {
+// getOrder encodes the order in the format negotiated from the Accept
+// header, JSON by default. With ?asOf=<RFC 3339 time> it returns the order
+// as it was stored at that time. The response carries the order's ETag, and
+// a matching If-None-Match gets 304 Not Modified.
+func getOrder(
+	w http.ResponseWriter,
+	r *http.Request,
+	app service.OrderServiceInterface,
+	codecs *codec.Registry,
+	log *logrus.Logger,
+) {
 	orderID := chi.URLParam(r, "uid")
 	if orderID == "" {
 		writeJSONError(log, w, http.StatusBadRequest, "Order ID is required")
@@ -109,9 +152,33 @@ func getOrder(w http.ResponseWriter, r *http.Request, app service.OrderServiceIn
 		return
 	}
 
+	orderCodec, ok := codecs.Negotiate(r.Header.Get("Accept"))
+	if !ok {
+		writeJSONError(log, w, http.StatusNotAcceptable, "Unsupported Accept header")
+
+		return
+	}
+
 	ctx := r.Context()
 
-	order, err := app.GetOrder(ctx, orderID)
+	var (
+		order *models.Order
+		err   error
+	)
+
+	if asOf := r.URL.Query().Get("asOf"); asOf != "" {
+		at, parseErr := time.Parse(time.RFC3339Nano, asOf)
+		if parseErr != nil {
+			writeJSONError(log, w, http.StatusBadRequest, "Invalid asOf parameter")
+
+			return
+		}
+
+		order, err = app.OrderAsOf(ctx, orderID, at)
+	} else {
+		order, err = app.GetOrder(ctx, orderID)
+	}
+
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -125,14 +192,23 @@ func getOrder(w http.ResponseWriter, r *http.Request, app service.OrderServiceIn
 		return
 	}
 
-	response, err := json.Marshal(order)
+	w.Header().Set("ETag", order.ETag())
+	w.Header().Add("Vary", "Accept")
+
+	if match := r.Header.Get("If-None-Match"); match != "" && models.MatchIfNoneMatch(match, order) {
+		w.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	response, err := orderCodec.Marshal(order)
 	if err != nil {
 		writeJSONError(log, w, http.StatusInternalServerError, "Failed to serialize the order")
 
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", orderCodec.ContentType())
 
 	_, err = w.Write(response)
 	if err != nil {
@@ -140,6 +216,301 @@ func getOrder(w http.ResponseWriter, r *http.Request, app service.OrderServiceIn
 	}
 }
 
+// getRawOrder returns the payload an order was last received as, rendered
+// as JSON by its codec and including fields the order model doesn't have.
+func getRawOrder(w http.ResponseWriter, r *http.Request, app service.OrderServiceInterface, log *logrus.Logger) {
+	raw, err := app.RawOrder(r.Context(), chi.URLParam(r, "uid"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrOrderNotFound):
+			writeJSONError(log, w, http.StatusNotFound, "Order not found")
+		case errors.Is(err, models.ErrRawNotFound):
+			writeJSONError(log, w, http.StatusNotFound, "Raw payload not found")
+		default:
+			writeJSONError(log, w, http.StatusInternalServerError, err.Error())
+		}
+
+		return
+	}
+
+	writeJSON(log, w, http.StatusOK, raw)
+}
+
+// listOrders returns a page of orders. Filters: customerId, trackNumber,
+// deliveryService, paymentProvider, currency, brand and the createdFrom /
+// createdTo RFC 3339 range. sort is dateCreated or orderUid, prefixed with
+// "-" for descending order (default -dateCreated); limit and cursor page
+// through the results.
+func listOrders(w http.ResponseWriter, r *http.Request, app service.OrderServiceInterface, log *logrus.Logger) {
+	query, err := parseOrderQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(log, w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	page, err := app.ListOrders(r.Context(), query)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidQuery) {
+			writeJSONError(log, w, http.StatusBadRequest, err.Error())
+		} else {
+			writeJSONError(log, w, http.StatusInternalServerError, err.Error())
+		}
+
+		return
+	}
+
+	writeJSON(log, w, http.StatusOK, page)
+}
+
+func parseOrderQuery(params url.Values) (models.OrderQuery, error) {
+	query := models.OrderQuery{
+		Filter: models.OrderFilter{
+			CustomerID:      params.Get("customerId"),
+			TrackNumber:     params.Get("trackNumber"),
+			DeliveryService: params.Get("deliveryService"),
+			PaymentProvider: params.Get("paymentProvider"),
+			Currency:        params.Get("currency"),
+			ItemBrand:       params.Get("brand"),
+		},
+		Sort:   models.SortDateCreated,
+		Desc:   true,
+		Cursor: params.Get("cursor"),
+	}
+
+	if sort := params.Get("sort"); sort != "" {
+		query.Desc = strings.HasPrefix(sort, "-")
+		query.Sort = strings.TrimPrefix(sort, "-")
+	}
+
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{
+		{"createdFrom", &query.Filter.CreatedFrom},
+		{"createdTo", &query.Filter.CreatedTo},
+	} {
+		if value := params.Get(param.name); value != "" {
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return query, fmt.Errorf("%w: %s must be an RFC 3339 time", models.ErrInvalidQuery, param.name)
+			}
+
+			*param.dest = t
+		}
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return query, fmt.Errorf("%w: limit must be a number", models.ErrInvalidQuery)
+		}
+
+		query.Limit = n
+	}
+
+	return query, nil
+}
+
+// searchOrders returns a page of the orders matching the full-text search in
+// q, best matches first; limit and cursor page through the results.
+func searchOrders(w http.ResponseWriter, r *http.Request, app service.OrderServiceInterface, log *logrus.Logger) {
+	params := r.URL.Query()
+	query := models.SearchQuery{Text: params.Get("q"), Cursor: params.Get("cursor")}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			writeJSONError(log, w, http.StatusBadRequest, "limit must be a number")
+
+			return
+		}
+
+		query.Limit = n
+	}
+
+	page, err := app.SearchOrders(r.Context(), query)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidQuery):
+			writeJSONError(log, w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, models.ErrSearchUnsupported):
+			writeJSONError(log, w, http.StatusNotImplemented, err.Error())
+		default:
+			writeJSONError(log, w, http.StatusInternalServerError, err.Error())
+		}
+
+		return
+	}
+
+	writeJSON(log, w, http.StatusOK, page)
+}
+
+// putOrder stores the order in the request body, decoded by the codec of
+// its Content-Type like orders received from NATS. With If-Match the order
+// is stored only if the stored one still has one of the given ETags.
+func putOrder(
+	w http.ResponseWriter,
+	r *http.Request,
+	app service.OrderServiceInterface,
+	codecs *codec.Registry,
+	log *logrus.Logger,
+) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxOrderBodySize))
+	if err != nil {
+		writeJSONError(log, w, http.StatusRequestEntityTooLarge, "Request body is too large")
+
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+
+	order, err := codecs.Decode(contentType, data)
+	if err == nil {
+		order.Raw, err = codecs.RawJSON(contentType, data)
+	}
+
+	if err != nil {
+		if errors.Is(err, codec.ErrUnsupportedContentType) {
+			writeJSONError(log, w, http.StatusUnsupportedMediaType, err.Error())
+		} else {
+			writeJSONError(log, w, http.StatusBadRequest, "Malformed order: "+err.Error())
+		}
+
+		return
+	}
+
+	if order.OrderUID != chi.URLParam(r, "uid") {
+		writeJSONError(log, w, http.StatusBadRequest, "Order UID doesn't match the URL")
+
+		return
+	}
+
+	order.Source = models.Source{ReceivedAt: time.Now().UTC()}
+	order.IfMatch = r.Header.Get("If-Match")
+
+	if err := app.UpsertOrder(r.Context(), order); err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidOrder):
+			writeJSONError(log, w, http.StatusUnprocessableEntity, err.Error())
+		case errors.Is(err, models.ErrPreconditionFailed):
+			writeJSONError(log, w, http.StatusPreconditionFailed, "Order doesn't match If-Match")
+		case errors.Is(err, models.ErrStaleVersion):
+			writeJSONError(log, w, http.StatusConflict, err.Error())
+		default:
+			writeJSONError(log, w, http.StatusInternalServerError, err.Error())
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteOrder soft-deletes an order, or removes it completely with ?hard=true.
+// With If-Match the order is deleted only if it still has one of the given
+// ETags.
+func deleteOrder(w http.ResponseWriter, r *http.Request, app service.OrderServiceInterface, log *logrus.Logger) {
+	tombstone := models.Tombstone{OrderUID: chi.URLParam(r, "uid"), IfMatch: r.Header.Get("If-Match")}
+
+	if hard := r.URL.Query().Get("hard"); hard != "" {
+		var err error
+
+		tombstone.Hard, err = strconv.ParseBool(hard)
+		if err != nil {
+			writeJSONError(log, w, http.StatusBadRequest, "Invalid hard parameter")
+
+			return
+		}
+	}
+
+	err := app.DeleteOrder(r.Context(), tombstone)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrOrderNotFound):
+			writeJSONError(log, w, http.StatusNotFound, "Order not found")
+		case errors.Is(err, models.ErrPreconditionFailed):
+			writeJSONError(log, w, http.StatusPreconditionFailed, "Order doesn't match If-Match")
+		default:
+			writeJSONError(log, w, http.StatusInternalServerError, err.Error())
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listRevisions(w http.ResponseWriter, r *http.Request, app service.OrderServiceInterface, log *logrus.Logger) {
+	revisions, err := app.OrderRevisions(r.Context(), chi.URLParam(r, "uid"))
+	if err != nil {
+		writeRevisionError(log, w, err)
+
+		return
+	}
+
+	writeJSON(log, w, http.StatusOK, revisions)
+}
+
+func getRevision(w http.ResponseWriter, r *http.Request, app service.OrderServiceInterface, log *logrus.Logger) {
+	revision, err := strconv.Atoi(chi.URLParam(r, "revision"))
+	if err != nil {
+		writeJSONError(log, w, http.StatusBadRequest, "Invalid revision")
+
+		return
+	}
+
+	rev, err := app.OrderRevision(r.Context(), chi.URLParam(r, "uid"), revision)
+	if err != nil {
+		writeRevisionError(log, w, err)
+
+		return
+	}
+
+	writeJSON(log, w, http.StatusOK, rev)
+}
+
+// diffRevisions compares the revisions given by the from and to parameters.
+func diffRevisions(w http.ResponseWriter, r *http.Request, app service.OrderServiceInterface, log *logrus.Logger) {
+	from, fromErr := strconv.Atoi(r.URL.Query().Get("from"))
+	to, toErr := strconv.Atoi(r.URL.Query().Get("to"))
+
+	if fromErr != nil || toErr != nil {
+		writeJSONError(log, w, http.StatusBadRequest, "Parameters from and to must be revision numbers")
+
+		return
+	}
+
+	diff, err := app.DiffOrderRevisions(r.Context(), chi.URLParam(r, "uid"), from, to)
+	if err != nil {
+		writeRevisionError(log, w, err)
+
+		return
+	}
+
+	writeJSON(log, w, http.StatusOK, diff)
+}
+
+func writeRevisionError(log *logrus.Logger, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrOrderNotFound):
+		writeJSONError(log, w, http.StatusNotFound, "Order not found")
+	case errors.Is(err, models.ErrRevisionNotFound):
+		writeJSONError(log, w, http.StatusNotFound, "Revision not found")
+	default:
+		writeJSONError(log, w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeJSON(log *logrus.Logger, w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Infof("Failed to write response: %s", err)
+	}
+}
+
 func writeJSONError(log *logrus.Logger, w http.ResponseWriter, statusCode int, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,10 +14,14 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/codec"
 	"github.com/stsolovey/order_tracker/internal/config"
 	"github.com/stsolovey/order_tracker/internal/logger"
 	"github.com/stsolovey/order_tracker/internal/models"
+	ordercache "github.com/stsolovey/order_tracker/internal/order-cache"
+	"github.com/stsolovey/order_tracker/internal/repository/memory"
 	"github.com/stsolovey/order_tracker/internal/server"
+	"github.com/stsolovey/order_tracker/internal/service"
 )
 
 type MockOrderService struct {
@@ -33,6 +38,11 @@ func (m *MockOrderService) UpsertOrder(ctx context.Context, order models.Order)
 	return args.Error(0)
 }
 
+func (m *MockOrderService) UpsertOrders(ctx context.Context, orders []models.Order) []error {
+	args := m.Called(ctx, orders)
+	return args.Get(0).([]error)
+}
+
 func (m *MockOrderService) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
 	args := m.Called(ctx, orderID)
 	if obj := args.Get(0); obj != nil {
@@ -41,6 +51,74 @@ func (m *MockOrderService) GetOrder(ctx context.Context, orderID string) (*model
 	return nil, args.Error(1)
 }
 
+func (m *MockOrderService) RawOrder(ctx context.Context, orderUID string) (*models.RawPayload, error) {
+	args := m.Called(ctx, orderUID)
+	if obj := args.Get(0); obj != nil {
+		return obj.(*models.RawPayload), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockOrderService) ListOrders(ctx context.Context, query models.OrderQuery) (*models.OrderPage, error) {
+	args := m.Called(ctx, query)
+	if obj := args.Get(0); obj != nil {
+		return obj.(*models.OrderPage), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockOrderService) SearchOrders(ctx context.Context, query models.SearchQuery) (*models.SearchPage, error) {
+	args := m.Called(ctx, query)
+	if obj := args.Get(0); obj != nil {
+		return obj.(*models.SearchPage), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockOrderService) DeleteOrder(ctx context.Context, tombstone models.Tombstone) error {
+	args := m.Called(ctx, tombstone)
+	return args.Error(0)
+}
+
+func (m *MockOrderService) OrderRevisions(ctx context.Context, orderUID string) ([]models.Revision, error) {
+	args := m.Called(ctx, orderUID)
+	if obj := args.Get(0); obj != nil {
+		return obj.([]models.Revision), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockOrderService) OrderRevision(ctx context.Context, orderUID string, revision int) (*models.Revision, error) {
+	args := m.Called(ctx, orderUID, revision)
+	if obj := args.Get(0); obj != nil {
+		return obj.(*models.Revision), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockOrderService) OrderAsOf(ctx context.Context, orderUID string, at time.Time) (*models.Order, error) {
+	args := m.Called(ctx, orderUID, at)
+	if obj := args.Get(0); obj != nil {
+		return obj.(*models.Order), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockOrderService) DiffOrderRevisions(
+	ctx context.Context, orderUID string, from, to int,
+) (*models.RevisionDiff, error) {
+	args := m.Called(ctx, orderUID, from, to)
+	if obj := args.Get(0); obj != nil {
+		return obj.(*models.RevisionDiff), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockOrderService) ValidationStats() service.ValidationStats {
+	args := m.Called()
+	return args.Get(0).(service.ValidationStats)
+}
+
 type ServerTestSuite struct {
 	suite.Suite
 	srv      *server.Server
@@ -86,6 +164,199 @@ func (s *ServerTestSuite) TestGetOrder_Success() {
 	require.Contains(s.T(), s.recorder.Body.String(), orderUID)
 }
 
+func (s *ServerTestSuite) TestGetOrder_WBFormat() {
+	orderUID := "testUID123"
+	order := &models.Order{
+		OrderUID:    orderUID,
+		DateCreated: time.Now(),
+		Payment:     models.Payment{OrderUID: orderUID, PaymentDT: time.Unix(1637907727, 0)},
+	}
+
+	s.service.On("GetOrder", mock.Anything, orderUID).Return(order, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/"+orderUID, nil)
+	req.Header.Set("Accept", codec.ContentTypeWB)
+	s.router.ServeHTTP(s.recorder, req)
+
+	require.Equal(s.T(), http.StatusOK, s.recorder.Code)
+	require.Equal(s.T(), codec.ContentTypeWB, s.recorder.Header().Get("Content-Type"))
+	require.Contains(s.T(), s.recorder.Body.String(), `"order_uid":"testUID123"`)
+	require.Contains(s.T(), s.recorder.Body.String(), `"payment_dt":1637907727`)
+}
+
+func (s *ServerTestSuite) TestGetOrder_ETag() {
+	orderUID := "testUID123"
+	order := &models.Order{OrderUID: orderUID, Version: 2, DateCreated: time.Now()}
+	etag := order.ETag()
+
+	s.service.On("GetOrder", mock.Anything, orderUID).Return(order, nil)
+
+	s.router.ServeHTTP(s.recorder, httptest.NewRequest(http.MethodGet, "/api/v1/orders/"+orderUID, nil))
+	require.Equal(s.T(), http.StatusOK, s.recorder.Code)
+	require.Equal(s.T(), etag, s.recorder.Header().Get("ETag"))
+
+	for match, code := range map[string]int{
+		etag:               http.StatusNotModified,
+		"W/" + etag:        http.StatusNotModified,
+		`"other", ` + etag: http.StatusNotModified,
+		`"other"`:          http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/"+orderUID, nil)
+		req.Header.Set("If-None-Match", match)
+
+		recorder := httptest.NewRecorder()
+		s.router.ServeHTTP(recorder, req)
+		require.Equal(s.T(), code, recorder.Code, match)
+		require.Equal(s.T(), etag, recorder.Header().Get("ETag"), match)
+
+		if code == http.StatusNotModified {
+			require.Empty(s.T(), recorder.Body.String(), match)
+		}
+	}
+}
+
+func (s *ServerTestSuite) TestPutOrder() {
+	body := `{"orderUid":"testUID123","trackNumber":"TN1","extra":true}`
+
+	isOrder := func(ifMatch string) interface{} {
+		return mock.MatchedBy(func(order models.Order) bool {
+			return order.OrderUID == "testUID123" && order.TrackNumber == "TN1" && order.IfMatch == ifMatch &&
+				order.Raw != nil && string(order.Raw.Payload) == body
+		})
+	}
+
+	s.service.On("UpsertOrder", mock.Anything, isOrder("")).Return(nil)
+	s.service.On("UpsertOrder", mock.Anything, isOrder(`"current"`)).Return(nil)
+	s.service.On("UpsertOrder", mock.Anything, isOrder(`"stale"`)).Return(models.ErrPreconditionFailed)
+
+	cases := []struct {
+		target, contentType, ifMatch, body string
+		code                               int
+	}{
+		{"/api/v1/orders/testUID123", "application/json", "", body, http.StatusNoContent},
+		{"/api/v1/orders/testUID123", "application/json", `"current"`, body, http.StatusNoContent},
+		{"/api/v1/orders/testUID123", "application/json", `"stale"`, body, http.StatusPreconditionFailed},
+		{"/api/v1/orders/otherUID", "application/json", "", body, http.StatusBadRequest},
+		{"/api/v1/orders/testUID123", "application/json", "", "{", http.StatusBadRequest},
+		{"/api/v1/orders/testUID123", "text/plain", "", body, http.StatusUnsupportedMediaType},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPut, tc.target, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+
+		if tc.ifMatch != "" {
+			req.Header.Set("If-Match", tc.ifMatch)
+		}
+
+		recorder := httptest.NewRecorder()
+		s.router.ServeHTTP(recorder, req)
+		require.Equal(s.T(), tc.code, recorder.Code, tc)
+	}
+
+	s.service.AssertExpectations(s.T())
+}
+
+func (s *ServerTestSuite) TestPutOrder_GetServesTheNewOrder() {
+	app := service.New(s.log, ordercache.New(s.log), memory.New())
+	router := chi.NewRouter()
+	server.ConfigureRoutes(router, app, s.log)
+
+	put := func(order models.Order, ifMatch string) int {
+		body, err := json.Marshal(order)
+		require.NoError(s.T(), err)
+
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/orders/"+order.OrderUID, strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		return recorder.Code
+	}
+
+	get := func(orderUID string) (models.Order, string) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/orders/"+orderUID, nil))
+		require.Equal(s.T(), http.StatusOK, recorder.Code)
+
+		var order models.Order
+		require.NoError(s.T(), json.Unmarshal(recorder.Body.Bytes(), &order))
+
+		return order, recorder.Header().Get("ETag")
+	}
+
+	order := putTestOrder("testUID123")
+	order.Version = 5
+	require.Equal(s.T(), http.StatusNoContent, put(order, ""))
+
+	_, etag := get(order.OrderUID)
+
+	// Unversioned writes keep the stored version.
+	order.Version = 0
+	order.TrackNumber = "TN0987654321"
+	require.Equal(s.T(), http.StatusNoContent, put(order, etag))
+
+	got, newETag := get(order.OrderUID)
+	require.Equal(s.T(), "TN0987654321", got.TrackNumber)
+	require.Equal(s.T(), uint64(5), got.Version)
+	require.NotEqual(s.T(), etag, newETag)
+
+	order.TrackNumber = "TN1122334455"
+	require.Equal(s.T(), http.StatusNoContent, put(order, newETag), "the ETag of the last GET should match")
+	require.Equal(s.T(), http.StatusPreconditionFailed, put(order, etag))
+}
+
+func putTestOrder(orderUID string) models.Order {
+	created := time.Date(2024, 5, 24, 12, 0, 0, 0, time.UTC)
+
+	return models.Order{
+		OrderUID:        orderUID,
+		TrackNumber:     "TN1234567890",
+		Locale:          "en",
+		CustomerID:      "Cust123",
+		DeliveryService: "TestService",
+		DateCreated:     created,
+		Delivery: models.Delivery{
+			OrderUID: orderUID,
+			Name:     "John Doe",
+			Phone:    "+1234567890",
+			City:     "TestCity",
+			Address:  "123 Test St",
+		},
+		Payment: models.Payment{
+			OrderUID:    orderUID,
+			Transaction: "TX1234567890",
+			Currency:    "USD",
+			Provider:    "TestProvider",
+			Amount:      models.MustParseMoney("150.00"),
+			PaymentDT:   created,
+		},
+		Items: []models.Item{{
+			ChrtID:      1,
+			OrderUID:    orderUID,
+			TrackNumber: "TN1234567890",
+			Price:       models.MustParseMoney("150.00"),
+			Name:        "Test Item 1",
+			NMID:        1001,
+			Brand:       "TestBrand",
+			Status:      1,
+		}},
+	}
+}
+
+func (s *ServerTestSuite) TestGetOrder_NotAcceptable() {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/testUID123", nil)
+	req.Header.Set("Accept", "text/html")
+	s.router.ServeHTTP(s.recorder, req)
+
+	require.Equal(s.T(), http.StatusNotAcceptable, s.recorder.Code)
+}
+
 func (s *ServerTestSuite) TestGetOrder_NotFound() {
 	orderUID := "nonExistentUID"
 	s.service.On("GetOrder", mock.Anything, orderUID).Return(nil, models.ErrOrderNotFound)
@@ -96,11 +367,198 @@ func (s *ServerTestSuite) TestGetOrder_NotFound() {
 	require.Equal(s.T(), http.StatusNotFound, s.recorder.Code)
 }
 
-func (s *ServerTestSuite) TestGetOrder_BadRequest() {
+func (s *ServerTestSuite) TestGetRawOrder() {
+	raw := &models.RawPayload{
+		ContentType: codec.ContentTypeJSON,
+		Payload:     json.RawMessage(`{"orderUid":"testUID123","giftWrap":true}`),
+	}
+
+	s.service.On("RawOrder", mock.Anything, "testUID123").Return(raw, nil)
+	s.service.On("RawOrder", mock.Anything, "noRaw").Return(nil, models.ErrRawNotFound)
+	s.service.On("RawOrder", mock.Anything, "missing").Return(nil, models.ErrOrderNotFound)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/testUID123/raw", nil)
+	s.router.ServeHTTP(s.recorder, req)
+
+	require.Equal(s.T(), http.StatusOK, s.recorder.Code)
+	require.JSONEq(s.T(),
+		`{"contentType":"application/json","payload":{"orderUid":"testUID123","giftWrap":true}}`,
+		s.recorder.Body.String())
+
+	for _, uid := range []string{"noRaw", "missing"} {
+		recorder := httptest.NewRecorder()
+		s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/orders/"+uid+"/raw", nil))
+		require.Equal(s.T(), http.StatusNotFound, recorder.Code, uid)
+	}
+}
+
+func (s *ServerTestSuite) TestListOrders() {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	page := &models.OrderPage{Orders: []models.Order{{OrderUID: "testUID123"}}, NextCursor: "next"}
+
+	s.service.On("ListOrders", mock.Anything, models.OrderQuery{
+		Sort: models.SortDateCreated, Desc: true,
+	}).Return(page, nil)
+	s.service.On("ListOrders", mock.Anything, models.OrderQuery{
+		Filter: models.OrderFilter{
+			CustomerID: "Cust123", PaymentProvider: "wbpay", Currency: "USD", ItemBrand: "BrandX", CreatedFrom: from,
+		},
+		Sort: models.SortOrderUID, Limit: 10, Cursor: "abc",
+	}).Return(page, nil)
+
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/", nil)
 	s.router.ServeHTTP(s.recorder, req)
 
-	require.Equal(s.T(), http.StatusBadRequest, s.recorder.Code)
+	require.Equal(s.T(), http.StatusOK, s.recorder.Code)
+
+	var got models.OrderPage
+	require.NoError(s.T(), json.Unmarshal(s.recorder.Body.Bytes(), &got))
+	require.Equal(s.T(), "next", got.NextCursor)
+	require.Len(s.T(), got.Orders, 1)
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/orders/?customerId=Cust123"+
+		"&paymentProvider=wbpay&currency=USD&brand=BrandX&createdFrom=2024-06-01T00:00:00Z"+
+		"&sort=orderUid&limit=10&cursor=abc", nil))
+	require.Equal(s.T(), http.StatusOK, recorder.Code)
+
+	s.service.AssertExpectations(s.T())
+}
+
+func (s *ServerTestSuite) TestListOrders_BadRequest() {
+	s.service.On("ListOrders", mock.Anything, mock.Anything).Return(nil, models.ErrInvalidQuery)
+
+	for _, target := range []string{
+		"/api/v1/orders/?createdFrom=yesterday",
+		"/api/v1/orders/?limit=ten",
+		"/api/v1/orders/?sort=price",
+	} {
+		recorder := httptest.NewRecorder()
+		s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(s.T(), http.StatusBadRequest, recorder.Code, target)
+	}
+}
+
+func (s *ServerTestSuite) TestSearchOrders() {
+	page := &models.SearchPage{
+		Results: []models.SearchResult{{
+			Order: models.Order{OrderUID: "testUID123"},
+			Rank:  0.5,
+			Highlights: []models.Highlight{
+				{Field: models.SearchFieldItemBrand, Fragment: "<mark>Vivienne</mark> Sabo"},
+			},
+		}},
+		NextCursor: "next",
+	}
+
+	s.service.On("SearchOrders", mock.Anything, models.SearchQuery{Text: "vivienne"}).Return(page, nil)
+	s.service.On("SearchOrders", mock.Anything, models.SearchQuery{Text: "moscow", Limit: 10, Cursor: "abc"}).
+		Return(&models.SearchPage{Results: []models.SearchResult{}}, nil)
+	s.service.On("SearchOrders", mock.Anything, models.SearchQuery{}).Return(nil, models.ErrInvalidQuery)
+	s.service.On("SearchOrders", mock.Anything, models.SearchQuery{Text: "kazan"}).
+		Return(nil, models.ErrSearchUnsupported)
+
+	s.router.ServeHTTP(s.recorder, httptest.NewRequest(http.MethodGet, "/api/v1/orders/search?q=vivienne", nil))
+	require.Equal(s.T(), http.StatusOK, s.recorder.Code)
+
+	var got models.SearchPage
+	require.NoError(s.T(), json.Unmarshal(s.recorder.Body.Bytes(), &got))
+	require.Equal(s.T(), *page, got)
+
+	cases := []struct {
+		target string
+		code   int
+	}{
+		{"/api/v1/orders/search?q=moscow&limit=10&cursor=abc", http.StatusOK},
+		{"/api/v1/orders/search", http.StatusBadRequest},
+		{"/api/v1/orders/search?q=moscow&limit=ten", http.StatusBadRequest},
+		{"/api/v1/orders/search?q=kazan", http.StatusNotImplemented},
+	}
+
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.target, nil))
+		require.Equal(s.T(), tc.code, recorder.Code, tc.target)
+	}
+
+	s.service.AssertExpectations(s.T())
+}
+
+func (s *ServerTestSuite) TestDeleteOrder() {
+	s.service.On("DeleteOrder", mock.Anything, models.Tombstone{OrderUID: "softUID"}).Return(nil)
+	s.service.On("DeleteOrder", mock.Anything, models.Tombstone{OrderUID: "hardUID", Hard: true}).Return(nil)
+	s.service.On("DeleteOrder", mock.Anything, models.Tombstone{OrderUID: "missingUID"}).
+		Return(models.ErrOrderNotFound)
+	s.service.On("DeleteOrder", mock.Anything, models.Tombstone{OrderUID: "softUID", IfMatch: `"stale"`}).
+		Return(models.ErrPreconditionFailed)
+
+	cases := []struct {
+		target string
+		code   int
+	}{
+		{"/api/v1/orders/softUID", http.StatusNoContent},
+		{"/api/v1/orders/hardUID?hard=true", http.StatusNoContent},
+		{"/api/v1/orders/missingUID", http.StatusNotFound},
+		{"/api/v1/orders/softUID?hard=maybe", http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, tc.target, nil))
+		require.Equal(s.T(), tc.code, recorder.Code, tc.target)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/orders/softUID", nil)
+	req.Header.Set("If-Match", `"stale"`)
+
+	s.router.ServeHTTP(s.recorder, req)
+	require.Equal(s.T(), http.StatusPreconditionFailed, s.recorder.Code)
+
+	s.service.AssertExpectations(s.T())
+}
+
+func (s *ServerTestSuite) TestRevisions() {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	s.service.On("OrderRevisions", mock.Anything, "testUID123").
+		Return([]models.Revision{{OrderUID: "testUID123", Revision: 1}, {OrderUID: "testUID123", Revision: 2}}, nil)
+	s.service.On("OrderRevisions", mock.Anything, "missingUID").Return(nil, models.ErrOrderNotFound)
+	s.service.On("OrderRevision", mock.Anything, "testUID123", 2).
+		Return(&models.Revision{OrderUID: "testUID123", Revision: 2, Order: &models.Order{OrderUID: "testUID123"}}, nil)
+	s.service.On("OrderRevision", mock.Anything, "testUID123", 9).Return(nil, models.ErrRevisionNotFound)
+	s.service.On("DiffOrderRevisions", mock.Anything, "testUID123", 1, 2).
+		Return(&models.RevisionDiff{OrderUID: "testUID123", From: 1, To: 2}, nil)
+	s.service.On("OrderAsOf", mock.Anything, "testUID123", at).Return(&models.Order{OrderUID: "testUID123"}, nil)
+
+	cases := []struct {
+		target string
+		code   int
+	}{
+		{"/api/v1/orders/testUID123/revisions", http.StatusOK},
+		{"/api/v1/orders/missingUID/revisions", http.StatusNotFound},
+		{"/api/v1/orders/testUID123/revisions/2", http.StatusOK},
+		{"/api/v1/orders/testUID123/revisions/9", http.StatusNotFound},
+		{"/api/v1/orders/testUID123/revisions/latest", http.StatusBadRequest},
+		{"/api/v1/orders/testUID123/revisions/diff?from=1&to=2", http.StatusOK},
+		{"/api/v1/orders/testUID123/revisions/diff?from=1", http.StatusBadRequest},
+		{"/api/v1/orders/testUID123?asOf=2024-06-01T12:00:00Z", http.StatusOK},
+		{"/api/v1/orders/testUID123?asOf=yesterday", http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.target, nil))
+		require.Equal(s.T(), tc.code, recorder.Code, tc.target)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/orders/testUID123/revisions", nil))
+
+	var revisions []models.Revision
+	require.NoError(s.T(), json.Unmarshal(recorder.Body.Bytes(), &revisions))
+	require.Len(s.T(), revisions, 2)
+
+	s.service.AssertExpectations(s.T())
 }
 
 func (s *ServerTestSuite) TestStartServer() {
@@ -134,3 +592,20 @@ func (s *ServerTestSuite) TestStartServer() {
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
 	require.Equal(s.T(), orderUID, responseOrder.OrderUID)
 }
+
+func (s *ServerTestSuite) TestValidationStats() {
+	stats := service.ValidationStats{
+		RejectedOrders: 2,
+		Violations:     map[string]uint64{"payment.amount:min": 2},
+	}
+	s.service.On("ValidationStats").Return(stats)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/stats/validation", nil)
+	s.router.ServeHTTP(s.recorder, req)
+
+	require.Equal(s.T(), http.StatusOK, s.recorder.Code)
+
+	var got service.ValidationStats
+	require.NoError(s.T(), json.NewDecoder(s.recorder.Body).Decode(&got))
+	require.Equal(s.T(), stats, got)
+}
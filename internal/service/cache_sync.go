@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+// InvalidationPublisher broadcasts a cache invalidation to every instance.
+type InvalidationPublisher func(ctx context.Context, inv models.CacheInvalidation) error
+
+// cacheSync keeps the caches of several instances coherent. Every upsert or
+// delete is announced with the next sequence number of this instance; peers
+// refresh or evict the order and resync their whole cache when a sequence
+// number is skipped.
+type cacheSync struct {
+	instance string
+	publish  InvalidationPublisher
+	// pubMu orders publishes by sequence number: a number is taken and
+	// published under it, so peers never see N+1 before N.
+	pubMu sync.Mutex
+	seq   uint64
+
+	mu    sync.Mutex
+	peers map[string]uint64
+	// touched collects the orders changed while a resync runs, nil
+	// otherwise. The resync may have cached an older copy of them.
+	touched map[string]struct{}
+
+	resyncing atomic.Bool
+}
+
+// EnableCacheSync announces every change of this instance with publish and
+// returns the instance ID the announcements carry. The ID is random, so a
+// restarted instance starts a new sequence.
+func (s *Service) EnableCacheSync(publish InvalidationPublisher) string {
+	s.cacheSync = &cacheSync{
+		instance: newInstanceID(),
+		publish:  publish,
+		peers:    make(map[string]uint64),
+	}
+
+	return s.cacheSync.instance
+}
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// broadcast announces a change. A failed publish is only logged: the
+// sequence number is used up anyway, so peers notice the gap on the next
+// invalidation or heartbeat and resync.
+func (s *Service) broadcast(ctx context.Context, orderUID string, version uint64, deleted bool) {
+	cs := s.cacheSync
+	if cs == nil {
+		return
+	}
+
+	cs.touch(orderUID)
+
+	cs.pubMu.Lock()
+	defer cs.pubMu.Unlock()
+
+	cs.seq++
+
+	inv := models.CacheInvalidation{
+		Instance: cs.instance,
+		Seq:      cs.seq,
+		OrderUID: orderUID,
+		Version:  version,
+		Deleted:  deleted,
+	}
+
+	if err := cs.publish(ctx, inv); err != nil {
+		s.log.WithError(err).Warnf("service.go broadcast(%s) seq %d", orderUID, inv.Seq)
+	}
+}
+
+// RunCacheSyncHeartbeat repeats the last sequence number every interval
+// until ctx is done, so peers also notice when the last invalidations before
+// a quiet period were lost.
+func (s *Service) RunCacheSyncHeartbeat(ctx context.Context, interval time.Duration) {
+	cs := s.cacheSync
+	if cs == nil || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cs.pubMu.Lock()
+
+			inv := models.CacheInvalidation{Instance: cs.instance, Seq: cs.seq}
+			if err := cs.publish(ctx, inv); err != nil {
+				s.log.WithError(err).Warn("service.go RunCacheSyncHeartbeat publish(...)")
+			}
+
+			cs.pubMu.Unlock()
+		}
+	}
+}
+
+// ApplyInvalidation applies an invalidation received from another instance.
+// Deleted orders are evicted and changed orders are reloaded from storage,
+// replacing the cached copy whatever its version; an order storage doesn't
+// have at the announced version yet, e.g. on a lagging replica, is evicted
+// so reads fall through to storage.
+func (s *Service) ApplyInvalidation(ctx context.Context, inv models.CacheInvalidation) {
+	cs := s.cacheSync
+	if cs == nil || inv.Instance == cs.instance {
+		return
+	}
+
+	fresh, gap := cs.observe(inv)
+	if gap {
+		s.log.Warnf("Missed cache invalidations from %s, resyncing the cache", inv.Instance)
+		s.resyncCache(ctx)
+	}
+
+	if !fresh || inv.IsHeartbeat() {
+		return
+	}
+
+	cs.touch(inv.OrderUID)
+
+	if inv.Deleted {
+		s.cache.Delete(ctx, inv.OrderUID)
+
+		return
+	}
+
+	order, err := s.storage.Get(ctx, inv.OrderUID)
+	if err != nil || order.Version < inv.Version {
+		if err != nil && !errors.Is(err, models.ErrOrderNotFound) {
+			s.log.WithError(err).Warnf("service.go ApplyInvalidation s.storage.Get(%s)", inv.OrderUID)
+		}
+
+		s.cache.Delete(ctx, inv.OrderUID)
+
+		return
+	}
+
+	// A rewrite such as renormalize keeps the version, so the cached copy
+	// is replaced rather than upserted, which would reject it as stale.
+	s.cache.Delete(ctx, inv.OrderUID)
+
+	if err := s.cacheOrder(ctx, *order); err != nil {
+		s.log.WithError(err).Errorf("service.go ApplyInvalidation(%s)", inv.OrderUID)
+		s.cache.Delete(ctx, inv.OrderUID)
+	}
+}
+
+// observe records the sequence number of an invalidation. It reports
+// whether the invalidation is new and whether earlier ones were missed. The
+// first message of an instance is never a gap: whatever it changed before
+// was in storage when this cache was filled.
+func (cs *cacheSync) observe(inv models.CacheInvalidation) (bool, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	last, known := cs.peers[inv.Instance]
+
+	expected := last + 1
+	if inv.IsHeartbeat() {
+		expected = last
+	}
+
+	if known && inv.Seq < expected {
+		return false, false
+	}
+
+	cs.peers[inv.Instance] = max(last, inv.Seq)
+
+	return !inv.IsHeartbeat(), known && inv.Seq > expected
+}
+
+// touch records a change of an order if a resync is running.
+func (cs *cacheSync) touch(orderUID string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.touched != nil {
+		cs.touched[orderUID] = struct{}{}
+	}
+}
+
+// resyncCache empties the cache and fills it again from storage in the
+// background. Reads fall through to storage meanwhile. The scan may cache
+// an order as it was before a change that was applied while it ran, even
+// one that was deleted meanwhile, so the orders changed during the resync
+// are evicted once it is done. Only one resync runs at a time.
+func (s *Service) resyncCache(ctx context.Context) {
+	cs := s.cacheSync
+	if !cs.resyncing.CompareAndSwap(false, true) {
+		return
+	}
+
+	cs.mu.Lock()
+	cs.touched = make(map[string]struct{})
+	cs.mu.Unlock()
+
+	go func() {
+		defer cs.resyncing.Store(false)
+
+		s.cache.Clear(ctx)
+
+		if err := s.Init(ctx); err != nil {
+			s.log.WithError(err).Error("service.go resyncCache s.Init(...)")
+		}
+
+		cs.mu.Lock()
+		touched := cs.touched
+		cs.touched = nil
+		cs.mu.Unlock()
+
+		for orderUID := range touched {
+			s.cache.Delete(ctx, orderUID)
+		}
+	}()
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+// RawDecoder decodes a stored raw payload into an order.
+type RawDecoder func(raw *models.RawPayload) (models.Order, error)
+
+type RenormalizeStats struct {
+	Orders       int `json:"orders"`
+	Renormalized int `json:"renormalized"`
+	Unchanged    int `json:"unchanged"`
+	Skipped      int `json:"skipped"`
+	WithoutRaw   int `json:"withoutRaw"`
+	Failed       int `json:"failed"`
+}
+
+// RawOrder returns the payload an order was last received as.
+func (s *Service) RawOrder(ctx context.Context, orderUID string) (*models.RawPayload, error) {
+	raw, err := s.storage.RawOrder(ctx, orderUID)
+	if err != nil {
+		return nil, fmt.Errorf("service.go RawOrder s.storage.RawOrder(%s): %w", orderUID, err)
+	}
+
+	return raw, nil
+}
+
+// RenormalizeOrders decodes the raw payload of every live order again and
+// stores the orders that come out different, so that stored orders pick up
+// fields the model has gained since they were received. Orders that fail to
+// decode or validate are logged and counted, and the rest carry on.
+//
+// The rewrites are unversioned: they keep the stored version. Each rewrite
+// only applies while the order is still the one that was read, so an update
+// that arrives meanwhile wins and the order is counted as skipped. Rewritten
+// orders are cached and announced like any other upsert.
+func (s *Service) RenormalizeOrders(ctx context.Context, decode RawDecoder) (RenormalizeStats, error) {
+	var stats RenormalizeStats
+
+	err := s.storage.StreamOrders(ctx, models.OrderStream{}, func(orders []models.Order) error {
+		for i := range orders {
+			stats.Orders++
+
+			if err := s.renormalize(ctx, &orders[i], decode, &stats); err != nil {
+				stats.Failed++
+
+				s.log.WithError(err).Errorf("service.go RenormalizeOrders(%s)", orders[i].OrderUID)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("service.go RenormalizeOrders s.storage.StreamOrders(...): %w", err)
+	}
+
+	return stats, nil
+}
+
+func (s *Service) renormalize(
+	ctx context.Context, stored *models.Order, decode RawDecoder, stats *RenormalizeStats,
+) error {
+	raw, err := s.storage.RawOrder(ctx, stored.OrderUID)
+	if errors.Is(err, models.ErrRawNotFound) || errors.Is(err, models.ErrOrderNotFound) {
+		stats.WithoutRaw++
+
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("s.storage.RawOrder(...): %w", err)
+	}
+
+	order, err := decode(raw)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	if order.OrderUID != stored.OrderUID {
+		return fmt.Errorf("decode: %w: payload is for order %q", models.ErrInvalidOrder, order.OrderUID)
+	}
+
+	if len(order.ChangedSections(stored)) == 0 {
+		stats.Unchanged++
+
+		return nil
+	}
+
+	order.Version = 0
+	order.IfMatch = stored.ETag()
+	order.Raw = raw
+	order.Source = models.Source{ReceivedAt: time.Now().UTC()}
+
+	err = s.UpsertOrder(ctx, order)
+	if errors.Is(err, models.ErrPreconditionFailed) {
+		stats.Skipped++
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	stats.Renormalized++
+
+	return nil
+}
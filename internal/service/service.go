@@ -2,36 +2,48 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/repository"
 )
 
 type cache interface {
 	Upsert(ctx context.Context, order models.Order) error
 	Get(ctx context.Context, orderUID string) (*models.Order, error)
-}
-
-type storage interface {
-	Get(ctx context.Context, orderUID string) (*models.Order, error)
-	GetAll(ctx context.Context) ([]models.Order, error)
-	Upsert(ctx context.Context, order *models.Order) (*models.Order, error)
+	Delete(ctx context.Context, orderUID string)
+	Clear(ctx context.Context)
 }
 
 type Service struct {
-	log     *logrus.Logger
-	cache   cache
-	storage storage
+	log        *logrus.Logger
+	cache      cache
+	storage    repository.Repository
+	validation validationCounter
+	warmup     WarmupOptions
+	cacheSync  *cacheSync
 }
 
 type OrderServiceInterface interface {
 	Init(ctx context.Context) error
 	UpsertOrder(ctx context.Context, order models.Order) error
+	UpsertOrders(ctx context.Context, orders []models.Order) []error
 	GetOrder(ctx context.Context, orderID string) (*models.Order, error)
+	RawOrder(ctx context.Context, orderUID string) (*models.RawPayload, error)
+	ListOrders(ctx context.Context, query models.OrderQuery) (*models.OrderPage, error)
+	SearchOrders(ctx context.Context, query models.SearchQuery) (*models.SearchPage, error)
+	DeleteOrder(ctx context.Context, tombstone models.Tombstone) error
+	OrderRevisions(ctx context.Context, orderUID string) ([]models.Revision, error)
+	OrderRevision(ctx context.Context, orderUID string, revision int) (*models.Revision, error)
+	OrderAsOf(ctx context.Context, orderUID string, at time.Time) (*models.Order, error)
+	DiffOrderRevisions(ctx context.Context, orderUID string, from, to int) (*models.RevisionDiff, error)
+	ValidationStats() ValidationStats
 }
 
-func New(log *logrus.Logger, cache cache, storage storage) *Service {
+func New(log *logrus.Logger, cache cache, storage repository.Repository) *Service {
 	return &Service{
 		log:     log,
 		cache:   cache,
@@ -39,32 +51,82 @@ func New(log *logrus.Logger, cache cache, storage storage) *Service {
 	}
 }
 
-func (s *Service) Init(ctx context.Context) error {
-	orders, err := s.storage.GetAll(ctx)
+func (s *Service) UpsertOrder(ctx context.Context, order models.Order) error {
+	if err := s.validate(&order); err != nil {
+		return fmt.Errorf("service.go UpsertOrder s.validate(%s): %w", order.OrderUID, err)
+	}
+
+	stored, err := s.storage.Upsert(ctx, &order)
 	if err != nil {
-		return fmt.Errorf("service.go Init(...): %w", err)
+		return fmt.Errorf("service.go UpsertOrder s.storage.Upsert(...): %w", err)
+	}
+
+	order.Version = stored.Version
+	s.broadcast(ctx, order.OrderUID, order.Version, false)
+
+	return s.cacheOrder(ctx, order)
+}
+
+// UpsertOrders validates and stores a batch of orders in one storage
+// transaction and returns the outcome for each order, index-aligned with
+// the input.
+func (s *Service) UpsertOrders(ctx context.Context, orders []models.Order) []error {
+	results := make([]error, len(orders))
+	valid := make([]*models.Order, 0, len(orders))
+	validIdx := make([]int, 0, len(orders))
+
+	for i := range orders {
+		if err := s.validate(&orders[i]); err != nil {
+			results[i] = fmt.Errorf("service.go UpsertOrders s.validate(%s): %w", orders[i].OrderUID, err)
+
+			continue
+		}
+
+		valid = append(valid, &orders[i])
+		validIdx = append(validIdx, i)
 	}
 
-	s.log.Debugf("Fetched %d orders from storage", len(orders))
+	if len(valid) == 0 {
+		return results
+	}
 
-	for _, order := range orders {
-		if err := s.cache.Upsert(ctx, order); err != nil {
-			s.log.WithError(err).Errorf("service.go Init(...) Upsert(%s)", order.OrderUID)
+	stored, err := s.storage.UpsertBatch(ctx, valid)
+	if err != nil {
+		for _, i := range validIdx {
+			results[i] = fmt.Errorf("service.go UpsertOrders s.storage.UpsertBatch(...): %w", err)
 		}
+
+		return results
 	}
 
-	s.log.Infof("Initialized cache with %d orders", len(orders))
+	for j, i := range validIdx {
+		if stored[j] != nil {
+			results[i] = fmt.Errorf("service.go UpsertOrders s.storage.UpsertBatch(..., %s): %w", orders[i].OrderUID, stored[j])
 
-	return nil
+			continue
+		}
+
+		s.broadcast(ctx, orders[i].OrderUID, orders[i].Version, false)
+		results[i] = s.cacheOrder(ctx, orders[i])
+	}
+
+	return results
 }
 
-func (s *Service) UpsertOrder(ctx context.Context, order models.Order) error {
+// cacheOrder caches an order that was just written to storage. The cache
+// rejects it when it holds the same or a newer version, e.g. after an
+// unversioned write, which keeps the stored version. Storage is the source
+// of truth then, so the entry is evicted and the next read reloads it.
+func (s *Service) cacheOrder(ctx context.Context, order models.Order) error {
 	if err := s.cache.Upsert(ctx, order); err != nil {
-		return fmt.Errorf("service.go UpsertOrder s.cache.Upsert(..., %s): %w", order.OrderUID, err)
-	}
+		if errors.Is(err, models.ErrStaleVersion) {
+			s.log.WithError(err).Debugf("service.go cacheOrder evicting %s", order.OrderUID)
+			s.cache.Delete(ctx, order.OrderUID)
 
-	if _, err := s.storage.Upsert(ctx, &order); err != nil {
-		return fmt.Errorf("service.go UpsertOrder s.storage.Upsert(...): %w", err)
+			return nil
+		}
+
+		return fmt.Errorf("service.go cacheOrder s.cache.Upsert(..., %s): %w", order.OrderUID, err)
 	}
 
 	return nil
@@ -80,10 +142,111 @@ func (s *Service) GetOrder(ctx context.Context, orderID string) (*models.Order,
 			return nil, fmt.Errorf("service.go GetOrder s.storage.Get(...): %w", err)
 		}
 
-		if err := s.cache.Upsert(ctx, *order); err != nil {
+		if err := s.cache.Upsert(ctx, *order); err != nil && !errors.Is(err, models.ErrStaleVersion) {
 			s.log.WithError(err).Errorf("service.go GetOrder s.cache.Upsert(%s)", orderID)
 		}
 	}
 
 	return order, nil
 }
+
+// ListOrders reads a page of orders straight from storage; the cache only
+// serves lookups by order UID.
+func (s *Service) ListOrders(ctx context.Context, query models.OrderQuery) (*models.OrderPage, error) {
+	page, err := s.storage.ListOrders(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("service.go ListOrders s.storage.ListOrders(...): %w", err)
+	}
+
+	return page, nil
+}
+
+// SearchOrders runs a full-text search in storage. It fails with
+// models.ErrSearchUnsupported when the storage backend can't search.
+func (s *Service) SearchOrders(ctx context.Context, query models.SearchQuery) (*models.SearchPage, error) {
+	searcher, ok := s.storage.(repository.Searcher)
+	if !ok {
+		return nil, models.ErrSearchUnsupported
+	}
+
+	page, err := searcher.SearchOrders(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("service.go SearchOrders searcher.SearchOrders(...): %w", err)
+	}
+
+	return page, nil
+}
+
+// DeleteOrder deletes an order from storage and evicts it from the cache.
+// The cache is evicted even if storage no longer has the order.
+func (s *Service) DeleteOrder(ctx context.Context, tombstone models.Tombstone) error {
+	err := s.storage.Delete(ctx, tombstone)
+	if err == nil || errors.Is(err, models.ErrOrderNotFound) {
+		s.cache.Delete(ctx, tombstone.OrderUID)
+		s.broadcast(ctx, tombstone.OrderUID, tombstone.Version, true)
+	}
+
+	if err != nil {
+		return fmt.Errorf("service.go DeleteOrder s.storage.Delete(%s): %w", tombstone.OrderUID, err)
+	}
+
+	return nil
+}
+
+func (s *Service) OrderRevisions(ctx context.Context, orderUID string) ([]models.Revision, error) {
+	revisions, err := s.storage.Revisions(ctx, orderUID)
+	if err != nil {
+		return nil, fmt.Errorf("service.go OrderRevisions s.storage.Revisions(%s): %w", orderUID, err)
+	}
+
+	return revisions, nil
+}
+
+func (s *Service) OrderRevision(ctx context.Context, orderUID string, revision int) (*models.Revision, error) {
+	rev, err := s.storage.Revision(ctx, orderUID, revision)
+	if err != nil {
+		return nil, fmt.Errorf("service.go OrderRevision s.storage.Revision(%s, %d): %w", orderUID, revision, err)
+	}
+
+	return rev, nil
+}
+
+// OrderAsOf returns the order as it was stored at the given time. It fails
+// with ErrOrderNotFound if the order did not exist yet or was deleted then.
+func (s *Service) OrderAsOf(ctx context.Context, orderUID string, at time.Time) (*models.Order, error) {
+	rev, err := s.storage.RevisionAt(ctx, orderUID, at)
+	if errors.Is(err, models.ErrRevisionNotFound) {
+		err = models.ErrOrderNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("service.go OrderAsOf s.storage.RevisionAt(%s): %w", orderUID, err)
+	}
+
+	if rev.Order == nil {
+		return nil, fmt.Errorf("service.go OrderAsOf(%s) deleted in revision %d: %w",
+			orderUID, rev.Revision, models.ErrOrderNotFound)
+	}
+
+	return rev.Order, nil
+}
+
+// DiffOrderRevisions compares two revisions of an order field by field.
+func (s *Service) DiffOrderRevisions(ctx context.Context, orderUID string, from, to int) (*models.RevisionDiff, error) {
+	fromRev, err := s.OrderRevision(ctx, orderUID, from)
+	if err != nil {
+		return nil, err
+	}
+
+	toRev, err := s.OrderRevision(ctx, orderUID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := models.DiffOrders(fromRev.Order, toRev.Order)
+	if err != nil {
+		return nil, fmt.Errorf("service.go DiffOrderRevisions(%s): %w", orderUID, err)
+	}
+
+	return &models.RevisionDiff{OrderUID: orderUID, From: from, To: to, Changes: changes}, nil
+}
@@ -2,19 +2,27 @@ package service_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/suite"
 	"github.com/stsolovey/order_tracker/internal/models"
+	ordercache "github.com/stsolovey/order_tracker/internal/order-cache"
+	"github.com/stsolovey/order_tracker/internal/repository/memory"
 	"github.com/stsolovey/order_tracker/internal/service"
 )
 
 type MockCache struct {
 	UpsertFunc func(ctx context.Context, order models.Order) error
 	GetFunc    func(ctx context.Context, orderUID string) (*models.Order, error)
+	RawFunc    func(ctx context.Context, orderUID string) (*models.RawPayload, error)
+	DeleteFunc func(ctx context.Context, orderUID string)
+	ClearFunc  func(ctx context.Context)
 }
 
 func (m *MockCache) Upsert(ctx context.Context, order models.Order) error {
@@ -31,10 +39,28 @@ func (m *MockCache) Get(ctx context.Context, orderUID string) (*models.Order, er
 	return nil, models.ErrOrderNotFound
 }
 
+func (m *MockCache) Delete(ctx context.Context, orderUID string) {
+	if m.DeleteFunc != nil {
+		m.DeleteFunc(ctx, orderUID)
+	}
+}
+
+func (m *MockCache) Clear(ctx context.Context) {
+	if m.ClearFunc != nil {
+		m.ClearFunc(ctx)
+	}
+}
+
 type MockStorage struct {
 	GetFunc    func(ctx context.Context, orderUID string) (*models.Order, error)
-	GetAllFunc func(ctx context.Context) ([]models.Order, error)
+	RawFunc    func(ctx context.Context, orderUID string) (*models.RawPayload, error)
+	StreamFunc func(ctx context.Context, stream models.OrderStream, fn func([]models.Order) error) error
 	UpsertFunc func(ctx context.Context, order *models.Order) (*models.Order, error)
+	BatchFunc  func(ctx context.Context, orders []*models.Order) ([]error, error)
+	DeleteFunc func(ctx context.Context, tombstone models.Tombstone) error
+
+	RevisionFunc   func(ctx context.Context, orderUID string, revision int) (*models.Revision, error)
+	RevisionAtFunc func(ctx context.Context, orderUID string, at time.Time) (*models.Revision, error)
 }
 
 func (m *MockStorage) Get(ctx context.Context, orderUID string) (*models.Order, error) {
@@ -44,11 +70,20 @@ func (m *MockStorage) Get(ctx context.Context, orderUID string) (*models.Order,
 	return nil, models.ErrOrderNotFound
 }
 
-func (m *MockStorage) GetAll(ctx context.Context) ([]models.Order, error) {
-	if m.GetAllFunc != nil {
-		return m.GetAllFunc(ctx)
+func (m *MockStorage) RawOrder(ctx context.Context, orderUID string) (*models.RawPayload, error) {
+	if m.RawFunc != nil {
+		return m.RawFunc(ctx, orderUID)
+	}
+	return nil, models.ErrRawNotFound
+}
+
+func (m *MockStorage) StreamOrders(
+	ctx context.Context, stream models.OrderStream, fn func([]models.Order) error,
+) error {
+	if m.StreamFunc != nil {
+		return m.StreamFunc(ctx, stream, fn)
 	}
-	return nil, nil
+	return nil
 }
 
 func (m *MockStorage) Upsert(ctx context.Context, order *models.Order) (*models.Order, error) {
@@ -58,6 +93,42 @@ func (m *MockStorage) Upsert(ctx context.Context, order *models.Order) (*models.
 	return order, nil
 }
 
+func (m *MockStorage) UpsertBatch(ctx context.Context, orders []*models.Order) ([]error, error) {
+	if m.BatchFunc != nil {
+		return m.BatchFunc(ctx, orders)
+	}
+	return make([]error, len(orders)), nil
+}
+
+func (m *MockStorage) Delete(ctx context.Context, tombstone models.Tombstone) error {
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(ctx, tombstone)
+	}
+	return nil
+}
+
+func (m *MockStorage) ListOrders(context.Context, models.OrderQuery) (*models.OrderPage, error) {
+	return &models.OrderPage{}, nil
+}
+
+func (m *MockStorage) Revisions(context.Context, string) ([]models.Revision, error) {
+	return nil, models.ErrOrderNotFound
+}
+
+func (m *MockStorage) Revision(ctx context.Context, orderUID string, revision int) (*models.Revision, error) {
+	if m.RevisionFunc != nil {
+		return m.RevisionFunc(ctx, orderUID, revision)
+	}
+	return nil, models.ErrRevisionNotFound
+}
+
+func (m *MockStorage) RevisionAt(ctx context.Context, orderUID string, at time.Time) (*models.Revision, error) {
+	if m.RevisionAtFunc != nil {
+		return m.RevisionAtFunc(ctx, orderUID, at)
+	}
+	return nil, models.ErrRevisionNotFound
+}
+
 type ServiceSuite struct {
 	suite.Suite
 	service     *service.Service
@@ -77,36 +148,161 @@ func TestServiceSuite(t *testing.T) {
 	suite.Run(t, new(ServiceSuite))
 }
 
-func (s *ServiceSuite) TestInit() {
-	expectedOrders := []models.Order{
-		{
-			OrderUID:    "testUID123",
-			TrackNumber: "TN1234567890",
-			CustomerID:  "Cust123",
-			DateCreated: time.Now(),
-		},
+// streamChunks serves orders the way Storage.StreamOrders does: split
+// between partitions round-robin and handed out chunk by chunk.
+func streamChunks(orders []models.Order) func(context.Context, models.OrderStream, func([]models.Order) error) error {
+	return func(_ context.Context, stream models.OrderStream, fn func([]models.Order) error) error {
+		var part []models.Order
+
+		for i, order := range orders {
+			if stream.Partitions <= 1 || i%stream.Partitions == stream.Partition {
+				part = append(part, order)
+			}
+		}
+
+		for len(part) > 0 {
+			n := min(stream.ChunkSize, len(part))
+			if err := fn(part[:n]); err != nil {
+				return err
+			}
+
+			part = part[n:]
+		}
+
+		return nil
 	}
+}
 
-	s.mockStorage.GetAllFunc = func(ctx context.Context) ([]models.Order, error) {
-		return expectedOrders, nil
+func (s *ServiceSuite) warmupOrders(n int) []models.Order {
+	orders := make([]models.Order, n)
+	for i := range orders {
+		orders[i] = models.Order{OrderUID: fmt.Sprintf("order%03d", i), DateCreated: time.Now()}
+	}
+
+	return orders
+}
+
+func (s *ServiceSuite) TestInit() {
+	var (
+		mu     sync.Mutex
+		cached = make(map[string]bool)
+		chunks []int
+	)
+
+	orders := s.warmupOrders(25)
+	stream := streamChunks(orders)
+
+	s.mockStorage.StreamFunc = func(ctx context.Context, st models.OrderStream, fn func([]models.Order) error) error {
+		return stream(ctx, st, func(chunk []models.Order) error {
+			mu.Lock()
+			chunks = append(chunks, len(chunk))
+			mu.Unlock()
+
+			return fn(chunk)
+		})
 	}
 
 	s.mockCache.UpsertFunc = func(ctx context.Context, order models.Order) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cached[order.OrderUID] = true
+
 		return nil
 	}
 
+	s.service.SetWarmupOptions(service.WarmupOptions{ChunkSize: 4, Workers: 3})
+
 	err := s.service.Init(context.Background())
 	s.Require().NoError(err)
+	s.Require().Len(cached, len(orders))
+
+	for _, n := range chunks {
+		s.Require().LessOrEqual(n, 4)
+	}
 }
 
-func (s *ServiceSuite) TestUpsertOrder() {
-	order := models.Order{
-		OrderUID:    "testUID123",
-		TrackNumber: "TN1234567890",
-		CustomerID:  "Cust123",
-		DateCreated: time.Now(),
+func (s *ServiceSuite) TestInit_MaxOrders() {
+	var cached atomic.Int64
+
+	s.mockStorage.StreamFunc = streamChunks(s.warmupOrders(100))
+	s.mockCache.UpsertFunc = func(ctx context.Context, order models.Order) error {
+		cached.Add(1)
+
+		return nil
+	}
+
+	s.service.SetWarmupOptions(service.WarmupOptions{ChunkSize: 7, Workers: 4, MaxOrders: 30})
+
+	s.Require().NoError(s.service.Init(context.Background()))
+	s.Require().Equal(int64(30), cached.Load())
+}
+
+func (s *ServiceSuite) TestInit_MaxAge() {
+	var got models.OrderStream
+
+	s.mockStorage.StreamFunc = func(_ context.Context, stream models.OrderStream, _ func([]models.Order) error) error {
+		got = stream
+
+		return nil
+	}
+
+	s.service.SetWarmupOptions(service.WarmupOptions{MaxAge: time.Hour})
+
+	s.Require().NoError(s.service.Init(context.Background()))
+	s.Require().WithinDuration(time.Now().Add(-time.Hour), got.CreatedFrom, time.Minute)
+	s.Require().Equal(1, got.Partitions)
+}
+
+func (s *ServiceSuite) TestInit_StorageError() {
+	s.mockStorage.StreamFunc = func(context.Context, models.OrderStream, func([]models.Order) error) error {
+		return errors.New("connection refused")
 	}
 
+	s.Require().Error(s.service.Init(context.Background()))
+}
+
+func validOrder(orderUID string) models.Order {
+	return models.Order{
+		OrderUID:        orderUID,
+		TrackNumber:     "TN1234567890",
+		Locale:          "en",
+		CustomerID:      "Cust123",
+		DeliveryService: "TestService",
+		DateCreated:     time.Now(),
+		Delivery: models.Delivery{
+			OrderUID: orderUID,
+			Name:     "John Doe",
+			Phone:    "+1234567890",
+			City:     "TestCity",
+			Address:  "123 Test St",
+		},
+		Payment: models.Payment{
+			OrderUID:    orderUID,
+			Transaction: "TX1234567890",
+			Currency:    "USD",
+			Provider:    "TestProvider",
+			Amount:      models.MustParseMoney("150.00"),
+			PaymentDT:   time.Now(),
+		},
+		Items: []models.Item{
+			{
+				ChrtID:      1,
+				OrderUID:    orderUID,
+				TrackNumber: "TN1234567890",
+				Price:       models.MustParseMoney("150.00"),
+				Name:        "Test Item 1",
+				NMID:        1001,
+				Brand:       "TestBrand",
+				Status:      1,
+			},
+		},
+	}
+}
+
+func (s *ServiceSuite) TestUpsertOrder() {
+	order := validOrder("testUID123")
+
 	s.mockCache.UpsertFunc = func(ctx context.Context, order models.Order) error {
 		return nil
 	}
@@ -162,12 +358,7 @@ func (s *ServiceSuite) TestGetOrder_CacheMiss() {
 }
 
 func (s *ServiceSuite) TestConcurrentUpsert() {
-	order := models.Order{
-		OrderUID:    "testUID123",
-		TrackNumber: "TN1234567890",
-		CustomerID:  "Cust123",
-		DateCreated: time.Now(),
-	}
+	order := validOrder("testUID123")
 
 	s.mockCache.UpsertFunc = func(ctx context.Context, order models.Order) error {
 		return nil
@@ -188,3 +379,513 @@ func (s *ServiceSuite) TestConcurrentUpsert() {
 	}
 	wg.Wait()
 }
+
+func (s *ServiceSuite) TestUpsertOrder_Invalid() {
+	order := validOrder("testUID123")
+	order.Payment.Amount = models.MustParseMoney("-1")
+	order.Delivery.OrderUID = "otherUID"
+
+	cacheCalled, storageCalled := false, false
+
+	s.mockCache.UpsertFunc = func(ctx context.Context, order models.Order) error {
+		cacheCalled = true
+		return nil
+	}
+
+	s.mockStorage.UpsertFunc = func(ctx context.Context, order *models.Order) (*models.Order, error) {
+		storageCalled = true
+		return order, nil
+	}
+
+	before := s.service.ValidationStats()
+
+	err := s.service.UpsertOrder(context.Background(), order)
+	s.Require().ErrorIs(err, models.ErrInvalidOrder)
+	s.Require().False(cacheCalled, "invalid order must not reach the cache")
+	s.Require().False(storageCalled, "invalid order must not reach the storage")
+
+	var validationErr *models.ValidationError
+	s.Require().ErrorAs(err, &validationErr)
+	s.Require().Len(validationErr.Violations, 2)
+
+	after := s.service.ValidationStats()
+	s.Require().Equal(before.RejectedOrders+1, after.RejectedOrders)
+	s.Require().Equal(before.Violations["payment.amount:min"]+1, after.Violations["payment.amount:min"])
+	s.Require().Equal(before.Violations["delivery.orderUid:mismatch"]+1, after.Violations["delivery.orderUid:mismatch"])
+}
+
+func (s *ServiceSuite) TestUpsertOrder_StaleVersion() {
+	order := validOrder("testUID123")
+	order.Version = 3
+
+	cacheCalled := false
+
+	s.mockStorage.UpsertFunc = func(ctx context.Context, order *models.Order) (*models.Order, error) {
+		return nil, models.ErrStaleVersion
+	}
+
+	s.mockCache.UpsertFunc = func(ctx context.Context, order models.Order) error {
+		cacheCalled = true
+		return nil
+	}
+
+	err := s.service.UpsertOrder(context.Background(), order)
+	s.Require().ErrorIs(err, models.ErrStaleVersion)
+	s.Require().False(cacheCalled, "stale order must not reach the cache")
+}
+
+func (s *ServiceSuite) TestUpsertOrder_UnversionedRefreshesCache() {
+	ctx := context.Background()
+	svc := service.New(s.log, ordercache.New(s.log), memory.New())
+
+	order := validOrder("testUID123")
+	order.Version = 5
+	s.Require().NoError(svc.UpsertOrder(ctx, order))
+
+	updated := validOrder("testUID123")
+	updated.TrackNumber = "TN0987654321"
+	s.Require().NoError(svc.UpsertOrder(ctx, updated))
+
+	got, err := svc.GetOrder(ctx, order.OrderUID)
+	s.Require().NoError(err)
+	s.Require().Equal("TN0987654321", got.TrackNumber, "an unversioned write should not leave the old order cached")
+	s.Require().Equal(uint64(5), got.Version)
+}
+
+func (s *ServiceSuite) TestUpsertOrders() {
+	invalid := validOrder("invalidUID")
+	invalid.Items = nil
+
+	orders := []models.Order{validOrder("okUID"), invalid, validOrder("failUID")}
+	storageErr := errors.New("constraint violation")
+
+	var batched []string
+
+	s.mockStorage.BatchFunc = func(ctx context.Context, orders []*models.Order) ([]error, error) {
+		results := make([]error, len(orders))
+		for i, order := range orders {
+			batched = append(batched, order.OrderUID)
+			if order.OrderUID == "failUID" {
+				results[i] = storageErr
+			}
+		}
+		return results, nil
+	}
+
+	var cached []string
+
+	s.mockCache.UpsertFunc = func(ctx context.Context, order models.Order) error {
+		cached = append(cached, order.OrderUID)
+		return nil
+	}
+
+	results := s.service.UpsertOrders(context.Background(), orders)
+	s.Require().Len(results, 3)
+	s.Require().NoError(results[0])
+	s.Require().ErrorIs(results[1], models.ErrInvalidOrder)
+	s.Require().ErrorIs(results[2], storageErr)
+
+	s.Require().Equal([]string{"okUID", "failUID"}, batched, "only valid orders reach the storage")
+	s.Require().Equal([]string{"okUID"}, cached, "only stored orders reach the cache")
+}
+
+func (s *ServiceSuite) TestDeleteOrder() {
+	var evicted []string
+
+	s.mockCache.DeleteFunc = func(ctx context.Context, orderUID string) {
+		evicted = append(evicted, orderUID)
+	}
+
+	s.mockStorage.DeleteFunc = func(ctx context.Context, tombstone models.Tombstone) error {
+		switch tombstone.OrderUID {
+		case "missingUID":
+			return models.ErrOrderNotFound
+		case "staleUID":
+			return models.ErrStaleVersion
+		}
+		return nil
+	}
+
+	s.Require().NoError(s.service.DeleteOrder(context.Background(), models.Tombstone{OrderUID: "testUID123"}))
+
+	err := s.service.DeleteOrder(context.Background(), models.Tombstone{OrderUID: "missingUID"})
+	s.Require().ErrorIs(err, models.ErrOrderNotFound)
+
+	err = s.service.DeleteOrder(context.Background(), models.Tombstone{OrderUID: "staleUID", Version: 1})
+	s.Require().ErrorIs(err, models.ErrStaleVersion)
+
+	s.Require().Equal([]string{"testUID123", "missingUID"}, evicted, "stale tombstones must not evict the cache")
+}
+
+func (s *ServiceSuite) TestOrderAsOf() {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	s.mockStorage.RevisionAtFunc = func(ctx context.Context, orderUID string, _ time.Time) (*models.Revision, error) {
+		switch orderUID {
+		case "deletedUID":
+			return &models.Revision{OrderUID: orderUID, Revision: 3, ChangeType: models.ChangeTypeDeleted}, nil
+		case "testUID123":
+			return &models.Revision{OrderUID: orderUID, Revision: 1, Order: &models.Order{OrderUID: orderUID}}, nil
+		}
+		return nil, models.ErrRevisionNotFound
+	}
+
+	order, err := s.service.OrderAsOf(context.Background(), "testUID123", at)
+	s.Require().NoError(err)
+	s.Require().Equal("testUID123", order.OrderUID)
+
+	_, err = s.service.OrderAsOf(context.Background(), "deletedUID", at)
+	s.Require().ErrorIs(err, models.ErrOrderNotFound)
+
+	_, err = s.service.OrderAsOf(context.Background(), "futureUID", at)
+	s.Require().ErrorIs(err, models.ErrOrderNotFound)
+}
+
+func (s *ServiceSuite) TestDiffOrderRevisions() {
+	s.mockStorage.RevisionFunc = func(ctx context.Context, orderUID string, revision int) (*models.Revision, error) {
+		order := &models.Order{OrderUID: orderUID, TrackNumber: "TN1", Version: uint64(revision)}
+		if revision == 2 {
+			order.TrackNumber = "TN2"
+		}
+		return &models.Revision{OrderUID: orderUID, Revision: revision, Order: order}, nil
+	}
+
+	diff, err := s.service.DiffOrderRevisions(context.Background(), "testUID123", 1, 2)
+	s.Require().NoError(err)
+	s.Require().Equal(1, diff.From)
+	s.Require().Equal(2, diff.To)
+
+	paths := make([]string, 0, len(diff.Changes))
+	for _, change := range diff.Changes {
+		paths = append(paths, change.Path)
+	}
+	s.Require().Equal([]string{"trackNumber", "version"}, paths)
+}
+
+// newCacheSyncService returns a service with mocks of its own, so that
+// enabling cache sync doesn't leak into the other tests.
+func (s *ServiceSuite) newCacheSyncService() (*service.Service, *MockCache, *MockStorage) {
+	cache, storage := &MockCache{}, &MockStorage{}
+
+	return service.New(s.log, cache, storage), cache, storage
+}
+
+func (s *ServiceSuite) TestCacheSync_Broadcast() {
+	svc, _, storage := s.newCacheSyncService()
+
+	var published []models.CacheInvalidation
+
+	instance := svc.EnableCacheSync(func(_ context.Context, inv models.CacheInvalidation) error {
+		published = append(published, inv)
+
+		return nil
+	})
+
+	storage.UpsertFunc = func(ctx context.Context, order *models.Order) (*models.Order, error) {
+		stored := *order
+		stored.Version = 3
+
+		return &stored, nil
+	}
+
+	s.Require().NoError(svc.UpsertOrder(context.Background(), validOrder("testUID1")))
+	s.Require().NoError(svc.DeleteOrder(context.Background(), models.Tombstone{OrderUID: "testUID1", Version: 4}))
+
+	s.Require().Equal([]models.CacheInvalidation{
+		{Instance: instance, Seq: 1, OrderUID: "testUID1", Version: 3},
+		{Instance: instance, Seq: 2, OrderUID: "testUID1", Version: 4, Deleted: true},
+	}, published)
+}
+
+func (s *ServiceSuite) TestCacheSync_ConcurrentBroadcastsInOrder() {
+	svc, _, _ := s.newCacheSyncService()
+
+	var (
+		mu   sync.Mutex
+		seqs []uint64
+	)
+
+	svc.EnableCacheSync(func(_ context.Context, inv models.CacheInvalidation) error {
+		// Uneven publish times reorder sends that aren't serialized.
+		time.Sleep(time.Duration(inv.Seq%3) * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		seqs = append(seqs, inv.Seq)
+
+		return nil
+	})
+
+	const senders = 50
+
+	var wg sync.WaitGroup
+	for i := range senders {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			tombstone := models.Tombstone{OrderUID: fmt.Sprintf("testUID%d", i)}
+			s.NoError(svc.DeleteOrder(context.Background(), tombstone))
+		}()
+	}
+	wg.Wait()
+
+	s.Require().Len(seqs, senders)
+
+	for i, seq := range seqs {
+		s.Require().Equal(uint64(i+1), seq, "invalidations must be published in sequence order")
+	}
+}
+
+func (s *ServiceSuite) TestCacheSync_Apply() {
+	svc, cache, storage := s.newCacheSyncService()
+
+	var (
+		upserted []models.Order
+		deleted  []string
+		stored   = map[string]uint64{"testUID1": 5, "testUID2": 4}
+	)
+
+	instance := svc.EnableCacheSync(func(context.Context, models.CacheInvalidation) error { return nil })
+
+	cache.UpsertFunc = func(_ context.Context, order models.Order) error {
+		upserted = append(upserted, order)
+
+		return nil
+	}
+	cache.DeleteFunc = func(_ context.Context, orderUID string) {
+		deleted = append(deleted, orderUID)
+	}
+	cache.ClearFunc = func(context.Context) {
+		s.Fail("no sequence gap, no resync")
+	}
+	storage.GetFunc = func(_ context.Context, orderUID string) (*models.Order, error) {
+		return &models.Order{OrderUID: orderUID, Version: stored[orderUID]}, nil
+	}
+
+	ctx := context.Background()
+
+	svc.ApplyInvalidation(ctx, models.CacheInvalidation{Instance: instance, Seq: 1, OrderUID: "own"})
+	s.Require().Empty(upserted, "own invalidations are ignored")
+
+	svc.ApplyInvalidation(ctx, models.CacheInvalidation{Instance: "peer", Seq: 7, OrderUID: "testUID1", Version: 5})
+	s.Require().Len(upserted, 1, "the first invalidation of a peer is applied")
+	s.Require().Equal(uint64(5), upserted[0].Version)
+	s.Require().Equal([]string{"testUID1"}, deleted, "the cached copy is replaced")
+
+	svc.ApplyInvalidation(ctx, models.CacheInvalidation{Instance: "peer", Seq: 8, OrderUID: "testUID1", Deleted: true})
+	svc.ApplyInvalidation(ctx, models.CacheInvalidation{Instance: "peer", Seq: 8, OrderUID: "testUID1", Deleted: true})
+	s.Require().Equal([]string{"testUID1", "testUID1"}, deleted, "duplicates are ignored")
+
+	svc.ApplyInvalidation(ctx, models.CacheInvalidation{Instance: "peer", Seq: 9, OrderUID: "testUID2", Version: 6})
+	s.Require().Len(upserted, 1)
+	s.Require().Equal([]string{"testUID1", "testUID1", "testUID2"}, deleted, "orders storage lags behind on are evicted")
+
+	svc.ApplyInvalidation(ctx, models.CacheInvalidation{Instance: "peer", Seq: 9})
+	s.Require().Len(deleted, 3, "heartbeats don't touch the cache")
+}
+
+func (s *ServiceSuite) TestCacheSync_ApplyRewriteAtSameVersion() {
+	ctx := context.Background()
+	storage := memory.New()
+	svc := service.New(s.log, ordercache.New(s.log), storage)
+	svc.EnableCacheSync(func(context.Context, models.CacheInvalidation) error { return nil })
+
+	order := validOrder("testUID1")
+	order.Version = 5
+	s.Require().NoError(svc.UpsertOrder(ctx, order))
+
+	// Another instance rewrites the order without a new version, as
+	// renormalize does.
+	rewritten := validOrder("testUID1")
+	rewritten.TrackNumber = "TN0987654321"
+	_, err := storage.Upsert(ctx, &rewritten)
+	s.Require().NoError(err)
+
+	svc.ApplyInvalidation(ctx, models.CacheInvalidation{Instance: "peer", Seq: 1, OrderUID: "testUID1", Version: 5})
+
+	got, err := svc.GetOrder(ctx, "testUID1")
+	s.Require().NoError(err)
+	s.Require().Equal("TN0987654321", got.TrackNumber)
+	s.Require().Equal(uint64(5), got.Version)
+}
+
+func (s *ServiceSuite) TestCacheSync_GapResyncs() {
+	for name, missed := range map[string]models.CacheInvalidation{
+		"invalidation": {Instance: "peer", Seq: 3, OrderUID: "testUID2", Deleted: true},
+		"heartbeat":    {Instance: "peer", Seq: 2},
+	} {
+		s.Run(name, func() {
+			svc, cache, storage := s.newCacheSyncService()
+
+			var cleared, streamed atomic.Bool
+
+			cache.ClearFunc = func(context.Context) { cleared.Store(true) }
+			storage.StreamFunc = func(context.Context, models.OrderStream, func([]models.Order) error) error {
+				streamed.Store(true)
+
+				return nil
+			}
+
+			svc.EnableCacheSync(func(context.Context, models.CacheInvalidation) error { return nil })
+
+			ctx := context.Background()
+
+			svc.ApplyInvalidation(ctx, models.CacheInvalidation{Instance: "peer", Seq: 1, OrderUID: "testUID1", Deleted: true})
+			s.Require().False(cleared.Load())
+
+			svc.ApplyInvalidation(ctx, missed)
+			s.Require().Eventually(func() bool { return cleared.Load() && streamed.Load() }, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func (s *ServiceSuite) TestCacheSync_ResyncEvictsOrdersChangedMeanwhile() {
+	svc, cache, storage := s.newCacheSyncService()
+
+	var (
+		mu     sync.Mutex
+		cached = make(map[string]models.Order)
+		done   atomic.Bool
+	)
+
+	cache.UpsertFunc = func(_ context.Context, order models.Order) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cached[order.OrderUID] = order
+
+		return nil
+	}
+	cache.DeleteFunc = func(_ context.Context, orderUID string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		delete(cached, orderUID)
+	}
+	cache.ClearFunc = func(context.Context) {}
+
+	deleted := make(chan struct{})
+
+	// The scan reads testUID2 before the peer deletes it and caches it after.
+	storage.StreamFunc = func(_ context.Context, _ models.OrderStream, fn func([]models.Order) error) error {
+		<-deleted
+
+		defer done.Store(true)
+
+		return fn([]models.Order{validOrder("testUID1"), validOrder("testUID2")})
+	}
+
+	svc.EnableCacheSync(func(context.Context, models.CacheInvalidation) error { return nil })
+
+	ctx := context.Background()
+
+	svc.ApplyInvalidation(ctx, models.CacheInvalidation{Instance: "peer", Seq: 1, OrderUID: "testUID3", Deleted: true})
+	svc.ApplyInvalidation(ctx, models.CacheInvalidation{Instance: "peer", Seq: 3, OrderUID: "testUID2", Deleted: true})
+	close(deleted)
+
+	s.Require().Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		_, stale := cached["testUID2"]
+
+		return done.Load() && !stale && len(cached) == 1
+	}, time.Second, 10*time.Millisecond, "the order deleted during the resync must not stay cached")
+}
+
+func (s *ServiceSuite) TestRenormalizeOrders() {
+	cache, storage := &MockCache{}, &MockStorage{}
+	svc := service.New(s.log, cache, storage)
+
+	stored := []models.Order{
+		validOrder("changed"), validOrder("same"), validOrder("noRaw"), validOrder("broken"), validOrder("raced"),
+	}
+	stored[0].Version = 3
+	storage.StreamFunc = func(_ context.Context, _ models.OrderStream, fn func([]models.Order) error) error {
+		return fn(stored)
+	}
+
+	storage.RawFunc = func(_ context.Context, orderUID string) (*models.RawPayload, error) {
+		if orderUID == "noRaw" {
+			return nil, models.ErrRawNotFound
+		}
+
+		return &models.RawPayload{ContentType: "application/json", Payload: []byte(`"` + orderUID + `"`)}, nil
+	}
+
+	var upserted []models.Order
+
+	storage.UpsertFunc = func(_ context.Context, order *models.Order) (*models.Order, error) {
+		if order.OrderUID == "raced" {
+			return nil, models.ErrPreconditionFailed
+		}
+
+		upserted = append(upserted, *order)
+
+		returned := *order
+		returned.Version = stored[0].Version
+
+		return &returned, nil
+	}
+
+	var cached []models.Order
+
+	cache.UpsertFunc = func(_ context.Context, order models.Order) error {
+		cached = append(cached, order)
+
+		return nil
+	}
+
+	var announced []models.CacheInvalidation
+
+	svc.EnableCacheSync(func(_ context.Context, inv models.CacheInvalidation) error {
+		announced = append(announced, inv)
+
+		return nil
+	})
+
+	decode := func(raw *models.RawPayload) (models.Order, error) {
+		for _, order := range stored {
+			if `"`+order.OrderUID+`"` != string(raw.Payload) || order.OrderUID == "broken" {
+				continue
+			}
+
+			if order.OrderUID == "changed" || order.OrderUID == "raced" {
+				order.TrackNumber = "TN-renormalized"
+			}
+
+			return order, nil
+		}
+
+		return models.Order{}, errors.New("cannot decode")
+	}
+
+	stats, err := svc.RenormalizeOrders(context.Background(), decode)
+	s.Require().NoError(err)
+	s.Require().Equal(service.RenormalizeStats{
+		Orders: 5, Renormalized: 1, Unchanged: 1, Skipped: 1, WithoutRaw: 1, Failed: 1,
+	}, stats)
+
+	s.Require().Len(upserted, 1)
+	s.Require().Equal("TN-renormalized", upserted[0].TrackNumber)
+	s.Require().Zero(upserted[0].Version, "Renormalized orders keep the stored version")
+	s.Require().Equal(stored[0].ETag(), upserted[0].IfMatch, "The rewrite is conditional on the order that was read")
+	s.Require().Equal(`"changed"`, string(upserted[0].Raw.Payload), "The raw payload is kept")
+
+	s.Require().Len(cached, 1)
+	s.Require().Equal("TN-renormalized", cached[0].TrackNumber)
+	s.Require().Equal(uint64(3), cached[0].Version)
+
+	s.Require().Len(announced, 1)
+	s.Require().Equal("changed", announced[0].OrderUID)
+}
+
+func (s *ServiceSuite) TestSearchOrders_Unsupported() {
+	svc := service.New(s.log, &MockCache{}, &MockStorage{})
+
+	_, err := svc.SearchOrders(context.Background(), models.SearchQuery{Text: "moscow"})
+	s.Require().ErrorIs(err, models.ErrSearchUnsupported)
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+var indexPattern = regexp.MustCompile(`\[\d+\]`)
+
+type ValidationStats struct {
+	RejectedOrders uint64            `json:"rejectedOrders"`
+	Violations     map[string]uint64 `json:"violations"`
+}
+
+type validationCounter struct {
+	mu         sync.Mutex
+	rejected   uint64
+	violations map[string]uint64
+}
+
+func (c *validationCounter) record(violations []models.Violation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.violations == nil {
+		c.violations = make(map[string]uint64)
+	}
+
+	c.rejected++
+
+	for _, v := range violations {
+		c.violations[indexPattern.ReplaceAllString(v.Path, "[]")+":"+v.Rule]++
+	}
+}
+
+func (c *validationCounter) snapshot() ValidationStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := ValidationStats{
+		RejectedOrders: c.rejected,
+		Violations:     make(map[string]uint64, len(c.violations)),
+	}
+
+	for key, count := range c.violations {
+		stats.Violations[key] = count
+	}
+
+	return stats
+}
+
+func (s *Service) validate(order *models.Order) error {
+	err := order.Validate()
+	if err == nil {
+		return nil
+	}
+
+	var validationErr *models.ValidationError
+	if errors.As(err, &validationErr) {
+		s.validation.record(validationErr.Violations)
+		s.log.WithField("orderUid", order.OrderUID).
+			WithField("violations", validationErr.Violations).
+			Warn("Order rejected by validation")
+	}
+
+	return err //nolint:wrapcheck
+}
+
+func (s *Service) ValidationStats() ValidationStats {
+	return s.validation.snapshot()
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+const defaultWarmupProgressInterval = 5 * time.Second
+
+// errWarmupLimit stops the storage scan once MaxOrders orders are cached.
+var errWarmupLimit = errors.New("warm-up limit reached")
+
+// WarmupOptions controls how Init fills the cache.
+type WarmupOptions struct {
+	ChunkSize        int           // orders read per query
+	Workers          int           // concurrent readers, each scanning its own part of the orders
+	MaxOrders        int           // 0 preloads every order
+	MaxAge           time.Duration // only orders created within MaxAge; 0 preloads regardless of age
+	ProgressInterval time.Duration
+}
+
+func (s *Service) SetWarmupOptions(opts WarmupOptions) {
+	s.warmup = opts
+}
+
+// Init streams orders from storage into the cache chunk by chunk, so the
+// whole table is never held in memory. Orders are read in order_uid order;
+// when MaxOrders caps the warm-up, which orders get preloaded is arbitrary,
+// so combine it with MaxAge to prefer recent ones.
+func (s *Service) Init(ctx context.Context) error {
+	opts := s.warmup
+
+	workers := max(opts.Workers, 1)
+
+	progressInterval := opts.ProgressInterval
+	if progressInterval <= 0 {
+		progressInterval = defaultWarmupProgressInterval
+	}
+
+	var createdFrom time.Time
+	if opts.MaxAge > 0 {
+		createdFrom = time.Now().Add(-opts.MaxAge)
+	}
+
+	var (
+		loaded atomic.Int64
+		wg     sync.WaitGroup
+		errs   = make([]error, workers)
+		start  = time.Now()
+	)
+
+	stopProgress := s.logWarmupProgress(&loaded, progressInterval)
+
+	for i := range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			stream := models.OrderStream{
+				ChunkSize:   opts.ChunkSize,
+				CreatedFrom: createdFrom,
+				Partition:   i,
+				Partitions:  workers,
+			}
+
+			errs[i] = s.storage.StreamOrders(ctx, stream, func(orders []models.Order) error {
+				return s.cacheChunk(ctx, orders, &loaded, opts.MaxOrders)
+			})
+		}()
+	}
+
+	wg.Wait()
+	stopProgress()
+
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, errWarmupLimit) {
+			return fmt.Errorf("service.go Init(...) s.storage.StreamOrders(...): %w", err)
+		}
+	}
+
+	s.log.Infof("Initialized cache with %d orders in %s", loaded.Load(), time.Since(start).Round(time.Millisecond))
+
+	return nil
+}
+
+// cacheChunk upserts a chunk into the cache, trimmed to what is left of
+// maxOrders, and returns errWarmupLimit once the cap is reached.
+func (s *Service) cacheChunk(ctx context.Context, orders []models.Order, loaded *atomic.Int64, maxOrders int) error {
+	n := reserve(loaded, int64(len(orders)), int64(maxOrders))
+
+	for _, order := range orders[:n] {
+		if err := s.cache.Upsert(ctx, order); err != nil {
+			s.log.WithError(err).Errorf("service.go Init(...) Upsert(%s)", order.OrderUID)
+		}
+	}
+
+	if maxOrders > 0 && loaded.Load() >= int64(maxOrders) {
+		return errWarmupLimit
+	}
+
+	return nil
+}
+
+// reserve adds up to n to counter without exceeding limit (0 is no limit)
+// and returns how much was added.
+func reserve(counter *atomic.Int64, n, limit int64) int64 {
+	if limit <= 0 {
+		counter.Add(n)
+
+		return n
+	}
+
+	for {
+		current := counter.Load()
+		granted := min(n, max(limit-current, 0))
+
+		if counter.CompareAndSwap(current, current+granted) {
+			return granted
+		}
+	}
+}
+
+func (s *Service) logWarmupProgress(loaded *atomic.Int64, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.log.Infof("Cache warm-up: %d orders loaded", loaded.Load())
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,53 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/stsolovey/order_tracker/internal/config"
+	"github.com/stsolovey/order_tracker/internal/logger"
+	"github.com/stsolovey/order_tracker/internal/repository"
+	"github.com/stsolovey/order_tracker/internal/repository/repositorytest"
+	"github.com/stsolovey/order_tracker/internal/storage"
+)
+
+func TestConformance(t *testing.T) {
+	cfg := config.New("../../.env")
+	log := logger.New(cfg.LogLevel)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stor, err := storage.NewStorage(ctx, log, cfg.DatabaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suite.Run(t, &repositorytest.Suite{
+		NewRepository: func(t *testing.T) repository.Repository {
+			t.Helper()
+
+			if err := truncate(stor); err != nil {
+				t.Fatal(err)
+			}
+
+			return stor
+		},
+	})
+}
+
+func truncate(stor *storage.Storage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := stor.DB().Exec(ctx,
+		"TRUNCATE TABLE order_revisions, order_outbox, items, payment, delivery, orders RESTART IDENTITY CASCADE")
+	if err != nil {
+		return fmt.Errorf("failed to truncate tables: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+type ErrorClass int
+
+const (
+	// ErrorUnknown is an error that is neither known to be transient nor
+	// permanent. Callers should treat it as transient and bound the retries.
+	ErrorUnknown ErrorClass = iota
+	// ErrorTransient may succeed when retried: the database was unreachable,
+	// the operation timed out or lost a concurrency conflict.
+	ErrorTransient
+	// ErrorPermanent fails the same way on every retry: invalid input or a
+	// violated constraint.
+	ErrorPermanent
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorTransient:
+		return "transient"
+	case ErrorPermanent:
+		return "permanent"
+	default:
+		return "unknown"
+	}
+}
+
+// Postgres error classes and codes, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html.
+const (
+	pgClassConnectionException  = "08"
+	pgClassDataException        = "22"
+	pgClassIntegrityViolation   = "23"
+	pgClassInsufficientResource = "53"
+	pgClassOperatorIntervention = "57"
+
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+// Classify tells whether an error returned by Storage, or by a caller that
+// wraps it, is worth retrying.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ErrorUnknown
+	}
+
+	if errors.Is(err, models.ErrInvalidOrder) {
+		return ErrorPermanent
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return classifyPgError(pgErr)
+	}
+
+	var (
+		connectErr *pgconn.ConnectError
+		netErr     net.Error
+		retryable  interface{ SafeToRetry() bool }
+	)
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), pgconn.Timeout(err):
+		return ErrorTransient
+	case errors.As(err, &connectErr), errors.As(err, &netErr):
+		return ErrorTransient
+	case errors.As(err, &retryable) && retryable.SafeToRetry():
+		return ErrorTransient
+	}
+
+	return ErrorUnknown
+}
+
+func classifyPgError(pgErr *pgconn.PgError) ErrorClass {
+	switch pgErr.Code {
+	case pgSerializationFailure, pgDeadlockDetected:
+		return ErrorTransient
+	}
+
+	switch {
+	case strings.HasPrefix(pgErr.Code, pgClassIntegrityViolation),
+		strings.HasPrefix(pgErr.Code, pgClassDataException):
+		return ErrorPermanent
+	case strings.HasPrefix(pgErr.Code, pgClassConnectionException),
+		strings.HasPrefix(pgErr.Code, pgClassInsufficientResource),
+		strings.HasPrefix(pgErr.Code, pgClassOperatorIntervention):
+		return ErrorTransient
+	}
+
+	return ErrorUnknown
+}
@@ -0,0 +1,44 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/storage"
+)
+
+func TestClassify(t *testing.T) {
+	wrap := func(err error) error {
+		return fmt.Errorf("storage.go Upsert order: %w", err)
+	}
+
+	cases := []struct {
+		name string
+		err  error
+		want storage.ErrorClass
+	}{
+		{"validation", wrap(&models.ValidationError{}), storage.ErrorPermanent},
+		{"unique violation", wrap(&pgconn.PgError{Code: "23505"}), storage.ErrorPermanent},
+		{"invalid text", wrap(&pgconn.PgError{Code: "22P02"}), storage.ErrorPermanent},
+		{"serialization failure", wrap(&pgconn.PgError{Code: "40001"}), storage.ErrorTransient},
+		{"deadlock", wrap(&pgconn.PgError{Code: "40P01"}), storage.ErrorTransient},
+		{"admin shutdown", wrap(&pgconn.PgError{Code: "57P01"}), storage.ErrorTransient},
+		{"too many connections", wrap(&pgconn.PgError{Code: "53300"}), storage.ErrorTransient},
+		{"deadline", wrap(context.DeadlineExceeded), storage.ErrorTransient},
+		{"network", wrap(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), storage.ErrorTransient},
+		{"syntax", wrap(&pgconn.PgError{Code: "42601"}), storage.ErrorUnknown},
+		{"other", errors.New("boom"), storage.ErrorUnknown},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, storage.Classify(tc.err))
+		})
+	}
+}
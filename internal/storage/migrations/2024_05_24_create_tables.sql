@@ -65,7 +65,7 @@ CREATE INDEX idx_payment_transaction ON payment(transaction);
 
 -- +migrate Down
 
-DROP TABLE IF EXISTS orders;
-DROP TABLE IF EXISTS delivery;
-DROP TABLE IF EXISTS payment;
 DROP TABLE IF EXISTS items;
+DROP TABLE IF EXISTS payment;
+DROP TABLE IF EXISTS delivery;
+DROP TABLE IF EXISTS orders;
@@ -0,0 +1,8 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+ALTER TABLE orders ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
+
+-- +migrate Down
+
+ALTER TABLE orders DROP COLUMN IF EXISTS version;
@@ -0,0 +1,21 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+CREATE TABLE order_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    order_uid TEXT NOT NULL,
+    version BIGINT NOT NULL,
+    change_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    sent_at TIMESTAMP,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_order_outbox_pending ON order_outbox(id) WHERE sent_at IS NULL;
+
+-- +migrate Down
+
+DROP TABLE IF EXISTS order_outbox;
@@ -0,0 +1,8 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+ALTER TABLE orders ADD COLUMN deleted_at TIMESTAMP;
+
+-- +migrate Down
+
+ALTER TABLE orders DROP COLUMN IF EXISTS deleted_at;
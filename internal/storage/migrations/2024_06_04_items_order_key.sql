@@ -0,0 +1,18 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+-- Items used to be keyed by chrt_id alone, so every stored chrt_id has exactly
+-- one row and the existing rows fit the new key as they are. Items that were
+-- taken over by another order sharing their chrt_id are lost; replaying the
+-- stream restores them.
+ALTER TABLE items DROP CONSTRAINT items_pkey;
+ALTER TABLE items ADD CONSTRAINT items_pkey PRIMARY KEY (order_uid, chrt_id);
+
+-- +migrate Down
+
+-- The old key allows a single row per chrt_id: keep one and drop the others.
+DELETE FROM items a USING items b
+WHERE a.chrt_id = b.chrt_id AND a.ctid < b.ctid;
+
+ALTER TABLE items DROP CONSTRAINT items_pkey;
+ALTER TABLE items ADD CONSTRAINT items_pkey PRIMARY KEY (chrt_id);
@@ -0,0 +1,22 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+CREATE TABLE order_revisions (
+    order_uid TEXT NOT NULL,
+    revision INTEGER NOT NULL,
+    version BIGINT NOT NULL,
+    change_type TEXT NOT NULL,
+    changed_sections TEXT[] NOT NULL DEFAULT '{}',
+    snapshot JSONB,
+    source_subject TEXT NOT NULL DEFAULT '',
+    source_sequence BIGINT NOT NULL DEFAULT 0,
+    received_at TIMESTAMPTZ NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (order_uid, revision)
+);
+
+CREATE INDEX idx_order_revisions_recorded_at ON order_revisions(order_uid, recorded_at);
+
+-- +migrate Down
+
+DROP TABLE IF EXISTS order_revisions;
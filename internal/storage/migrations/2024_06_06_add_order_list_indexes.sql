@@ -0,0 +1,20 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+CREATE INDEX idx_orders_date_created ON orders(date_created, order_uid) WHERE deleted_at IS NULL;
+CREATE INDEX idx_orders_customer_id ON orders(customer_id, date_created, order_uid) WHERE deleted_at IS NULL;
+CREATE INDEX idx_orders_track_number ON orders(track_number) WHERE deleted_at IS NULL;
+CREATE INDEX idx_orders_delivery_service ON orders(delivery_service, date_created, order_uid) WHERE deleted_at IS NULL;
+CREATE INDEX idx_payment_provider ON payment(provider);
+CREATE INDEX idx_payment_currency ON payment(currency);
+CREATE INDEX idx_items_brand ON items(brand, order_uid);
+
+-- +migrate Down
+
+DROP INDEX IF EXISTS idx_items_brand;
+DROP INDEX IF EXISTS idx_payment_currency;
+DROP INDEX IF EXISTS idx_payment_provider;
+DROP INDEX IF EXISTS idx_orders_delivery_service;
+DROP INDEX IF EXISTS idx_orders_track_number;
+DROP INDEX IF EXISTS idx_orders_customer_id;
+DROP INDEX IF EXISTS idx_orders_date_created;
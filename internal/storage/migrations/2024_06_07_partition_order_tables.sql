@@ -0,0 +1,323 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+-- orders, delivery, payment and items are range-partitioned by the month of
+-- the order's date_created, so that a month can be archived and dropped as a
+-- whole. Every table carries date_created and is keyed by it; order_uid alone
+-- can no longer be unique, so the service keeps it unique by upserting each
+-- order under an advisory lock. The foreign keys from the child tables are
+-- dropped as well: partitions of a month are detached and dropped together,
+-- and the service writes and deletes all rows of an order in one transaction.
+
+ALTER TABLE orders RENAME TO orders_unpartitioned;
+ALTER TABLE delivery RENAME TO delivery_unpartitioned;
+ALTER TABLE payment RENAME TO payment_unpartitioned;
+ALTER TABLE items RENAME TO items_unpartitioned;
+
+ALTER INDEX orders_pkey RENAME TO orders_unpartitioned_pkey;
+ALTER INDEX delivery_pkey RENAME TO delivery_unpartitioned_pkey;
+ALTER INDEX payment_pkey RENAME TO payment_unpartitioned_pkey;
+ALTER INDEX items_pkey RENAME TO items_unpartitioned_pkey;
+
+-- Keep the id sequences, so ids continue where they were.
+ALTER TABLE delivery_unpartitioned ALTER COLUMN delivery_id DROP DEFAULT;
+ALTER SEQUENCE delivery_delivery_id_seq OWNED BY NONE;
+ALTER TABLE payment_unpartitioned ALTER COLUMN payment_id DROP DEFAULT;
+ALTER SEQUENCE payment_payment_id_seq OWNED BY NONE;
+
+CREATE TABLE orders (
+    order_uid TEXT NOT NULL,
+    track_number TEXT NOT NULL,
+    entry TEXT,
+    locale TEXT NOT NULL,
+    internal_signature TEXT,
+    customer_id TEXT NOT NULL,
+    delivery_service TEXT NOT NULL,
+    shardkey TEXT,
+    sm_id INTEGER,
+    date_created TIMESTAMP NOT NULL,
+    oof_shard TEXT,
+    version BIGINT NOT NULL DEFAULT 0,
+    deleted_at TIMESTAMP,
+    PRIMARY KEY (order_uid, date_created)
+) PARTITION BY RANGE (date_created);
+
+CREATE TABLE delivery (
+    delivery_id INTEGER NOT NULL DEFAULT nextval('delivery_delivery_id_seq'),
+    order_uid TEXT NOT NULL,
+    date_created TIMESTAMP NOT NULL,
+    name TEXT NOT NULL,
+    phone TEXT NOT NULL,
+    zip TEXT,
+    city TEXT NOT NULL,
+    address TEXT NOT NULL,
+    region TEXT,
+    email TEXT,
+    PRIMARY KEY (delivery_id, date_created),
+    UNIQUE (order_uid, date_created)
+) PARTITION BY RANGE (date_created);
+
+CREATE TABLE payment (
+    payment_id INTEGER NOT NULL DEFAULT nextval('payment_payment_id_seq'),
+    order_uid TEXT NOT NULL,
+    date_created TIMESTAMP NOT NULL,
+    transaction TEXT NOT NULL,
+    request_id TEXT,
+    currency TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    amount DECIMAL NOT NULL,
+    payment_dt TIMESTAMP NOT NULL,
+    bank TEXT,
+    delivery_cost DECIMAL,
+    goods_total DECIMAL NOT NULL,
+    custom_fee DECIMAL NOT NULL,
+    PRIMARY KEY (payment_id, date_created),
+    UNIQUE (order_uid, date_created)
+) PARTITION BY RANGE (date_created);
+
+CREATE TABLE items (
+    order_uid TEXT NOT NULL,
+    chrt_id BIGINT NOT NULL,
+    date_created TIMESTAMP NOT NULL,
+    track_number TEXT NOT NULL,
+    price DECIMAL NOT NULL,
+    rid TEXT,
+    name TEXT NOT NULL,
+    sale INTEGER,
+    size TEXT,
+    total_price DECIMAL NOT NULL,
+    nm_id INTEGER NOT NULL,
+    brand TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    PRIMARY KEY (order_uid, chrt_id, date_created)
+) PARTITION BY RANGE (date_created);
+
+ALTER SEQUENCE delivery_delivery_id_seq OWNED BY delivery.delivery_id;
+ALTER SEQUENCE payment_payment_id_seq OWNED BY payment.payment_id;
+
+-- Orders outside of every monthly partition land in the default partitions.
+CREATE TABLE orders_default PARTITION OF orders DEFAULT;
+CREATE TABLE delivery_default PARTITION OF delivery DEFAULT;
+CREATE TABLE payment_default PARTITION OF payment DEFAULT;
+CREATE TABLE items_default PARTITION OF items DEFAULT;
+
+-- create_order_partition creates the partitions of the month containing the
+-- given date, named like orders_y2024m06, unless they exist. Rows of that
+-- month that are in the default partitions are moved into them.
+-- +migrate StatementBegin
+CREATE FUNCTION create_order_partition(in_month DATE) RETURNS VOID AS $$
+DECLARE
+    parent TEXT;
+    child TEXT;
+    lower_bound DATE := date_trunc('month', in_month);
+    upper_bound DATE := date_trunc('month', in_month) + INTERVAL '1 month';
+BEGIN
+    FOREACH parent IN ARRAY ARRAY['orders', 'delivery', 'payment', 'items'] LOOP
+        child := parent || '_' || to_char(lower_bound, '"y"YYYY"m"MM');
+
+        IF to_regclass(child) IS NOT NULL THEN
+            CONTINUE;
+        END IF;
+
+        EXECUTE format('CREATE TABLE %I (LIKE %I INCLUDING DEFAULTS)', child, parent);
+        EXECUTE format(
+            'WITH moved AS (DELETE FROM %I WHERE date_created >= $1 AND date_created < $2 RETURNING *) '
+            'INSERT INTO %I SELECT * FROM moved',
+            parent || '_default', child
+        ) USING lower_bound, upper_bound;
+        EXECUTE format(
+            'ALTER TABLE %I ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+            parent, child, lower_bound, upper_bound
+        );
+    END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+SELECT create_order_partition(month_start::DATE)
+FROM (
+    SELECT DISTINCT date_trunc('month', date_created) AS month_start FROM orders_unpartitioned
+    UNION
+    SELECT generate_series(
+        date_trunc('month', now()), date_trunc('month', now()) + INTERVAL '3 months', INTERVAL '1 month'
+    )
+) months;
+
+INSERT INTO orders (
+    order_uid, track_number, entry, locale, internal_signature, customer_id,
+    delivery_service, shardkey, sm_id, date_created, oof_shard, version, deleted_at
+)
+SELECT
+    order_uid, track_number, entry, locale, internal_signature, customer_id,
+    delivery_service, shardkey, sm_id, date_created, oof_shard, version, deleted_at
+FROM orders_unpartitioned;
+
+INSERT INTO delivery (delivery_id, order_uid, date_created, name, phone, zip, city, address, region, email)
+SELECT d.delivery_id, d.order_uid, o.date_created, d.name, d.phone, d.zip, d.city, d.address, d.region, d.email
+FROM delivery_unpartitioned d
+JOIN orders_unpartitioned o ON o.order_uid = d.order_uid;
+
+INSERT INTO payment (
+    payment_id, order_uid, date_created, transaction, request_id, currency, provider, amount,
+    payment_dt, bank, delivery_cost, goods_total, custom_fee
+)
+SELECT
+    p.payment_id, p.order_uid, o.date_created, p.transaction, p.request_id, p.currency, p.provider, p.amount,
+    p.payment_dt, p.bank, p.delivery_cost, p.goods_total, p.custom_fee
+FROM payment_unpartitioned p
+JOIN orders_unpartitioned o ON o.order_uid = p.order_uid;
+
+INSERT INTO items (
+    order_uid, chrt_id, date_created, track_number, price, rid, name, sale, size,
+    total_price, nm_id, brand, status
+)
+SELECT
+    i.order_uid, i.chrt_id, o.date_created, i.track_number, i.price, i.rid, i.name, i.sale, i.size,
+    i.total_price, i.nm_id, i.brand, i.status
+FROM items_unpartitioned i
+JOIN orders_unpartitioned o ON o.order_uid = i.order_uid;
+
+DROP TABLE items_unpartitioned;
+DROP TABLE payment_unpartitioned;
+DROP TABLE delivery_unpartitioned;
+DROP TABLE orders_unpartitioned;
+
+CREATE INDEX idx_track_number ON items(track_number);
+CREATE INDEX idx_payment_transaction ON payment(transaction);
+CREATE INDEX idx_orders_date_created ON orders(date_created, order_uid) WHERE deleted_at IS NULL;
+CREATE INDEX idx_orders_customer_id ON orders(customer_id, date_created, order_uid) WHERE deleted_at IS NULL;
+CREATE INDEX idx_orders_track_number ON orders(track_number) WHERE deleted_at IS NULL;
+CREATE INDEX idx_orders_delivery_service ON orders(delivery_service, date_created, order_uid) WHERE deleted_at IS NULL;
+CREATE INDEX idx_payment_provider ON payment(provider);
+CREATE INDEX idx_payment_currency ON payment(currency);
+CREATE INDEX idx_items_brand ON items(brand, order_uid);
+
+-- +migrate Down
+
+-- Only the attached partitions are converted back; archived months stay in
+-- their archive files.
+
+ALTER TABLE orders RENAME TO orders_partitioned;
+ALTER TABLE delivery RENAME TO delivery_partitioned;
+ALTER TABLE payment RENAME TO payment_partitioned;
+ALTER TABLE items RENAME TO items_partitioned;
+
+ALTER INDEX orders_pkey RENAME TO orders_partitioned_pkey;
+ALTER INDEX delivery_pkey RENAME TO delivery_partitioned_pkey;
+ALTER INDEX payment_pkey RENAME TO payment_partitioned_pkey;
+ALTER INDEX items_pkey RENAME TO items_partitioned_pkey;
+
+ALTER TABLE delivery_partitioned ALTER COLUMN delivery_id DROP DEFAULT;
+ALTER SEQUENCE delivery_delivery_id_seq OWNED BY NONE;
+ALTER TABLE payment_partitioned ALTER COLUMN payment_id DROP DEFAULT;
+ALTER SEQUENCE payment_payment_id_seq OWNED BY NONE;
+
+CREATE TABLE orders (
+    order_uid TEXT PRIMARY KEY,
+    track_number TEXT NOT NULL,
+    entry TEXT,
+    locale TEXT NOT NULL,
+    internal_signature TEXT,
+    customer_id TEXT NOT NULL,
+    delivery_service TEXT NOT NULL,
+    shardkey TEXT,
+    sm_id INTEGER,
+    date_created TIMESTAMP NOT NULL,
+    oof_shard TEXT,
+    version BIGINT NOT NULL DEFAULT 0,
+    deleted_at TIMESTAMP
+);
+
+CREATE TABLE delivery (
+    delivery_id INTEGER PRIMARY KEY DEFAULT nextval('delivery_delivery_id_seq'),
+    order_uid TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    phone TEXT NOT NULL,
+    zip TEXT,
+    city TEXT NOT NULL,
+    address TEXT NOT NULL,
+    region TEXT,
+    email TEXT,
+    CONSTRAINT fk_order_uid FOREIGN KEY (order_uid) REFERENCES orders(order_uid)
+);
+
+CREATE TABLE payment (
+    payment_id INTEGER PRIMARY KEY DEFAULT nextval('payment_payment_id_seq'),
+    order_uid TEXT NOT NULL UNIQUE,
+    transaction TEXT NOT NULL,
+    request_id TEXT,
+    currency TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    amount DECIMAL NOT NULL,
+    payment_dt TIMESTAMP NOT NULL,
+    bank TEXT,
+    delivery_cost DECIMAL,
+    goods_total DECIMAL NOT NULL,
+    custom_fee DECIMAL NOT NULL,
+    CONSTRAINT fk_order_uid FOREIGN KEY (order_uid) REFERENCES orders(order_uid)
+);
+
+CREATE TABLE items (
+    order_uid TEXT NOT NULL,
+    chrt_id BIGINT NOT NULL,
+    track_number TEXT NOT NULL,
+    price DECIMAL NOT NULL,
+    rid TEXT,
+    name TEXT NOT NULL,
+    sale INTEGER,
+    size TEXT,
+    total_price DECIMAL NOT NULL,
+    nm_id INTEGER NOT NULL,
+    brand TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    CONSTRAINT items_pkey PRIMARY KEY (order_uid, chrt_id),
+    CONSTRAINT fk_order_uid FOREIGN KEY (order_uid) REFERENCES orders(order_uid)
+);
+
+ALTER SEQUENCE delivery_delivery_id_seq OWNED BY delivery.delivery_id;
+ALTER SEQUENCE payment_payment_id_seq OWNED BY payment.payment_id;
+
+INSERT INTO orders (
+    order_uid, track_number, entry, locale, internal_signature, customer_id,
+    delivery_service, shardkey, sm_id, date_created, oof_shard, version, deleted_at
+)
+SELECT
+    order_uid, track_number, entry, locale, internal_signature, customer_id,
+    delivery_service, shardkey, sm_id, date_created, oof_shard, version, deleted_at
+FROM orders_partitioned;
+
+INSERT INTO delivery (delivery_id, order_uid, name, phone, zip, city, address, region, email)
+SELECT delivery_id, order_uid, name, phone, zip, city, address, region, email
+FROM delivery_partitioned;
+
+INSERT INTO payment (
+    payment_id, order_uid, transaction, request_id, currency, provider, amount,
+    payment_dt, bank, delivery_cost, goods_total, custom_fee
+)
+SELECT
+    payment_id, order_uid, transaction, request_id, currency, provider, amount,
+    payment_dt, bank, delivery_cost, goods_total, custom_fee
+FROM payment_partitioned;
+
+INSERT INTO items (
+    order_uid, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status
+)
+SELECT order_uid, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status
+FROM items_partitioned;
+
+DROP TABLE items_partitioned;
+DROP TABLE payment_partitioned;
+DROP TABLE delivery_partitioned;
+DROP TABLE orders_partitioned;
+
+DROP FUNCTION IF EXISTS create_order_partition(DATE);
+
+CREATE INDEX idx_track_number ON items(track_number);
+CREATE INDEX idx_payment_transaction ON payment(transaction);
+CREATE INDEX idx_orders_date_created ON orders(date_created, order_uid) WHERE deleted_at IS NULL;
+CREATE INDEX idx_orders_customer_id ON orders(customer_id, date_created, order_uid) WHERE deleted_at IS NULL;
+CREATE INDEX idx_orders_track_number ON orders(track_number) WHERE deleted_at IS NULL;
+CREATE INDEX idx_orders_delivery_service ON orders(delivery_service, date_created, order_uid) WHERE deleted_at IS NULL;
+CREATE INDEX idx_payment_provider ON payment(provider);
+CREATE INDEX idx_payment_currency ON payment(currency);
+CREATE INDEX idx_items_brand ON items(brand, order_uid);
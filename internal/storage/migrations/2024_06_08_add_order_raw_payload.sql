@@ -0,0 +1,10 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+ALTER TABLE orders ADD COLUMN raw_content_type TEXT;
+ALTER TABLE orders ADD COLUMN raw_payload JSONB;
+
+-- +migrate Down
+
+ALTER TABLE orders DROP COLUMN IF EXISTS raw_payload;
+ALTER TABLE orders DROP COLUMN IF EXISTS raw_content_type;
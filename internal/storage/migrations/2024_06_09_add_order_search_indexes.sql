@@ -0,0 +1,18 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+-- The expressions must stay identical to the search documents in
+-- storage_search.go, or searches stop using the indexes.
+CREATE INDEX idx_orders_search ON orders
+    USING GIN (to_tsvector('simple', order_uid || ' ' || track_number || ' ' || customer_id))
+    WHERE deleted_at IS NULL;
+CREATE INDEX idx_delivery_search ON delivery
+    USING GIN (to_tsvector('simple', city || ' ' || address));
+CREATE INDEX idx_items_search ON items
+    USING GIN (to_tsvector('simple', name || ' ' || brand));
+
+-- +migrate Down
+
+DROP INDEX IF EXISTS idx_items_search;
+DROP INDEX IF EXISTS idx_delivery_search;
+DROP INDEX IF EXISTS idx_orders_search;
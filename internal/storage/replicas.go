@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultReplicaMaxLag        = 5 * time.Second
+	defaultReplicaCheckInterval = 5 * time.Second
+	replicaCheckTimeout         = 2 * time.Second
+)
+
+// replicaLagQuery reports how far a replica is behind the primary, whose
+// current WAL position is $1. A replica that has replayed up to it is not
+// lagging, however old its last transaction is. Otherwise the lag is the age
+// of the last replayed transaction, so a replica that stopped receiving WAL
+// falls behind as soon as the primary moves on; -1 means a replica that has
+// not replayed anything yet. A server that is not in recovery reports no lag.
+const replicaLagQuery = `
+	SELECT CASE
+		WHEN NOT pg_is_in_recovery() OR pg_last_wal_replay_lsn() >= $1::pg_lsn THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), -1)
+	END::float8;
+`
+
+var errReplicaLagUnknown = errors.New("replica has not replayed any transaction")
+
+type ReplicaOptions struct {
+	DSNs          []string
+	MaxLag        time.Duration // replicas lagging more than this are skipped
+	CheckInterval time.Duration
+}
+
+type ReplicaStatus struct {
+	Host    string        `json:"host"`
+	Healthy bool          `json:"healthy"`
+	Lag     time.Duration `json:"lag"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type replica struct {
+	host    string
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+	lag     atomic.Int64
+	lastErr atomic.Pointer[string]
+}
+
+// ConnectReplicas adds read-only replicas. Reads of orders, listings and
+// revisions are spread over the healthy replicas that lag at most MaxLag;
+// writes, and reads inside write transactions, always use the primary. The
+// replicas are checked right away and then every CheckInterval until ctx is
+// done. When no replica is usable, or a replica fails with a connection
+// error, reads fall back to the primary.
+func (s *Storage) ConnectReplicas(ctx context.Context, opts ReplicaOptions) error {
+	s.replicaMaxLag = opts.MaxLag
+	if s.replicaMaxLag <= 0 {
+		s.replicaMaxLag = defaultReplicaMaxLag
+	}
+
+	interval := opts.CheckInterval
+	if interval <= 0 {
+		interval = defaultReplicaCheckInterval
+	}
+
+	for _, dsn := range opts.DSNs {
+		config, err := pgxpool.ParseConfig(dsn)
+		if err != nil {
+			return fmt.Errorf("replicas.go ConnectReplicas pgxpool.ParseConfig(...): %w", err)
+		}
+
+		pool, err := pgxpool.NewWithConfig(ctx, config)
+		if err != nil {
+			return fmt.Errorf("replicas.go ConnectReplicas pgxpool.NewWithConfig(...): %w", err)
+		}
+
+		s.replicas = append(s.replicas, &replica{host: config.ConnConfig.Host, pool: pool})
+	}
+
+	if len(s.replicas) == 0 {
+		return nil
+	}
+
+	s.checkReplicas(ctx)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.checkReplicas(ctx)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *Storage) checkReplicas(ctx context.Context) {
+	primaryCtx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+
+	var primaryLSN string
+
+	err := s.db.QueryRow(primaryCtx, `SELECT pg_current_wal_lsn()::text;`).Scan(&primaryLSN)
+
+	cancel()
+
+	if err != nil {
+		err = fmt.Errorf("replicas.go checkReplicas reading the primary WAL position: %w", err)
+
+		for _, r := range s.replicas {
+			s.markUnhealthy(r, err)
+		}
+
+		return
+	}
+
+	for _, r := range s.replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+
+		var lagSeconds float64
+
+		err := r.pool.QueryRow(checkCtx, replicaLagQuery, primaryLSN).Scan(&lagSeconds)
+
+		cancel()
+
+		if err == nil && lagSeconds < 0 {
+			err = errReplicaLagUnknown
+		}
+
+		if err != nil {
+			s.markUnhealthy(r, err)
+
+			continue
+		}
+
+		lag := time.Duration(lagSeconds * float64(time.Second))
+		r.lag.Store(int64(lag))
+		r.lastErr.Store(nil)
+
+		if !r.healthy.Swap(true) {
+			s.log.Infof("Replica %s is healthy, lag %s", r.host, lag)
+		}
+	}
+}
+
+func (s *Storage) markUnhealthy(r *replica, err error) {
+	msg := err.Error()
+	r.lastErr.Store(&msg)
+
+	if r.healthy.Swap(false) {
+		s.log.WithError(err).Warnf("Replica %s is unhealthy, reading from the primary", r.host)
+	}
+}
+
+func (s *Storage) ReplicaStatus() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(s.replicas))
+
+	for _, r := range s.replicas {
+		status := ReplicaStatus{Host: r.host, Healthy: r.healthy.Load(), Lag: time.Duration(r.lag.Load())}
+		if msg := r.lastErr.Load(); msg != nil {
+			status.Error = *msg
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// Close closes the primary and replica pools.
+func (s *Storage) Close() {
+	for _, r := range s.replicas {
+		r.pool.Close()
+	}
+
+	s.db.Close()
+}
+
+// pickReplica returns the next usable replica round-robin, or nil.
+func (s *Storage) pickReplica() *replica {
+	n := len(s.replicas)
+
+	for range n {
+		r := s.replicas[s.replicaNext.Add(1)%uint64(n)]
+		if r.healthy.Load() && time.Duration(r.lag.Load()) <= s.replicaMaxLag {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// reader returns the Querier for read-only queries: a usable replica that
+// falls back to the primary on connection errors, or the primary itself.
+func (s *Storage) reader() Querier {
+	r := s.pickReplica()
+	if r == nil {
+		return s.db
+	}
+
+	return &replicaQuerier{storage: s, replica: r}
+}
+
+// readPool is reader for callers that need a transaction.
+func (s *Storage) readPool() *pgxpool.Pool {
+	if r := s.pickReplica(); r != nil {
+		return r.pool
+	}
+
+	return s.db
+}
+
+type replicaQuerier struct {
+	storage *Storage
+	replica *replica
+}
+
+// fallback tells whether a failed replica query should be retried on the
+// primary, and marks the replica unhealthy if so.
+func (q *replicaQuerier) fallback(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || Classify(err) != ErrorTransient {
+		return false
+	}
+
+	q.storage.markUnhealthy(q.replica, err)
+
+	return true
+}
+
+func (q *replicaQuerier) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	rows, err := q.replica.pool.Query(ctx, sql, args...)
+	if q.fallback(ctx, err) {
+		return q.storage.db.Query(ctx, sql, args...) //nolint:wrapcheck
+	}
+
+	return rows, err //nolint:wrapcheck
+}
+
+func (q *replicaQuerier) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return &replicaRow{
+		ctx:     ctx,
+		querier: q,
+		row:     q.replica.pool.QueryRow(ctx, sql, args...),
+		sql:     sql,
+		args:    args,
+	}
+}
+
+func (q *replicaQuerier) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	tag, err := q.replica.pool.Exec(ctx, sql, args...)
+	if q.fallback(ctx, err) {
+		return q.storage.db.Exec(ctx, sql, args...) //nolint:wrapcheck
+	}
+
+	return tag, err //nolint:wrapcheck
+}
+
+// replicaRow defers the fallback to Scan, where QueryRow errors surface.
+type replicaRow struct {
+	ctx     context.Context //nolint:containedctx
+	querier *replicaQuerier
+	row     pgx.Row
+	sql     string
+	args    []any
+}
+
+func (r *replicaRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	if r.querier.fallback(r.ctx, err) {
+		return r.querier.storage.db.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...) //nolint:wrapcheck
+	}
+
+	return err //nolint:wrapcheck
+}
@@ -2,14 +2,14 @@ package storage
 
 import (
 	"context"
-	"database/sql"
 	"embed"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	migrate "github.com/rubenv/sql-migrate"
 	"github.com/sirupsen/logrus"
 )
 
@@ -20,6 +20,10 @@ type Storage struct {
 	log *logrus.Logger
 	db  *pgxpool.Pool
 	dsn string
+
+	replicas      []*replica
+	replicaNext   atomic.Uint64
+	replicaMaxLag time.Duration
 }
 
 type Querier interface {
@@ -28,6 +32,23 @@ type Querier interface {
 	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
 }
 
+// execer runs statements whose results are not read. Besides a Querier,
+// a batchExecer satisfies it by queueing the statements on a pgx.Batch.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+type batchExecer struct {
+	batch *pgx.Batch
+}
+
+// Exec queues the statement. Its error surfaces when the batch is sent.
+func (b batchExecer) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	b.batch.Queue(sql, args...)
+
+	return pgconn.CommandTag{}, nil
+}
+
 func (s *Storage) DB() *pgxpool.Pool {
 	return s.db
 }
@@ -49,35 +70,3 @@ func NewStorage(ctx context.Context, log *logrus.Logger, dsn string) (*Storage,
 		dsn: dsn,
 	}, nil
 }
-
-func (s *Storage) Migrate() error {
-	files, _ := migrationFiles.ReadDir("migrations")
-	for _, file := range files {
-		s.log.Infof("Found migration file: %s", file.Name())
-	}
-
-	conn, err := sql.Open("pgx", s.dsn)
-	if err != nil {
-		return fmt.Errorf("storage.go.go Migrate, sql.Open(...) failed: %w", err)
-	}
-
-	defer func() {
-		if err := conn.Close(); err != nil {
-			s.log.Warnf("storage.go Migrate conn.Close(): %v", err)
-		}
-	}()
-
-	migrations := &migrate.EmbedFileSystemMigrationSource{
-		FileSystem: migrationFiles,
-		Root:       "migrations",
-	}
-
-	n, err := migrate.Exec(conn, "postgres", migrations, migrate.Up)
-	if err != nil {
-		return fmt.Errorf("storage.go.go Migrate(...) migrate.Exec(...): %w", err)
-	}
-
-	s.log.Infof("Applied %d migrations successfully", n)
-
-	return nil
-}
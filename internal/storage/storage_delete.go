@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+// Delete removes an order according to the tombstone. Versioned tombstones
+// apply only when they are newer than the stored order.
+func (s *Storage) Delete(ctx context.Context, tombstone models.Tombstone) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("storage_delete.go Delete starting transaction: %w", err)
+	}
+
+	shouldRollback := true
+
+	defer func() {
+		if shouldRollback {
+			if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+				s.log.Warn("Failed to rollback transaction", rollbackErr)
+			}
+		}
+	}()
+
+	if err := lockOrder(ctx, tx, tombstone.OrderUID); err != nil {
+		return fmt.Errorf("storage_delete.go Delete: %w", err)
+	}
+
+	if err := s.checkIfMatch(ctx, tx, tombstone); err != nil {
+		return err
+	}
+
+	var (
+		version uint64
+		deleted bool
+	)
+
+	err = tx.QueryRow(ctx, `
+		SELECT version, deleted_at IS NOT NULL FROM orders WHERE order_uid = $1 FOR UPDATE;
+	`, tombstone.OrderUID).Scan(&version, &deleted)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("storage_delete.go Delete(%s): %w", tombstone.OrderUID, models.ErrOrderNotFound)
+		}
+
+		return fmt.Errorf("storage_delete.go Delete tx.QueryRow(...): %w", err)
+	}
+
+	if tombstone.Version != 0 && tombstone.Version <= version {
+		return fmt.Errorf("storage_delete.go Delete(%s): %w", tombstone.OrderUID, models.ErrStaleVersion)
+	}
+
+	if deleted && !tombstone.Hard {
+		return fmt.Errorf("storage_delete.go Delete(%s): %w", tombstone.OrderUID, models.ErrOrderNotFound)
+	}
+
+	if tombstone.Hard {
+		err = s.hardDelete(ctx, tx, tombstone.OrderUID)
+	} else {
+		_, err = tx.Exec(ctx, `
+			UPDATE orders SET deleted_at = now(), version = GREATEST(version, $2) WHERE order_uid = $1;
+		`, tombstone.OrderUID, tombstone.Version)
+	}
+
+	if err != nil {
+		return fmt.Errorf("storage_delete.go Delete(%s): %w", tombstone.OrderUID, err)
+	}
+
+	if !deleted {
+		err = s.insertEvent(ctx, tx, models.OrderEvent{
+			OrderUID:   tombstone.OrderUID,
+			Version:    max(version, tombstone.Version),
+			ChangeType: models.ChangeTypeDeleted,
+			OccurredAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return fmt.Errorf("storage_delete.go Delete outbox: %w", err)
+		}
+	}
+
+	if !deleted && !tombstone.Hard {
+		err = s.insertRevision(ctx, tx, models.Revision{
+			OrderUID:   tombstone.OrderUID,
+			Version:    max(version, tombstone.Version),
+			ChangeType: models.ChangeTypeDeleted,
+			Source:     tombstone.Source,
+		})
+		if err != nil {
+			return fmt.Errorf("storage_delete.go Delete revision: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("storage_delete.go Delete committing transaction: %w", err)
+	}
+
+	shouldRollback = false
+
+	return nil
+}
+
+// checkIfMatch fails with models.ErrPreconditionFailed when the tombstone
+// is conditional and the live order doesn't match it.
+func (s *Storage) checkIfMatch(ctx context.Context, q Querier, tombstone models.Tombstone) error {
+	if tombstone.IfMatch == "" {
+		return nil
+	}
+
+	prev, err := s.previousState(ctx, q, tombstone.OrderUID)
+	if err != nil {
+		return fmt.Errorf("storage_delete.go checkIfMatch previous state: %w", err)
+	}
+
+	if !models.MatchIfMatch(tombstone.IfMatch, prev) {
+		return fmt.Errorf("storage_delete.go Delete(%s): %w", tombstone.OrderUID, models.ErrPreconditionFailed)
+	}
+
+	return nil
+}
+
+// hardDelete removes the order together with its revision history.
+func (s *Storage) hardDelete(ctx context.Context, q Querier, orderUID string) error {
+	for _, table := range []string{"order_revisions", "items", "payment", "delivery", "orders"} {
+		if _, err := q.Exec(ctx, "DELETE FROM "+table+" WHERE order_uid = $1;", orderUID); err != nil {
+			return fmt.Errorf("storage_delete.go hardDelete %s: %w", table, err)
+		}
+	}
+
+	return nil
+}
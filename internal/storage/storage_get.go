@@ -10,53 +10,58 @@ import (
 	"github.com/stsolovey/order_tracker/internal/models"
 )
 
+// Get returns a live order with its delivery, payment and items.
 func (s *Storage) Get(ctx context.Context, orderUID string) (*models.Order, error) {
-	const query = `
-	SELECT
-		o.order_uid, o.track_number, o.entry, o.locale, o.internal_signature, o.customer_id, 
-		o.delivery_service, o.shardkey, o.sm_id, o.date_created, o.oof_shard,
-		d.name, d.phone, d.zip, d.city, d.address, d.region, d.email,
-		p.transaction, p.request_id, p.currency, p.provider, p.amount, p.payment_dt,
-		p.bank, p.delivery_cost, p.goods_total, p.custom_fee,
-		json_agg(i.*) as items
-	FROM
-		orders o
-	JOIN
-		delivery d ON o.order_uid = d.order_uid
-	JOIN
-		payment p ON o.order_uid = p.order_uid
-	LEFT JOIN
-		items i ON o.order_uid = i.order_uid
-	WHERE
-		o.order_uid = $1
-	GROUP BY
-		o.order_uid, d.delivery_id, p.payment_id
-	`
-
-	row := s.db.QueryRow(ctx, query, orderUID)
+	db := s.reader()
 
-	var order models.Order
+	rows, err := db.Query(ctx, orderColumns+`
+		WHERE o.order_uid = $1 AND o.deleted_at IS NULL;
+	`, orderUID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.Get: db.Query: %w", err)
+	}
 
-	err := row.Scan(
-		&order.OrderUID, &order.TrackNumber, &order.Entry, &order.Locale,
-		&order.InternalSignature, &order.CustomerID, &order.DeliveryService,
-		&order.Shardkey, &order.SMID, &order.DateCreated, &order.OOFShard,
-		&order.Delivery.Name, &order.Delivery.Phone, &order.Delivery.Zip,
-		&order.Delivery.City, &order.Delivery.Address, &order.Delivery.Region, &order.Delivery.Email,
-		&order.Payment.Transaction, &order.Payment.RequestID, &order.Payment.Currency,
-		&order.Payment.Provider, &order.Payment.Amount, &order.Payment.PaymentDT,
-		&order.Payment.Bank, &order.Payment.DeliveryCost, &order.Payment.GoodsTotal, &order.Payment.CustomFee,
-		&order.Items,
+	orders, err := scanOrders(rows, 1)
+	rows.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("storage.Get: scanOrders: %w", err)
+	}
+
+	if len(orders) == 0 {
+		return nil, models.ErrOrderNotFound
+	}
+
+	if err := s.attachItems(ctx, db, orders); err != nil {
+		return nil, fmt.Errorf("storage.Get: %w", err)
+	}
+
+	return &orders[0], nil
+}
+
+// RawOrder returns the payload a live order was last upserted from.
+func (s *Storage) RawOrder(ctx context.Context, orderUID string) (*models.RawPayload, error) {
+	var (
+		contentType *string
+		payload     []byte
 	)
+
+	err := s.reader().QueryRow(ctx, `
+		SELECT raw_content_type, raw_payload FROM orders WHERE order_uid = $1 AND deleted_at IS NULL;
+	`, orderUID).Scan(&contentType, &payload)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, models.ErrOrderNotFound
 		}
 
-		return nil, fmt.Errorf("storage.Get: row.Scan: %w", err)
+		return nil, fmt.Errorf("storage.RawOrder: QueryRow: %w", err)
 	}
 
-	return &order, nil
+	if contentType == nil || payload == nil {
+		return nil, models.ErrRawNotFound
+	}
+
+	return &models.RawPayload{ContentType: *contentType, Payload: payload}, nil
 }
 
 func (s *Storage) GetOrder(ctx context.Context, q Querier, orderUID string) (*models.Order, error) {
@@ -66,15 +71,15 @@ func (s *Storage) GetOrder(ctx context.Context, q Querier, orderUID string) (*mo
 
 	query := `
         SELECT order_uid, track_number, entry, locale, internal_signature, customer_id, 
-               delivery_service, shardkey, sm_id, date_created, oof_shard
+               delivery_service, shardkey, sm_id, date_created, oof_shard, version
         FROM orders 
-        WHERE order_uid = $1;
+        WHERE order_uid = $1 AND deleted_at IS NULL;
     `
 
 	err := q.QueryRow(ctx, query, orderUID).Scan(
 		&order.OrderUID, &order.TrackNumber, &order.Entry, &order.Locale,
 		&order.InternalSignature, &order.CustomerID, &order.DeliveryService,
-		&order.Shardkey, &order.SMID, &dateCreated, &order.OOFShard,
+		&order.Shardkey, &order.SMID, &dateCreated, &order.OOFShard, &order.Version,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
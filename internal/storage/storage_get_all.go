@@ -10,7 +10,7 @@ import (
 )
 
 func (s *Storage) GetAll(ctx context.Context) ([]models.Order, error) {
-	tx, err := s.db.Begin(ctx)
+	tx, err := s.readPool().Begin(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("Storage GetAll(...) beginTransaction(...): %w", err)
 	}
@@ -106,8 +106,9 @@ func (s *Storage) associateData(
 func (s *Storage) GetOrders(ctx context.Context, q Querier) ([]models.Order, error) {
 	query := `
         SELECT order_uid, track_number, entry, locale, internal_signature, customer_id, 
-               delivery_service, shardkey, sm_id, date_created, oof_shard
-        FROM orders;
+               delivery_service, shardkey, sm_id, date_created, oof_shard, version
+        FROM orders
+        WHERE deleted_at IS NULL;
     `
 
 	rows, err := q.Query(ctx, query)
@@ -127,7 +128,7 @@ func (s *Storage) GetOrders(ctx context.Context, q Querier) ([]models.Order, err
 		if err := rows.Scan(
 			&order.OrderUID, &order.TrackNumber, &order.Entry, &order.Locale,
 			&order.InternalSignature, &order.CustomerID, &order.DeliveryService,
-			&order.Shardkey, &order.SMID, &dateCreated, &order.OOFShard,
+			&order.Shardkey, &order.SMID, &dateCreated, &order.OOFShard, &order.Version,
 		); err != nil {
 			return nil, fmt.Errorf("Storage GetOrders(...) rows.Scan(...): %w", err)
 		}
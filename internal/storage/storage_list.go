@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+// orderColumns selects an order joined with its delivery and payment, as
+// scanned by scanOrders.
+const orderColumns = `
+		SELECT
+			o.order_uid, o.track_number, o.entry, o.locale, o.internal_signature, o.customer_id,
+			o.delivery_service, o.shardkey, o.sm_id, o.date_created, o.oof_shard, o.version,
+			d.name, d.phone, d.zip, d.city, d.address, d.region, d.email,
+			p.transaction, p.request_id, p.currency, p.provider, p.amount, p.payment_dt,
+			p.bank, p.delivery_cost, p.goods_total, p.custom_fee
+		FROM orders o
+		JOIN delivery d ON o.order_uid = d.order_uid
+		JOIN payment p ON o.order_uid = p.order_uid`
+
+// ListOrders returns a page of orders matching the query, ordered by the
+// sort key and then by order_uid. Pagination is keyset-based: the cursor
+// holds the key of the last returned order and the next page starts right
+// after it, so pages stay stable while orders are being written.
+func (s *Storage) ListOrders(ctx context.Context, query models.OrderQuery) (*models.OrderPage, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	sql, args, err := buildListQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	db := s.reader()
+
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("storage_list.go ListOrders db.Query(...): %w", err)
+	}
+
+	defer rows.Close()
+
+	orders, err := scanOrders(rows, query.Limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("storage_list.go ListOrders scanOrders(...): %w", err)
+	}
+
+	page := &models.OrderPage{Orders: orders}
+
+	if len(orders) > query.Limit {
+		page.Orders = orders[:query.Limit]
+		last := page.Orders[query.Limit-1]
+
+		page.NextCursor, err = query.NextCursor(last)
+		if err != nil {
+			return nil, fmt.Errorf("storage_list.go ListOrders: %w", err)
+		}
+	}
+
+	if err := s.attachItems(ctx, db, page.Orders); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// scanOrders reads rows selected with orderColumns. Items are attached
+// separately.
+func scanOrders(rows pgx.Rows, capacity int) ([]models.Order, error) {
+	orders := make([]models.Order, 0, capacity)
+
+	for rows.Next() {
+		var order models.Order
+
+		if err := rows.Scan(
+			&order.OrderUID, &order.TrackNumber, &order.Entry, &order.Locale,
+			&order.InternalSignature, &order.CustomerID, &order.DeliveryService,
+			&order.Shardkey, &order.SMID, &order.DateCreated, &order.OOFShard, &order.Version,
+			&order.Delivery.Name, &order.Delivery.Phone, &order.Delivery.Zip,
+			&order.Delivery.City, &order.Delivery.Address, &order.Delivery.Region, &order.Delivery.Email,
+			&order.Payment.Transaction, &order.Payment.RequestID, &order.Payment.Currency,
+			&order.Payment.Provider, &order.Payment.Amount, &order.Payment.PaymentDT,
+			&order.Payment.Bank, &order.Payment.DeliveryCost, &order.Payment.GoodsTotal, &order.Payment.CustomFee,
+		); err != nil {
+			return nil, fmt.Errorf("rows.Scan(...): %w", err)
+		}
+
+		order.Delivery.OrderUID = order.OrderUID
+		order.Payment.OrderUID = order.OrderUID
+		orders = append(orders, order)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(...): %w", err)
+	}
+
+	return orders, nil
+}
+
+func buildListQuery(query models.OrderQuery) (string, []any, error) {
+	var (
+		conds = []string{"o.deleted_at IS NULL"}
+		args  []any
+	)
+
+	arg := func(v any) string {
+		args = append(args, v)
+
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	f := query.Filter
+
+	for _, eq := range []struct{ column, value string }{
+		{"o.customer_id", f.CustomerID},
+		{"o.track_number", f.TrackNumber},
+		{"o.delivery_service", f.DeliveryService},
+		{"p.provider", f.PaymentProvider},
+		{"p.currency", f.Currency},
+	} {
+		if eq.value != "" {
+			conds = append(conds, eq.column+" = "+arg(eq.value))
+		}
+	}
+
+	if !f.CreatedFrom.IsZero() {
+		conds = append(conds, "o.date_created >= "+arg(f.CreatedFrom))
+	}
+
+	if !f.CreatedTo.IsZero() {
+		conds = append(conds, "o.date_created < "+arg(f.CreatedTo))
+	}
+
+	if f.ItemBrand != "" {
+		conds = append(conds,
+			"EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND i.brand = "+arg(f.ItemBrand)+")")
+	}
+
+	direction, cmp := "ASC", ">"
+	if query.Desc {
+		direction, cmp = "DESC", "<"
+	}
+
+	orderBy := fmt.Sprintf("o.date_created %s, o.order_uid %s", direction, direction)
+	if query.Sort == models.SortOrderUID {
+		orderBy = "o.order_uid " + direction
+	}
+
+	cursor, err := query.After()
+	if err != nil {
+		return "", nil, fmt.Errorf("storage_list.go buildListQuery: %w", err)
+	}
+
+	if cursor != nil {
+		if query.Sort == models.SortOrderUID {
+			conds = append(conds, "o.order_uid "+cmp+" "+arg(cursor.OrderUID))
+		} else {
+			conds = append(conds, fmt.Sprintf("(o.date_created, o.order_uid) %s (%s, %s)",
+				cmp, arg(cursor.DateCreated), arg(cursor.OrderUID)))
+		}
+	}
+
+	sql := orderColumns + `
+		WHERE ` + strings.Join(conds, " AND ") + `
+		ORDER BY ` + orderBy + `
+		LIMIT ` + arg(query.Limit+1) + `;`
+
+	return sql, args, nil
+}
+
+// attachItems loads the items of all orders on a page with one query.
+func (s *Storage) attachItems(ctx context.Context, q Querier, orders []models.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	uids := make([]string, 0, len(orders))
+	index := make(map[string]int, len(orders))
+
+	for i, order := range orders {
+		uids = append(uids, order.OrderUID)
+		index[order.OrderUID] = i
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT order_uid, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status
+		FROM items
+		WHERE order_uid = ANY($1)
+		ORDER BY order_uid, chrt_id;
+	`, uids)
+	if err != nil {
+		return fmt.Errorf("storage_list.go attachItems q.Query(...): %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.Item
+
+		if err := rows.Scan(
+			&item.OrderUID, &item.ChrtID, &item.TrackNumber, &item.Price, &item.RID,
+			&item.Name, &item.Sale, &item.Size, &item.TotalPrice, &item.NMID,
+			&item.Brand, &item.Status,
+		); err != nil {
+			return fmt.Errorf("storage_list.go attachItems rows.Scan(...): %w", err)
+		}
+
+		order := &orders[index[item.OrderUID]]
+		order.Items = append(order.Items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("storage_list.go attachItems rows.Err(...): %w", err)
+	}
+
+	return nil
+}
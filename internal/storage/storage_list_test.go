@@ -0,0 +1,34 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/storage"
+)
+
+// Invalid queries are rejected before the database is touched.
+func TestListOrders_InvalidQuery(t *testing.T) {
+	var s storage.Storage
+
+	for name, query := range map[string]models.OrderQuery{
+		"unknown sort":       {Sort: "price"},
+		"negative limit":     {Limit: -1},
+		"limit over maximum": {Limit: models.MaxListLimit + 1},
+		"malformed cursor":   {Cursor: "not a cursor!"},
+		"foreign cursor":     {Cursor: "eyJzIjoib3JkZXJVaWQiLCJ1IjoiYSJ9"}, // {"s":"orderUid","u":"a"}
+	} {
+		_, err := s.ListOrders(context.Background(), query)
+		require.ErrorIs(t, err, models.ErrInvalidQuery, name)
+	}
+}
+
+func TestStreamOrders_InvalidPartition(t *testing.T) {
+	var s storage.Storage
+
+	err := s.StreamOrders(context.Background(), models.OrderStream{Partition: 3, Partitions: 3},
+		func([]models.Order) error { return nil })
+	require.ErrorIs(t, err, models.ErrInvalidQuery)
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+var (
+	ErrMigrationNotFound = errors.New("migration not found")
+	errInvalidSteps      = errors.New("steps must be positive")
+)
+
+var migrationSource = &migrate.EmbedFileSystemMigrationSource{
+	FileSystem: migrationFiles,
+	Root:       "migrations",
+}
+
+// MigrationStatus is an embedded migration and whether it has been applied.
+type MigrationStatus struct {
+	ID        string     `json:"id"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// Migrate applies every pending migration.
+func (s *Storage) Migrate() error {
+	n, err := s.MigrateUp(context.Background(), 0)
+	if err != nil {
+		return err
+	}
+
+	s.log.Infof("Applied %d migrations successfully", n)
+
+	return nil
+}
+
+// MigrateUp applies up to limit pending migrations, all of them if limit is 0.
+func (s *Storage) MigrateUp(ctx context.Context, limit int) (int, error) {
+	var applied int
+
+	err := s.withMigrationLock(ctx, func(conn *sql.DB) error {
+		var err error
+
+		applied, err = migrate.ExecMaxContext(ctx, conn, "postgres", migrationSource, migrate.Up, limit)
+		if err != nil {
+			return fmt.Errorf("storage_migrate.go MigrateUp migrate.ExecMaxContext(...): %w", err)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// MigrateDown rolls back the last steps applied migrations.
+func (s *Storage) MigrateDown(ctx context.Context, steps int) (int, error) {
+	if steps <= 0 {
+		return 0, fmt.Errorf("storage_migrate.go MigrateDown: %w, got %d", errInvalidSteps, steps)
+	}
+
+	var rolledBack int
+
+	err := s.withMigrationLock(ctx, func(conn *sql.DB) error {
+		var err error
+
+		rolledBack, err = migrate.ExecMaxContext(ctx, conn, "postgres", migrationSource, migrate.Down, steps)
+		if err != nil {
+			return fmt.Errorf("storage_migrate.go MigrateDown migrate.ExecMaxContext(...): %w", err)
+		}
+
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// MigrateRedo rolls back the last applied migration and applies it again.
+func (s *Storage) MigrateRedo(ctx context.Context) error {
+	return s.withMigrationLock(ctx, func(conn *sql.DB) error {
+		n, err := migrate.ExecMaxContext(ctx, conn, "postgres", migrationSource, migrate.Down, 1)
+		if err != nil {
+			return fmt.Errorf("storage_migrate.go MigrateRedo migrate.ExecMaxContext(Down): %w", err)
+		}
+
+		if n == 0 {
+			return fmt.Errorf("storage_migrate.go MigrateRedo: %w: nothing has been applied", ErrMigrationNotFound)
+		}
+
+		if _, err := migrate.ExecMaxContext(ctx, conn, "postgres", migrationSource, migrate.Up, 1); err != nil {
+			return fmt.Errorf("storage_migrate.go MigrateRedo migrate.ExecMaxContext(Up): %w", err)
+		}
+
+		return nil
+	})
+}
+
+// MigrateTo applies or rolls back migrations until id is the last applied
+// one. id may be given with or without its .sql suffix. It returns the number
+// of migrations run; a negative number counts rollbacks.
+func (s *Storage) MigrateTo(ctx context.Context, id string) (int, error) {
+	id = strings.TrimSuffix(id, ".sql") + ".sql"
+
+	var moved int
+
+	err := s.withMigrationLock(ctx, func(conn *sql.DB) error {
+		statuses, err := migrationStatus(conn)
+		if err != nil {
+			return err
+		}
+
+		target := -1
+
+		for i, status := range statuses {
+			if status.ID == id {
+				target = i
+			}
+		}
+
+		if target < 0 {
+			return fmt.Errorf("storage_migrate.go MigrateTo: %w: %s", ErrMigrationNotFound, id)
+		}
+
+		var pending, later int
+
+		for i, status := range statuses {
+			switch {
+			case i <= target && !status.Applied:
+				pending++
+			case i > target && status.Applied:
+				later++
+			}
+		}
+
+		dir, steps := migrate.Up, pending
+		if later > 0 {
+			dir, steps = migrate.Down, later
+		}
+
+		if steps == 0 {
+			return nil
+		}
+
+		n, err := migrate.ExecMaxContext(ctx, conn, "postgres", migrationSource, dir, steps)
+		if err != nil {
+			return fmt.Errorf("storage_migrate.go MigrateTo migrate.ExecMaxContext(...): %w", err)
+		}
+
+		moved = n
+		if dir == migrate.Down {
+			moved = -n
+		}
+
+		return nil
+	})
+
+	return moved, err
+}
+
+// MigrationStatus lists the embedded migrations in the order they apply.
+func (s *Storage) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+
+	err := s.withMigrationLock(ctx, func(conn *sql.DB) error {
+		var err error
+
+		statuses, err = migrationStatus(conn)
+
+		return err
+	})
+
+	return statuses, err
+}
+
+func migrationStatus(conn *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := migrationSource.FindMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("storage_migrate.go migrationStatus migrationSource.FindMigrations(): %w", err)
+	}
+
+	records, err := migrate.GetMigrationRecords(conn, "postgres")
+	if err != nil {
+		return nil, fmt.Errorf("storage_migrate.go migrationStatus migrate.GetMigrationRecords(...): %w", err)
+	}
+
+	applied := make(map[string]time.Time, len(records))
+	for _, record := range records {
+		applied[record.Id] = record.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+
+	for _, migration := range migrations {
+		status := MigrationStatus{ID: migration.Id}
+
+		if at, ok := applied[migration.Id]; ok {
+			status.Applied = true
+			status.AppliedAt = &at
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// withMigrationLock runs fn while holding a session advisory lock, so
+// instances starting together apply migrations one at a time. The lock is
+// held on a connection of its own; fn runs on the others.
+func (s *Storage) withMigrationLock(ctx context.Context, fn func(conn *sql.DB) error) error {
+	conn, err := sql.Open("pgx", s.dsn)
+	if err != nil {
+		return fmt.Errorf("storage_migrate.go withMigrationLock sql.Open(...): %w", err)
+	}
+
+	defer func() {
+		if err := conn.Close(); err != nil {
+			s.log.Warnf("storage_migrate.go withMigrationLock conn.Close(): %v", err)
+		}
+	}()
+
+	lock, err := conn.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("storage_migrate.go withMigrationLock conn.Conn(...): %w", err)
+	}
+	defer lock.Close()
+
+	if _, err := lock.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext('schema_migrations'), 0)"); err != nil {
+		return fmt.Errorf("storage_migrate.go withMigrationLock pg_advisory_lock(...): %w", err)
+	}
+
+	defer func() {
+		// Closing the connection releases the lock too, so a failure here
+		// only delays other instances.
+		if _, err := lock.ExecContext(context.WithoutCancel(ctx),
+			"SELECT pg_advisory_unlock(hashtext('schema_migrations'), 0)"); err != nil {
+			s.log.Warnf("storage_migrate.go withMigrationLock pg_advisory_unlock(...): %v", err)
+		}
+	}()
+
+	return fn(conn)
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stsolovey/order_tracker/internal/models"
+	"github.com/stsolovey/order_tracker/internal/outbox"
+)
+
+const (
+	maxOutboxBackoffSeconds = 300
+	// outboxClaimLease bounds how long a relay may take to publish a claimed
+	// batch before other relays consider the events pending again.
+	outboxClaimLease = time.Minute
+)
+
+// previousState locks the stored order and returns it, or nil if the order
+// doesn't exist yet or was soft-deleted.
+func (s *Storage) previousState(ctx context.Context, q Querier, orderUID string) (*models.Order, error) {
+	var deleted bool
+
+	err := q.QueryRow(ctx, `SELECT deleted_at IS NOT NULL FROM orders WHERE order_uid = $1 FOR UPDATE;`,
+		orderUID).Scan(&deleted)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil //nolint:nilnil
+		}
+
+		return nil, fmt.Errorf("storage_outbox.go previousState lock: %w", err)
+	}
+
+	if deleted {
+		return nil, nil //nolint:nilnil
+	}
+
+	order, err := s.GetOrder(ctx, q, orderUID)
+	if err != nil {
+		return nil, fmt.Errorf("storage_outbox.go previousState: %w", err)
+	}
+
+	delivery, err := s.GetDelivery(ctx, q, orderUID)
+	if err != nil && !errors.Is(err, models.ErrDeliveryNotFound) {
+		return nil, fmt.Errorf("storage_outbox.go previousState: %w", err)
+	}
+
+	if delivery != nil {
+		delivery.OrderUID = orderUID
+		order.Delivery = *delivery
+	}
+
+	payment, err := s.GetPayment(ctx, q, orderUID)
+	if err != nil && !errors.Is(err, models.ErrPaymentNotFound) {
+		return nil, fmt.Errorf("storage_outbox.go previousState: %w", err)
+	}
+
+	if payment != nil {
+		payment.OrderUID = orderUID
+		order.Payment = *payment
+	}
+
+	items, err := s.GetItems(ctx, q, orderUID)
+	if err != nil && !errors.Is(err, models.ErrItemsNotFound) {
+		return nil, fmt.Errorf("storage_outbox.go previousState: %w", err)
+	}
+
+	order.Items = items
+
+	return order, nil
+}
+
+// enqueueEvent writes an outbox row describing the change from prev to next.
+// Nothing is written when no section changed.
+func (s *Storage) enqueueEvent(ctx context.Context, q execer, prev, next *models.Order, version uint64) error {
+	sections := next.ChangedSections(prev)
+	if len(sections) == 0 {
+		return nil
+	}
+
+	event := models.OrderEvent{
+		OrderUID:        next.OrderUID,
+		Version:         version,
+		ChangeType:      models.ChangeTypeUpdated,
+		ChangedSections: sections,
+		OccurredAt:      time.Now().UTC(),
+	}
+
+	if prev == nil {
+		event.ChangeType = models.ChangeTypeCreated
+	}
+
+	return s.insertEvent(ctx, q, event)
+}
+
+func (s *Storage) insertEvent(ctx context.Context, q execer, event models.OrderEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("storage_outbox.go insertEvent json.Marshal(...): %w", err)
+	}
+
+	_, err = q.Exec(ctx, `
+		INSERT INTO order_outbox (order_uid, version, change_type, payload)
+		VALUES ($1, $2, $3, $4);
+	`, event.OrderUID, event.Version, event.ChangeType, payload)
+	if err != nil {
+		return fmt.Errorf("storage_outbox.go insertEvent q.Exec(...): %w", err)
+	}
+
+	return nil
+}
+
+// RelayOutbox passes up to limit pending events to publish in insertion
+// order and marks the published ones as sent. The events are claimed in a
+// short transaction first, so the publishes run without holding rows or a
+// transaction open; a claim expires after outboxClaimLease, so the events of
+// a relay that dies mid-batch are relayed again. On the first publish
+// failure the event is rescheduled with exponential backoff, the rest of the
+// batch is released and the batch stops, so events are never published out
+// of order.
+func (s *Storage) RelayOutbox(
+	ctx context.Context,
+	limit int,
+	publish outbox.PublishFunc,
+) (int, error) {
+	events, err := s.claimEvents(ctx, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, event := range events {
+		if publishErr := publish(ctx, event); publishErr != nil {
+			s.log.WithError(publishErr).Warnf("Failed to publish outbox event %d, rescheduled", event.ID)
+
+			return i, s.rescheduleEvents(ctx, events[i:], publishErr)
+		}
+
+		if _, err := s.db.Exec(ctx, `UPDATE order_outbox SET sent_at = now() WHERE id = $1;`, event.ID); err != nil {
+			return i, fmt.Errorf("storage_outbox.go RelayOutbox mark sent %d: %w", event.ID, err)
+		}
+	}
+
+	return len(events), nil
+}
+
+// claimEvents locks up to limit due events with SKIP LOCKED, which lets
+// several instances relay concurrently, and pushes their next attempt past
+// the claim lease so that no other relay picks them up meanwhile.
+func (s *Storage) claimEvents(ctx context.Context, limit int) ([]models.OrderEvent, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage_outbox.go claimEvents starting transaction: %w", err)
+	}
+
+	shouldRollback := true
+
+	defer func() {
+		if shouldRollback {
+			if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+				s.log.Warn("Failed to rollback transaction", rollbackErr)
+			}
+		}
+	}()
+
+	events, err := s.pendingEvents(ctx, tx, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) > 0 {
+		_, err = tx.Exec(ctx, `
+			UPDATE order_outbox SET next_attempt_at = now() + $2 * interval '1 second' WHERE id = ANY($1);
+		`, eventIDs(events), outboxClaimLease.Seconds())
+		if err != nil {
+			return nil, fmt.Errorf("storage_outbox.go claimEvents claim: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("storage_outbox.go claimEvents committing transaction: %w", err)
+	}
+
+	shouldRollback = false
+
+	return events, nil
+}
+
+// rescheduleEvents backs off the first event, which failed to publish, and
+// releases the claim on the others.
+func (s *Storage) rescheduleEvents(ctx context.Context, events []models.OrderEvent, publishErr error) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE order_outbox
+		SET attempts = attempts + 1,
+			last_error = $2,
+			next_attempt_at = now() + LEAST(power(2, attempts), $3) * interval '1 second'
+		WHERE id = $1;
+	`, events[0].ID, publishErr.Error(), maxOutboxBackoffSeconds)
+	if err != nil {
+		return fmt.Errorf("storage_outbox.go rescheduleEvents reschedule %d: %w", events[0].ID, err)
+	}
+
+	if len(events) == 1 {
+		return nil
+	}
+
+	_, err = s.db.Exec(ctx, `UPDATE order_outbox SET next_attempt_at = now() WHERE id = ANY($1);`,
+		eventIDs(events[1:]))
+	if err != nil {
+		return fmt.Errorf("storage_outbox.go rescheduleEvents release: %w", err)
+	}
+
+	return nil
+}
+
+func eventIDs(events []models.OrderEvent) []int64 {
+	ids := make([]int64, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+
+	return ids
+}
+
+func (s *Storage) pendingEvents(ctx context.Context, q Querier, limit int) ([]models.OrderEvent, error) {
+	rows, err := q.Query(ctx, `
+		SELECT id, payload
+		FROM order_outbox
+		WHERE sent_at IS NULL AND next_attempt_at <= now()
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED;
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("storage_outbox.go pendingEvents q.Query(...): %w", err)
+	}
+
+	defer rows.Close()
+
+	var events []models.OrderEvent
+
+	for rows.Next() {
+		var (
+			id      int64
+			payload []byte
+			event   models.OrderEvent
+		)
+
+		if err := rows.Scan(&id, &payload); err != nil {
+			return nil, fmt.Errorf("storage_outbox.go pendingEvents rows.Scan(...): %w", err)
+		}
+
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("storage_outbox.go pendingEvents json.Unmarshal(%d): %w", id, err)
+		}
+
+		event.ID = id
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage_outbox.go pendingEvents rows.Err(...): %w", err)
+	}
+
+	return events, nil
+}
+
+// PurgeOutbox deletes events that were sent before olderThan.
+func (s *Storage) PurgeOutbox(ctx context.Context, olderThan time.Time) (int64, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM order_outbox WHERE sent_at IS NOT NULL AND sent_at < $1;`, olderThan)
+	if err != nil {
+		return 0, fmt.Errorf("storage_outbox.go PurgeOutbox s.db.Exec(...): %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,515 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+const restoreBatchSize = 500
+
+var errUnknownArchiveTable = errors.New("unknown table in archive")
+
+// partitionedTables are partitioned by date_created, parents first. Archives
+// hold their rows in this order, so orders are restored before their
+// deliveries, payments and items.
+var partitionedTables = []string{"orders", "delivery", "payment", "items"}
+
+// archiveLine is one line of an archive: a row of table as produced by
+// row_to_json, which json_populate_recordset reads back.
+type archiveLine struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+func partitionName(table string, month time.Time) string {
+	return table + "_" + month.Format("y2006m01")
+}
+
+// ArchiveFileName is the name of the archive of a month.
+func ArchiveFileName(month time.Time) string {
+	return "orders-" + month.Format("2006-01") + ".ndjson.gz"
+}
+
+// lockPartitions serializes partition maintenance between instances until
+// the transaction ends.
+func lockPartitions(ctx context.Context, q Querier) error {
+	if _, err := q.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('order_partitions'), 0);`); err != nil {
+		return fmt.Errorf("storage_partitions.go lockPartitions(...): %w", err)
+	}
+
+	return nil
+}
+
+// EnsurePartitions creates the partitions of the month containing from and
+// of the following months, unless they exist.
+func (s *Storage) EnsurePartitions(ctx context.Context, from time.Time, months int) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("storage_partitions.go EnsurePartitions starting transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warn("Failed to rollback transaction", err)
+		}
+	}()
+
+	if err := lockPartitions(ctx, tx); err != nil {
+		return err
+	}
+
+	first := models.PartitionMonth(from)
+
+	for i := 0; i <= months; i++ {
+		if _, err := tx.Exec(ctx, `SELECT create_order_partition($1);`, first.AddDate(0, i, 0)); err != nil {
+			return fmt.Errorf("storage_partitions.go EnsurePartitions create_order_partition(...): %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("storage_partitions.go EnsurePartitions committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// OrderPartitions lists the monthly partitions, oldest first, including the
+// ones an unfinished archive run left detached. The order counts are the
+// planner's estimates. The default partition is not listed.
+func (s *Storage) OrderPartitions(ctx context.Context) ([]models.OrderPartition, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT c.relname, GREATEST(c.reltuples, 0)::BIGINT, i.inhrelid IS NULL
+		FROM pg_class c
+		LEFT JOIN pg_inherits i ON i.inhrelid = c.oid AND i.inhparent = 'orders'::regclass
+		WHERE c.relname ~ '^orders_y[0-9]{4}m[0-9]{2}$' AND c.relkind IN ('r', 'p')
+			AND c.relnamespace = (SELECT relnamespace FROM pg_class WHERE oid = 'orders'::regclass)
+		ORDER BY c.relname;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("storage_partitions.go OrderPartitions s.db.Query(...): %w", err)
+	}
+
+	defer rows.Close()
+
+	var partitions []models.OrderPartition
+
+	for rows.Next() {
+		var (
+			name      string
+			partition models.OrderPartition
+		)
+
+		if err := rows.Scan(&name, &partition.Orders, &partition.Detached); err != nil {
+			return nil, fmt.Errorf("storage_partitions.go OrderPartitions rows.Scan(...): %w", err)
+		}
+
+		partition.Month, err = time.Parse("y2006m01", strings.TrimPrefix(name, "orders_"))
+		if err != nil {
+			return nil, fmt.Errorf("storage_partitions.go OrderPartitions time.Parse(%s): %w", name, err)
+		}
+
+		partitions = append(partitions, partition)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage_partitions.go OrderPartitions rows.Err(...): %w", err)
+	}
+
+	return partitions, nil
+}
+
+// ArchivePartition detaches the partitions of a month, writes their rows to
+// a gzip-compressed NDJSON file in dir and drops them. It returns the path
+// of the archive. If the archive can't be written, the partitions are
+// attached again and nothing is lost. Partitions that are already detached,
+// because an earlier run died before dropping them, are archived from where
+// that run stopped.
+func (s *Storage) ArchivePartition(ctx context.Context, month time.Time, dir string) (string, error) {
+	month = models.PartitionMonth(month)
+
+	exists, attached, err := partitionState(ctx, s.db, month)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		return "", fmt.Errorf("storage_partitions.go ArchivePartition(%s): %w", month.Format("2006-01"),
+			models.ErrPartitionNotFound)
+	}
+
+	if attached {
+		if err := s.detachPartitions(ctx, month); err != nil {
+			return "", err
+		}
+	} else {
+		s.log.Warnf("Resuming the archive of the detached partitions of %s", month.Format("2006-01"))
+	}
+
+	path := filepath.Join(dir, ArchiveFileName(month))
+
+	if err := s.exportPartitions(ctx, month, path); err != nil {
+		// The partitions are gone if another instance resumed the same
+		// month and finished first.
+		if attachErr := s.attachPartitions(ctx, month); attachErr != nil {
+			if errors.Is(attachErr, models.ErrPartitionNotFound) {
+				return "", attachErr
+			}
+
+			s.log.WithError(attachErr).Errorf("Failed to re-attach partitions of %s", month.Format("2006-01"))
+		}
+
+		return "", err
+	}
+
+	if err := s.dropPartitions(ctx, month); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+func (s *Storage) detachPartitions(ctx context.Context, month time.Time) error {
+	return s.alterPartitions(ctx, month, true, func(table string) string {
+		return "ALTER TABLE " + table + " DETACH PARTITION " + partitionName(table, month) + ";"
+	})
+}
+
+func (s *Storage) attachPartitions(ctx context.Context, month time.Time) error {
+	bounds := fmt.Sprintf("FOR VALUES FROM ('%s') TO ('%s')",
+		month.Format(time.DateOnly), month.AddDate(0, 1, 0).Format(time.DateOnly))
+
+	return s.alterPartitions(ctx, month, false, func(table string) string {
+		return "ALTER TABLE " + table + " ATTACH PARTITION " + partitionName(table, month) + " " + bounds + ";"
+	})
+}
+
+func (s *Storage) dropPartitions(ctx context.Context, month time.Time) error {
+	return s.alterPartitions(ctx, month, false, func(table string) string {
+		return "DROP TABLE " + partitionName(table, month) + ";"
+	})
+}
+
+// alterPartitions runs a statement per partition of a month in one
+// transaction, provided the partitions exist and are attached or detached
+// as expected. That way two instances never archive the same month.
+func (s *Storage) alterPartitions(
+	ctx context.Context, month time.Time, wantAttached bool, statement func(table string) string,
+) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("storage_partitions.go alterPartitions starting transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warn("Failed to rollback transaction", err)
+		}
+	}()
+
+	if err := lockPartitions(ctx, tx); err != nil {
+		return err
+	}
+
+	exists, attached, err := partitionState(ctx, tx, month)
+	if err != nil {
+		return err
+	}
+
+	if !exists || attached != wantAttached {
+		return fmt.Errorf("storage_partitions.go alterPartitions(%s): %w", month.Format("2006-01"),
+			models.ErrPartitionNotFound)
+	}
+
+	for _, table := range partitionedTables {
+		if _, err := tx.Exec(ctx, statement(table)); err != nil {
+			return fmt.Errorf("storage_partitions.go alterPartitions %s: %w", table, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("storage_partitions.go alterPartitions committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// partitionState reports whether the orders partition of a month exists and
+// whether it is attached to the orders table.
+func partitionState(ctx context.Context, q Querier, month time.Time) (bool, bool, error) {
+	var exists, attached bool
+
+	err := q.QueryRow(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM pg_class WHERE relname = $1 AND relkind IN ('r', 'p')),
+			EXISTS (
+				SELECT 1 FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+				WHERE i.inhparent = 'orders'::regclass AND c.relname = $1
+			);
+	`, partitionName("orders", month)).Scan(&exists, &attached)
+	if err != nil {
+		return false, false, fmt.Errorf("storage_partitions.go partitionState q.QueryRow(...): %w", err)
+	}
+
+	return exists, attached, nil
+}
+
+// exportPartitions writes the rows of the detached partitions of a month to
+// path. The file appears only once it is complete.
+func (s *Storage) exportPartitions(ctx context.Context, month time.Time, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil { //nolint:mnd
+		return fmt.Errorf("storage_partitions.go exportPartitions os.MkdirAll(...): %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("storage_partitions.go exportPartitions os.CreateTemp(...): %w", err)
+	}
+
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	zw := gzip.NewWriter(file)
+
+	for _, table := range partitionedTables {
+		if err := s.exportTable(ctx, zw, table, partitionName(table, month)); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("storage_partitions.go exportPartitions zw.Close(): %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("storage_partitions.go exportPartitions file.Sync(): %w", err)
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("storage_partitions.go exportPartitions os.Rename(...): %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) exportTable(ctx context.Context, w io.Writer, table, partition string) error {
+	rows, err := s.db.Query(ctx, "SELECT row_to_json(t)::TEXT FROM "+partition+" t;")
+	if err != nil {
+		return fmt.Errorf("storage_partitions.go exportTable s.db.Query(%s): %w", partition, err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var row string
+
+		if err := rows.Scan(&row); err != nil {
+			return fmt.Errorf("storage_partitions.go exportTable rows.Scan(...): %w", err)
+		}
+
+		line, err := json.Marshal(archiveLine{Table: table, Row: json.RawMessage(row)})
+		if err != nil {
+			return fmt.Errorf("storage_partitions.go exportTable json.Marshal(...): %w", err)
+		}
+
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("storage_partitions.go exportTable w.Write(...): %w", err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("storage_partitions.go exportTable rows.Err(...): %w", err)
+	}
+
+	return nil
+}
+
+// RestoreArchive loads an archive written by ArchivePartition back, creating
+// the partitions of its month if needed, and returns the number of restored
+// orders. Orders that were written again after they were archived are kept
+// as they are, together with their deliveries, payments and items.
+func (s *Storage) RestoreArchive(ctx context.Context, path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("storage_partitions.go RestoreArchive os.Open(...): %w", err)
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("storage_partitions.go RestoreArchive gzip.NewReader(...): %w", err)
+	}
+	defer zr.Close()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("storage_partitions.go RestoreArchive starting transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warn("Failed to rollback transaction", err)
+		}
+	}()
+
+	if err := lockPartitions(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	r := &archiveRestore{tx: tx, restored: make(map[string]struct{})}
+
+	if err := r.read(ctx, bufio.NewReader(zr)); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("storage_partitions.go RestoreArchive committing transaction: %w", err)
+	}
+
+	return len(r.restored), nil
+}
+
+// archiveRestore inserts the lines of an archive in batches of one table.
+type archiveRestore struct {
+	tx       pgx.Tx
+	restored map[string]struct{}
+
+	table string
+	rows  []string
+}
+
+func (r *archiveRestore) read(ctx context.Context, reader *bufio.Reader) error {
+	for {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 {
+			if err := r.add(ctx, data); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return r.flush(ctx)
+		}
+
+		if err != nil {
+			return fmt.Errorf("storage_partitions.go RestoreArchive reader.ReadBytes(...): %w", err)
+		}
+	}
+}
+
+func (r *archiveRestore) add(ctx context.Context, data []byte) error {
+	var line archiveLine
+	if err := json.Unmarshal(data, &line); err != nil {
+		return fmt.Errorf("storage_partitions.go RestoreArchive json.Unmarshal(...): %w", err)
+	}
+
+	if !slices.Contains(partitionedTables, line.Table) {
+		return fmt.Errorf("storage_partitions.go RestoreArchive(%s): %w", line.Table, errUnknownArchiveTable)
+	}
+
+	if line.Table != r.table {
+		if err := r.flush(ctx); err != nil {
+			return err
+		}
+
+		r.table = line.Table
+	}
+
+	if line.Table != "orders" {
+		var row struct {
+			OrderUID string `json:"order_uid"`
+		}
+
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return fmt.Errorf("storage_partitions.go RestoreArchive json.Unmarshal(...): %w", err)
+		}
+
+		if _, ok := r.restored[row.OrderUID]; !ok {
+			return nil
+		}
+	}
+
+	if len(r.rows) == restoreBatchSize {
+		if err := r.flush(ctx); err != nil {
+			return err
+		}
+	}
+
+	r.rows = append(r.rows, string(line.Row))
+
+	return nil
+}
+
+func (r *archiveRestore) flush(ctx context.Context) error {
+	if len(r.rows) == 0 {
+		return nil
+	}
+
+	batch := "[" + strings.Join(r.rows, ",") + "]"
+	r.rows = r.rows[:0]
+
+	if r.table != "orders" {
+		_, err := r.tx.Exec(ctx, `
+			INSERT INTO `+r.table+`
+			SELECT r.* FROM json_populate_recordset(NULL::`+r.table+`, $1::JSON) r
+			ON CONFLICT DO NOTHING;
+		`, batch)
+		if err != nil {
+			return fmt.Errorf("storage_partitions.go RestoreArchive inserting %s: %w", r.table, err)
+		}
+
+		return nil
+	}
+
+	_, err := r.tx.Exec(ctx, `
+		SELECT create_order_partition(month)
+		FROM (
+			SELECT DISTINCT date_trunc('month', date_created)::DATE AS month
+			FROM json_populate_recordset(NULL::orders, $1::JSON)
+		) months;
+	`, batch)
+	if err != nil {
+		return fmt.Errorf("storage_partitions.go RestoreArchive create_order_partition(...): %w", err)
+	}
+
+	rows, err := r.tx.Query(ctx, `
+		INSERT INTO orders
+		SELECT r.* FROM json_populate_recordset(NULL::orders, $1::JSON) r
+		WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_uid = r.order_uid)
+		RETURNING order_uid;
+	`, batch)
+	if err != nil {
+		return fmt.Errorf("storage_partitions.go RestoreArchive inserting orders: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var orderUID string
+		if err := rows.Scan(&orderUID); err != nil {
+			return fmt.Errorf("storage_partitions.go RestoreArchive rows.Scan(...): %w", err)
+		}
+
+		r.restored[orderUID] = struct{}{}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("storage_partitions.go RestoreArchive rows.Err(...): %w", err)
+	}
+
+	return nil
+}
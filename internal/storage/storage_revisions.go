@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+const revisionColumns = `
+	order_uid, revision, version, change_type, changed_sections,
+	source_subject, source_sequence, received_at, recorded_at`
+
+// recordRevision appends the state written by an upsert to the order's
+// history. Nothing is recorded when no section changed.
+func (s *Storage) recordRevision(ctx context.Context, q execer, prev, next *models.Order, version uint64) error {
+	sections := next.ChangedSections(prev)
+	if len(sections) == 0 {
+		return nil
+	}
+
+	changeType := models.ChangeTypeUpdated
+	if prev == nil {
+		changeType = models.ChangeTypeCreated
+	}
+
+	snapshot := *next
+	snapshot.Version = version
+
+	return s.insertRevision(ctx, q, models.Revision{
+		OrderUID:        next.OrderUID,
+		Version:         version,
+		ChangeType:      changeType,
+		ChangedSections: sections,
+		Source:          next.Source,
+		Order:           &snapshot,
+	})
+}
+
+// insertRevision stores rev under the next revision number of its order.
+// Callers hold the lock on the order row, which serializes the numbering.
+func (s *Storage) insertRevision(ctx context.Context, q execer, rev models.Revision) error {
+	var snapshot []byte
+
+	if rev.Order != nil {
+		var err error
+
+		snapshot, err = json.Marshal(rev.Order)
+		if err != nil {
+			return fmt.Errorf("storage_revisions.go insertRevision json.Marshal(...): %w", err)
+		}
+	}
+
+	receivedAt := rev.Source.ReceivedAt
+	if receivedAt.IsZero() {
+		receivedAt = time.Now()
+	}
+
+	if rev.ChangedSections == nil {
+		rev.ChangedSections = []string{}
+	}
+
+	_, err := q.Exec(ctx, `
+		INSERT INTO order_revisions (
+			order_uid, revision, version, change_type, changed_sections, snapshot,
+			source_subject, source_sequence, received_at
+		)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, $4, $5, $6, $7, $8
+		FROM order_revisions WHERE order_uid = $1;
+	`, rev.OrderUID, rev.Version, rev.ChangeType, rev.ChangedSections, snapshot,
+		rev.Source.Subject, rev.Source.Sequence, receivedAt)
+	if err != nil {
+		return fmt.Errorf("storage_revisions.go insertRevision q.Exec(...): %w", err)
+	}
+
+	return nil
+}
+
+// Revisions lists the revisions of an order, oldest first, without their
+// snapshots.
+func (s *Storage) Revisions(ctx context.Context, orderUID string) ([]models.Revision, error) {
+	rows, err := s.reader().Query(ctx, `
+		SELECT `+revisionColumns+`
+		FROM order_revisions
+		WHERE order_uid = $1
+		ORDER BY revision;
+	`, orderUID)
+	if err != nil {
+		return nil, fmt.Errorf("storage_revisions.go Revisions s.reader().Query(...): %w", err)
+	}
+
+	defer rows.Close()
+
+	var revisions []models.Revision
+
+	for rows.Next() {
+		rev, err := scanRevision(rows, false)
+		if err != nil {
+			return nil, fmt.Errorf("storage_revisions.go Revisions: %w", err)
+		}
+
+		revisions = append(revisions, *rev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage_revisions.go Revisions rows.Err(): %w", err)
+	}
+
+	if len(revisions) == 0 {
+		return nil, models.ErrOrderNotFound
+	}
+
+	return revisions, nil
+}
+
+// Revision returns a single revision of an order with its snapshot.
+func (s *Storage) Revision(ctx context.Context, orderUID string, revision int) (*models.Revision, error) {
+	row := s.reader().QueryRow(ctx, `
+		SELECT `+revisionColumns+`, snapshot
+		FROM order_revisions
+		WHERE order_uid = $1 AND revision = $2;
+	`, orderUID, revision)
+
+	rev, err := scanRevision(row, true)
+	if err != nil {
+		return nil, fmt.Errorf("storage_revisions.go Revision(%s, %d): %w", orderUID, revision, err)
+	}
+
+	return rev, nil
+}
+
+// RevisionAt returns the latest revision of an order recorded at or before
+// at, with its snapshot.
+func (s *Storage) RevisionAt(ctx context.Context, orderUID string, at time.Time) (*models.Revision, error) {
+	row := s.reader().QueryRow(ctx, `
+		SELECT `+revisionColumns+`, snapshot
+		FROM order_revisions
+		WHERE order_uid = $1 AND recorded_at <= $2
+		ORDER BY revision DESC
+		LIMIT 1;
+	`, orderUID, at)
+
+	rev, err := scanRevision(row, true)
+	if err != nil {
+		return nil, fmt.Errorf("storage_revisions.go RevisionAt(%s, %s): %w", orderUID, at, err)
+	}
+
+	return rev, nil
+}
+
+// scanRevision reads the revisionColumns, followed by the snapshot when
+// withSnapshot is set.
+func scanRevision(row pgx.Row, withSnapshot bool) (*models.Revision, error) {
+	var (
+		rev      models.Revision
+		snapshot []byte
+	)
+
+	dest := []any{
+		&rev.OrderUID, &rev.Revision, &rev.Version, &rev.ChangeType, &rev.ChangedSections,
+		&rev.Source.Subject, &rev.Source.Sequence, &rev.Source.ReceivedAt, &rev.RecordedAt,
+	}
+
+	if withSnapshot {
+		dest = append(dest, &snapshot)
+	}
+
+	if err := row.Scan(dest...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrRevisionNotFound
+		}
+
+		return nil, fmt.Errorf("scan revision: %w", err)
+	}
+
+	if snapshot != nil {
+		rev.Order = new(models.Order)
+		if err := json.Unmarshal(snapshot, rev.Order); err != nil {
+			return nil, fmt.Errorf("unmarshal revision snapshot: %w", err)
+		}
+	}
+
+	return &rev, nil
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+// Search documents of the orders, delivery and items tables. They must stay
+// identical to the index expressions of 2024_06_09_add_order_search_indexes.sql.
+const (
+	ordersSearchDocument   = `to_tsvector('simple', o.order_uid || ' ' || o.track_number || ' ' || o.customer_id)`
+	deliverySearchDocument = `to_tsvector('simple', d.city || ' ' || d.address)`
+	itemsSearchDocument    = `to_tsvector('simple', i.name || ' ' || i.brand)`
+)
+
+// searchQueries turns the search text in $1 into its words, lexemes, and a
+// prefix query any_query matching any of them. Both are NULL when the text
+// has no words, which matches nothing.
+const searchQueries = `
+		q AS (
+			SELECT
+				array_agg(lexeme) AS lexemes,
+				to_tsquery('simple', string_agg(quote_literal(lexeme) || ':*', ' | ')) AS any_query
+			FROM unnest(tsvector_to_array(to_tsvector('simple', $1))) AS lexeme
+		)`
+
+// searchFields are the highlighted fields, in the order highlights are
+// reported.
+var searchFields = []struct{ field, table, column string }{
+	{models.SearchFieldOrderUID, "orders", "order_uid"},
+	{models.SearchFieldTrackNumber, "orders", "track_number"},
+	{models.SearchFieldCustomerID, "orders", "customer_id"},
+	{models.SearchFieldDeliveryCity, "delivery", "city"},
+	{models.SearchFieldDeliveryAddress, "delivery", "address"},
+	{models.SearchFieldItemName, "items", "name"},
+	{models.SearchFieldItemBrand, "items", "brand"},
+}
+
+// SearchOrders returns a page of the live orders matching a full-text
+// search, ranked by the sum of the ranks of their matching documents. The
+// documents are matched on any word, using their indexes, and an order is
+// kept when its documents together contain every word, so a search may
+// combine e.g. a brand with a city. Like ListOrders, pagination is
+// keyset-based, on rank and order_uid.
+func (s *Storage) SearchOrders(ctx context.Context, query models.SearchQuery) (*models.SearchPage, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := query.After()
+	if err != nil {
+		return nil, fmt.Errorf("storage_search.go SearchOrders: %w", err)
+	}
+
+	db := s.reader()
+
+	args := []any{query.Text, query.Limit + 1}
+	after := ""
+
+	if cursor != nil {
+		args = append(args, cursor.Rank, cursor.OrderUID)
+		after = "WHERE rank < $3 OR (rank = $3 AND order_uid > $4)"
+	}
+
+	rows, err := db.Query(ctx, `
+		WITH`+searchQueries+`,
+		hits AS (
+			SELECT o.order_uid, `+ordersSearchDocument+` AS document,
+				ts_rank(`+ordersSearchDocument+`, q.any_query) AS rank
+			FROM orders o, q
+			WHERE o.deleted_at IS NULL AND `+ordersSearchDocument+` @@ q.any_query
+			UNION ALL
+			SELECT d.order_uid, `+deliverySearchDocument+`, ts_rank(`+deliverySearchDocument+`, q.any_query)
+			FROM delivery d, q
+			WHERE `+deliverySearchDocument+` @@ q.any_query
+			UNION ALL
+			SELECT i.order_uid, `+itemsSearchDocument+`, ts_rank(`+itemsSearchDocument+`, q.any_query)
+			FROM items i, q
+			WHERE `+itemsSearchDocument+` @@ q.any_query
+		),
+		complete AS (
+			SELECT h.order_uid
+			FROM hits h, q, unnest(q.lexemes) AS lexeme
+			WHERE h.document @@ to_tsquery('simple', quote_literal(lexeme) || ':*')
+			GROUP BY h.order_uid
+			HAVING count(DISTINCT lexeme) = max(cardinality(q.lexemes))
+		),
+		ranked AS (
+			SELECT h.order_uid, sum(h.rank)::real AS rank
+			FROM hits h
+			JOIN complete c ON c.order_uid = h.order_uid
+			JOIN orders o ON o.order_uid = h.order_uid AND o.deleted_at IS NULL
+			GROUP BY h.order_uid
+		)
+		SELECT order_uid, rank
+		FROM ranked
+		`+after+`
+		ORDER BY rank DESC, order_uid
+		LIMIT $2;
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("storage_search.go SearchOrders db.Query(...): %w", err)
+	}
+
+	defer rows.Close()
+
+	results := make([]models.SearchResult, 0, query.Limit+1)
+
+	for rows.Next() {
+		var result models.SearchResult
+
+		if err := rows.Scan(&result.Order.OrderUID, &result.Rank); err != nil {
+			return nil, fmt.Errorf("storage_search.go SearchOrders rows.Scan(...): %w", err)
+		}
+
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage_search.go SearchOrders rows.Err(...): %w", err)
+	}
+
+	page := &models.SearchPage{Results: results}
+
+	if len(results) > query.Limit {
+		page.Results = results[:query.Limit]
+
+		page.NextCursor, err = query.NextCursor(page.Results[query.Limit-1])
+		if err != nil {
+			return nil, fmt.Errorf("storage_search.go SearchOrders: %w", err)
+		}
+	}
+
+	page.Results, err = s.fillSearchResults(ctx, db, query.Text, page.Results)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// fillSearchResults loads the orders and highlights of a page of results
+// holding only order_uids and ranks. Results whose order was deleted in the
+// meantime are dropped.
+func (s *Storage) fillSearchResults(
+	ctx context.Context, db Querier, text string, results []models.SearchResult,
+) ([]models.SearchResult, error) {
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	uids := make([]string, 0, len(results))
+	index := make(map[string]int, len(results))
+
+	for i, result := range results {
+		uids = append(uids, result.Order.OrderUID)
+		index[result.Order.OrderUID] = i
+	}
+
+	rows, err := db.Query(ctx, orderColumns+`
+		WHERE o.order_uid = ANY($1) AND o.deleted_at IS NULL;
+	`, uids)
+	if err != nil {
+		return nil, fmt.Errorf("storage_search.go fillSearchResults db.Query(...): %w", err)
+	}
+
+	defer rows.Close()
+
+	orders, err := scanOrders(rows, len(uids))
+	if err != nil {
+		return nil, fmt.Errorf("storage_search.go fillSearchResults scanOrders(...): %w", err)
+	}
+
+	if err := s.attachItems(ctx, db, orders); err != nil {
+		return nil, err
+	}
+
+	found := make([]bool, len(results))
+
+	for _, order := range orders {
+		i := index[order.OrderUID]
+		results[i].Order = order
+		results[i].Highlights = []models.Highlight{}
+		found[i] = true
+	}
+
+	if err := s.attachHighlights(ctx, db, text, uids, results, index); err != nil {
+		return nil, err
+	}
+
+	kept := results[:0]
+
+	for i, result := range results {
+		if found[i] {
+			kept = append(kept, result)
+		}
+	}
+
+	return kept, nil
+}
+
+// attachHighlights marks the words of text in every field of the results
+// that contains any of them.
+func (s *Storage) attachHighlights(
+	ctx context.Context, db Querier, text string, uids []string,
+	results []models.SearchResult, index map[string]int,
+) error {
+	fields := make([]string, 0, len(searchFields))
+
+	for pos, f := range searchFields {
+		live := ""
+		if f.table == "orders" {
+			live = " AND deleted_at IS NULL"
+		}
+
+		fields = append(fields, fmt.Sprintf(
+			"SELECT order_uid, %d AS pos, '%s' AS field, %s AS text FROM %s WHERE order_uid = ANY($2)%s",
+			pos, f.field, f.column, f.table, live))
+	}
+
+	rows, err := db.Query(ctx, `
+		WITH`+searchQueries+`,
+		fields AS (
+			`+strings.Join(fields, "\n\t\t\tUNION ALL ")+`
+		)
+		SELECT DISTINCT f.order_uid, f.pos, f.field,
+			ts_headline('simple', f.text, q.any_query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')
+		FROM fields f, q
+		WHERE to_tsvector('simple', f.text) @@ q.any_query
+		ORDER BY f.order_uid, f.pos, 4;
+	`, text, uids)
+	if err != nil {
+		return fmt.Errorf("storage_search.go attachHighlights db.Query(...): %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			orderUID  string
+			pos       int
+			highlight models.Highlight
+		)
+
+		if err := rows.Scan(&orderUID, &pos, &highlight.Field, &highlight.Fragment); err != nil {
+			return fmt.Errorf("storage_search.go attachHighlights rows.Scan(...): %w", err)
+		}
+
+		result := &results[index[orderUID]]
+		result.Highlights = append(result.Highlights, highlight)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("storage_search.go attachHighlights rows.Err(...): %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+const defaultStreamChunkSize = 1000
+
+// StreamOrders reads the live orders selected by stream chunk by chunk and
+// hands every chunk to fn. Chunks are keyed by order_uid, so only one chunk
+// is held in memory at a time and every query is an index range scan. An
+// error returned by fn stops the scan and is returned wrapped.
+func (s *Storage) StreamOrders(
+	ctx context.Context, stream models.OrderStream, fn func([]models.Order) error,
+) error {
+	if stream.ChunkSize <= 0 {
+		stream.ChunkSize = defaultStreamChunkSize
+	}
+
+	if stream.Partitions > 1 && (stream.Partition < 0 || stream.Partition >= stream.Partitions) {
+		return fmt.Errorf("%w: partition %d out of %d", models.ErrInvalidQuery, stream.Partition, stream.Partitions)
+	}
+
+	var after string
+
+	for {
+		sql, args := buildStreamQuery(stream, after)
+
+		db := s.reader()
+
+		rows, err := db.Query(ctx, sql, args...)
+		if err != nil {
+			return fmt.Errorf("storage_stream.go StreamOrders db.Query(...): %w", err)
+		}
+
+		orders, err := scanOrders(rows, stream.ChunkSize)
+		rows.Close()
+
+		if err != nil {
+			return fmt.Errorf("storage_stream.go StreamOrders scanOrders(...): %w", err)
+		}
+
+		if len(orders) == 0 {
+			return nil
+		}
+
+		if err := s.attachItems(ctx, db, orders); err != nil {
+			return err
+		}
+
+		if err := fn(orders); err != nil {
+			return fmt.Errorf("storage_stream.go StreamOrders fn(...): %w", err)
+		}
+
+		if len(orders) < stream.ChunkSize {
+			return nil
+		}
+
+		after = orders[len(orders)-1].OrderUID
+	}
+}
+
+func buildStreamQuery(stream models.OrderStream, after string) (string, []any) {
+	conds := []string{"o.deleted_at IS NULL", "o.order_uid > $1"}
+	args := []any{after}
+
+	if !stream.CreatedFrom.IsZero() {
+		args = append(args, stream.CreatedFrom)
+		conds = append(conds, fmt.Sprintf("o.date_created >= $%d", len(args)))
+	}
+
+	if stream.Partitions > 1 {
+		args = append(args, stream.Partitions, stream.Partition)
+		conds = append(conds, fmt.Sprintf("(hashtext(o.order_uid) & 2147483647) %% $%d = $%d", len(args)-1, len(args)))
+	}
+
+	args = append(args, stream.ChunkSize)
+
+	return orderColumns + `
+		WHERE ` + strings.Join(conds, " AND ") + `
+		ORDER BY o.order_uid
+		LIMIT ` + fmt.Sprintf("$%d", len(args)) + `;`, args
+}
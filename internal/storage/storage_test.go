@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -55,7 +58,7 @@ func (s *StorageSuite) truncateTables() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	tables := []string{"items", "payment", "delivery", "orders"}
+	tables := []string{"order_revisions", "order_outbox", "items", "payment", "delivery", "orders"}
 	for _, table := range tables {
 		_, err := s.storage.DB().Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s RESTART IDENTITY CASCADE", table))
 		if err != nil {
@@ -90,7 +93,7 @@ func (s *StorageSuite) TestUpsert() {
 			Transaction: "TX1234567890",
 			Currency:    "USD",
 			Provider:    "TestProvider",
-			Amount:      150.00,
+			Amount:      models.MustParseMoney("150.00"),
 			PaymentDT:   time.Now(),
 		},
 		Items: []models.Item{
@@ -98,7 +101,7 @@ func (s *StorageSuite) TestUpsert() {
 				ChrtID:      1,
 				OrderUID:    "uniqueOrderID123",
 				TrackNumber: "TN1234567890",
-				Price:       100.00,
+				Price:       models.MustParseMoney("100.00"),
 				Name:        "Test Item 1",
 				NMID:        1001,
 				Brand:       "TestBrand",
@@ -108,7 +111,7 @@ func (s *StorageSuite) TestUpsert() {
 				ChrtID:      2,
 				OrderUID:    "uniqueOrderID123",
 				TrackNumber: "TN1234567890",
-				Price:       50.00,
+				Price:       models.MustParseMoney("50.00"),
 				Name:        "Test Item 2",
 				NMID:        2002,
 				Brand:       "BrandTest",
@@ -171,7 +174,7 @@ func (s *StorageSuite) TestGet() {
 			Transaction: "TX1234567890",
 			Currency:    "USD",
 			Provider:    "TestProvider",
-			Amount:      150.00,
+			Amount:      models.MustParseMoney("150.00"),
 			PaymentDT:   time.Now(),
 		},
 		Items: []models.Item{
@@ -179,7 +182,7 @@ func (s *StorageSuite) TestGet() {
 				OrderUID:    "uniqueOrderID123",
 				ChrtID:      1,
 				TrackNumber: "TN1234567890",
-				Price:       100.00,
+				Price:       models.MustParseMoney("100.00"),
 				Name:        "Test Item 1",
 				NMID:        1001,
 				Brand:       "TestBrand",
@@ -189,7 +192,7 @@ func (s *StorageSuite) TestGet() {
 				OrderUID:    "uniqueOrderID123",
 				ChrtID:      2,
 				TrackNumber: "TN1234567890",
-				Price:       50.00,
+				Price:       models.MustParseMoney("50.00"),
 				Name:        "Test Item 2",
 				NMID:        2002,
 				Brand:       "BrandTest",
@@ -281,12 +284,12 @@ func (s *StorageSuite) TestUpsertPayment() {
 		RequestID:    "RQ1234567890",
 		Currency:     "USD",
 		Provider:     "TestProvider",
-		Amount:       100.50,
+		Amount:       models.MustParseMoney("100.50"),
 		PaymentDT:    time.Now(),
 		Bank:         "TestBank",
-		DeliveryCost: 5.00,
-		GoodsTotal:   95.50,
-		CustomFee:    0.00,
+		DeliveryCost: models.MustParseMoney("5.00"),
+		GoodsTotal:   models.MustParseMoney("95.50"),
+		CustomFee:    models.MustParseMoney("0.00"),
 	}
 
 	s.Run("Insertion of a new payment", func() {
@@ -297,11 +300,11 @@ func (s *StorageSuite) TestUpsertPayment() {
 	})
 
 	s.Run("Updating the existing payment", func() {
-		payment.Amount = 200.00
+		payment.Amount = models.MustParseMoney("200.00")
 		updatedPayment, err := s.storage.UpsertPayment(s.ctx, s.storage.DB(), payment)
 		s.Require().NoError(err, "Upsert should not fail on update")
 		s.Require().NotNil(updatedPayment, "Updated payment should not be nil")
-		s.Require().Equal(200.00, updatedPayment.Amount, "Payment amount should be updated")
+		s.Require().Equal(models.MustParseMoney("200.00"), updatedPayment.Amount, "Payment amount should be updated")
 	})
 }
 
@@ -311,12 +314,12 @@ func (s *StorageSuite) TestUpsertItems() {
 			OrderUID:    "testUID123",
 			ChrtID:      101,
 			TrackNumber: "TN1234567890",
-			Price:       29.99,
+			Price:       models.MustParseMoney("29.99"),
 			RID:         "RID1234567890",
 			Name:        "Test Item 1",
 			Sale:        10,
 			Size:        "M",
-			TotalPrice:  26.99,
+			TotalPrice:  models.MustParseMoney("26.99"),
 			NMID:        1001,
 			Brand:       "TestBrand",
 			Status:      0,
@@ -325,12 +328,12 @@ func (s *StorageSuite) TestUpsertItems() {
 			OrderUID:    "testUID123",
 			ChrtID:      102,
 			TrackNumber: "TN0987654321",
-			Price:       39.99,
+			Price:       models.MustParseMoney("39.99"),
 			RID:         "RID0987654321",
 			Name:        "Test Item 2",
 			Sale:        0,
 			Size:        "L",
-			TotalPrice:  39.99,
+			TotalPrice:  models.MustParseMoney("39.99"),
 			NMID:        1002,
 			Brand:       "TestBrand",
 			Status:      1,
@@ -338,24 +341,24 @@ func (s *StorageSuite) TestUpsertItems() {
 	}
 
 	s.Run("Insertion of new items", func() {
-		insertedItems, err := s.storage.UpsertItems(s.ctx, s.storage.DB(), *items)
+		insertedItems, err := s.storage.UpsertItems(s.ctx, s.storage.DB(), "testUID123", *items)
 		s.Require().NoError(err, "Upsert should not fail on insertion")
 		s.Require().NotNil(insertedItems, "Inserted items should not be nil")
 		s.Require().Len(*insertedItems, 2, "Should insert two items")
 	})
 
 	s.Run("Updating the existing items", func() {
-		(*items)[0].Price = 19.99
-		(*items)[0].TotalPrice = 17.99
+		(*items)[0].Price = models.MustParseMoney("19.99")
+		(*items)[0].TotalPrice = models.MustParseMoney("17.99")
 		(*items)[0].Sale = 20
 
-		updatedItems, err := s.storage.UpsertItems(s.ctx, s.storage.DB(), *items)
+		updatedItems, err := s.storage.UpsertItems(s.ctx, s.storage.DB(), "testUID123", *items)
 		s.Require().NoError(err, "Upsert should not fail on update")
 		s.Require().NotNil(updatedItems, "Updated items should not be nil")
 		s.Require().Len(*updatedItems, 2, "Should maintain two items")
 
-		s.Require().Equal(19.99, (*updatedItems)[0].Price, "Item price should be updated")
-		s.Require().Equal(17.99, (*updatedItems)[0].TotalPrice, "Total price should be updated")
+		s.Require().Equal(models.MustParseMoney("19.99"), (*updatedItems)[0].Price, "Item price should be updated")
+		s.Require().Equal(models.MustParseMoney("17.99"), (*updatedItems)[0].TotalPrice, "Total price should be updated")
 		s.Require().Equal(20, (*updatedItems)[0].Sale, "Sale percentage should be updated")
 	})
 }
@@ -444,12 +447,12 @@ func (s *StorageSuite) TestGetPayment() {
 		RequestID:    "RQ1234567890",
 		Currency:     "USD",
 		Provider:     "TestProvider",
-		Amount:       100.50,
+		Amount:       models.MustParseMoney("100.50"),
 		PaymentDT:    time.Now(),
 		Bank:         "TestBank",
-		DeliveryCost: 5.00,
-		GoodsTotal:   95.50,
-		CustomFee:    0.00,
+		DeliveryCost: models.MustParseMoney("5.00"),
+		GoodsTotal:   models.MustParseMoney("95.50"),
+		CustomFee:    models.MustParseMoney("0.00"),
 	}
 
 	order := &models.Order{
@@ -492,12 +495,12 @@ func (s *StorageSuite) TestGetItems() {
 			OrderUID:    "testUID123",
 			ChrtID:      1001,
 			TrackNumber: "TN1234567890",
-			Price:       299.99,
+			Price:       models.MustParseMoney("299.99"),
 			RID:         "RID123456",
 			Name:        "Widget A",
 			Sale:        10,
 			Size:        "M",
-			TotalPrice:  269.99,
+			TotalPrice:  models.MustParseMoney("269.99"),
 			NMID:        501,
 			Brand:       "BrandX",
 			Status:      1,
@@ -506,12 +509,12 @@ func (s *StorageSuite) TestGetItems() {
 			OrderUID:    "testUID123",
 			ChrtID:      1002,
 			TrackNumber: "TN0987654321",
-			Price:       159.49,
+			Price:       models.MustParseMoney("159.49"),
 			RID:         "RID654321",
 			Name:        "Widget B",
 			Sale:        15,
 			Size:        "L",
-			TotalPrice:  135.57,
+			TotalPrice:  models.MustParseMoney("135.57"),
 			NMID:        502,
 			Brand:       "BrandY",
 			Status:      1,
@@ -533,7 +536,7 @@ func (s *StorageSuite) TestGetItems() {
 	_, err := s.storage.UpsertOrder(s.ctx, s.storage.DB(), order)
 	s.Require().NoError(err, "Insertion for test setup should not fail")
 
-	_, err = s.storage.UpsertItems(s.ctx, s.storage.DB(), items)
+	_, err = s.storage.UpsertItems(s.ctx, s.storage.DB(), "testUID123", items)
 	s.Require().NoError(err, "Insertion for test setup of items should not fail")
 
 	s.Run("Retrieve existing items", func() {
@@ -555,3 +558,761 @@ func (s *StorageSuite) TestGetItems() {
 		s.Require().Len(all, 2, "Should retrieve two items")
 	})
 }
+
+func (s *StorageSuite) TestUpsertOrderVersioning() {
+	order := &models.Order{
+		OrderUID:        "versionedUID123",
+		TrackNumber:     "TN1",
+		CustomerID:      "Cust123",
+		DateCreated:     time.Now(),
+		DeliveryService: "TestService",
+		Locale:          "en",
+		Version:         10,
+	}
+
+	_, err := s.storage.UpsertOrder(s.ctx, s.storage.DB(), order)
+	s.Require().NoError(err, "Insertion for test setup should not fail")
+
+	s.Run("Older version is ignored", func() {
+		older := *order
+		older.TrackNumber = "TN0"
+		older.Version = 9
+
+		_, err := s.storage.UpsertOrder(s.ctx, s.storage.DB(), &older)
+		s.Require().ErrorIs(err, models.ErrStaleVersion)
+
+		retrievedOrder, err := s.storage.GetOrder(s.ctx, s.storage.DB(), order.OrderUID)
+		s.Require().NoError(err)
+		s.Require().Equal("TN1", retrievedOrder.TrackNumber, "Track number should be unchanged")
+		s.Require().Equal(uint64(10), retrievedOrder.Version)
+	})
+
+	s.Run("Newer version is applied", func() {
+		newer := *order
+		newer.TrackNumber = "TN2"
+		newer.Version = 11
+
+		updatedOrder, err := s.storage.UpsertOrder(s.ctx, s.storage.DB(), &newer)
+		s.Require().NoError(err)
+		s.Require().Equal("TN2", updatedOrder.TrackNumber)
+		s.Require().Equal(uint64(11), updatedOrder.Version)
+	})
+}
+
+func (s *StorageSuite) TestOutbox() {
+	s.Require().NoError(s.truncateTables())
+
+	order := &models.Order{
+		OrderUID:        "outboxUID123",
+		TrackNumber:     "TN1",
+		CustomerID:      "Cust123",
+		DateCreated:     time.Now(),
+		DeliveryService: "TestService",
+		Locale:          "en",
+		Delivery:        models.Delivery{OrderUID: "outboxUID123", Name: "John Doe"},
+		Payment:         models.Payment{OrderUID: "outboxUID123", Transaction: "TX1", PaymentDT: time.Now()},
+		Items:           []models.Item{{ChrtID: 1, OrderUID: "outboxUID123", Name: "Item"}},
+	}
+
+	_, err := s.storage.Upsert(s.ctx, order)
+	s.Require().NoError(err)
+
+	_, err = s.storage.Upsert(s.ctx, order)
+	s.Require().NoError(err, "Unchanged upsert should not fail")
+
+	updated := *order
+	updated.Delivery.City = "TestCity"
+
+	_, err = s.storage.Upsert(s.ctx, &updated)
+	s.Require().NoError(err)
+
+	var events []models.OrderEvent
+
+	sent, err := s.storage.RelayOutbox(s.ctx, 10, func(_ context.Context, event models.OrderEvent) error {
+		events = append(events, event)
+
+		return nil
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(2, sent, "Unchanged upsert should not produce an event")
+
+	s.Require().Equal(models.ChangeTypeCreated, events[0].ChangeType)
+	s.Require().Equal(models.ChangeTypeUpdated, events[1].ChangeType)
+	s.Require().Equal([]string{models.SectionDelivery}, events[1].ChangedSections)
+
+	sent, err = s.storage.RelayOutbox(s.ctx, 10, func(context.Context, models.OrderEvent) error {
+		s.Fail("Sent events should not be relayed again")
+
+		return nil
+	})
+	s.Require().NoError(err)
+	s.Require().Zero(sent)
+
+	purged, err := s.storage.PurgeOutbox(s.ctx, time.Now().Add(time.Hour))
+	s.Require().NoError(err)
+	s.Require().Equal(int64(2), purged)
+}
+
+func (s *StorageSuite) TestDelete() {
+	newOrder := func(uid string, version uint64) *models.Order {
+		return &models.Order{
+			OrderUID:        uid,
+			TrackNumber:     "TN1",
+			CustomerID:      "Cust123",
+			DateCreated:     time.Now(),
+			DeliveryService: "TestService",
+			Locale:          "en",
+			Version:         version,
+			Delivery:        models.Delivery{OrderUID: uid, Name: "John Doe"},
+			Payment:         models.Payment{OrderUID: uid, Transaction: "TX1", PaymentDT: time.Now()},
+			Items:           []models.Item{{ChrtID: 1, OrderUID: uid, Name: "Item"}},
+		}
+	}
+
+	s.Run("Soft delete hides the order and keeps its version", func() {
+		order := newOrder("softDeleteUID", 5)
+		_, err := s.storage.Upsert(s.ctx, order)
+		s.Require().NoError(err)
+
+		err = s.storage.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID, Version: 4})
+		s.Require().ErrorIs(err, models.ErrStaleVersion)
+
+		s.Require().NoError(s.storage.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID, Version: 6}))
+
+		_, err = s.storage.Get(s.ctx, order.OrderUID)
+		s.Require().Error(err, "Soft-deleted order should be hidden")
+
+		err = s.storage.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID})
+		s.Require().ErrorIs(err, models.ErrOrderNotFound)
+
+		_, err = s.storage.Upsert(s.ctx, newOrder(order.OrderUID, 5))
+		s.Require().ErrorIs(err, models.ErrStaleVersion, "Older update should not resurrect the order")
+
+		_, err = s.storage.Upsert(s.ctx, newOrder(order.OrderUID, 7))
+		s.Require().NoError(err)
+
+		_, err = s.storage.Get(s.ctx, order.OrderUID)
+		s.Require().NoError(err, "Newer update should resurrect the order")
+	})
+
+	s.Run("Hard delete removes every row", func() {
+		order := newOrder("hardDeleteUID", 0)
+		_, err := s.storage.Upsert(s.ctx, order)
+		s.Require().NoError(err)
+
+		s.Require().NoError(s.storage.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID, Hard: true}))
+
+		_, err = s.storage.GetItems(s.ctx, s.storage.DB(), order.OrderUID)
+		s.Require().ErrorIs(err, models.ErrItemsNotFound)
+
+		err = s.storage.Delete(s.ctx, models.Tombstone{OrderUID: order.OrderUID, Hard: true})
+		s.Require().ErrorIs(err, models.ErrOrderNotFound)
+	})
+}
+
+func (s *StorageSuite) TestUpsertItemSet() {
+	newOrder := func(uid string, chrtIDs ...int) *models.Order {
+		order := &models.Order{
+			OrderUID:        uid,
+			TrackNumber:     "TN1",
+			CustomerID:      "Cust123",
+			DateCreated:     time.Now(),
+			DeliveryService: "TestService",
+			Locale:          "en",
+			Delivery:        models.Delivery{OrderUID: uid, Name: "John Doe"},
+			Payment:         models.Payment{OrderUID: uid, Transaction: "TX1", PaymentDT: time.Now()},
+		}
+
+		for _, chrtID := range chrtIDs {
+			order.Items = append(order.Items, models.Item{ChrtID: chrtID, OrderUID: uid, Name: "Item"})
+		}
+
+		return order
+	}
+
+	chrtIDs := func(items []models.Item) []int {
+		ids := make([]int, 0, len(items))
+		for _, item := range items {
+			ids = append(ids, item.ChrtID)
+		}
+
+		return ids
+	}
+
+	s.Run("Orders sharing a chrt id keep their own items", func() {
+		_, err := s.storage.Upsert(s.ctx, newOrder("itemSetUID1", 7001, 7002))
+		s.Require().NoError(err)
+
+		_, err = s.storage.Upsert(s.ctx, newOrder("itemSetUID2", 7001))
+		s.Require().NoError(err)
+
+		first, err := s.storage.GetItems(s.ctx, s.storage.DB(), "itemSetUID1")
+		s.Require().NoError(err)
+		s.Require().ElementsMatch([]int{7001, 7002}, chrtIDs(first))
+
+		second, err := s.storage.GetItems(s.ctx, s.storage.DB(), "itemSetUID2")
+		s.Require().NoError(err)
+		s.Require().ElementsMatch([]int{7001}, chrtIDs(second))
+	})
+
+	s.Run("Dropped items are removed", func() {
+		_, err := s.storage.Upsert(s.ctx, newOrder("itemSetUID1", 7002, 7003))
+		s.Require().NoError(err)
+
+		items, err := s.storage.GetItems(s.ctx, s.storage.DB(), "itemSetUID1")
+		s.Require().NoError(err)
+		s.Require().ElementsMatch([]int{7002, 7003}, chrtIDs(items))
+
+		other, err := s.storage.GetItems(s.ctx, s.storage.DB(), "itemSetUID2")
+		s.Require().NoError(err)
+		s.Require().ElementsMatch([]int{7001}, chrtIDs(other), "Other orders must not be touched")
+	})
+}
+
+func (s *StorageSuite) TestRevisions() {
+	uid := "revisionUID123"
+	order := &models.Order{
+		OrderUID:        uid,
+		TrackNumber:     "TN1",
+		CustomerID:      "Cust123",
+		DateCreated:     time.Now(),
+		DeliveryService: "TestService",
+		Locale:          "en",
+		Version:         1,
+		Delivery:        models.Delivery{OrderUID: uid, Name: "John Doe"},
+		Payment:         models.Payment{OrderUID: uid, Transaction: "TX1", PaymentDT: time.Now()},
+		Items:           []models.Item{{ChrtID: 1, OrderUID: uid, Name: "Item"}},
+		Source:          models.Source{Subject: "orders", Sequence: 41},
+	}
+
+	_, err := s.storage.Upsert(s.ctx, order)
+	s.Require().NoError(err)
+
+	_, err = s.storage.Upsert(s.ctx, order)
+	s.Require().NoError(err, "Unchanged upsert should not fail")
+
+	between := time.Now()
+
+	updated := *order
+	updated.TrackNumber = "TN2"
+	updated.Version = 2
+	updated.Source.Sequence = 42
+
+	_, err = s.storage.Upsert(s.ctx, &updated)
+	s.Require().NoError(err)
+
+	s.Require().NoError(s.storage.Delete(s.ctx, models.Tombstone{OrderUID: uid, Version: 3}))
+
+	revisions, err := s.storage.Revisions(s.ctx, uid)
+	s.Require().NoError(err)
+	s.Require().Len(revisions, 3, "Unchanged upserts are not recorded")
+	s.Require().Equal(models.ChangeTypeCreated, revisions[0].ChangeType)
+	s.Require().Equal(uint64(41), revisions[0].Source.Sequence)
+	s.Require().Equal(models.ChangeTypeUpdated, revisions[1].ChangeType)
+	s.Require().Equal(models.ChangeTypeDeleted, revisions[2].ChangeType)
+	s.Require().Nil(revisions[0].Order, "Listings don't carry snapshots")
+
+	second, err := s.storage.Revision(s.ctx, uid, 2)
+	s.Require().NoError(err)
+	s.Require().Equal("TN2", second.Order.TrackNumber)
+	s.Require().Equal(uint64(2), second.Order.Version)
+
+	_, err = s.storage.Revision(s.ctx, uid, 4)
+	s.Require().ErrorIs(err, models.ErrRevisionNotFound)
+
+	asOf, err := s.storage.RevisionAt(s.ctx, uid, between)
+	s.Require().NoError(err)
+	s.Require().Equal(1, asOf.Revision)
+	s.Require().Equal("TN1", asOf.Order.TrackNumber)
+
+	s.Require().NoError(s.storage.Delete(s.ctx, models.Tombstone{OrderUID: uid, Hard: true}))
+
+	_, err = s.storage.Revisions(s.ctx, uid)
+	s.Require().ErrorIs(err, models.ErrOrderNotFound, "Hard deletes remove the history")
+}
+
+func (s *StorageSuite) TestListOrders() {
+	s.Require().NoError(s.truncateTables())
+
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, brand := range []string{"BrandX", "BrandY", "BrandX", "BrandX", "BrandY"} {
+		uid := fmt.Sprintf("listUID%d", i)
+		provider := "wbpay"
+		if i%2 == 1 {
+			provider = "otherpay"
+		}
+
+		_, err := s.storage.Upsert(s.ctx, &models.Order{
+			OrderUID:        uid,
+			TrackNumber:     "TN1",
+			CustomerID:      "Cust123",
+			DateCreated:     base.Add(time.Duration(i) * time.Hour),
+			DeliveryService: "TestService",
+			Locale:          "en",
+			Delivery:        models.Delivery{OrderUID: uid, Name: "John Doe"},
+			Payment:         models.Payment{OrderUID: uid, Transaction: "TX1", Currency: "USD", Provider: provider, PaymentDT: base},
+			Items:           []models.Item{{ChrtID: 1, OrderUID: uid, Name: "Item", Brand: brand}},
+		})
+		s.Require().NoError(err)
+	}
+
+	s.Run("Pages follow the sort order without gaps", func() {
+		var uids []string
+
+		query := models.OrderQuery{Sort: models.SortDateCreated, Desc: true, Limit: 2}
+
+		for {
+			page, err := s.storage.ListOrders(s.ctx, query)
+			s.Require().NoError(err)
+
+			for _, order := range page.Orders {
+				uids = append(uids, order.OrderUID)
+				s.Require().Len(order.Items, 1)
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+
+			query.Cursor = page.NextCursor
+		}
+
+		s.Require().Equal([]string{"listUID4", "listUID3", "listUID2", "listUID1", "listUID0"}, uids)
+	})
+
+	s.Run("Filters", func() {
+		page, err := s.storage.ListOrders(s.ctx, models.OrderQuery{
+			Filter: models.OrderFilter{ItemBrand: "BrandX", PaymentProvider: "wbpay", CreatedFrom: base.Add(time.Hour)},
+			Sort:   models.SortOrderUID,
+		})
+		s.Require().NoError(err)
+		s.Require().Len(page.Orders, 2)
+		s.Require().Equal("listUID2", page.Orders[0].OrderUID)
+		s.Require().Equal("listUID4", page.Orders[1].OrderUID)
+		s.Require().Empty(page.NextCursor)
+	})
+}
+
+func (s *StorageSuite) TestSearchOrders() {
+	s.Require().NoError(s.truncateTables())
+
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, order := range []struct{ city, item, brand string }{
+		{"Moscow", "Mascara", "Vivienne Sabo"},
+		{"Kazan", "Lipstick", "Vivienne Sabo"},
+		{"Moscow", "Vivienne Sabo Mascara", "Vivienne Sabo"},
+		{"Saint Petersburg", "Perfume", "Chanel"},
+	} {
+		uid := fmt.Sprintf("searchUID%d", i)
+
+		_, err := s.storage.Upsert(s.ctx, &models.Order{
+			OrderUID:    uid,
+			TrackNumber: fmt.Sprintf("WBTRACK%d", i),
+			CustomerID:  "Cust123",
+			DateCreated: base.Add(time.Duration(i) * time.Hour),
+			Locale:      "en",
+			Delivery:    models.Delivery{OrderUID: uid, Name: "John Doe", City: order.city, Address: "Lenina 1"},
+			Payment:     models.Payment{OrderUID: uid, Transaction: "TX1", Currency: "USD", PaymentDT: base},
+			Items:       []models.Item{{ChrtID: 1, OrderUID: uid, Name: order.item, Brand: order.brand}},
+		})
+		s.Require().NoError(err)
+	}
+
+	s.Require().NoError(s.storage.Delete(s.ctx, models.Tombstone{OrderUID: "searchUID1"}))
+
+	s.Run("Best matches come first", func() {
+		page, err := s.storage.SearchOrders(s.ctx, models.SearchQuery{Text: "vivienne"})
+		s.Require().NoError(err)
+		s.Require().Len(page.Results, 2)
+		s.Require().Equal("searchUID2", page.Results[0].Order.OrderUID)
+		s.Require().Equal("searchUID0", page.Results[1].Order.OrderUID)
+		s.Require().Greater(page.Results[0].Rank, page.Results[1].Rank)
+		s.Require().Len(page.Results[1].Order.Items, 1)
+		s.Require().Equal([]models.Highlight{
+			{Field: models.SearchFieldItemBrand, Fragment: "<mark>Vivienne</mark> Sabo"},
+		}, page.Results[1].Highlights)
+	})
+
+	s.Run("Words match as prefixes", func() {
+		page, err := s.storage.SearchOrders(s.ctx, models.SearchQuery{Text: "mosc len"})
+		s.Require().NoError(err)
+		s.Require().Len(page.Results, 2)
+		s.Require().Equal([]models.Highlight{
+			{Field: models.SearchFieldDeliveryCity, Fragment: "<mark>Moscow</mark>"},
+			{Field: models.SearchFieldDeliveryAddress, Fragment: "<mark>Lenina</mark> 1"},
+		}, page.Results[0].Highlights)
+
+		page, err = s.storage.SearchOrders(s.ctx, models.SearchQuery{Text: "moscow chanel"})
+		s.Require().NoError(err)
+		s.Require().Empty(page.Results, "Every word must match")
+	})
+
+	s.Run("Words match across documents", func() {
+		page, err := s.storage.SearchOrders(s.ctx, models.SearchQuery{Text: "vivienne moscow"})
+		s.Require().NoError(err)
+		s.Require().Len(page.Results, 2)
+		s.Require().Equal("searchUID2", page.Results[0].Order.OrderUID)
+		s.Require().Equal("searchUID0", page.Results[1].Order.OrderUID)
+		s.Require().Equal([]models.Highlight{
+			{Field: models.SearchFieldDeliveryCity, Fragment: "<mark>Moscow</mark>"},
+			{Field: models.SearchFieldItemBrand, Fragment: "<mark>Vivienne</mark> Sabo"},
+		}, page.Results[1].Highlights)
+
+		page, err = s.storage.SearchOrders(s.ctx, models.SearchQuery{Text: "chanel petersburg wbtrack3"})
+		s.Require().NoError(err)
+		s.Require().Len(page.Results, 1)
+		s.Require().Equal("searchUID3", page.Results[0].Order.OrderUID)
+	})
+
+	s.Run("Identifiers", func() {
+		page, err := s.storage.SearchOrders(s.ctx, models.SearchQuery{Text: "WBTRACK3"})
+		s.Require().NoError(err)
+		s.Require().Len(page.Results, 1)
+		s.Require().Equal("searchUID3", page.Results[0].Order.OrderUID)
+	})
+
+	s.Run("Pages follow the rank without gaps", func() {
+		var uids []string
+
+		query := models.SearchQuery{Text: "cust123", Limit: 2}
+
+		for {
+			page, err := s.storage.SearchOrders(s.ctx, query)
+			s.Require().NoError(err)
+
+			for _, result := range page.Results {
+				uids = append(uids, result.Order.OrderUID)
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+
+			query.Cursor = page.NextCursor
+		}
+
+		s.Require().Equal([]string{"searchUID0", "searchUID2", "searchUID3"}, uids)
+
+		_, err := s.storage.SearchOrders(s.ctx, models.SearchQuery{Text: "moscow", Cursor: query.Cursor})
+		s.Require().ErrorIs(err, models.ErrInvalidQuery)
+	})
+}
+
+func (s *StorageSuite) TestStreamOrders() {
+	s.Require().NoError(s.truncateTables())
+
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := range 7 {
+		uid := fmt.Sprintf("streamUID%d", i)
+
+		_, err := s.storage.Upsert(s.ctx, &models.Order{
+			OrderUID:        uid,
+			TrackNumber:     "TN1",
+			CustomerID:      "Cust123",
+			DateCreated:     base.Add(time.Duration(i) * time.Hour),
+			DeliveryService: "TestService",
+			Locale:          "en",
+			Delivery:        models.Delivery{OrderUID: uid, Name: "John Doe"},
+			Payment:         models.Payment{OrderUID: uid, Transaction: "TX1", Currency: "USD", PaymentDT: base},
+			Items:           []models.Item{{ChrtID: 1, OrderUID: uid, Name: "Item"}},
+		})
+		s.Require().NoError(err)
+	}
+
+	s.Require().NoError(s.storage.Delete(s.ctx, models.Tombstone{OrderUID: "streamUID6"}))
+
+	stream := func(stream models.OrderStream) ([]string, []int) {
+		var (
+			uids   []string
+			chunks []int
+		)
+
+		err := s.storage.StreamOrders(s.ctx, stream, func(orders []models.Order) error {
+			chunks = append(chunks, len(orders))
+
+			for _, order := range orders {
+				s.Require().Len(order.Items, 1)
+				uids = append(uids, order.OrderUID)
+			}
+
+			return nil
+		})
+		s.Require().NoError(err)
+
+		return uids, chunks
+	}
+
+	s.Run("Chunks in order_uid order skip deleted orders", func() {
+		uids, chunks := stream(models.OrderStream{ChunkSize: 2})
+		s.Require().Equal([]string{
+			"streamUID0", "streamUID1", "streamUID2", "streamUID3", "streamUID4", "streamUID5",
+		}, uids)
+		s.Require().Equal([]int{2, 2, 2}, chunks)
+	})
+
+	s.Run("CreatedFrom", func() {
+		uids, _ := stream(models.OrderStream{ChunkSize: 2, CreatedFrom: base.Add(4 * time.Hour)})
+		s.Require().Equal([]string{"streamUID4", "streamUID5"}, uids)
+	})
+
+	s.Run("Partitions are disjoint and cover every order", func() {
+		var all []string
+
+		for i := range 3 {
+			uids, _ := stream(models.OrderStream{ChunkSize: 2, Partition: i, Partitions: 3})
+			all = append(all, uids...)
+		}
+
+		s.Require().ElementsMatch([]string{
+			"streamUID0", "streamUID1", "streamUID2", "streamUID3", "streamUID4", "streamUID5",
+		}, all)
+	})
+
+	s.Run("An error from fn stops the scan", func() {
+		errStop := errors.New("stop")
+		calls := 0
+
+		err := s.storage.StreamOrders(s.ctx, models.OrderStream{ChunkSize: 2}, func([]models.Order) error {
+			calls++
+
+			return errStop
+		})
+		s.Require().ErrorIs(err, errStop)
+		s.Require().Equal(1, calls)
+	})
+}
+
+// The primary doubles as a replica that never lags, so every read is routed
+// to the replica pool.
+func (s *StorageSuite) TestReplicaReads() {
+	s.Require().NoError(s.truncateTables())
+
+	cfg := config.New("../../.env")
+
+	replicated, err := storage.NewStorage(s.ctx, s.log, cfg.DatabaseURL)
+	s.Require().NoError(err)
+
+	defer replicated.Close()
+
+	s.Require().NoError(replicated.ConnectReplicas(s.ctx, storage.ReplicaOptions{DSNs: []string{cfg.DatabaseURL}}))
+
+	status := replicated.ReplicaStatus()
+	s.Require().Len(status, 1)
+	s.Require().True(status[0].Healthy, status[0].Error)
+	s.Require().Zero(status[0].Lag)
+
+	uid := "replicaUID1"
+
+	_, err = replicated.Upsert(s.ctx, &models.Order{
+		OrderUID:        uid,
+		TrackNumber:     "TN1",
+		CustomerID:      "Cust123",
+		DateCreated:     time.Now(),
+		DeliveryService: "TestService",
+		Locale:          "en",
+		Delivery:        models.Delivery{OrderUID: uid, Name: "John Doe"},
+		Payment:         models.Payment{OrderUID: uid, Transaction: "TX1", Currency: "USD", PaymentDT: time.Now()},
+		Items:           []models.Item{{ChrtID: 1, OrderUID: uid, Name: "Item"}},
+	})
+	s.Require().NoError(err)
+
+	order, err := replicated.Get(s.ctx, uid)
+	s.Require().NoError(err)
+	s.Require().Equal("TN1", order.TrackNumber)
+
+	page, err := replicated.ListOrders(s.ctx, models.OrderQuery{})
+	s.Require().NoError(err)
+	s.Require().Len(page.Orders, 1)
+	s.Require().Len(page.Orders[0].Items, 1)
+
+	revisions, err := replicated.Revisions(s.ctx, uid)
+	s.Require().NoError(err)
+	s.Require().Len(revisions, 1)
+}
+
+func (s *StorageSuite) TestUpsertMovesOrderBetweenMonths() {
+	uid := "movedUID1"
+	order := &models.Order{
+		OrderUID:        uid,
+		TrackNumber:     "TN1",
+		CustomerID:      "Cust123",
+		DateCreated:     time.Date(2024, time.January, 31, 23, 0, 0, 0, time.UTC),
+		DeliveryService: "TestService",
+		Locale:          "en",
+		Version:         3,
+		Delivery:        models.Delivery{OrderUID: uid, Name: "John Doe"},
+		Payment:         models.Payment{OrderUID: uid, Transaction: "TX1", PaymentDT: time.Now()},
+		Items:           []models.Item{{ChrtID: 1, OrderUID: uid, Name: "Item"}},
+	}
+
+	_, err := s.storage.Upsert(s.ctx, order)
+	s.Require().NoError(err)
+
+	moved := *order
+	moved.DateCreated = time.Date(2024, time.February, 1, 1, 0, 0, 0, time.UTC)
+	moved.Version = 2
+
+	_, err = s.storage.Upsert(s.ctx, &moved)
+	s.Require().ErrorIs(err, models.ErrStaleVersion)
+
+	moved.Version = 0
+
+	stored, err := s.storage.Upsert(s.ctx, &moved)
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(3), stored.Version, "Unversioned write should keep the stored version")
+
+	got, err := s.storage.Get(s.ctx, uid)
+	s.Require().NoError(err)
+	s.Require().True(moved.DateCreated.Equal(got.DateCreated))
+	s.Require().Equal("John Doe", got.Delivery.Name)
+	s.Require().Len(got.Items, 1)
+
+	for _, table := range []string{"orders", "delivery", "payment", "items"} {
+		var count int
+
+		err := s.storage.DB().QueryRow(s.ctx, "SELECT count(*) FROM "+table+" WHERE order_uid = $1", uid).Scan(&count)
+		s.Require().NoError(err)
+		s.Require().Equal(1, count, table)
+	}
+}
+
+func (s *StorageSuite) TestArchivePartition() {
+	s.Require().NoError(s.truncateTables())
+
+	month := time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)
+	s.Require().NoError(s.storage.EnsurePartitions(s.ctx, month, 0))
+
+	for _, uid := range []string{"archivedUID1", "archivedUID2"} {
+		_, err := s.storage.Upsert(s.ctx, &models.Order{
+			OrderUID:        uid,
+			TrackNumber:     "TN1",
+			CustomerID:      "Cust123",
+			DateCreated:     month.Add(36 * time.Hour),
+			DeliveryService: "TestService",
+			Locale:          "en",
+			Delivery:        models.Delivery{OrderUID: uid, Name: "John Doe"},
+			Payment: models.Payment{
+				OrderUID: uid, Transaction: "TX1", Amount: models.MustParseMoney("10.50"), PaymentDT: month,
+			},
+			Items: []models.Item{{ChrtID: 1, OrderUID: uid, Name: "Item"}, {ChrtID: 2, OrderUID: uid, Name: "Item"}},
+		})
+		s.Require().NoError(err)
+	}
+
+	partitions, err := s.storage.OrderPartitions(s.ctx)
+	s.Require().NoError(err)
+	s.Require().NotEmpty(partitions)
+	s.Require().Equal(month, partitions[0].Month)
+
+	dir := s.T().TempDir()
+
+	path, err := s.storage.ArchivePartition(s.ctx, month, dir)
+	s.Require().NoError(err)
+	s.Require().FileExists(path)
+	s.Require().Equal(storage.ArchiveFileName(month), filepath.Base(path))
+
+	_, err = s.storage.Get(s.ctx, "archivedUID1")
+	s.Require().ErrorIs(err, models.ErrOrderNotFound)
+
+	_, err = s.storage.ArchivePartition(s.ctx, month, dir)
+	s.Require().ErrorIs(err, models.ErrPartitionNotFound)
+
+	s.Run("Partitions left detached are archived on the next run", func() {
+		detached := month.AddDate(0, 1, 0)
+		s.Require().NoError(s.storage.EnsurePartitions(s.ctx, detached, 0))
+
+		for _, table := range []string{"orders", "delivery", "payment", "items"} {
+			_, err := s.storage.DB().Exec(s.ctx,
+				"ALTER TABLE "+table+" DETACH PARTITION "+table+"_"+detached.Format("y2006m01")+";")
+			s.Require().NoError(err)
+		}
+
+		partitions, err := s.storage.OrderPartitions(s.ctx)
+		s.Require().NoError(err)
+		s.Require().Equal(models.OrderPartition{Month: detached, Detached: true}, partitions[0])
+
+		path, err := s.storage.ArchivePartition(s.ctx, detached, dir)
+		s.Require().NoError(err)
+		s.Require().FileExists(path)
+
+		partitions, err = s.storage.OrderPartitions(s.ctx)
+		s.Require().NoError(err)
+		s.Require().NotEqual(detached, partitions[0].Month)
+	})
+
+	// An order written again after archiving is kept over the archived one.
+	_, err = s.storage.Upsert(s.ctx, &models.Order{
+		OrderUID:        "archivedUID2",
+		TrackNumber:     "TN2",
+		CustomerID:      "Cust123",
+		DateCreated:     time.Now(),
+		DeliveryService: "TestService",
+		Locale:          "en",
+		Delivery:        models.Delivery{OrderUID: "archivedUID2", Name: "Jane Doe"},
+		Payment:         models.Payment{OrderUID: "archivedUID2", Transaction: "TX2", PaymentDT: time.Now()},
+	})
+	s.Require().NoError(err)
+
+	restored, err := s.storage.RestoreArchive(s.ctx, path)
+	s.Require().NoError(err)
+	s.Require().Equal(1, restored)
+
+	order, err := s.storage.Get(s.ctx, "archivedUID1")
+	s.Require().NoError(err)
+	s.Require().True(month.Add(36 * time.Hour).Equal(order.DateCreated))
+	s.Require().Equal(models.MustParseMoney("10.50"), order.Payment.Amount)
+	s.Require().Len(order.Items, 2)
+
+	order, err = s.storage.Get(s.ctx, "archivedUID2")
+	s.Require().NoError(err)
+	s.Require().Equal("TN2", order.TrackNumber)
+	s.Require().Equal("Jane Doe", order.Delivery.Name)
+}
+
+func (s *StorageSuite) TestMigrationStatus() {
+	statuses, err := s.storage.MigrationStatus(s.ctx)
+	s.Require().NoError(err)
+	s.Require().NotEmpty(statuses)
+	s.Require().Equal("2024_05_24_create_tables.sql", statuses[0].ID)
+
+	for _, status := range statuses {
+		s.Require().True(status.Applied, status.ID)
+		s.Require().NotNil(status.AppliedAt, status.ID)
+	}
+
+	applied, err := s.storage.MigrateUp(s.ctx, 0)
+	s.Require().NoError(err)
+	s.Require().Zero(applied, "Applied migrations should not run again")
+
+	moved, err := s.storage.MigrateTo(s.ctx, strings.TrimSuffix(statuses[len(statuses)-1].ID, ".sql"))
+	s.Require().NoError(err)
+	s.Require().Zero(moved)
+
+	_, err = s.storage.MigrateTo(s.ctx, "2000_01_01_missing")
+	s.Require().ErrorIs(err, storage.ErrMigrationNotFound)
+}
+
+func (s *StorageSuite) TestMigrateConcurrently() {
+	var wg sync.WaitGroup
+
+	errs := make([]error, 4)
+
+	for i := range errs {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			errs[i] = s.storage.Migrate()
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		s.Require().NoError(err)
+	}
+}
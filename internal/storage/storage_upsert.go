@@ -2,9 +2,11 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/stsolovey/order_tracker/internal/models"
 )
 
@@ -24,49 +26,132 @@ func (s *Storage) Upsert(ctx context.Context, order *models.Order) (*models.Orde
 		}
 	}()
 
-	orderReturning, err := s.UpsertOrder(ctx, tx, order)
+	orderReturning, err := s.upsertAll(ctx, tx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("storage.go Upsert committing transaction: %w", err)
+	}
+
+	shouldRollback = false
+
+	return orderReturning, nil
+}
+
+func (s *Storage) upsertAll(ctx context.Context, q Querier, order *models.Order) (*models.Order, error) {
+	if err := lockOrder(ctx, q, order.OrderUID); err != nil {
+		return nil, fmt.Errorf("storage.go Upsert: %w", err)
+	}
+
+	prev, err := s.previousState(ctx, q, order.OrderUID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.go Upsert previous state: %w", err)
+	}
+
+	if order.IfMatch != "" && !models.MatchIfMatch(order.IfMatch, prev) {
+		return nil, fmt.Errorf("storage.go Upsert(%s): %w", order.OrderUID, models.ErrPreconditionFailed)
+	}
+
+	target, err := s.relocateOrder(ctx, q, order)
+	if err != nil {
+		return nil, fmt.Errorf("storage.go Upsert relocate: %w", err)
+	}
+
+	orderReturning, err := s.UpsertOrder(ctx, q, target)
 	if err != nil {
 		return nil, fmt.Errorf("storage.go Upsert order: %w", err)
 	}
 
-	delivery, err := s.UpsertDelivery(ctx, tx, &order.Delivery)
+	delivery, err := s.UpsertDelivery(ctx, q, &order.Delivery)
 	if err != nil {
 		return nil, fmt.Errorf("storage.go Upsert delivery: %w", err)
 	}
 
 	orderReturning.Delivery = *delivery
 
-	payment, err := s.UpsertPayment(ctx, tx, &order.Payment)
+	payment, err := s.UpsertPayment(ctx, q, &order.Payment)
 	if err != nil {
 		return nil, fmt.Errorf("storage.go Upsert payment: %w", err)
 	}
 
 	orderReturning.Payment = *payment
 
-	items, err := s.UpsertItems(ctx, tx, order.Items)
+	items, err := s.UpsertItems(ctx, q, order.OrderUID, order.Items)
 	if err != nil {
 		return nil, fmt.Errorf("storage.go Upsert items: %w", err)
 	}
 
 	orderReturning.Items = *items
 
-	if err := tx.Commit(ctx); err != nil {
-		return nil, fmt.Errorf("storage.go Upsert committing transaction: %w", err)
+	if err := s.enqueueEvent(ctx, q, prev, order, orderReturning.Version); err != nil {
+		return nil, fmt.Errorf("storage.go Upsert outbox: %w", err)
 	}
 
-	shouldRollback = false
+	if err := s.recordRevision(ctx, q, prev, order, orderReturning.Version); err != nil {
+		return nil, fmt.Errorf("storage.go Upsert revision: %w", err)
+	}
 
 	return orderReturning, nil
 }
 
-func (s *Storage) UpsertOrder(ctx context.Context, q Querier, order *models.Order) (*models.Order, error) {
-	query := `
+// lockOrder serializes writes of an order until the transaction ends. The
+// tables are partitioned by date_created, so no unique index covers
+// order_uid alone and concurrent inserts of an order can't conflict.
+func lockOrder(ctx context.Context, q Querier, orderUID string) error {
+	if _, err := q.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0));`, orderUID); err != nil {
+		return fmt.Errorf("storage.go lockOrder(%s): %w", orderUID, err)
+	}
+
+	return nil
+}
+
+// relocateOrder handles an order whose date_created changed: its rows live
+// in another partition, so they are removed and the order is inserted anew.
+// The returned order carries the version to insert, which never goes below
+// the stored one.
+func (s *Storage) relocateOrder(ctx context.Context, q Querier, order *models.Order) (*models.Order, error) {
+	var version uint64
+
+	err := q.QueryRow(ctx, `
+		SELECT version FROM orders WHERE order_uid = $1 AND date_created <> $2 FOR UPDATE;
+	`, order.OrderUID, order.DateCreated).Scan(&version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return order, nil
+		}
+
+		return nil, fmt.Errorf("storage.go relocateOrder q.QueryRow(...): %w", err)
+	}
+
+	if order.Version != 0 && order.Version <= version {
+		return nil, models.ErrStaleVersion
+	}
+
+	for _, table := range []string{"items", "payment", "delivery", "orders"} {
+		if _, err := q.Exec(ctx, "DELETE FROM "+table+" WHERE order_uid = $1;", order.OrderUID); err != nil {
+			return nil, fmt.Errorf("storage.go relocateOrder %s: %w", table, err)
+		}
+	}
+
+	moved := *order
+	moved.Version = max(order.Version, version)
+
+	return &moved, nil
+}
+
+// upsertOrderQuery writes the orders row. Unversioned writes (version 0)
+// always apply and keep the stored version; versioned writes apply only when
+// they are strictly newer.
+const upsertOrderQuery = `
 		INSERT INTO orders (
 			order_uid, track_number, entry, locale, internal_signature, customer_id,
-			delivery_service, shardkey, sm_id, date_created, oof_shard
+			delivery_service, shardkey, sm_id, date_created, oof_shard, version,
+			raw_content_type, raw_payload
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
-		) ON CONFLICT (order_uid) DO UPDATE SET
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14
+		) ON CONFLICT (order_uid, date_created) DO UPDATE SET
 			track_number = EXCLUDED.track_number,
 			entry = EXCLUDED.entry,
 			locale = EXCLUDED.locale,
@@ -75,38 +160,59 @@ func (s *Storage) UpsertOrder(ctx context.Context, q Querier, order *models.Orde
 			delivery_service = EXCLUDED.delivery_service,
 			shardkey = EXCLUDED.shardkey,
 			sm_id = EXCLUDED.sm_id,
-			date_created = EXCLUDED.date_created,
-			oof_shard = EXCLUDED.oof_shard
+			oof_shard = EXCLUDED.oof_shard,
+			version = GREATEST(orders.version, EXCLUDED.version),
+			raw_content_type = EXCLUDED.raw_content_type,
+			raw_payload = EXCLUDED.raw_payload,
+			deleted_at = NULL
+		WHERE EXCLUDED.version = 0 OR orders.version < EXCLUDED.version
 		RETURNING 
 			order_uid, track_number, entry, locale, internal_signature, customer_id,
-			delivery_service, shardkey, sm_id, date_created, oof_shard;
+			delivery_service, shardkey, sm_id, date_created, oof_shard, version;
 	`
 
-	var returningOrder models.Order
+// upsertOrderArgs are the arguments of upsertOrderQuery. An order written
+// without a raw payload drops the stored one, which no longer describes it.
+func upsertOrderArgs(order *models.Order) []any {
+	var rawContentType, rawPayload any
+	if order.Raw != nil {
+		rawContentType, rawPayload = order.Raw.ContentType, string(order.Raw.Payload)
+	}
 
-	err := q.QueryRow(ctx, query,
+	return []any{
 		order.OrderUID, order.TrackNumber, order.Entry, order.Locale,
 		order.InternalSignature, order.CustomerID, order.DeliveryService, order.Shardkey,
-		order.SMID, order.DateCreated, order.OOFShard,
-	).Scan(
+		order.SMID, order.DateCreated, order.OOFShard, order.Version,
+		rawContentType, rawPayload,
+	}
+}
+
+func (s *Storage) UpsertOrder(ctx context.Context, q Querier, order *models.Order) (*models.Order, error) {
+	var returningOrder models.Order
+
+	err := q.QueryRow(ctx, upsertOrderQuery, upsertOrderArgs(order)...).Scan(
 		&returningOrder.OrderUID, &returningOrder.TrackNumber, &returningOrder.Entry, &returningOrder.Locale,
 		&returningOrder.InternalSignature, &returningOrder.CustomerID, &returningOrder.DeliveryService,
 		&returningOrder.Shardkey, &returningOrder.SMID, &returningOrder.DateCreated, &returningOrder.OOFShard,
+		&returningOrder.Version,
 	)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrStaleVersion
+		}
+
 		return nil, fmt.Errorf("storage.go UpsertOrder q.QueryRow(...): %w", err)
 	}
 
 	return &returningOrder, nil
 }
 
-func (s *Storage) UpsertDelivery(ctx context.Context, q Querier, delivery *models.Delivery) (*models.Delivery, error) {
-	query := `
+const upsertDeliveryQuery = `
         INSERT INTO delivery (
-            order_uid, name, phone, zip, city, address, region, email
+            order_uid, date_created, name, phone, zip, city, address, region, email
         ) VALUES (
-            $1, $2, $3, $4, $5, $6, $7, $8
-        ) ON CONFLICT (order_uid) DO UPDATE SET
+            $1, (SELECT date_created FROM orders WHERE order_uid = $1), $2, $3, $4, $5, $6, $7, $8
+        ) ON CONFLICT (order_uid, date_created) DO UPDATE SET
             name = EXCLUDED.name,
             phone = EXCLUDED.phone,
             zip = EXCLUDED.zip,
@@ -118,12 +224,10 @@ func (s *Storage) UpsertDelivery(ctx context.Context, q Querier, delivery *model
             order_uid, name, phone, zip, city, address, region, email;
     `
 
+func (s *Storage) UpsertDelivery(ctx context.Context, q Querier, delivery *models.Delivery) (*models.Delivery, error) {
 	var returningDelivery models.Delivery
 
-	err := q.QueryRow(ctx, query,
-		delivery.OrderUID, delivery.Name, delivery.Phone, delivery.Zip,
-		delivery.City, delivery.Address, delivery.Region, delivery.Email,
-	).Scan(
+	err := q.QueryRow(ctx, upsertDeliveryQuery, upsertDeliveryArgs(delivery)...).Scan(
 		&returningDelivery.OrderUID, &returningDelivery.Name, &returningDelivery.Phone,
 		&returningDelivery.Zip, &returningDelivery.City, &returningDelivery.Address,
 		&returningDelivery.Region, &returningDelivery.Email,
@@ -135,14 +239,20 @@ func (s *Storage) UpsertDelivery(ctx context.Context, q Querier, delivery *model
 	return &returningDelivery, nil
 }
 
-func (s *Storage) UpsertPayment(ctx context.Context, q Querier, payment *models.Payment) (*models.Payment, error) {
-	query := `
+func upsertDeliveryArgs(delivery *models.Delivery) []any {
+	return []any{
+		delivery.OrderUID, delivery.Name, delivery.Phone, delivery.Zip,
+		delivery.City, delivery.Address, delivery.Region, delivery.Email,
+	}
+}
+
+const upsertPaymentQuery = `
 		INSERT INTO payment (
-			order_uid, transaction, request_id, currency, provider, amount, payment_dt,
+			order_uid, date_created, transaction, request_id, currency, provider, amount, payment_dt,
 			bank, delivery_cost, goods_total, custom_fee
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
-		) ON CONFLICT (order_uid) DO UPDATE SET
+			$1, (SELECT date_created FROM orders WHERE order_uid = $1), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		) ON CONFLICT (order_uid, date_created) DO UPDATE SET
 			transaction = EXCLUDED.transaction,
 			request_id = EXCLUDED.request_id,
 			currency = EXCLUDED.currency,
@@ -158,13 +268,10 @@ func (s *Storage) UpsertPayment(ctx context.Context, q Querier, payment *models.
 			bank, delivery_cost, goods_total, custom_fee;
 	`
 
+func (s *Storage) UpsertPayment(ctx context.Context, q Querier, payment *models.Payment) (*models.Payment, error) {
 	var returnedPayment models.Payment
 
-	err := q.QueryRow(ctx, query,
-		payment.OrderUID, payment.Transaction, payment.RequestID, payment.Currency, payment.Provider,
-		payment.Amount, payment.PaymentDT, payment.Bank, payment.DeliveryCost, payment.GoodsTotal,
-		payment.CustomFee,
-	).Scan(
+	err := q.QueryRow(ctx, upsertPaymentQuery, upsertPaymentArgs(payment)...).Scan(
 		&returnedPayment.OrderUID, &returnedPayment.Transaction, &returnedPayment.RequestID,
 		&returnedPayment.Currency, &returnedPayment.Provider, &returnedPayment.Amount,
 		&returnedPayment.PaymentDT, &returnedPayment.Bank, &returnedPayment.DeliveryCost,
@@ -177,40 +284,31 @@ func (s *Storage) UpsertPayment(ctx context.Context, q Querier, payment *models.
 	return &returnedPayment, nil
 }
 
-func (s *Storage) UpsertItems(ctx context.Context, q Querier, items []models.Item) (*[]models.Item, error) {
-	if len(items) == 0 {
-		return &items, nil
+func upsertPaymentArgs(payment *models.Payment) []any {
+	return []any{
+		payment.OrderUID, payment.Transaction, payment.RequestID, payment.Currency, payment.Provider,
+		payment.Amount, payment.PaymentDT, payment.Bank, payment.DeliveryCost, payment.GoodsTotal,
+		payment.CustomFee,
 	}
+}
 
-	valueStrings := make([]string, 0, len(items))
-	valueArgs := make([]any, 0, len(items)*12) //nolint:mnd
-
-	for i, item := range items {
-		valueStrings = append(valueStrings,
-			fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
-				i*12+1, i*12+2, i*12+3, i*12+4, i*12+5, i*12+6, i*12+7, i*12+8, i*12+9, i*12+10, i*12+11, i*12+12)) //nolint:mnd
+// UpsertItems makes the stored items of an order exactly match items: rows
+// missing from items are deleted, the others are inserted or updated by
+// their (order_uid, chrt_id) key. Items are stored in the partition of
+// their order, so the order must be written first.
+func (s *Storage) UpsertItems(
+	ctx context.Context, q Querier, orderUID string, items []models.Item,
+) (*[]models.Item, error) {
+	_, err := q.Exec(ctx, deleteStaleItemsQuery, orderUID, itemChrtIDs(items))
+	if err != nil {
+		return nil, fmt.Errorf("storage.go UpsertItems deleting stale items: %w", err)
+	}
 
-		valueArgs = append(valueArgs, item.OrderUID, item.ChrtID, item.TrackNumber, item.Price, item.RID, item.Name,
-			item.Sale, item.Size, item.TotalPrice, item.NMID, item.Brand, item.Status)
+	if len(items) == 0 {
+		return &items, nil
 	}
 
-	insertQuery := fmt.Sprintf(`
-        INSERT INTO items (order_uid, chrt_id, track_number, price, 
-			rid, name, sale, size, total_price, nm_id, brand, status)
-        VALUES %s
-        ON CONFLICT (chrt_id) DO UPDATE SET
-            track_number = EXCLUDED.track_number,
-            price = EXCLUDED.price,
-            rid = EXCLUDED.rid,
-            name = EXCLUDED.name,
-            sale = EXCLUDED.sale,
-            size = EXCLUDED.size,
-            total_price = EXCLUDED.total_price,
-            nm_id = EXCLUDED.nm_id,
-            brand = EXCLUDED.brand,
-            status = EXCLUDED.status
-        RETURNING chrt_id, order_uid, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status;
-    `, strings.Join(valueStrings, ","))
+	insertQuery, valueArgs := upsertItemsQuery(items)
 
 	rows, err := q.Query(ctx, insertQuery, valueArgs...)
 	if err != nil {
@@ -239,3 +337,50 @@ func (s *Storage) UpsertItems(ctx context.Context, q Querier, items []models.Ite
 
 	return &returnedItems, nil
 }
+
+const deleteStaleItemsQuery = `DELETE FROM items WHERE order_uid = $1 AND chrt_id <> ALL($2);`
+
+func itemChrtIDs(items []models.Item) []int64 {
+	chrtIDs := make([]int64, 0, len(items))
+	for _, item := range items {
+		chrtIDs = append(chrtIDs, int64(item.ChrtID))
+	}
+
+	return chrtIDs
+}
+
+// upsertItemsQuery builds the statement inserting or updating a non-empty
+// set of items of one order.
+func upsertItemsQuery(items []models.Item) (string, []any) {
+	valueStrings := make([]string, 0, len(items))
+	valueArgs := make([]any, 0, len(items)*12) //nolint:mnd
+
+	for i, item := range items {
+		valueStrings = append(valueStrings,
+			fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, "+
+				"(SELECT date_created FROM orders WHERE order_uid = $%d))",
+				i*12+1, i*12+2, i*12+3, i*12+4, i*12+5, i*12+6, i*12+7, i*12+8, i*12+9, i*12+10, i*12+11, i*12+12, //nolint:mnd
+				i*12+1)) //nolint:mnd
+
+		valueArgs = append(valueArgs, item.OrderUID, item.ChrtID, item.TrackNumber, item.Price, item.RID, item.Name,
+			item.Sale, item.Size, item.TotalPrice, item.NMID, item.Brand, item.Status)
+	}
+
+	return fmt.Sprintf(`
+        INSERT INTO items (order_uid, chrt_id, track_number, price, 
+			rid, name, sale, size, total_price, nm_id, brand, status, date_created)
+        VALUES %s
+        ON CONFLICT (order_uid, chrt_id, date_created) DO UPDATE SET
+            track_number = EXCLUDED.track_number,
+            price = EXCLUDED.price,
+            rid = EXCLUDED.rid,
+            name = EXCLUDED.name,
+            sale = EXCLUDED.sale,
+            size = EXCLUDED.size,
+            total_price = EXCLUDED.total_price,
+            nm_id = EXCLUDED.nm_id,
+            brand = EXCLUDED.brand,
+            status = EXCLUDED.status
+        RETURNING chrt_id, order_uid, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status;
+    `, strings.Join(valueStrings, ",")), valueArgs
+}
@@ -0,0 +1,417 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stsolovey/order_tracker/internal/models"
+)
+
+var errBatchConflict = errors.New("stored order changed while the batch was written")
+
+// batchState is the stored state of an order at the start of a bulk write.
+type batchState struct {
+	version uint64
+	moved   bool          // date_created differs, so the order changes partition
+	live    *models.Order // nil when the order is soft-deleted
+}
+
+// UpsertBatch writes all orders in a single transaction and returns the
+// per-order outcome (nil on success); successful orders get their stored
+// Version written back. The second return value is set only when the batch
+// as a whole could not be committed.
+//
+// The orders are written in bulk: their stored states are read with a few
+// queries and all their statements are sent as one pgx.Batch. Stale and
+// conditional writes are decided up front, under the orders' locks, so they
+// fail on their own. If the bulk write fails anyway, the batch is retried
+// with every order under its own savepoint, so one failing order does not
+// abort the rest.
+func (s *Storage) UpsertBatch(ctx context.Context, orders []*models.Order) ([]error, error) {
+	results, err := s.upsertBulk(ctx, orders)
+	if err == nil || ctx.Err() != nil {
+		return results, err
+	}
+
+	s.log.WithError(err).Warn("Bulk upsert failed, retrying the batch order by order")
+
+	return s.upsertEach(ctx, orders)
+}
+
+func (s *Storage) upsertBulk(ctx context.Context, orders []*models.Order) ([]error, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.go UpsertBatch starting transaction: %w", err)
+	}
+
+	shouldRollback := true
+
+	defer func() {
+		if shouldRollback {
+			if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+				s.log.Warn("Failed to rollback transaction", rollbackErr)
+			}
+		}
+	}()
+
+	if err := lockOrders(ctx, tx, orders); err != nil {
+		return nil, err
+	}
+
+	results := make([]error, len(orders))
+	versions := make([]uint64, len(orders))
+
+	for _, round := range batchRounds(orders) {
+		if err := s.upsertRound(ctx, tx, orders, round, results, versions); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("storage.go UpsertBatch committing transaction: %w", err)
+	}
+
+	shouldRollback = false
+
+	for i, order := range orders {
+		if results[i] == nil {
+			order.Version = versions[i]
+		}
+	}
+
+	return results, nil
+}
+
+// upsertEach writes the orders one by one, each under its own savepoint.
+func (s *Storage) upsertEach(ctx context.Context, orders []*models.Order) ([]error, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.go UpsertBatch starting transaction: %w", err)
+	}
+
+	shouldRollback := true
+
+	defer func() {
+		if shouldRollback {
+			if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+				s.log.Warn("Failed to rollback transaction", rollbackErr)
+			}
+		}
+	}()
+
+	if err := lockOrders(ctx, tx, orders); err != nil {
+		return nil, err
+	}
+
+	results := make([]error, len(orders))
+	versions := make([]uint64, len(orders))
+
+	for i, order := range orders {
+		stored, err := s.upsertSavepoint(ctx, tx, order)
+		if err != nil {
+			results[i] = err
+
+			continue
+		}
+
+		versions[i] = stored.Version
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("storage.go UpsertBatch committing transaction: %w", err)
+	}
+
+	shouldRollback = false
+
+	for i, order := range orders {
+		if results[i] == nil {
+			order.Version = versions[i]
+		}
+	}
+
+	return results, nil
+}
+
+func (s *Storage) upsertSavepoint(ctx context.Context, tx pgx.Tx, order *models.Order) (*models.Order, error) {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.go UpsertBatch creating savepoint: %w", err)
+	}
+
+	stored, err := s.upsertAll(ctx, savepoint, order)
+	if err != nil {
+		if rollbackErr := savepoint.Rollback(ctx); rollbackErr != nil {
+			s.log.Warn("Failed to rollback savepoint", rollbackErr)
+		}
+
+		return nil, err
+	}
+
+	if err := savepoint.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("storage.go UpsertBatch releasing savepoint: %w", err)
+	}
+
+	return stored, nil
+}
+
+// lockOrders takes the locks of lockOrder for all orders in one round trip,
+// in order_uid order, so that concurrent batches sharing orders can't
+// deadlock.
+func lockOrders(ctx context.Context, tx pgx.Tx, orders []*models.Order) error {
+	uids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		uids = append(uids, order.OrderUID)
+	}
+
+	slices.Sort(uids)
+	uids = slices.Compact(uids)
+
+	batch := &pgx.Batch{}
+	for _, uid := range uids {
+		batch.Queue(`SELECT pg_advisory_xact_lock(hashtextextended($1, 0));`, uid)
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("storage.go UpsertBatch lockOrders(...): %w", err)
+	}
+
+	return nil
+}
+
+// batchRounds splits the indexes of orders into rounds in which every
+// OrderUID occurs at most once, keeping the order of the batch. A round is
+// written only after the previous one, so repeated updates of an order see
+// each other.
+func batchRounds(orders []*models.Order) [][]int {
+	var rounds [][]int
+
+	seen := make(map[string]int, len(orders))
+
+	for i, order := range orders {
+		round := seen[order.OrderUID]
+		seen[order.OrderUID]++
+
+		if round == len(rounds) {
+			rounds = append(rounds, nil)
+		}
+
+		rounds[round] = append(rounds[round], i)
+	}
+
+	return rounds
+}
+
+// upsertRound writes the orders at indexes round with a single batch. Orders
+// that are stale or don't match their IfMatch get their error in results and
+// are left out; the others get the version they are stored with in versions.
+func (s *Storage) upsertRound(
+	ctx context.Context, tx pgx.Tx, orders []*models.Order, round []int, results []error, versions []uint64,
+) error {
+	states, err := s.batchStates(ctx, tx, orders, round)
+	if err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+
+	for _, i := range round {
+		order := orders[i]
+		state := states[order.OrderUID]
+
+		var prev *models.Order
+		if state != nil {
+			prev = state.live
+		}
+
+		if order.IfMatch != "" && !models.MatchIfMatch(order.IfMatch, prev) {
+			results[i] = fmt.Errorf("storage.go UpsertBatch(%s): %w", order.OrderUID, models.ErrPreconditionFailed)
+
+			continue
+		}
+
+		target, version := order, order.Version
+
+		if state != nil {
+			if order.Version != 0 && order.Version <= state.version {
+				results[i] = fmt.Errorf("storage.go UpsertBatch(%s): %w", order.OrderUID, models.ErrStaleVersion)
+
+				continue
+			}
+
+			version = max(order.Version, state.version)
+
+			if state.moved {
+				// As in relocateOrder, the rows are removed and the order is
+				// inserted into its new partition, keeping the stored version.
+				for _, table := range []string{"items", "payment", "delivery", "orders"} {
+					batch.Queue("DELETE FROM "+table+" WHERE order_uid = $1;", order.OrderUID)
+				}
+
+				moved := *order
+				moved.Version = version
+				target = &moved
+			}
+		}
+
+		if err := s.queueUpsert(ctx, batch, prev, order, target, version); err != nil {
+			return err
+		}
+
+		versions[i] = version
+	}
+
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("storage.go UpsertBatch tx.SendBatch(...): %w", err)
+	}
+
+	return nil
+}
+
+// queueUpsert queues the statements of upsertAll for order, writing the
+// orders row from target, which carries the version to insert.
+func (s *Storage) queueUpsert(
+	ctx context.Context, batch *pgx.Batch, prev, order, target *models.Order, version uint64,
+) error {
+	batch.Queue(upsertOrderQuery, upsertOrderArgs(target)...).Exec(func(tag pgconn.CommandTag) error {
+		if tag.RowsAffected() != 1 {
+			return fmt.Errorf("storage.go UpsertBatch(%s): %w", order.OrderUID, errBatchConflict)
+		}
+
+		return nil
+	})
+
+	batch.Queue(upsertDeliveryQuery, upsertDeliveryArgs(&order.Delivery)...)
+	batch.Queue(upsertPaymentQuery, upsertPaymentArgs(&order.Payment)...)
+	batch.Queue(deleteStaleItemsQuery, order.OrderUID, itemChrtIDs(order.Items))
+
+	if len(order.Items) > 0 {
+		batch.Queue(upsertItemsQuery(order.Items))
+	}
+
+	queued := batchExecer{batch: batch}
+
+	if err := s.enqueueEvent(ctx, queued, prev, order, version); err != nil {
+		return fmt.Errorf("storage.go UpsertBatch outbox: %w", err)
+	}
+
+	if err := s.recordRevision(ctx, queued, prev, order, version); err != nil {
+		return fmt.Errorf("storage.go UpsertBatch revision: %w", err)
+	}
+
+	return nil
+}
+
+// batchStates locks the stored rows of the orders at indexes round, whose
+// OrderUIDs are distinct, and returns their states by OrderUID. Orders that
+// are not stored have no state.
+func (s *Storage) batchStates(
+	ctx context.Context, tx pgx.Tx, orders []*models.Order, round []int,
+) (map[string]*batchState, error) {
+	uids := make([]string, 0, len(round))
+	dates := make([]time.Time, 0, len(round))
+
+	for _, i := range round {
+		uids = append(uids, orders[i].OrderUID)
+		dates = append(dates, orders[i].DateCreated)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT o.order_uid, o.version, o.deleted_at IS NULL, o.date_created <> n.date_created
+		FROM unnest($1::TEXT[], $2::TIMESTAMP[]) AS n(order_uid, date_created)
+		JOIN orders o ON o.order_uid = n.order_uid
+		FOR UPDATE OF o;
+	`, uids, dates)
+	if err != nil {
+		return nil, fmt.Errorf("storage.go UpsertBatch batchStates tx.Query(...): %w", err)
+	}
+
+	states := make(map[string]*batchState, len(round))
+
+	var live []string
+
+	for rows.Next() {
+		var (
+			uid    string
+			isLive bool
+			state  batchState
+		)
+
+		if err := rows.Scan(&uid, &state.version, &isLive, &state.moved); err != nil {
+			rows.Close()
+
+			return nil, fmt.Errorf("storage.go UpsertBatch batchStates rows.Scan(...): %w", err)
+		}
+
+		states[uid] = &state
+
+		if isLive {
+			live = append(live, uid)
+		}
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.go UpsertBatch batchStates rows.Err(): %w", err)
+	}
+
+	if err := s.attachLiveStates(ctx, tx, states, live); err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+// attachLiveStates loads the live orders among states with their delivery,
+// payment and items, as previousState does for a single order.
+func (s *Storage) attachLiveStates(
+	ctx context.Context, tx pgx.Tx, states map[string]*batchState, live []string,
+) error {
+	if len(live) == 0 {
+		return nil
+	}
+
+	rows, err := tx.Query(ctx, orderColumns+`
+		WHERE o.order_uid = ANY($1) AND o.deleted_at IS NULL;
+	`, live)
+	if err != nil {
+		return fmt.Errorf("storage.go UpsertBatch attachLiveStates tx.Query(...): %w", err)
+	}
+
+	stored, err := scanOrders(rows, len(live))
+	rows.Close()
+
+	if err != nil {
+		return fmt.Errorf("storage.go UpsertBatch attachLiveStates scanOrders(...): %w", err)
+	}
+
+	if err := s.attachItems(ctx, tx, stored); err != nil {
+		return fmt.Errorf("storage.go UpsertBatch attachLiveStates: %w", err)
+	}
+
+	for i := range stored {
+		states[stored[i].OrderUID].live = &stored[i]
+	}
+
+	// An order missing its delivery or payment isn't joined above.
+	for _, uid := range live {
+		if states[uid].live != nil {
+			continue
+		}
+
+		if states[uid].live, err = s.previousState(ctx, tx, uid); err != nil {
+			return fmt.Errorf("storage.go UpsertBatch attachLiveStates: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -27,7 +27,7 @@ func (s *IntegrationTestSuite) TestNATSIntegration() {
 			Transaction: "TX1234567890",
 			Currency:    "USD",
 			Provider:    "TestProvider",
-			Amount:      150.00,
+			Amount:      models.MustParseMoney("150.00"),
 			PaymentDT:   time.Now(),
 		},
 		Items: []models.Item{
@@ -35,7 +35,7 @@ func (s *IntegrationTestSuite) TestNATSIntegration() {
 				OrderUID:    "uniqueOrderID123",
 				ChrtID:      1,
 				TrackNumber: "TN1234567890",
-				Price:       100.00,
+				Price:       models.MustParseMoney("100.00"),
 				Name:        "Test Item 1",
 				NMID:        1001,
 				Brand:       "TestBrand",
@@ -45,7 +45,7 @@ func (s *IntegrationTestSuite) TestNATSIntegration() {
 				OrderUID:    "uniqueOrderID123",
 				ChrtID:      2,
 				TrackNumber: "TN1234567890",
-				Price:       50.00,
+				Price:       models.MustParseMoney("50.00"),
 				Name:        "Test Item 2",
 				NMID:        2002,
 				Brand:       "BrandTest",